@@ -9,30 +9,46 @@ import History from './components/History';
 import Portfolio from './components/Portfolio';
 import InvestmentRecommendations from './components/InvestmentRecommendations';
 import Installments from './components/Installments';
+import Login from './components/Login';
 
 const API_URL = 'http://localhost:5000/api';
 
 function App() {
   const [activeTab, setActiveTab] = useState('dashboard');
   const [accounts, setAccounts] = useState([]);
-  const [transactions, setTransactions] = useState([]);
   const [categories, setCategories] = useState([]);
   const [refreshKey, setRefreshKey] = useState(0);
+  const [user, setUser] = useState(null);
+  const [authChecked, setAuthChecked] = useState(false);
+
+  useEffect(() => {
+    fetch(`${API_URL}/auth/me`)
+      .then(res => (res.ok ? res.json() : null))
+      .then(data => setUser(data))
+      .catch(() => setUser(null))
+      .finally(() => setAuthChecked(true));
+  }, []);
+
+  const handleLogout = async () => {
+    try {
+      await fetch(`${API_URL}/auth/logout`, { method: 'POST' });
+    } catch (error) {
+      console.error('Error logging out:', error);
+    }
+    setUser(null);
+  };
 
   const fetchData = async () => {
     try {
-      const [accountsRes, transactionsRes, categoriesRes] = await Promise.all([
+      const [accountsRes, categoriesRes] = await Promise.all([
         fetch(`${API_URL}/accounts`),
-        fetch(`${API_URL}/transactions`),
         fetch(`${API_URL}/categories`)
       ]);
 
       const accountsData = await accountsRes.json();
-      const transactionsData = await transactionsRes.json();
       const categoriesData = await categoriesRes.json();
       
       setAccounts(Array.isArray(accountsData) ? accountsData : []);
-      setTransactions(Array.isArray(transactionsData) ? transactionsData : []);
       setCategories(Array.isArray(categoriesData) ? categoriesData : []);
     } catch (error) {
       console.error('Error fetching data:', error);
@@ -40,12 +56,14 @@ function App() {
   };
 
   useEffect(() => {
-    fetchData();
-  }, [refreshKey]);
+    if (user) {
+      fetchData();
+    }
+  }, [refreshKey, user]);
 
   // Refresh data when switching to dashboard tab
   useEffect(() => {
-    if (activeTab === 'dashboard') {
+    if (activeTab === 'dashboard' && user) {
       // Small delay to ensure component is mounted
       setTimeout(() => {
         fetchData();
@@ -71,11 +89,29 @@ function App() {
     setRefreshKey(prev => prev + 1);
   };
 
+  if (!authChecked) {
+    return null;
+  }
+
+  if (!user) {
+    return (
+      <div className="App">
+        <Login onLogin={setUser} />
+      </div>
+    );
+  }
+
   return (
     <div className="App">
-      <header className="app-header">
-        <h1>Money Manager</h1>
-        <p>Gerenciamento financeiro pessoal</p>
+      <header className="app-header" style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center' }}>
+        <div>
+          <h1>Money Manager</h1>
+          <p>Gerenciamento financeiro pessoal</p>
+        </div>
+        <div style={{ display: 'flex', alignItems: 'center', gap: '12px' }}>
+          <span style={{ color: '#64748b', fontSize: '0.875rem' }}>{user.name}</span>
+          <button className="btn btn-secondary" onClick={handleLogout}>Sair</button>
+        </div>
       </header>
 
       <nav className="app-nav">
@@ -141,9 +177,9 @@ function App() {
         )}
               {activeTab === 'transactions' && (
           <Transactions 
-            transactions={Array.isArray(transactions) ? transactions : []}
             categories={Array.isArray(categories) ? categories : []}
             onRefresh={handleRefresh}
+            refreshKey={refreshKey}
           />
         )}
         {activeTab === 'add' && (
@@ -174,7 +210,7 @@ function App() {
           <Installments />
         )}
         {activeTab === 'settings' && (
-          <Settings onRefresh={handleRefresh} />
+          <Settings onRefresh={handleRefresh} refreshKey={refreshKey} />
         )}
       </main>
     </div>
@@ -2,12 +2,22 @@ import React, { useState, useEffect } from 'react';
 
 const API_URL = 'http://localhost:5000/api';
 
+// Tags typed as "viagem, reembolsável"; the server creates the new ones
+export const parseTags = (text) => text
+  .split(',')
+  .map(name => name.trim())
+  .filter(name => name)
+  .map(name => ({ name }));
+
+// Accounts shared as read-only cannot receive transactions
+const writableAccounts = (accounts) => accounts.filter(account => account.role !== 'viewer');
+
 const AddTransaction = ({ accounts, categories, onRefresh, onClose }) => {
-  const [localAccounts, setLocalAccounts] = useState(accounts);
+  const [localAccounts, setLocalAccounts] = useState(writableAccounts(accounts));
   const [isCreatingAccount, setIsCreatingAccount] = useState(false);
 
   useEffect(() => {
-    setLocalAccounts(accounts);
+    setLocalAccounts(writableAccounts(accounts));
   }, [accounts]);
 
   const ensureAccountExists = async () => {
@@ -33,7 +43,7 @@ const AddTransaction = ({ accounts, categories, onRefresh, onClose }) => {
       // Refresh accounts list
       const accountsRes = await fetch(`${API_URL}/accounts`);
       const accountsData = await accountsRes.json();
-      setLocalAccounts(Array.isArray(accountsData) ? accountsData : []);
+      setLocalAccounts(Array.isArray(accountsData) ? writableAccounts(accountsData) : []);
       
       setIsCreatingAccount(false);
       return newAccountId;
@@ -50,9 +60,32 @@ const AddTransaction = ({ accounts, categories, onRefresh, onClose }) => {
     type: 'expense',
     amount: '',
     description: '',
+    tags: '',
     date: new Date().toISOString().split('T')[0]
   });
 
+  const [suggestions, setSuggestions] = useState([]);
+
+  // Suggest categories learned from past transactions as the description is typed
+  useEffect(() => {
+    const description = formData.description.trim();
+    if (description.length < 3 || formData.category_id) {
+      setSuggestions([]);
+      return;
+    }
+    const timer = setTimeout(async () => {
+      try {
+        const params = new URLSearchParams({ description, type: formData.type });
+        const response = await fetch(`${API_URL}/transactions/suggest-category?${params}`);
+        const data = await response.json();
+        setSuggestions(response.ok ? data.suggestions.filter(s => s.confidence >= 0.2) : []);
+      } catch (error) {
+        console.error('Error fetching category suggestions:', error);
+      }
+    }, 300);
+    return () => clearTimeout(timer);
+  }, [formData.description, formData.type, formData.category_id]);
+
   useEffect(() => {
     if (localAccounts.length > 0 && !formData.account_id) {
       setFormData(prev => ({ ...prev, account_id: localAccounts[0].id }));
@@ -78,7 +111,8 @@ const AddTransaction = ({ accounts, categories, onRefresh, onClose }) => {
           ...formData,
           account_id: parseInt(accountId),
           amount: parseFloat(formData.amount),
-          category_id: formData.category_id ? parseInt(formData.category_id) : null
+          category_id: formData.category_id ? parseInt(formData.category_id) : null,
+          tags: parseTags(formData.tags)
         }),
       });
 
@@ -89,6 +123,7 @@ const AddTransaction = ({ accounts, categories, onRefresh, onClose }) => {
           type: 'expense',
           amount: '',
           description: '',
+          tags: '',
           date: new Date().toISOString().split('T')[0]
         });
         onRefresh();
@@ -183,6 +218,31 @@ const AddTransaction = ({ accounts, categories, onRefresh, onClose }) => {
             onChange={(e) => setFormData({ ...formData, description: e.target.value })}
             placeholder="Ex: Compra no supermercado"
           />
+          {suggestions.length > 0 && (
+            <div style={{ display: 'flex', gap: '6px', flexWrap: 'wrap', marginTop: '6px', fontSize: '0.8rem', color: '#6b7280' }}>
+              Sugestões:
+              {suggestions.map(s => (
+                <button
+                  type="button"
+                  key={s.category_id}
+                  className="btn btn-small btn-secondary"
+                  onClick={() => setFormData({ ...formData, category_id: String(s.category_id) })}
+                >
+                  {s.category_icon} {s.category_name} ({Math.round(s.confidence * 100)}%)
+                </button>
+              ))}
+            </div>
+          )}
+        </div>
+
+        <div className="form-group">
+          <label>Tags</label>
+          <input
+            type="text"
+            value={formData.tags}
+            onChange={(e) => setFormData({ ...formData, tags: e.target.value })}
+            placeholder="Ex: viagem, reembolsável"
+          />
         </div>
 
         <div className="form-group">
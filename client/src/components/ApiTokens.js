@@ -0,0 +1,125 @@
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const scopeOptions = [
+  { value: 'read', label: 'Leitura' },
+  { value: 'transactions:write', label: 'Lançar transações' },
+  { value: 'investments:write', label: 'Alterar investimentos' }
+];
+
+const ApiTokens = () => {
+  const [tokens, setTokens] = useState([]);
+  const [name, setName] = useState('');
+  const [scopes, setScopes] = useState(['read']);
+  const [createdToken, setCreatedToken] = useState(null);
+
+  const fetchTokens = async () => {
+    try {
+      const response = await fetch(`${API_URL}/tokens`);
+      const data = await response.json();
+      setTokens(Array.isArray(data) ? data : []);
+    } catch (error) {
+      console.error('Error fetching tokens:', error);
+    }
+  };
+
+  useEffect(() => {
+    fetchTokens();
+  }, []);
+
+  const toggleScope = (scope) => {
+    setScopes(prev => (prev.includes(scope) ? prev.filter(s => s !== scope) : [...prev, scope]));
+  };
+
+  const handleCreate = async (e) => {
+    e.preventDefault();
+    try {
+      const response = await fetch(`${API_URL}/tokens`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ name, scopes })
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao criar token');
+        return;
+      }
+      setCreatedToken(data.token);
+      setName('');
+      fetchTokens();
+    } catch (error) {
+      console.error('Error creating token:', error);
+      alert('Erro ao criar token');
+    }
+  };
+
+  const handleRevoke = async (id) => {
+    if (!window.confirm('Revogar este token? Scripts que o utilizam deixarão de funcionar.')) {
+      return;
+    }
+    try {
+      await fetch(`${API_URL}/tokens/${id}`, { method: 'DELETE' });
+      fetchTokens();
+    } catch (error) {
+      console.error('Error revoking token:', error);
+    }
+  };
+
+  return (
+    <div className="card" style={{ marginBottom: '24px' }}>
+      <h3 style={{ marginBottom: '20px', color: '#1a202c' }}>Tokens de API</h3>
+      <p style={{ color: '#6b7280', marginBottom: '15px' }}>
+        Use tokens para que scripts acessem a API com o cabeçalho <code>Authorization: Bearer &lt;token&gt;</code>.
+      </p>
+
+      <form onSubmit={handleCreate} style={{ marginBottom: '20px' }}>
+        <div style={{ display: 'flex', gap: '10px', marginBottom: '10px' }}>
+          <input
+            type="text"
+            value={name}
+            onChange={(e) => setName(e.target.value)}
+            placeholder="Nome (ex: importação diária)"
+            required
+            style={{ flex: 1, padding: '10px', border: '1px solid #d1d5db', borderRadius: '6px' }}
+          />
+          <button type="submit" className="btn btn-primary" disabled={scopes.length === 0}>Criar Token</button>
+        </div>
+        <div style={{ display: 'flex', gap: '15px', color: '#374151', fontSize: '0.875rem' }}>
+          {scopeOptions.map(option => (
+            <label key={option.value}>
+              <input
+                type="checkbox"
+                checked={scopes.includes(option.value)}
+                onChange={() => toggleScope(option.value)}
+                style={{ marginRight: '5px' }}
+              />
+              {option.label}
+            </label>
+          ))}
+        </div>
+      </form>
+
+      {createdToken && (
+        <div style={{ background: '#f0fdf4', border: '1px solid #bbf7d0', borderRadius: '6px', padding: '12px', marginBottom: '15px' }}>
+          <p style={{ margin: '0 0 8px 0', color: '#166534' }}>Copie o token agora; ele não será exibido novamente.</p>
+          <code style={{ wordBreak: 'break-all' }}>{createdToken}</code>
+        </div>
+      )}
+
+      {tokens.map(token => (
+        <div key={token.id} style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', borderTop: '1px solid #e2e8f0', padding: '10px 0' }}>
+          <div>
+            <strong>{token.name}</strong> <code style={{ color: '#6b7280' }}>{token.prefix}…</code>
+            <div style={{ color: '#6b7280', fontSize: '0.8rem' }}>
+              {token.scopes.join(', ')} · último uso: {token.last_used_at ? new Date(token.last_used_at).toLocaleString('pt-BR') : 'nunca'}
+            </div>
+          </div>
+          <button className="btn btn-small btn-danger" onClick={() => handleRevoke(token.id)}>Revogar</button>
+        </div>
+      ))}
+    </div>
+  );
+};
+
+export default ApiTokens;
@@ -0,0 +1,91 @@
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const formatSize = (bytes) => {
+  if (bytes < 1024) return `${bytes} B`;
+  if (bytes < 1024 * 1024) return `${(bytes / 1024).toFixed(0)} KB`;
+  return `${(bytes / 1024 / 1024).toFixed(1)} MB`;
+};
+
+// Files attached to a transaction, such as receipts
+const Attachments = ({ transactionId, onChange }) => {
+  const [attachments, setAttachments] = useState([]);
+  const [uploading, setUploading] = useState(false);
+
+  const fetchAttachments = async () => {
+    try {
+      const response = await fetch(`${API_URL}/transactions/${transactionId}/attachments`);
+      const data = await response.json();
+      setAttachments(Array.isArray(data) ? data : []);
+    } catch (error) {
+      console.error('Error fetching attachments:', error);
+    }
+  };
+
+  useEffect(() => {
+    fetchAttachments();
+  }, [transactionId]);
+
+  const handleUpload = async (e) => {
+    const file = e.target.files[0];
+    e.target.value = '';
+    if (!file) {
+      return;
+    }
+    const body = new FormData();
+    body.append('file', file);
+    setUploading(true);
+    try {
+      const response = await fetch(`${API_URL}/transactions/${transactionId}/attachments`, { method: 'POST', body });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao anexar arquivo');
+        return;
+      }
+      fetchAttachments();
+      onChange();
+    } catch (error) {
+      console.error('Error uploading attachment:', error);
+      alert('Erro ao anexar arquivo');
+    } finally {
+      setUploading(false);
+    }
+  };
+
+  const handleDelete = async (id) => {
+    if (!window.confirm('Excluir este anexo?')) {
+      return;
+    }
+    try {
+      await fetch(`${API_URL}/attachments/${id}`, { method: 'DELETE' });
+      fetchAttachments();
+      onChange();
+    } catch (error) {
+      console.error('Error deleting attachment:', error);
+    }
+  };
+
+  return (
+    <div style={{ marginTop: '8px', padding: '10px', background: '#f8fafc', border: '1px solid #e2e8f0', borderRadius: '6px' }}>
+      {attachments.length === 0 && (
+        <p style={{ fontSize: '0.85rem', color: '#6b7280', marginBottom: '8px' }}>Nenhum anexo.</p>
+      )}
+      {attachments.map(attachment => (
+        <div key={attachment.id} style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', fontSize: '0.85rem', padding: '4px 0' }}>
+          <a href={`${API_URL}/attachments/${attachment.id}`}>📎 {attachment.file_name}</a>
+          <span style={{ display: 'flex', gap: '10px', alignItems: 'center' }}>
+            <span style={{ color: '#6b7280' }}>{formatSize(attachment.size)}</span>
+            <button className="btn btn-small btn-danger" onClick={() => handleDelete(attachment.id)}>Excluir</button>
+          </span>
+        </div>
+      ))}
+      <label className="btn btn-small btn-secondary" style={{ marginTop: '8px', display: 'inline-block' }}>
+        {uploading ? 'Enviando...' : 'Anexar arquivo'}
+        <input type="file" onChange={handleUpload} disabled={uploading} style={{ display: 'none' }} />
+      </label>
+    </div>
+  );
+};
+
+export default Attachments;
@@ -0,0 +1,187 @@
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const emptyCategory = {
+  name: '',
+  type: 'expense',
+  parent_id: '',
+  color: '#6B7280',
+  icon: ''
+};
+
+// Top-level categories followed by their subcategories
+const sortedWithChildren = (categories) => {
+  const result = [];
+  categories.filter(cat => !cat.parent_id).forEach(parent => {
+    result.push(parent);
+    categories.filter(cat => cat.parent_id === parent.id).forEach(child => result.push(child));
+  });
+  return result;
+};
+
+const Categories = ({ onRefresh }) => {
+  const [categories, setCategories] = useState([]);
+  const [form, setForm] = useState(emptyCategory);
+  const [editing, setEditing] = useState(null);
+
+  const fetchCategories = async () => {
+    try {
+      const response = await fetch(`${API_URL}/categories`);
+      const data = await response.json();
+      setCategories(Array.isArray(data) ? data : []);
+    } catch (error) {
+      console.error('Error fetching categories:', error);
+    }
+  };
+
+  useEffect(() => {
+    fetchCategories();
+  }, []);
+
+  const parentsFor = (type, exceptId) =>
+    categories.filter(cat => cat.type === type && !cat.parent_id && cat.id !== exceptId);
+
+  const handleCreate = async (e) => {
+    e.preventDefault();
+    try {
+      const response = await fetch(`${API_URL}/categories`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+          ...form,
+          parent_id: form.parent_id ? parseInt(form.parent_id) : null
+        })
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao criar categoria');
+        return;
+      }
+      setForm(emptyCategory);
+      fetchCategories();
+      onRefresh();
+    } catch (error) {
+      console.error('Error creating category:', error);
+      alert('Erro ao criar categoria');
+    }
+  };
+
+  const handleSave = async () => {
+    try {
+      const response = await fetch(`${API_URL}/categories/${editing.id}`, {
+        method: 'PUT',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+          name: editing.name,
+          color: editing.color,
+          icon: editing.icon,
+          parent_id: editing.parent_id ? parseInt(editing.parent_id) : null,
+          remove_parent: !editing.parent_id
+        })
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao salvar categoria');
+        return;
+      }
+      setEditing(null);
+      fetchCategories();
+      onRefresh();
+    } catch (error) {
+      console.error('Error updating category:', error);
+      alert('Erro ao salvar categoria');
+    }
+  };
+
+  // The transactions of the category can be moved to another one of the
+  // same type, or left without category
+  const handleDelete = async (category) => {
+    const others = categories.filter(cat => cat.type === category.type && cat.id !== category.id);
+    const options = others.map(cat => `${cat.id} - ${cat.name}`).join('\n');
+    const answer = window.prompt(
+      `Excluir "${category.name}". Para mover as transações para outra categoria, informe o número dela; deixe em branco para deixá-las sem categoria.\n\n${options}`,
+      ''
+    );
+    if (answer === null) {
+      return;
+    }
+    const reassignTo = answer.trim() ? `?reassign_to=${encodeURIComponent(answer.trim())}` : '';
+    try {
+      const response = await fetch(`${API_URL}/categories/${category.id}${reassignTo}`, { method: 'DELETE' });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao excluir categoria');
+        return;
+      }
+      fetchCategories();
+      onRefresh();
+    } catch (error) {
+      console.error('Error deleting category:', error);
+      alert('Erro ao excluir categoria');
+    }
+  };
+
+  return (
+    <div className="card" style={{ marginBottom: '24px' }}>
+      <h3 style={{ marginBottom: '20px', color: '#1a202c' }}>Categorias</h3>
+      <p style={{ color: '#6b7280', marginBottom: '15px' }}>
+        Subcategorias são somadas à categoria principal nos relatórios. As categorias padrão não podem ser alteradas.
+      </p>
+
+      <form onSubmit={handleCreate} style={{ display: 'grid', gridTemplateColumns: 'repeat(auto-fit, minmax(140px, 1fr))', gap: '10px', marginBottom: '20px' }}>
+        <input type="text" placeholder="Nome" value={form.name} onChange={(e) => setForm({ ...form, name: e.target.value })} required />
+        <select value={form.type} onChange={(e) => setForm({ ...form, type: e.target.value, parent_id: '' })}>
+          <option value="expense">Despesa</option>
+          <option value="income">Receita</option>
+        </select>
+        <select value={form.parent_id} onChange={(e) => setForm({ ...form, parent_id: e.target.value })}>
+          <option value="">Sem categoria principal</option>
+          {parentsFor(form.type).map(cat => (
+            <option key={cat.id} value={cat.id}>{cat.icon} {cat.name}</option>
+          ))}
+        </select>
+        <input type="text" placeholder="Ícone" value={form.icon} onChange={(e) => setForm({ ...form, icon: e.target.value })} />
+        <input type="color" value={form.color} onChange={(e) => setForm({ ...form, color: e.target.value })} />
+        <button type="submit" className="btn btn-primary">Criar Categoria</button>
+      </form>
+
+      {sortedWithChildren(categories).map(category => (
+        <div key={category.id} style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', borderTop: '1px solid #e2e8f0', padding: '8px 0', paddingLeft: category.parent_id ? '24px' : 0 }}>
+          {editing && editing.id === category.id ? (
+            <div style={{ display: 'flex', gap: '8px', flexWrap: 'wrap', alignItems: 'center' }}>
+              <input type="text" value={editing.icon} onChange={(e) => setEditing({ ...editing, icon: e.target.value })} style={{ width: '50px' }} />
+              <input type="text" value={editing.name} onChange={(e) => setEditing({ ...editing, name: e.target.value })} />
+              <input type="color" value={editing.color} onChange={(e) => setEditing({ ...editing, color: e.target.value })} />
+              <select value={editing.parent_id || ''} onChange={(e) => setEditing({ ...editing, parent_id: e.target.value })}>
+                <option value="">Sem categoria principal</option>
+                {parentsFor(category.type, category.id).map(cat => (
+                  <option key={cat.id} value={cat.id}>{cat.icon} {cat.name}</option>
+                ))}
+              </select>
+              <button className="btn btn-small btn-primary" onClick={handleSave}>Salvar</button>
+              <button className="btn btn-small btn-secondary" onClick={() => setEditing(null)}>Cancelar</button>
+            </div>
+          ) : (
+            <>
+              <span>
+                <span style={{ color: category.color }}>●</span> {category.icon} {category.name}
+                <span style={{ color: '#6b7280', fontSize: '0.8rem' }}>
+                  {' '}· {category.type === 'income' ? 'receita' : 'despesa'}{category.is_default ? ' · padrão' : ''}
+                </span>
+              </span>
+              {!category.is_default && (
+                <div style={{ display: 'flex', gap: '8px' }}>
+                  <button className="btn btn-small btn-secondary" onClick={() => setEditing(category)}>Editar</button>
+                  <button className="btn btn-small btn-danger" onClick={() => handleDelete(category)}>Excluir</button>
+                </div>
+              )}
+            </>
+          )}
+        </div>
+      ))}
+    </div>
+  );
+};
+
+export default Categories;
@@ -0,0 +1,181 @@
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const emptyRule = {
+  name: '',
+  description_contains: '',
+  description_regex: '',
+  min_amount: '',
+  max_amount: '',
+  category_id: '',
+  priority: 0
+};
+
+const describeRule = (rule) => {
+  const conditions = [];
+  if (rule.description_contains) conditions.push(`contém "${rule.description_contains}"`);
+  if (rule.description_regex) conditions.push(`regex /${rule.description_regex}/`);
+  if (rule.min_amount !== null) conditions.push(`valor ≥ ${rule.min_amount}`);
+  if (rule.max_amount !== null) conditions.push(`valor ≤ ${rule.max_amount}`);
+  if (rule.account_id !== null) conditions.push(`conta #${rule.account_id}`);
+  return conditions.join(' e ');
+};
+
+const CategorizationRules = ({ onRefresh }) => {
+  const [rules, setRules] = useState([]);
+  const [categories, setCategories] = useState([]);
+  const [form, setForm] = useState(emptyRule);
+  const [preview, setPreview] = useState(null);
+
+  const fetchRules = async () => {
+    try {
+      const response = await fetch(`${API_URL}/categorization-rules`);
+      const data = await response.json();
+      setRules(Array.isArray(data) ? data : []);
+    } catch (error) {
+      console.error('Error fetching categorization rules:', error);
+    }
+  };
+
+  useEffect(() => {
+    fetchRules();
+    fetch(`${API_URL}/categories`)
+      .then(res => res.json())
+      .then(data => setCategories(Array.isArray(data) ? data : []))
+      .catch(error => console.error('Error fetching categories:', error));
+  }, []);
+
+  const handleCreate = async (e) => {
+    e.preventDefault();
+    const optionalNumber = (value) => (value === '' ? null : parseFloat(value));
+    try {
+      const response = await fetch(`${API_URL}/categorization-rules`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({
+          name: form.name,
+          description_contains: form.description_contains || null,
+          description_regex: form.description_regex || null,
+          min_amount: optionalNumber(form.min_amount),
+          max_amount: optionalNumber(form.max_amount),
+          category_id: parseInt(form.category_id),
+          priority: parseInt(form.priority) || 0
+        })
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao criar regra');
+        return;
+      }
+      setForm(emptyRule);
+      fetchRules();
+    } catch (error) {
+      console.error('Error creating categorization rule:', error);
+      alert('Erro ao criar regra');
+    }
+  };
+
+  const handleDelete = async (id) => {
+    if (!window.confirm('Excluir esta regra?')) {
+      return;
+    }
+    try {
+      await fetch(`${API_URL}/categorization-rules/${id}`, { method: 'DELETE' });
+      fetchRules();
+    } catch (error) {
+      console.error('Error deleting categorization rule:', error);
+    }
+  };
+
+  // The first run only shows what would change; confirming applies it
+  const handleApply = async (dryRun) => {
+    try {
+      const response = await fetch(`${API_URL}/categorization-rules/apply${dryRun ? '?dry_run=true' : ''}`, {
+        method: 'POST'
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao aplicar regras');
+        return;
+      }
+      if (dryRun) {
+        setPreview(data);
+        return;
+      }
+      setPreview(null);
+      alert(`${data.categorized} transação(ões) categorizada(s).`);
+      onRefresh();
+    } catch (error) {
+      console.error('Error applying categorization rules:', error);
+      alert('Erro ao aplicar regras');
+    }
+  };
+
+  return (
+    <div className="card" style={{ marginBottom: '24px' }}>
+      <h3 style={{ marginBottom: '20px', color: '#1a202c' }}>Regras de Categorização</h3>
+      <p style={{ color: '#6b7280', marginBottom: '15px' }}>
+        Transações criadas ou importadas sem categoria recebem a categoria da primeira regra que combinar,
+        na ordem de prioridade.
+      </p>
+
+      <form onSubmit={handleCreate} style={{ display: 'grid', gridTemplateColumns: 'repeat(auto-fit, minmax(160px, 1fr))', gap: '10px', marginBottom: '20px' }}>
+        <input type="text" placeholder="Nome" value={form.name} onChange={(e) => setForm({ ...form, name: e.target.value })} required />
+        <input type="text" placeholder="Descrição contém" value={form.description_contains} onChange={(e) => setForm({ ...form, description_contains: e.target.value })} />
+        <input type="text" placeholder="Regex da descrição" value={form.description_regex} onChange={(e) => setForm({ ...form, description_regex: e.target.value })} />
+        <input type="number" step="0.01" placeholder="Valor mínimo" value={form.min_amount} onChange={(e) => setForm({ ...form, min_amount: e.target.value })} />
+        <input type="number" step="0.01" placeholder="Valor máximo" value={form.max_amount} onChange={(e) => setForm({ ...form, max_amount: e.target.value })} />
+        <select value={form.category_id} onChange={(e) => setForm({ ...form, category_id: e.target.value })} required>
+          <option value="">Categoria</option>
+          {categories.map(cat => (
+            <option key={cat.id} value={cat.id}>
+              {cat.icon} {cat.name} ({cat.type === 'income' ? 'receita' : 'despesa'})
+            </option>
+          ))}
+        </select>
+        <input type="number" placeholder="Prioridade" value={form.priority} onChange={(e) => setForm({ ...form, priority: e.target.value })} />
+        <button type="submit" className="btn btn-primary">Criar Regra</button>
+      </form>
+
+      {rules.map(rule => (
+        <div key={rule.id} style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', borderTop: '1px solid #e2e8f0', padding: '10px 0' }}>
+          <div>
+            <strong>{rule.name}</strong> → {rule.category_name}
+            <div style={{ color: '#6b7280', fontSize: '0.8rem' }}>
+              {describeRule(rule)} · prioridade {rule.priority}{rule.active ? '' : ' · inativa'}
+            </div>
+          </div>
+          <button className="btn btn-small btn-danger" onClick={() => handleDelete(rule.id)}>Excluir</button>
+        </div>
+      ))}
+
+      <div style={{ marginTop: '15px' }}>
+        <button className="btn btn-secondary" onClick={() => handleApply(true)} disabled={rules.length === 0}>
+          Aplicar às transações sem categoria
+        </button>
+      </div>
+
+      {preview && (
+        <div style={{ marginTop: '15px', background: '#f8fafc', border: '1px solid #e2e8f0', borderRadius: '6px', padding: '12px' }}>
+          <p style={{ marginBottom: '10px' }}>
+            {preview.categorized} de {preview.examined} transação(ões) sem categoria seriam categorizadas.
+          </p>
+          {preview.changes.slice(0, 20).map(change => (
+            <div key={change.transaction_id} style={{ fontSize: '0.85rem', color: '#374151' }}>
+              {new Date(change.date).toLocaleDateString('pt-BR')} · {change.description || 'Sem descrição'} → {change.category_name}
+            </div>
+          ))}
+          <div style={{ display: 'flex', gap: '10px', marginTop: '10px' }}>
+            <button className="btn btn-primary" onClick={() => handleApply(false)} disabled={preview.categorized === 0}>
+              Confirmar
+            </button>
+            <button className="btn btn-secondary" onClick={() => setPreview(null)}>Cancelar</button>
+          </div>
+        </div>
+      )}
+    </div>
+  );
+};
+
+export default CategorizationRules;
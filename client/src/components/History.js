@@ -1,5 +1,6 @@
 import React, { useState, useEffect } from 'react';
 import { PieChart, Pie, Cell, ResponsiveContainer, LineChart, Line, BarChart, Bar, XAxis, YAxis, CartesianGrid, Tooltip, Legend } from 'recharts';
+import IncomeTaxReport from './IncomeTaxReport';
 
 const API_URL = 'http://localhost:5000/api';
 
@@ -307,6 +308,8 @@ const History = () => {
           </div>
         )}
       </div>
+
+      <IncomeTaxReport />
     </div>
   );
 };
@@ -0,0 +1,195 @@
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const roleLabels = {
+  owner: 'Proprietário',
+  editor: 'Editor',
+  viewer: 'Leitor'
+};
+
+const Households = ({ onRefresh }) => {
+  const [households, setHouseholds] = useState([]);
+  const [accounts, setAccounts] = useState([]);
+  const [newName, setNewName] = useState('');
+  const [memberForms, setMemberForms] = useState({});
+
+  const fetchHouseholds = async () => {
+    try {
+      const [householdsRes, accountsRes] = await Promise.all([
+        fetch(`${API_URL}/households`),
+        fetch(`${API_URL}/accounts`)
+      ]);
+      const householdsData = await householdsRes.json();
+      const accountsData = await accountsRes.json();
+      setHouseholds(Array.isArray(householdsData) ? householdsData : []);
+      setAccounts(Array.isArray(accountsData) ? accountsData.filter(a => a.role === 'owner') : []);
+    } catch (error) {
+      console.error('Error fetching households:', error);
+    }
+  };
+
+  useEffect(() => {
+    fetchHouseholds();
+  }, []);
+
+  const request = async (url, method, body) => {
+    const response = await fetch(url, {
+      method,
+      headers: { 'Content-Type': 'application/json' },
+      body: body ? JSON.stringify(body) : undefined
+    });
+    const data = await response.json();
+    if (!response.ok) {
+      alert(data.error || 'Erro ao salvar');
+      return false;
+    }
+    fetchHouseholds();
+    if (onRefresh) onRefresh();
+    return true;
+  };
+
+  const handleCreate = async (e) => {
+    e.preventDefault();
+    if (await request(`${API_URL}/households`, 'POST', { name: newName })) {
+      setNewName('');
+    }
+  };
+
+  const updateMemberForm = (householdId, field, value) => {
+    setMemberForms(prev => ({
+      ...prev,
+      [householdId]: { username: '', role: 'viewer', ...prev[householdId], [field]: value }
+    }));
+  };
+
+  const handleAddMember = async (householdId) => {
+    const form = memberForms[householdId] || {};
+    if (await request(`${API_URL}/households/${householdId}/members`, 'POST', { username: form.username, role: form.role || 'viewer' })) {
+      updateMemberForm(householdId, 'username', '');
+    }
+  };
+
+  const handleRemoveMember = (householdId, userId) => {
+    if (window.confirm('Remover este membro? As contas que ele compartilhou deixarão de ser compartilhadas.')) {
+      request(`${API_URL}/households/${householdId}/members/${userId}`, 'DELETE');
+    }
+  };
+
+  const handleDelete = (householdId) => {
+    if (window.confirm('Excluir este grupo? As contas voltarão a ser visíveis apenas para seus donos.')) {
+      request(`${API_URL}/households/${householdId}`, 'DELETE');
+    }
+  };
+
+  return (
+    <div className="card" style={{ marginBottom: '24px' }}>
+      <h3 style={{ marginBottom: '20px', color: '#1a202c' }}>Contas Compartilhadas</h3>
+      <p style={{ color: '#6b7280', marginBottom: '15px' }}>
+        Crie um grupo familiar e escolha quais contas compartilhar. Editores podem lançar transações; leitores apenas visualizam.
+      </p>
+
+      <form onSubmit={handleCreate} style={{ display: 'flex', gap: '10px', marginBottom: '20px' }}>
+        <input
+          type="text"
+          value={newName}
+          onChange={(e) => setNewName(e.target.value)}
+          placeholder="Nome do grupo (ex: Casa)"
+          required
+          style={{ flex: 1, padding: '10px', border: '1px solid #d1d5db', borderRadius: '6px' }}
+        />
+        <button type="submit" className="btn btn-primary">Criar Grupo</button>
+      </form>
+
+      {households.map(household => {
+        const isOwner = household.role === 'owner';
+        const form = memberForms[household.id] || { username: '', role: 'viewer' };
+        const sharedIds = household.accounts.map(a => a.account_id);
+
+        return (
+          <div key={household.id} style={{ borderTop: '1px solid #e2e8f0', paddingTop: '15px', marginTop: '15px' }}>
+            <div style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center' }}>
+              <strong>{household.name}</strong>
+              <span style={{ color: '#6b7280', fontSize: '0.875rem' }}>{roleLabels[household.role]}</span>
+            </div>
+
+            <ul style={{ margin: '10px 0', paddingLeft: '20px', color: '#374151' }}>
+              {household.members.map(member => (
+                <li key={member.user_id}>
+                  {member.name} ({member.username}) — {roleLabels[member.role]}
+                  {isOwner && (
+                    <button
+                      className="btn btn-small btn-danger"
+                      style={{ marginLeft: '10px' }}
+                      onClick={() => handleRemoveMember(household.id, member.user_id)}
+                    >
+                      Remover
+                    </button>
+                  )}
+                </li>
+              ))}
+            </ul>
+
+            {isOwner && (
+              <div style={{ display: 'flex', gap: '10px', marginBottom: '10px' }}>
+                <input
+                  type="text"
+                  value={form.username}
+                  onChange={(e) => updateMemberForm(household.id, 'username', e.target.value)}
+                  placeholder="Usuário"
+                  style={{ flex: 1, padding: '8px', border: '1px solid #d1d5db', borderRadius: '6px' }}
+                />
+                <select
+                  value={form.role}
+                  onChange={(e) => updateMemberForm(household.id, 'role', e.target.value)}
+                  style={{ padding: '8px', border: '1px solid #d1d5db', borderRadius: '6px' }}
+                >
+                  <option value="viewer">Leitor</option>
+                  <option value="editor">Editor</option>
+                  <option value="owner">Proprietário</option>
+                </select>
+                <button className="btn btn-primary" onClick={() => handleAddMember(household.id)}>Adicionar</button>
+              </div>
+            )}
+
+            <div style={{ color: '#374151', fontSize: '0.875rem' }}>
+              <strong>Contas:</strong>
+              {household.accounts.length === 0 && <span style={{ color: '#6b7280' }}> nenhuma conta compartilhada</span>}
+              {household.accounts.map(account => (
+                <span key={account.account_id} style={{ marginLeft: '10px' }}>
+                  {account.name}
+                  <button
+                    className="btn btn-small btn-secondary"
+                    style={{ marginLeft: '5px' }}
+                    onClick={() => request(`${API_URL}/households/${household.id}/accounts/${account.account_id}`, 'DELETE')}
+                  >
+                    ✕
+                  </button>
+                </span>
+              ))}
+            </div>
+
+            <div style={{ display: 'flex', gap: '10px', marginTop: '10px' }}>
+              {accounts.filter(a => !sharedIds.includes(a.id)).map(account => (
+                <button
+                  key={account.id}
+                  className="btn btn-small btn-success"
+                  onClick={() => request(`${API_URL}/households/${household.id}/accounts`, 'POST', { account_id: account.id })}
+                >
+                  Compartilhar {account.name}
+                </button>
+              ))}
+              {isOwner && (
+                <button className="btn btn-small btn-danger" style={{ marginLeft: 'auto' }} onClick={() => handleDelete(household.id)}>
+                  Excluir Grupo
+                </button>
+              )}
+            </div>
+          </div>
+        );
+      })}
+    </div>
+  );
+};
+
+export default Households;
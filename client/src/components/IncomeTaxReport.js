@@ -0,0 +1,128 @@
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const sections = [
+  { key: 'health', title: 'Despesas médicas', pick: (report) => report.deductible_expenses.health },
+  { key: 'education', title: 'Despesas com instrução', pick: (report) => report.deductible_expenses.education },
+  { key: 'dividends', title: 'Dividendos (isentos)', pick: (report) => report.dividends },
+  { key: 'jcp', title: 'Juros sobre capital próprio (tributação exclusiva)', pick: (report) => report.jcp }
+];
+
+// Yearly summary for the income tax declaration, by default of the year
+// before, which is the one declared
+const IncomeTaxReport = () => {
+  const [year, setYear] = useState(new Date().getFullYear() - 1);
+  const [report, setReport] = useState(null);
+
+  useEffect(() => {
+    const fetchReport = async () => {
+      try {
+        const response = await fetch(`${API_URL}/reports/irpf?year=${year}`);
+        const data = await response.json();
+        setReport(response.ok ? data : null);
+      } catch (error) {
+        console.error('Error fetching income tax report:', error);
+      }
+    };
+    fetchReport();
+  }, [year]);
+
+  const formatCurrency = (value, currency) => new Intl.NumberFormat('pt-BR', {
+    style: 'currency',
+    currency: currency || 'BRL'
+  }).format(value || 0);
+
+  const handleExportJSON = () => {
+    const blob = new Blob([JSON.stringify(report, null, 2)], { type: 'application/json' });
+    const link = document.createElement('a');
+    link.href = URL.createObjectURL(blob);
+    link.download = `irpf-${year}.json`;
+    link.click();
+    URL.revokeObjectURL(link.href);
+  };
+
+  const years = Array.from({ length: 6 }, (_, i) => new Date().getFullYear() - i);
+  const rowStyle = { display: 'flex', justifyContent: 'space-between', padding: '6px 0', borderBottom: '1px solid #e5e7eb', fontSize: '0.9rem' };
+
+  return (
+    <div className="card" style={{ marginTop: '24px' }}>
+      <div style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', flexWrap: 'wrap', gap: '10px', marginBottom: '20px' }}>
+        <h3 style={{ color: '#1a202c' }}>Imposto de Renda (IRPF)</h3>
+        <div style={{ display: 'flex', gap: '10px' }}>
+          <select value={year} onChange={(e) => setYear(parseInt(e.target.value))}>
+            {years.map(y => <option key={y} value={y}>{y}</option>)}
+          </select>
+          <a className="btn btn-secondary" href={`${API_URL}/reports/irpf?year=${year}&format=csv`}>Exportar CSV</a>
+          <button className="btn btn-secondary" onClick={handleExportJSON} disabled={!report}>Exportar JSON</button>
+        </div>
+      </div>
+
+      {!report ? (
+        <p style={{ color: '#6b7280' }}>Carregando...</p>
+      ) : (
+        <>
+          {report.missingRates && (
+            <p style={{ color: '#b45309', marginBottom: '15px' }}>
+              Sem cotação para {report.missingRates.join(', ')}: esses valores não foram convertidos.
+            </p>
+          )}
+
+          {sections.map(({ key, title, pick }) => {
+            const section = pick(report);
+            return (
+              <div key={key} style={{ marginBottom: '20px' }}>
+                <h4 style={{ color: '#374151', marginBottom: '8px' }}>
+                  {title}: {formatCurrency(section.total, report.currency)}
+                </h4>
+                {section.payees.length === 0 && <p style={{ color: '#6b7280', fontSize: '0.9rem' }}>Nenhum lançamento.</p>}
+                {section.payees.map(payee => (
+                  <div key={payee.payee_id || 'none'} style={rowStyle}>
+                    <span>
+                      {payee.name || 'Sem favorecido'}
+                      <span style={{ color: '#6b7280' }}>
+                        {' '}· {payee.items.length} lançamento(s)
+                        {payee.items.some(item => item.attachment_count === 0) && ' · sem recibo anexado'}
+                      </span>
+                    </span>
+                    <strong>{formatCurrency(payee.total, report.currency)}</strong>
+                  </div>
+                ))}
+              </div>
+            );
+          })}
+
+          <div style={{ marginBottom: '20px' }}>
+            <h4 style={{ color: '#374151', marginBottom: '8px' }}>Bens e direitos</h4>
+            {report.assets.length === 0 && <p style={{ color: '#6b7280', fontSize: '0.9rem' }}>Nenhuma posição.</p>}
+            {report.assets.map(asset => (
+              <div key={asset.investment_id} style={rowStyle}>
+                <span>
+                  {asset.ticker} <span style={{ color: '#6b7280' }}>· {asset.type} · {asset.quantity} un.</span>
+                </span>
+                <span>
+                  31/12/{year - 1}: {formatCurrency(asset.previous_cost, asset.currency)} · 31/12/{year}: <strong>{formatCurrency(asset.cost, asset.currency)}</strong>
+                </span>
+              </div>
+            ))}
+          </div>
+
+          <div>
+            <h4 style={{ color: '#374151', marginBottom: '8px' }}>
+              Ganhos em vendas: {formatCurrency(report.realized_gains.total, report.currency)}
+            </h4>
+            {report.realized_gains.months.length === 0 && <p style={{ color: '#6b7280', fontSize: '0.9rem' }}>Nenhuma venda.</p>}
+            {report.realized_gains.months.map(month => (
+              <div key={`${month.month}-${month.type}`} style={rowStyle}>
+                <span>{month.month} · {month.type} <span style={{ color: '#6b7280' }}>· vendas de {formatCurrency(month.sales, report.currency)}</span></span>
+                <strong style={{ color: month.gain < 0 ? '#EF4444' : '#10B981' }}>{formatCurrency(month.gain, report.currency)}</strong>
+              </div>
+            ))}
+          </div>
+        </>
+      )}
+    </div>
+  );
+};
+
+export default IncomeTaxReport;
@@ -0,0 +1,91 @@
+import React, { useState } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const Login = ({ onLogin }) => {
+  const [mode, setMode] = useState('login');
+  const [formData, setFormData] = useState({ username: '', name: '', password: '' });
+  const [error, setError] = useState('');
+  const [loading, setLoading] = useState(false);
+
+  const handleChange = (e) => {
+    setFormData({ ...formData, [e.target.name]: e.target.value });
+  };
+
+  const login = async () => {
+    const response = await fetch(`${API_URL}/auth/login`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ username: formData.username, password: formData.password })
+    });
+    const data = await response.json();
+    if (!response.ok) {
+      throw new Error(data.error || 'Erro ao entrar');
+    }
+    onLogin(data.user);
+  };
+
+  const handleSubmit = async (e) => {
+    e.preventDefault();
+    setError('');
+    setLoading(true);
+
+    try {
+      if (mode === 'register') {
+        const response = await fetch(`${API_URL}/auth/register`, {
+          method: 'POST',
+          headers: { 'Content-Type': 'application/json' },
+          body: JSON.stringify(formData)
+        });
+        const data = await response.json();
+        if (!response.ok) {
+          throw new Error(data.error || 'Erro ao criar usuário');
+        }
+      }
+      await login();
+    } catch (err) {
+      setError(err.message);
+    } finally {
+      setLoading(false);
+    }
+  };
+
+  return (
+    <div style={{ maxWidth: '400px', margin: '80px auto' }}>
+      <div className="card">
+        <h3>{mode === 'login' ? 'Entrar' : 'Criar primeiro usuário'}</h3>
+        <form onSubmit={handleSubmit}>
+          <div className="form-group">
+            <label>Usuário</label>
+            <input type="text" name="username" value={formData.username} onChange={handleChange} required autoFocus />
+          </div>
+          {mode === 'register' && (
+            <div className="form-group">
+              <label>Nome</label>
+              <input type="text" name="name" value={formData.name} onChange={handleChange} />
+            </div>
+          )}
+          <div className="form-group">
+            <label>Senha</label>
+            <input type="password" name="password" value={formData.password} onChange={handleChange} required />
+          </div>
+          {error && (
+            <p style={{ color: '#dc2626', marginBottom: '15px' }}>{error}</p>
+          )}
+          <button type="submit" className="btn btn-primary" disabled={loading} style={{ width: '100%', justifyContent: 'center' }}>
+            {loading ? 'Aguarde...' : mode === 'login' ? 'Entrar' : 'Criar e entrar'}
+          </button>
+        </form>
+        <p style={{ color: '#6b7280', marginTop: '15px', fontSize: '0.875rem' }}>
+          {mode === 'login' ? (
+            <>Primeiro acesso? <a href="#" onClick={(e) => { e.preventDefault(); setMode('register'); }}>Criar usuário</a></>
+          ) : (
+            <>Já tem conta? <a href="#" onClick={(e) => { e.preventDefault(); setMode('login'); }}>Entrar</a></>
+          )}
+        </p>
+      </div>
+    </div>
+  );
+};
+
+export default Login;
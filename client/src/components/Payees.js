@@ -0,0 +1,160 @@
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const matchTypes = {
+  contains: 'contém',
+  starts_with: 'começa com',
+  regex: 'regex'
+};
+
+// One rule per line, "contém" unless prefixed: "^PAO ACUCAR" is read as a
+// regex and "gpa*" as "começa com"
+const parseRules = (text) =>
+  text.split('\n').map(line => line.trim()).filter(Boolean).map(line => {
+    if (line.startsWith('^')) return { match_type: 'regex', pattern: line };
+    if (line.endsWith('*')) return { match_type: 'starts_with', pattern: line.slice(0, -1) };
+    return { match_type: 'contains', pattern: line };
+  });
+
+const Payees = ({ onRefresh }) => {
+  const [payees, setPayees] = useState([]);
+  const [name, setName] = useState('');
+  const [rules, setRules] = useState('');
+  const [preview, setPreview] = useState(null);
+
+  const fetchPayees = async () => {
+    try {
+      const response = await fetch(`${API_URL}/payees`);
+      const data = await response.json();
+      setPayees(Array.isArray(data) ? data : []);
+    } catch (error) {
+      console.error('Error fetching payees:', error);
+    }
+  };
+
+  useEffect(() => {
+    fetchPayees();
+  }, []);
+
+  const handleCreate = async (e) => {
+    e.preventDefault();
+    try {
+      const response = await fetch(`${API_URL}/payees`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: JSON.stringify({ name, rules: parseRules(rules) })
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao criar favorecido');
+        return;
+      }
+      setName('');
+      setRules('');
+      fetchPayees();
+    } catch (error) {
+      console.error('Error creating payee:', error);
+      alert('Erro ao criar favorecido');
+    }
+  };
+
+  const handleDelete = async (id) => {
+    if (!window.confirm('Excluir este favorecido? As transações ficarão sem favorecido.')) {
+      return;
+    }
+    try {
+      await fetch(`${API_URL}/payees/${id}`, { method: 'DELETE' });
+      fetchPayees();
+      onRefresh();
+    } catch (error) {
+      console.error('Error deleting payee:', error);
+    }
+  };
+
+  // The first run only shows what would change; confirming applies it
+  const handleApply = async (dryRun) => {
+    try {
+      const response = await fetch(`${API_URL}/payees/apply${dryRun ? '?dry_run=true' : ''}`, { method: 'POST' });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao aplicar regras');
+        return;
+      }
+      if (dryRun) {
+        setPreview(data);
+        return;
+      }
+      setPreview(null);
+      alert(`${data.assigned} transação(ões) com favorecido.`);
+      fetchPayees();
+      onRefresh();
+    } catch (error) {
+      console.error('Error applying payee rules:', error);
+      alert('Erro ao aplicar regras');
+    }
+  };
+
+  const payeeName = (id) => (payees.find(p => p.id === id) || {}).name;
+
+  return (
+    <div className="card" style={{ marginBottom: '24px' }}>
+      <h3 style={{ marginBottom: '20px', color: '#1a202c' }}>Favorecidos</h3>
+      <p style={{ color: '#6b7280', marginBottom: '15px' }}>
+        Descrições como "UBER *TRIP 1234" e "Uber Trip" são reconhecidas pelo nome do favorecido ou pelas suas regras,
+        ignorando maiúsculas, acentos e números.
+      </p>
+
+      <form onSubmit={handleCreate} style={{ display: 'grid', gridTemplateColumns: '1fr 2fr auto', gap: '10px', marginBottom: '20px' }}>
+        <input type="text" placeholder="Nome" value={name} onChange={(e) => setName(e.target.value)} required />
+        <textarea
+          placeholder={'Regras, uma por linha: "uber trip" (contém), "gpa*" (começa com), "^PAO ACUCAR" (regex)'}
+          value={rules}
+          onChange={(e) => setRules(e.target.value)}
+          rows={2}
+        />
+        <button type="submit" className="btn btn-primary">Criar Favorecido</button>
+      </form>
+
+      {payees.map(payee => (
+        <div key={payee.id} style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', borderTop: '1px solid #e2e8f0', padding: '10px 0' }}>
+          <div>
+            <strong>{payee.name}</strong>
+            <span style={{ color: '#6b7280', fontSize: '0.8rem' }}> · {payee.transaction_count} transação(ões)</span>
+            <div style={{ color: '#6b7280', fontSize: '0.8rem' }}>
+              {payee.rules.map(rule => `${matchTypes[rule.match_type]} "${rule.pattern}"`).join(' ou ')}
+            </div>
+          </div>
+          <button className="btn btn-small btn-danger" onClick={() => handleDelete(payee.id)}>Excluir</button>
+        </div>
+      ))}
+
+      <div style={{ marginTop: '15px' }}>
+        <button className="btn btn-secondary" onClick={() => handleApply(true)} disabled={payees.length === 0}>
+          Aplicar às transações sem favorecido
+        </button>
+      </div>
+
+      {preview && (
+        <div style={{ marginTop: '15px', background: '#f8fafc', border: '1px solid #e2e8f0', borderRadius: '6px', padding: '12px' }}>
+          <p style={{ marginBottom: '10px' }}>
+            {preview.assigned} de {preview.examined} transação(ões) sem favorecido seriam atribuídas.
+          </p>
+          {preview.changes.slice(0, 20).map(change => (
+            <div key={change.transaction_id} style={{ fontSize: '0.85rem', color: '#374151' }}>
+              {new Date(change.date).toLocaleDateString('pt-BR')} · {change.description || 'Sem descrição'} → {payeeName(change.payee_id)}
+            </div>
+          ))}
+          <div style={{ display: 'flex', gap: '10px', marginTop: '10px' }}>
+            <button className="btn btn-primary" onClick={() => handleApply(false)} disabled={preview.assigned === 0}>
+              Confirmar
+            </button>
+            <button className="btn btn-secondary" onClick={() => setPreview(null)}>Cancelar</button>
+          </div>
+        </div>
+      )}
+    </div>
+  );
+};
+
+export default Payees;
@@ -1,18 +1,24 @@
 import React from 'react';
+import Households from './Households';
+import ApiTokens from './ApiTokens';
+import Categories from './Categories';
+import CategorizationRules from './CategorizationRules';
+import Payees from './Payees';
+import Trash from './Trash';
 
 const API_URL = 'http://localhost:5000/api';
 
-const Settings = ({ onRefresh }) => {
+const Settings = ({ onRefresh, refreshKey }) => {
   const handleClearData = async () => {
-    if (window.confirm('⚠️ ATENÇÃO: Esta ação irá excluir TODAS as transações. As contas e categorias serão mantidas. Tem certeza?')) {
-      if (window.confirm('Esta ação é IRREVERSÍVEL. Confirma a exclusão?')) {
+    if (window.confirm('⚠️ ATENÇÃO: Esta ação irá mover TODAS as transações para a lixeira. As contas e categorias serão mantidas. Tem certeza?')) {
+      if (window.confirm('Confirma a exclusão?')) {
         try {
           const response = await fetch(`${API_URL}/transactions/clear`, {
             method: 'DELETE',
           });
 
           if (response.ok) {
-            alert('Todas as transações foram excluídas com sucesso!');
+            alert('Todas as transações foram movidas para a lixeira.');
             onRefresh();
           } else {
             alert('Erro ao excluir transações');
@@ -57,6 +63,57 @@ const Settings = ({ onRefresh }) => {
       });
   };
 
+  const handleBackup = async () => {
+    try {
+      const response = await fetch(`${API_URL}/export`);
+      if (!response.ok) {
+        alert('Erro ao gerar backup');
+        return;
+      }
+      const blob = await response.blob();
+      const link = document.createElement('a');
+      const url = URL.createObjectURL(blob);
+      link.setAttribute('href', url);
+      link.setAttribute('download', `money-manager-${new Date().toISOString().split('T')[0]}.json`);
+      link.style.visibility = 'hidden';
+      document.body.appendChild(link);
+      link.click();
+      document.body.removeChild(link);
+    } catch (error) {
+      console.error('Error exporting backup:', error);
+      alert('Erro ao gerar backup');
+    }
+  };
+
+  const handleRestore = async (event) => {
+    const file = event.target.files[0];
+    event.target.value = '';
+    if (!file) {
+      return;
+    }
+    if (!window.confirm('Os dados do backup serão adicionados aos seus dados atuais. Deseja continuar?')) {
+      return;
+    }
+
+    try {
+      const response = await fetch(`${API_URL}/import`, {
+        method: 'POST',
+        headers: { 'Content-Type': 'application/json' },
+        body: await file.text(),
+      });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(`Erro ao restaurar backup: ${data.error}`);
+        return;
+      }
+      alert('Backup restaurado com sucesso!');
+      onRefresh();
+    } catch (error) {
+      console.error('Error importing backup:', error);
+      alert('Erro ao restaurar backup');
+    }
+  };
+
   return (
     <div>
       <h2 style={{ marginBottom: '32px', color: '#1a202c', fontSize: '1.5rem', fontWeight: '600', letterSpacing: '-0.3px' }}>Configurações</h2>
@@ -71,11 +128,40 @@ const Settings = ({ onRefresh }) => {
         </button>
       </div>
 
+      <div className="card" style={{ marginBottom: '24px' }}>
+        <h3 style={{ marginBottom: '20px', color: '#1a202c' }}>Backup</h3>
+        <p style={{ color: '#6b7280', marginBottom: '15px' }}>
+          Gere um backup completo (contas, categorias, transações, parcelamentos, investimentos e configurações)
+          ou restaure um backup gerado anteriormente.
+        </p>
+        <div style={{ display: 'flex', gap: '12px', alignItems: 'center' }}>
+          <button className="btn btn-primary" onClick={handleBackup}>
+            Gerar Backup
+          </button>
+          <label className="btn btn-secondary" style={{ cursor: 'pointer' }}>
+            Restaurar Backup
+            <input type="file" accept="application/json,.json" onChange={handleRestore} style={{ display: 'none' }} />
+          </label>
+        </div>
+      </div>
+
+      <Categories onRefresh={onRefresh} />
+
+      <CategorizationRules onRefresh={onRefresh} />
+
+      <Payees onRefresh={onRefresh} />
+
+      <Households onRefresh={onRefresh} />
+
+      <ApiTokens />
+
+      <Trash onRefresh={onRefresh} refreshKey={refreshKey} />
+
       <div className="card" style={{ marginBottom: '24px', border: '1px solid #fecaca' }}>
         <h3 style={{ marginBottom: '20px', color: '#dc2626' }}>Limpar Dados</h3>
         <p style={{ color: '#6b7280', marginBottom: '15px' }}>
-          <strong>Atenção:</strong> Esta ação irá excluir todas as transações. 
-          As contas e categorias serão mantidas. Esta ação é irreversível.
+          <strong>Atenção:</strong> Esta ação irá mover todas as transações para a lixeira.
+          As contas e categorias serão mantidas.
         </p>
         <button 
           className="btn btn-danger" 
@@ -1,15 +1,26 @@
-import React, { useState } from 'react';
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
 
 const TransactionFilters = ({ categories, onFilterChange }) => {
+  const [tags, setTags] = useState([]);
   const [filters, setFilters] = useState({
     period: 'current-month',
     category: '',
+    tag: '',
     type: '',
     search: '',
     startDate: '',
     endDate: ''
   });
 
+  useEffect(() => {
+    fetch(`${API_URL}/tags`)
+      .then(res => res.json())
+      .then(data => setTags(Array.isArray(data) ? data : []))
+      .catch(error => console.error('Error fetching tags:', error));
+  }, []);
+
   const handleFilterChange = (key, value) => {
     const newFilters = { ...filters, [key]: value };
     setFilters(newFilters);
@@ -110,6 +121,22 @@ const TransactionFilters = ({ categories, onFilterChange }) => {
           </select>
         </div>
 
+        <div className="form-group">
+          <label>Tag</label>
+          <select
+            value={filters.tag}
+            onChange={(e) => handleFilterChange('tag', e.target.value)}
+          >
+            <option value="">Todas</option>
+            <option value="none">Sem tag</option>
+            {tags.map(tag => (
+              <option key={tag.id} value={tag.id}>
+                #{tag.name} ({tag.transaction_count})
+              </option>
+            ))}
+          </select>
+        </div>
+
         <div className="form-group" style={{ gridColumn: '1 / -1' }}>
           <label>Buscar por descrição</label>
           <input
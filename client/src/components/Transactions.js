@@ -1,19 +1,70 @@
-import React, { useState, useMemo } from 'react';
+import React, { useState, useEffect } from 'react';
 import TransactionFilters from './TransactionFilters';
+import Attachments from './Attachments';
+import { parseTags } from './AddTransaction';
 
 const API_URL = 'http://localhost:5000/api';
+const PAGE_SIZE = 50;
 
-const Transactions = ({ transactions, categories, onRefresh }) => {
+const getPeriodDates = (filters) => {
+  const now = new Date();
+  let start, end;
+
+  switch (filters.period) {
+    case 'current-month':
+      start = new Date(now.getFullYear(), now.getMonth(), 1);
+      end = new Date(now.getFullYear(), now.getMonth() + 1, 0);
+      break;
+    case 'last-3-months':
+      start = new Date(now.getFullYear(), now.getMonth() - 2, 1);
+      end = new Date(now.getFullYear(), now.getMonth() + 1, 0);
+      break;
+    case 'current-year':
+      start = new Date(now.getFullYear(), 0, 1);
+      end = new Date(now.getFullYear(), 11, 31);
+      break;
+    case 'custom':
+      return { start: filters.startDate, end: filters.endDate };
+    default:
+      return { start: '', end: '' };
+  }
+
+  return {
+    start: start.toISOString().split('T')[0],
+    end: end.toISOString().split('T')[0]
+  };
+};
+
+// Query string of the filters; the server does the filtering
+const buildQuery = (filters) => {
+  const params = new URLSearchParams();
+  const { start, end } = getPeriodDates(filters);
+  if (start) params.set('start', start);
+  if (end) params.set('end', end);
+  if (filters.type) params.set('type', filters.type);
+  if (filters.category) params.set('category_id', filters.category);
+  if (filters.tag) params.set('tag_id', filters.tag);
+  if (filters.search.trim()) params.set('q', filters.search.trim());
+  return params;
+};
+
+const Transactions = ({ categories, onRefresh, refreshKey }) => {
   const [filters, setFilters] = useState({
     period: 'all',
     category: '',
+    tag: '',
     type: '',
     search: '',
     startDate: '',
     endDate: ''
   });
+  const [filteredTransactions, setFilteredTransactions] = useState([]);
+  const [total, setTotal] = useState(0);
+  const [nextCursor, setNextCursor] = useState(null);
+  const [loading, setLoading] = useState(false);
   const [editingId, setEditingId] = useState(null);
   const [editForm, setEditForm] = useState({});
+  const [attachmentsId, setAttachmentsId] = useState(null);
 
   const formatCurrency = (value) => {
     return new Intl.NumberFormat('pt-BR', {
@@ -22,69 +73,32 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
     }).format(value || 0);
   };
 
-  const filteredTransactions = useMemo(() => {
-    const getPeriodDates = (period) => {
-      const now = new Date();
-      let start, end;
-
-      switch (period) {
-        case 'current-month':
-          start = new Date(now.getFullYear(), now.getMonth(), 1);
-          end = new Date(now.getFullYear(), now.getMonth() + 1, 0);
-          break;
-        case 'last-3-months':
-          start = new Date(now.getFullYear(), now.getMonth() - 2, 1);
-          end = new Date(now.getFullYear(), now.getMonth() + 1, 0);
-          break;
-        case 'current-year':
-          start = new Date(now.getFullYear(), 0, 1);
-          end = new Date(now.getFullYear(), 11, 31);
-          break;
-        case 'custom':
-          return { start: filters.startDate, end: filters.endDate };
-        default:
-          return { start: null, end: null };
-      }
-
-      return {
-        start: start.toISOString().split('T')[0],
-        end: end.toISOString().split('T')[0]
-      };
-    };
-
-    let filtered = [...transactions];
+  const fetchPage = async (cursor) => {
+    const params = buildQuery(filters);
+    params.set('limit', PAGE_SIZE);
+    if (cursor) params.set('cursor', cursor);
 
-    // Filter by period
-    if (filters.period !== 'all') {
-      const periodDates = getPeriodDates(filters.period);
-      if (periodDates.start && periodDates.end) {
-        filtered = filtered.filter(t => {
-          const date = t.date.split('T')[0];
-          return date >= periodDates.start && date <= periodDates.end;
-        });
+    setLoading(true);
+    try {
+      const response = await fetch(`${API_URL}/transactions?${params}`);
+      const data = await response.json();
+      if (!response.ok) {
+        console.error('Error fetching transactions:', data.error);
+        return;
       }
+      setFilteredTransactions(prev => (cursor ? [...prev, ...data] : data));
+      setTotal(parseInt(response.headers.get('X-Total-Count'), 10) || 0);
+      setNextCursor(response.headers.get('X-Next-Cursor'));
+    } catch (error) {
+      console.error('Error fetching transactions:', error);
+    } finally {
+      setLoading(false);
     }
+  };
 
-    // Filter by type
-    if (filters.type) {
-      filtered = filtered.filter(t => t.type === filters.type);
-    }
-
-    // Filter by category
-    if (filters.category) {
-      filtered = filtered.filter(t => t.category_id === parseInt(filters.category));
-    }
-
-    // Filter by search
-    if (filters.search) {
-      const searchLower = filters.search.toLowerCase();
-      filtered = filtered.filter(t => 
-        (t.description || '').toLowerCase().includes(searchLower)
-      );
-    }
-
-    return filtered;
-  }, [transactions, filters.period, filters.type, filters.category, filters.search, filters.startDate, filters.endDate]);
+  useEffect(() => {
+    fetchPage(null);
+  }, [filters, refreshKey]);
 
   const handleDelete = async (id) => {
     if (window.confirm('Tem certeza que deseja excluir esta transação?')) {
@@ -108,13 +122,50 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
     setEditForm({
       account_id: transaction.account_id,
       category_id: transaction.category_id || '',
+      // Kept as it is; without one the server picks it from the description
+      payee_id: transaction.payee_id || null,
       type: transaction.type,
       amount: transaction.amount,
       description: transaction.description || '',
+      tags: (transaction.tags || []).map(tag => tag.name).join(', '),
+      splits: (transaction.splits || []).map(split => ({
+        category_id: split.category_id || '',
+        amount: split.amount,
+        description: split.description || ''
+      })),
       date: transaction.date.split('T')[0]
     });
   };
 
+  const updateSplit = (index, changes) => {
+    setEditForm({
+      ...editForm,
+      splits: editForm.splits.map((split, i) => (i === index ? { ...split, ...changes } : split))
+    });
+  };
+
+  // Splitting starts with two lines: the whole amount and an empty one
+  const addSplit = () => {
+    const splits = editForm.splits.length > 0
+      ? [...editForm.splits, { category_id: '', amount: '', description: '' }]
+      : [
+          { category_id: editForm.category_id, amount: editForm.amount, description: '' },
+          { category_id: '', amount: '', description: '' }
+        ];
+    setEditForm({ ...editForm, splits });
+  };
+
+  const removeSplit = (index) => {
+    const splits = editForm.splits.filter((_, i) => i !== index);
+    // A single line is no split
+    setEditForm({ ...editForm, splits: splits.length > 1 ? splits : [] });
+  };
+
+  const splitRemainder = () => {
+    const allocated = (editForm.splits || []).reduce((sum, split) => sum + (parseFloat(split.amount) || 0), 0);
+    return (parseFloat(editForm.amount) || 0) - allocated;
+  };
+
   const handleUpdate = async (e) => {
     e.preventDefault();
     try {
@@ -127,7 +178,13 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
           ...editForm,
           account_id: parseInt(editForm.account_id),
           amount: parseFloat(editForm.amount),
-          category_id: editForm.category_id ? parseInt(editForm.category_id) : null
+          category_id: editForm.category_id ? parseInt(editForm.category_id) : null,
+          tags: parseTags(editForm.tags),
+          splits: editForm.splits.map(split => ({
+            category_id: split.category_id ? parseInt(split.category_id) : null,
+            amount: parseFloat(split.amount),
+            description: split.description || null
+          }))
         }),
       });
 
@@ -136,7 +193,8 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
         setEditForm({});
         onRefresh();
       } else {
-        alert('Erro ao atualizar transação');
+        const data = await response.json();
+        alert('Erro ao atualizar transação: ' + (data.error || 'Erro desconhecido'));
       }
     } catch (error) {
       console.error('Error updating transaction:', error);
@@ -144,16 +202,32 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
     }
   };
 
-  const handleExport = () => {
+  const handleExport = async () => {
+    // Every transaction matching the filters, not only the loaded pages
+    let transactions;
+    try {
+      const response = await fetch(`${API_URL}/transactions?${buildQuery(filters)}`);
+      transactions = await response.json();
+      if (!response.ok) {
+        throw new Error(transactions.error);
+      }
+    } catch (error) {
+      console.error('Error exporting transactions:', error);
+      alert('Erro ao exportar transações');
+      return;
+    }
+
     const csv = [
-      ['Data', 'Tipo', 'Descrição', 'Categoria', 'Valor', 'Conta'].join(','),
-      ...filteredTransactions.map(t => [
+      ['Data', 'Tipo', 'Descrição', 'Favorecido', 'Categoria', 'Valor', 'Conta', 'Tags'].join(','),
+      ...transactions.map(t => [
         new Date(t.date).toLocaleDateString('pt-BR'),
         t.type === 'income' ? 'Receita' : 'Despesa',
         `"${(t.description || '').replace(/"/g, '""')}"`,
+        `"${(t.payee_name || '').replace(/"/g, '""')}"`,
         t.category_name || 'Sem categoria',
         t.amount.toFixed(2),
-        t.account_name || 'Sem conta'
+        t.account_name || 'Sem conta',
+        `"${(t.tags || []).map(tag => tag.name).join(', ')}"`
       ].join(','))
     ].join('\n');
 
@@ -185,7 +259,7 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
       />
 
       <div style={{ marginTop: '24px', marginBottom: '24px', padding: '12px 16px', background: '#f8fafc', border: '1px solid #e2e8f0', borderRadius: '6px', fontSize: '0.875rem', color: '#64748b' }}>
-        <strong style={{ color: '#1a202c' }}>Total encontrado: {filteredTransactions.length} transação(ões)</strong>
+        <strong style={{ color: '#1a202c' }}>Total encontrado: {total} transação(ões)</strong>
       </div>
 
       {filteredTransactions.length === 0 ? (
@@ -253,6 +327,60 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
                         onChange={(e) => setEditForm({ ...editForm, description: e.target.value })}
                       />
                     </div>
+                    <div className="form-group" style={{ gridColumn: '1 / -1' }}>
+                      <label>Tags</label>
+                      <input
+                        type="text"
+                        value={editForm.tags}
+                        placeholder="Ex: viagem, reembolsável"
+                        onChange={(e) => setEditForm({ ...editForm, tags: e.target.value })}
+                      />
+                    </div>
+                    <div className="form-group" style={{ gridColumn: '1 / -1' }}>
+                      <label>Divisão entre categorias</label>
+                      {editForm.splits.map((split, index) => (
+                        <div key={index} style={{ display: 'flex', gap: '8px', marginBottom: '8px' }}>
+                          <select
+                            value={split.category_id}
+                            onChange={(e) => updateSplit(index, { category_id: e.target.value })}
+                          >
+                            <option value="">Sem categoria</option>
+                            {filteredCategories.map(cat => (
+                              <option key={cat.id} value={cat.id}>
+                                {cat.icon} {cat.name}
+                              </option>
+                            ))}
+                          </select>
+                          <input
+                            type="number"
+                            step="0.01"
+                            min="0.01"
+                            value={split.amount}
+                            onChange={(e) => updateSplit(index, { amount: e.target.value })}
+                            required
+                          />
+                          <input
+                            type="text"
+                            placeholder="Descrição"
+                            value={split.description}
+                            onChange={(e) => updateSplit(index, { description: e.target.value })}
+                          />
+                          <button type="button" className="btn btn-danger" onClick={() => removeSplit(index)}>
+                            ✕
+                          </button>
+                        </div>
+                      ))}
+                      <div style={{ display: 'flex', alignItems: 'center', gap: '12px' }}>
+                        <button type="button" className="btn btn-secondary" onClick={addSplit}>
+                          Dividir
+                        </button>
+                        {editForm.splits.length > 0 && Math.abs(splitRemainder()) >= 0.005 && (
+                          <span style={{ color: '#dc2626', fontSize: '0.875rem' }}>
+                            Falta distribuir {formatCurrency(splitRemainder())}
+                          </span>
+                        )}
+                      </div>
+                    </div>
                   </div>
                   <div style={{ display: 'flex', gap: '10px', marginTop: '15px' }}>
                     <button type="submit" className="btn btn-primary">
@@ -279,15 +407,45 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
                       {transaction.description || 'Sem descrição'}
                     </h4>
                     <p>
-                      {transaction.account_name} • {transaction.category_name || 'Sem categoria'} • 
+                      {transaction.account_name} • {transaction.payee_name && `${transaction.payee_name} • `}{transaction.category_name || 'Sem categoria'} • 
                       {' '}{new Date(transaction.date).toLocaleDateString('pt-BR')}
                     </p>
+                    {transaction.splits && transaction.splits.length > 0 && (
+                      <p style={{ fontSize: '0.8rem', color: '#64748b' }}>
+                        {transaction.splits.map(split => (
+                          `${split.category_icon || ''} ${split.category_name || 'Sem categoria'} ${formatCurrency(split.amount)}`
+                        )).join(' • ')}
+                      </p>
+                    )}
+                    {transaction.tags && transaction.tags.length > 0 && (
+                      <div style={{ display: 'flex', flexWrap: 'wrap', gap: '6px', marginTop: '6px' }}>
+                        {transaction.tags.map(tag => (
+                          <span
+                            key={tag.id}
+                            style={{ padding: '2px 8px', borderRadius: '10px', fontSize: '0.75rem', color: 'white', background: tag.color }}
+                          >
+                            #{tag.name}
+                          </span>
+                        ))}
+                      </div>
+                    )}
+                    {attachmentsId === transaction.id && (
+                      <Attachments transactionId={transaction.id} onChange={onRefresh} />
+                    )}
                   </div>
                   <div style={{ display: 'flex', alignItems: 'center', gap: '15px' }}>
                     <div className={`transaction-amount ${transaction.type}`}>
                       {transaction.type === 'income' ? '+' : '-'}
                       {formatCurrency(transaction.amount)}
                     </div>
+                    <button
+                      className="btn btn-secondary"
+                      style={{ padding: '8px 12px', fontSize: '0.9rem' }}
+                      onClick={() => setAttachmentsId(attachmentsId === transaction.id ? null : transaction.id)}
+                      title="Anexos"
+                    >
+                      📎 {transaction.attachment_count || ''}
+                    </button>
                     <button 
                       className="btn" 
                       style={{ padding: '8px 12px', fontSize: '0.9rem', background: '#3B82F6', color: 'white' }}
@@ -309,6 +467,13 @@ const Transactions = ({ transactions, categories, onRefresh }) => {
               )}
             </div>
           ))}
+          {nextCursor && (
+            <div style={{ textAlign: 'center', marginTop: '16px' }}>
+              <button className="btn btn-secondary" onClick={() => fetchPage(nextCursor)} disabled={loading}>
+                {loading ? 'Carregando...' : `Carregar mais (${filteredTransactions.length} de ${total})`}
+              </button>
+            </div>
+          )}
         </div>
       )}
     </div>
@@ -0,0 +1,83 @@
+import React, { useState, useEffect } from 'react';
+
+const API_URL = 'http://localhost:5000/api';
+
+const Trash = ({ onRefresh, refreshKey }) => {
+  const [trash, setTrash] = useState({ accounts: [], transactions: [], retention_days: 30 });
+
+  const fetchTrash = async () => {
+    try {
+      const response = await fetch(`${API_URL}/trash`);
+      const data = await response.json();
+      if (response.ok) {
+        setTrash(data);
+      }
+    } catch (error) {
+      console.error('Error fetching trash:', error);
+    }
+  };
+
+  useEffect(() => {
+    fetchTrash();
+  }, [refreshKey]);
+
+  const restore = async (url) => {
+    try {
+      const response = await fetch(url, { method: 'POST' });
+      const data = await response.json();
+      if (!response.ok) {
+        alert(data.error || 'Erro ao restaurar');
+        return;
+      }
+      fetchTrash();
+      onRefresh();
+    } catch (error) {
+      console.error('Error restoring:', error);
+      alert('Erro ao restaurar');
+    }
+  };
+
+  const formatDate = (value) => (value ? new Date(value).toLocaleDateString('pt-BR') : '');
+  const isEmpty = trash.accounts.length === 0 && trash.transactions.length === 0;
+
+  return (
+    <div className="card" style={{ marginBottom: '24px' }}>
+      <h3 style={{ marginBottom: '20px', color: '#1a202c' }}>Lixeira</h3>
+      <p style={{ color: '#6b7280', marginBottom: '15px' }}>
+        Contas e transações excluídas ficam aqui por {trash.retention_days} dias antes de serem apagadas definitivamente.
+      </p>
+
+      {isEmpty && <p style={{ color: '#6b7280' }}>A lixeira está vazia.</p>}
+
+      {trash.accounts.map(account => (
+        <div key={`account-${account.id}`} style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', borderTop: '1px solid #e2e8f0', padding: '10px 0' }}>
+          <div>
+            <strong>Conta: {account.name}</strong>
+            <div style={{ color: '#6b7280', fontSize: '0.8rem' }}>
+              {account.transaction_count} transações · excluída em {formatDate(account.deleted_at)} · apagada em {formatDate(account.purge_at)}
+            </div>
+          </div>
+          <button className="btn btn-small btn-success" onClick={() => restore(`${API_URL}/trash/accounts/${account.id}/restore`)}>
+            Restaurar
+          </button>
+        </div>
+      ))}
+
+      {trash.transactions.map(t => (
+        <div key={`transaction-${t.id}`} style={{ display: 'flex', justifyContent: 'space-between', alignItems: 'center', borderTop: '1px solid #e2e8f0', padding: '10px 0' }}>
+          <div>
+            <strong>{t.description || t.category_name || 'Transação'}</strong> — {t.type === 'income' ? '+' : '-'}{t.amount.toFixed(2)} {t.currency}
+            <div style={{ color: '#6b7280', fontSize: '0.8rem' }}>
+              {t.account_name} · {formatDate(t.date)} · excluída em {formatDate(t.deleted_at)}
+            </div>
+          </div>
+          <button className="btn btn-small btn-success" onClick={() => restore(`${API_URL}/trash/transactions/${t.id}/restore`)}>
+            Restaurar
+          </button>
+        </div>
+      ))}
+    </div>
+  );
+};
+
+export default Trash;
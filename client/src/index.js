@@ -3,6 +3,18 @@ import ReactDOM from 'react-dom/client';
 import './index.css';
 import App from './App';
 
+// The API authenticates with a session cookie, so every request to it has to
+// carry credentials
+const API_ORIGIN = 'http://localhost:5000';
+const originalFetch = window.fetch.bind(window);
+window.fetch = (input, init = {}) => {
+  const url = typeof input === 'string' ? input : input.url;
+  if (url && url.startsWith(API_ORIGIN)) {
+    return originalFetch(input, { credentials: 'include', ...init });
+  }
+  return originalFetch(input, init);
+};
+
 const root = ReactDOM.createRoot(document.getElementById('root'));
 root.render(
   <React.StrictMode>
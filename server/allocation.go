@@ -0,0 +1,393 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Deviation (in percentage points) from a type target before the analysis
+// warns about it.
+const allocationDriftTolerance = 5.0
+
+// AllocationTarget is the desired weight of an asset type or of a single
+// ticker, as a percentage of the whole portfolio.
+type AllocationTarget struct {
+	ID            int     `json:"id"`
+	Scope         string  `json:"scope"`
+	Key           string  `json:"key"`
+	Type          *string `json:"type"`
+	TargetPercent float64 `json:"target_percent"`
+	UpdatedAt     string  `json:"updated_at"`
+}
+
+func loadAllocationTargets(userID int) ([]AllocationTarget, error) {
+	rows, err := db.Query(`
+		SELECT id, scope, key, type, target_percent, updated_at
+		FROM allocation_targets
+		WHERE user_id = ?
+		ORDER BY scope DESC, target_percent DESC
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	targets := []AllocationTarget{}
+	for rows.Next() {
+		var t AllocationTarget
+		if err := rows.Scan(&t.ID, &t.Scope, &t.Key, &t.Type, &t.TargetPercent, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		targets = append(targets, t)
+	}
+	return targets, rows.Err()
+}
+
+// Targets per asset type (FII, Ação, ETF...). Empty when none were defined.
+func loadTypeTargets(userID int) (map[string]float64, error) {
+	targets, err := loadAllocationTargets(userID)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]float64)
+	for _, t := range targets {
+		if t.Scope == "type" {
+			result[t.Key] = t.TargetPercent
+		}
+	}
+	return result, nil
+}
+
+// Get allocation targets
+func getAllocationTargets(c *gin.Context) {
+	targets, err := loadAllocationTargets(currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, targets)
+}
+
+// Replace allocation targets. Type targets must not add up to more than 100%
+// and ticker targets of a type must fit in that type's target.
+func saveAllocationTargets(c *gin.Context) {
+	var targets []AllocationTarget
+	if err := c.ShouldBindJSON(&targets); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID := currentUserID(c)
+
+	typeTotal := 0.0
+	typeTargets := make(map[string]float64)
+	tickerTotals := make(map[string]float64)
+	seen := make(map[string]bool)
+	for i := range targets {
+		t := &targets[i]
+		t.Key = strings.TrimSpace(t.Key)
+		if t.Scope != "type" && t.Scope != "ticker" {
+			c.JSON(400, gin.H{"error": "scope must be 'type' or 'ticker'"})
+			return
+		}
+		if t.Key == "" {
+			c.JSON(400, gin.H{"error": "key is required"})
+			return
+		}
+		if t.TargetPercent < 0 || t.TargetPercent > 100 {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("%s: target_percent must be between 0 and 100", t.Key)})
+			return
+		}
+		if seen[t.Scope+":"+t.Key] {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("%s: duplicated target", t.Key)})
+			return
+		}
+		seen[t.Scope+":"+t.Key] = true
+
+		if t.Scope == "type" {
+			t.Type = nil
+			typeTotal += t.TargetPercent
+			typeTargets[t.Key] = t.TargetPercent
+			continue
+		}
+
+		t.Key = strings.ToUpper(t.Key)
+		if t.Type == nil || *t.Type == "" {
+			// Use the type of the position when the ticker is already owned
+			var invType string
+			if err := db.QueryRow("SELECT type FROM investments WHERE ticker = ? AND user_id = ? LIMIT 1", t.Key, userID).Scan(&invType); err != nil {
+				c.JSON(400, gin.H{"error": fmt.Sprintf("%s: type is required for tickers not in the portfolio", t.Key)})
+				return
+			}
+			t.Type = &invType
+		}
+		tickerTotals[*t.Type] += t.TargetPercent
+	}
+
+	if typeTotal > 100.0001 {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Type targets add up to %.2f%%, more than 100%%", typeTotal)})
+		return
+	}
+	for invType, total := range tickerTotals {
+		if typeTarget, ok := typeTargets[invType]; ok && total > typeTarget+0.0001 {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Ticker targets of %s add up to %.2f%%, more than the type target of %.2f%%", invType, total, typeTarget)})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.Exec("DELETE FROM allocation_targets WHERE user_id = ?", userID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, t := range targets {
+		_, err = tx.Exec(
+			"INSERT INTO allocation_targets (user_id, scope, key, type, target_percent) VALUES (?, ?, ?, ?, ?)",
+			userID, t.Scope, t.Key, t.Type, t.TargetPercent,
+		)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	saved, err := loadAllocationTargets(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Allocation targets saved successfully", "targets": saved})
+}
+
+type rebalancePosition struct {
+	Ticker        string   `json:"ticker"`
+	Type          string   `json:"type"`
+	CurrentValue  float64  `json:"current_value"`
+	CurrentWeight float64  `json:"current_weight"`
+	TargetWeight  float64  `json:"target_weight"`
+	TargetValue   float64  `json:"target_value"`
+	BuyAmount     float64  `json:"buy_amount"`
+	Price         *float64 `json:"price"`
+	Quantity      *float64 `json:"quantity"`
+	FinalWeight   float64  `json:"final_weight"`
+
+	// Bought in fractions regardless of the request (crypto, US stocks)
+	fractional bool
+}
+
+// Compute how a new contribution should be split across assets to move the
+// portfolio towards the allocation targets, buying only. Each ticker gets its
+// own target or, when it has none, an equal share of what is left of its
+// type's target. The contribution and the plan are in the user's base
+// currency, positions in other currencies are converted at today's rate.
+func getRebalancePlan(c *gin.Context) {
+	var req struct {
+		Amount     float64 `json:"amount"`
+		Fractional bool    `json:"fractional"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Amount <= 0 {
+		c.JSON(400, gin.H{"error": "amount must be greater than zero"})
+		return
+	}
+
+	userID := currentUserID(c)
+	targets, err := loadAllocationTargets(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if len(targets) == 0 {
+		c.JSON(400, gin.H{"error": "No allocation targets defined"})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT ticker, type, market, currency, COALESCE(current_value, total_invested), COALESCE(current_price, average_price)
+		FROM investments
+		WHERE user_id = ?
+	`, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	type holding struct {
+		ticker, invType, market, currency string
+		value, price                      float64
+	}
+	var holdings []holding
+	for rows.Next() {
+		var h holding
+		if err := rows.Scan(&h.ticker, &h.invType, &h.market, &h.currency, &h.value, &h.price); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		holdings = append(holdings, h)
+	}
+	rows.Close()
+
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+	today := time.Now().Format("2006-01-02")
+	byTicker := make(map[string]*rebalancePosition)
+	var positions []*rebalancePosition
+	for _, h := range holdings {
+		value := fx.convert(h.value, h.currency, currency, today)
+		price := fx.convert(h.price, h.currency, currency, today)
+		ticker := strings.ToUpper(h.ticker)
+		p, ok := byTicker[ticker]
+		if !ok {
+			p = &rebalancePosition{Ticker: ticker, Type: h.invType, fractional: markets[h.market].Fractional}
+			byTicker[ticker] = p
+			positions = append(positions, p)
+		}
+		p.CurrentValue += value
+		if price > 0 {
+			p.Price = &price
+		}
+	}
+
+	plan := planRebalance(positions, targets, req.Amount, req.Fractional)
+	response := gin.H{
+		"amount":           req.Amount,
+		"currency":         currency,
+		"portfolio_value":  plan.PortfolioValue,
+		"final_value":      plan.FinalValue,
+		"unallocated":      plan.Unallocated,
+		"targets_coverage": plan.TargetsCoverage,
+		"plan":             plan.Positions,
+	}
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		response["missingRates"] = missing
+	}
+	c.JSON(200, response)
+}
+
+type rebalancePlan struct {
+	PortfolioValue  float64
+	FinalValue      float64
+	Unallocated     float64
+	TargetsCoverage float64
+	Positions       []rebalancePosition
+}
+
+// Split a contribution across the positions of a portfolio, all valued in the
+// same currency, to move it towards the targets. Tickers with a target but no
+// position are added. When the contribution can't close every gap it is split
+// in proportion to the gaps; what is left over after closing them follows the
+// target weights. Whole units are bought unless fractional is set or the
+// market trades fractions.
+func planRebalance(positions []*rebalancePosition, targets []AllocationTarget, amount float64, fractional bool) rebalancePlan {
+	byTicker := make(map[string]*rebalancePosition)
+	total := 0.0
+	for _, p := range positions {
+		byTicker[p.Ticker] = p
+		total += p.CurrentValue
+	}
+
+	typeTargets := make(map[string]float64)
+	tickerTargets := make(map[string]float64)
+	for _, t := range targets {
+		if t.Scope == "type" {
+			typeTargets[t.Key] = t.TargetPercent
+			continue
+		}
+		tickerTargets[t.Key] = t.TargetPercent
+		if _, ok := byTicker[t.Key]; !ok {
+			market := normalizeMarket("", t.Key)
+			p := &rebalancePosition{Ticker: t.Key, Type: *t.Type, fractional: markets[market].Fractional}
+			byTicker[t.Key] = p
+			positions = append(positions, p)
+		}
+	}
+
+	// Split what is left of each type target among its untargeted tickers
+	explicitByType := make(map[string]float64)
+	untargetedByType := make(map[string]int)
+	for _, p := range positions {
+		if target, ok := tickerTargets[p.Ticker]; ok {
+			explicitByType[p.Type] += target
+		} else {
+			untargetedByType[p.Type]++
+		}
+	}
+	for _, p := range positions {
+		if target, ok := tickerTargets[p.Ticker]; ok {
+			p.TargetWeight = target
+		} else if typeTarget, ok := typeTargets[p.Type]; ok {
+			p.TargetWeight = math.Max(0, typeTarget-explicitByType[p.Type]) / float64(untargetedByType[p.Type])
+		}
+	}
+
+	newTotal := total + amount
+	deficitTotal := 0.0
+	weightTotal := 0.0
+	for _, p := range positions {
+		p.TargetValue = newTotal * p.TargetWeight / 100
+		deficitTotal += math.Max(0, p.TargetValue-p.CurrentValue)
+		weightTotal += p.TargetWeight
+	}
+
+	for _, p := range positions {
+		deficit := math.Max(0, p.TargetValue-p.CurrentValue)
+		if deficitTotal >= amount {
+			// Not enough to reach every target: split in proportion to how far
+			// each asset is below its target
+			p.BuyAmount = amount * deficit / deficitTotal
+		} else {
+			p.BuyAmount = deficit
+			if weightTotal > 0 {
+				p.BuyAmount += (amount - deficitTotal) * p.TargetWeight / weightTotal
+			}
+		}
+	}
+
+	unallocated := amount
+	plan := []rebalancePosition{}
+	for _, p := range positions {
+		if p.Price != nil && p.BuyAmount > 0 {
+			quantity := p.BuyAmount / *p.Price
+			if !fractional && !p.fractional {
+				quantity = math.Floor(quantity)
+				p.BuyAmount = quantity * *p.Price
+			}
+			p.Quantity = &quantity
+		}
+		p.BuyAmount = math.Round(p.BuyAmount*100) / 100
+		unallocated -= p.BuyAmount
+
+		if total > 0 {
+			p.CurrentWeight = p.CurrentValue / total * 100
+		}
+		p.FinalWeight = (p.CurrentValue + p.BuyAmount) / newTotal * 100
+		plan = append(plan, *p)
+	}
+	sort.SliceStable(plan, func(i, j int) bool { return plan[i].BuyAmount > plan[j].BuyAmount })
+
+	return rebalancePlan{
+		PortfolioValue:  total,
+		FinalValue:      newTotal,
+		Unallocated:     math.Round(unallocated*100) / 100,
+		TargetsCoverage: weightTotal,
+		Positions:       plan,
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import "testing"
+
+func TestPlanRebalance(t *testing.T) {
+	price := func(v float64) *float64 { return &v }
+	typeTarget := func(key string, percent float64) AllocationTarget {
+		return AllocationTarget{Scope: "type", Key: key, TargetPercent: percent}
+	}
+	tickerTarget := func(key, invType string, percent float64) AllocationTarget {
+		return AllocationTarget{Scope: "ticker", Key: key, Type: &invType, TargetPercent: percent}
+	}
+
+	tests := []struct {
+		name            string
+		positions       []*rebalancePosition
+		targets         []AllocationTarget
+		amount          float64
+		fractional      bool
+		wantBuy         map[string]float64
+		wantQuantity    map[string]float64
+		wantWeight      map[string]float64
+		wantUnallocated float64
+	}{
+		{
+			name: "contribution goes to the asset below its target",
+			positions: []*rebalancePosition{
+				{Ticker: "MXRF11", Type: "FII", CurrentValue: 600, Price: price(10)},
+				{Ticker: "ITSA4", Type: "Ação", CurrentValue: 400, Price: price(20)},
+			},
+			targets:      []AllocationTarget{typeTarget("FII", 50), typeTarget("Ação", 50)},
+			amount:       200,
+			wantBuy:      map[string]float64{"MXRF11": 0, "ITSA4": 200},
+			wantQuantity: map[string]float64{"ITSA4": 10},
+		},
+		{
+			name: "what is left after the gaps follows the targets",
+			positions: []*rebalancePosition{
+				{Ticker: "MXRF11", Type: "FII", CurrentValue: 500, Price: price(10)},
+				{Ticker: "ITSA4", Type: "Ação", CurrentValue: 500, Price: price(10)},
+			},
+			targets:      []AllocationTarget{typeTarget("FII", 50), typeTarget("Ação", 50)},
+			amount:       1100,
+			wantBuy:      map[string]float64{"MXRF11": 550, "ITSA4": 550},
+			wantQuantity: map[string]float64{"MXRF11": 55, "ITSA4": 55},
+		},
+		{
+			name: "gaps larger than the contribution share it",
+			positions: []*rebalancePosition{
+				{Ticker: "MXRF11", Type: "FII", CurrentValue: 0, Price: price(1)},
+				{Ticker: "ITSA4", Type: "Ação", CurrentValue: 1000, Price: price(1)},
+				{Ticker: "IVVB11", Type: "ETF", CurrentValue: 0, Price: price(1)},
+			},
+			targets:    []AllocationTarget{typeTarget("FII", 30), typeTarget("Ação", 40), typeTarget("ETF", 30)},
+			amount:     100,
+			wantBuy:    map[string]float64{"MXRF11": 50, "ITSA4": 0, "IVVB11": 50},
+			wantWeight: map[string]float64{"MXRF11": 30, "ITSA4": 40, "IVVB11": 30},
+		},
+		{
+			name: "whole units leave the rest unallocated",
+			positions: []*rebalancePosition{
+				{Ticker: "ITSA4", Type: "Ação", CurrentValue: 100, Price: price(30)},
+			},
+			targets:         []AllocationTarget{typeTarget("Ação", 100)},
+			amount:          100,
+			wantBuy:         map[string]float64{"ITSA4": 90},
+			wantQuantity:    map[string]float64{"ITSA4": 3},
+			wantUnallocated: 10,
+		},
+		{
+			name: "fractional buys use the whole contribution",
+			positions: []*rebalancePosition{
+				{Ticker: "ITSA4", Type: "Ação", CurrentValue: 100, Price: price(40)},
+			},
+			targets:      []AllocationTarget{typeTarget("Ação", 100)},
+			amount:       100,
+			fractional:   true,
+			wantBuy:      map[string]float64{"ITSA4": 100},
+			wantQuantity: map[string]float64{"ITSA4": 2.5},
+		},
+		{
+			name: "markets trading fractions ignore the request",
+			positions: []*rebalancePosition{
+				{Ticker: "VOO", Type: "ETF", CurrentValue: 100, Price: price(40), fractional: true},
+			},
+			targets:      []AllocationTarget{typeTarget("ETF", 100)},
+			amount:       100,
+			wantBuy:      map[string]float64{"VOO": 100},
+			wantQuantity: map[string]float64{"VOO": 2.5},
+		},
+		{
+			name: "targeted tickers without a position are added",
+			positions: []*rebalancePosition{
+				{Ticker: "ITSA4", Type: "Ação", CurrentValue: 900, Price: price(10)},
+			},
+			targets:    []AllocationTarget{typeTarget("Ação", 90), tickerTarget("BTC", "Cripto", 10)},
+			amount:     100,
+			wantBuy:    map[string]float64{"ITSA4": 0, "BTC": 100},
+			wantWeight: map[string]float64{"ITSA4": 90, "BTC": 10},
+		},
+		{
+			name: "untargeted tickers share what is left of their type",
+			positions: []*rebalancePosition{
+				{Ticker: "HGLG11", Type: "FII", CurrentValue: 400, Price: price(1)},
+				{Ticker: "MXRF11", Type: "FII", CurrentValue: 100, Price: price(1)},
+				{Ticker: "KNRI11", Type: "FII", CurrentValue: 100, Price: price(1)},
+				{Ticker: "ITSA4", Type: "Ação", CurrentValue: 400, Price: price(1)},
+			},
+			targets:    []AllocationTarget{typeTarget("FII", 60), tickerTarget("HGLG11", "FII", 40), typeTarget("Ação", 40)},
+			amount:     100,
+			fractional: true,
+			wantWeight: map[string]float64{"HGLG11": 40, "MXRF11": 10, "KNRI11": 10, "ITSA4": 40},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := planRebalance(tt.positions, tt.targets, tt.amount, tt.fractional)
+			byTicker := make(map[string]rebalancePosition)
+			for _, p := range plan.Positions {
+				byTicker[p.Ticker] = p
+			}
+			for ticker := range tt.wantWeight {
+				if _, ok := byTicker[ticker]; !ok {
+					t.Fatalf("plan has no position for %s", ticker)
+				}
+			}
+
+			for ticker, want := range tt.wantBuy {
+				if got := byTicker[ticker].BuyAmount; !approxEqual(got, want, 0.01) {
+					t.Errorf("BuyAmount[%s] = %v, want %v", ticker, got, want)
+				}
+			}
+			for ticker, want := range tt.wantQuantity {
+				got := byTicker[ticker].Quantity
+				if got == nil || !approxEqual(*got, want, 1e-9) {
+					t.Errorf("Quantity[%s] = %v, want %v", ticker, got, want)
+				}
+			}
+			for ticker, want := range tt.wantWeight {
+				if got := byTicker[ticker].TargetWeight; !approxEqual(got, want, 1e-9) {
+					t.Errorf("TargetWeight[%s] = %v, want %v", ticker, got, want)
+				}
+			}
+			if !approxEqual(plan.Unallocated, tt.wantUnallocated, 0.001) {
+				t.Errorf("Unallocated = %v, want %v", plan.Unallocated, tt.wantUnallocated)
+			}
+
+			for i := 1; i < len(plan.Positions); i++ {
+				if plan.Positions[i].BuyAmount > plan.Positions[i-1].BuyAmount {
+					t.Errorf("plan is not sorted by buy amount: %v", plan.Positions)
+					break
+				}
+			}
+		})
+	}
+}
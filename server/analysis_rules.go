@@ -0,0 +1,484 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Metrics a rule condition can look at. All percentages are in percent.
+var analysisMetrics = []string{
+	"profit_loss_percent",
+	"profit_loss",
+	"current_value",
+	"weight",
+	"days_held",
+	"drawdown_from_peak",
+}
+
+var analysisOperators = []string{"<", "<=", ">", ">=", "==", "!="}
+
+var analysisActions = []string{"vender", "vender_parcial", "comprar", "manter", "alerta"}
+
+type RuleCondition struct {
+	Metric   string  `json:"metric"`
+	Operator string  `json:"operator"`
+	Value    float64 `json:"value"`
+}
+
+// AnalysisRule fires for a position when all of its conditions hold. Rules
+// with kind "warning" go to the warnings list, the others are suggestions.
+// Message may reference {ticker}, {name} and any metric, e.g.
+// "Perda de {profit_loss_percent}%".
+type AnalysisRule struct {
+	ID         int             `json:"id"`
+	Name       string          `json:"name"`
+	Conditions []RuleCondition `json:"conditions"`
+	Action     string          `json:"action"`
+	Kind       string          `json:"kind"`
+	Priority   string          `json:"priority"`
+	Message    string          `json:"message"`
+	SortOrder  int             `json:"sort_order"`
+	Active     bool            `json:"active"`
+	CreatedAt  string          `json:"created_at"`
+	UpdatedAt  string          `json:"updated_at"`
+}
+
+var defaultAnalysisRules = []AnalysisRule{
+	{
+		Name:       "Stop loss",
+		Conditions: []RuleCondition{{"profit_loss_percent", "<", -15}},
+		Action:     "vender", Kind: "suggestion", Priority: "alta", SortOrder: 10,
+		Message: "Perda significativa de {profit_loss_percent}%. Considere realizar o prejuízo para evitar maiores perdas.",
+	},
+	{
+		Name:       "Realizar lucros",
+		Conditions: []RuleCondition{{"profit_loss_percent", ">", 30}},
+		Action:     "vender_parcial", Kind: "suggestion", Priority: "média", SortOrder: 20,
+		Message: "Ganho significativo de +{profit_loss_percent}%. Considere realizar parte dos lucros para proteger ganhos.",
+	},
+	{
+		Name:       "Aumentar posição",
+		Conditions: []RuleCondition{{"profit_loss_percent", ">", 5}, {"profit_loss_percent", "<", 20}},
+		Action:     "comprar", Kind: "suggestion", Priority: "baixa", SortOrder: 30,
+		Message: "Performance positiva de +{profit_loss_percent}%. Pode ser uma boa oportunidade para aumentar a posição.",
+	},
+	{
+		Name:       "Perda moderada",
+		Conditions: []RuleCondition{{"profit_loss_percent", "<", -5}, {"profit_loss_percent", ">=", -15}},
+		Action:     "alerta", Kind: "warning", Priority: "média", SortOrder: 40,
+		Message: "Atenção: perda de {profit_loss_percent}%. Monitore de perto.",
+	},
+}
+
+// Give the users without analysis rules their own set. Rules from before
+// they were kept per user have no owner: each user gets a copy of them
+// instead of the defaults, and then they are dropped.
+func seedAnalysisRules() {
+	rows, err := db.Query("SELECT id FROM users u WHERE NOT EXISTS (SELECT 1 FROM analysis_rules r WHERE r.user_id = u.id)")
+	if err != nil {
+		log.Printf("Failed to seed analysis rules: %v", err)
+		return
+	}
+	var userIDs []int
+	for rows.Next() {
+		var id int
+		if rows.Scan(&id) == nil {
+			userIDs = append(userIDs, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range userIDs {
+		seedUserAnalysisRules(id)
+	}
+	// Without users yet, the first one to register claims them
+	if _, err := db.Exec("DELETE FROM analysis_rules WHERE user_id IS NULL AND EXISTS (SELECT 1 FROM users)"); err != nil {
+		log.Printf("Failed to remove unowned analysis rules: %v", err)
+	}
+}
+
+// Give a user without analysis rules a copy of the unowned ones, or the
+// defaults when there are none
+func seedUserAnalysisRules(userID int) {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM analysis_rules WHERE user_id = ?", userID).Scan(&count); err != nil || count > 0 {
+		return
+	}
+
+	result, err := db.Exec(
+		`INSERT INTO analysis_rules (user_id, name, conditions, action, kind, priority, message, sort_order, active)
+		 SELECT ?, name, conditions, action, kind, priority, message, sort_order, active
+		 FROM analysis_rules WHERE user_id IS NULL ORDER BY id`,
+		userID,
+	)
+	if err != nil {
+		log.Printf("Failed to copy analysis rules to user %d: %v", userID, err)
+		return
+	}
+	if copied, _ := result.RowsAffected(); copied > 0 {
+		return
+	}
+
+	for _, rule := range defaultAnalysisRules {
+		conditions, _ := json.Marshal(rule.Conditions)
+		_, err := db.Exec(
+			`INSERT INTO analysis_rules (user_id, name, conditions, action, kind, priority, message, sort_order)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			userID, rule.Name, string(conditions), rule.Action, rule.Kind, rule.Priority, rule.Message, rule.SortOrder,
+		)
+		if err != nil {
+			log.Printf("Failed to insert analysis rule %s: %v", rule.Name, err)
+		}
+	}
+}
+
+const analysisRuleColumns = "id, name, conditions, action, kind, priority, message, sort_order, active, created_at, updated_at"
+
+func scanAnalysisRule(scanner interface{ Scan(...interface{}) error }) (AnalysisRule, error) {
+	var rule AnalysisRule
+	var conditions string
+	err := scanner.Scan(
+		&rule.ID, &rule.Name, &conditions, &rule.Action, &rule.Kind, &rule.Priority,
+		&rule.Message, &rule.SortOrder, &rule.Active, &rule.CreatedAt, &rule.UpdatedAt,
+	)
+	if err != nil {
+		return rule, err
+	}
+	rule.Conditions = []RuleCondition{}
+	if err := json.Unmarshal([]byte(conditions), &rule.Conditions); err != nil {
+		return rule, fmt.Errorf("rule %d has invalid conditions: %v", rule.ID, err)
+	}
+	return rule, nil
+}
+
+func loadAnalysisRules(userID int, activeOnly bool) ([]AnalysisRule, error) {
+	query := "SELECT " + analysisRuleColumns + " FROM analysis_rules WHERE user_id = ?"
+	if activeOnly {
+		query += " AND active = 1"
+	}
+	query += " ORDER BY sort_order, id"
+
+	rows, err := db.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []AnalysisRule{}
+	for rows.Next() {
+		rule, err := scanAnalysisRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func validateAnalysisRule(rule *AnalysisRule) string {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return "name is required"
+	}
+	if len(rule.Conditions) == 0 {
+		return "at least one condition is required"
+	}
+	for _, cond := range rule.Conditions {
+		if !containsString(analysisMetrics, cond.Metric) {
+			return fmt.Sprintf("unknown metric %q (valid: %s)", cond.Metric, strings.Join(analysisMetrics, ", "))
+		}
+		if !containsString(analysisOperators, cond.Operator) {
+			return fmt.Sprintf("unknown operator %q (valid: %s)", cond.Operator, strings.Join(analysisOperators, " "))
+		}
+	}
+	if !containsString(analysisActions, rule.Action) {
+		return fmt.Sprintf("unknown action %q (valid: %s)", rule.Action, strings.Join(analysisActions, ", "))
+	}
+	if rule.Kind == "" {
+		rule.Kind = "suggestion"
+	}
+	if rule.Kind != "suggestion" && rule.Kind != "warning" {
+		return "kind must be 'suggestion' or 'warning'"
+	}
+	if rule.Priority == "" {
+		rule.Priority = "média"
+	}
+	return ""
+}
+
+// Get analysis rules
+func getAnalysisRules(c *gin.Context) {
+	rules, err := loadAnalysisRules(currentUserID(c), false)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{
+		"rules":     rules,
+		"metrics":   analysisMetrics,
+		"operators": analysisOperators,
+		"actions":   analysisActions,
+	})
+}
+
+// Create analysis rule
+func createAnalysisRule(c *gin.Context) {
+	rule := AnalysisRule{Active: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateAnalysisRule(&rule); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	conditions, _ := json.Marshal(rule.Conditions)
+	result, err := db.Exec(
+		`INSERT INTO analysis_rules (user_id, name, conditions, action, kind, priority, message, sort_order, active)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		currentUserID(c), rule.Name, string(conditions), rule.Action, rule.Kind, rule.Priority, rule.Message, rule.SortOrder, rule.Active,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	created, err := scanAnalysisRule(db.QueryRow("SELECT "+analysisRuleColumns+" FROM analysis_rules WHERE id = ?", id))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, created)
+}
+
+// Update analysis rule
+func updateAnalysisRule(c *gin.Context) {
+	id := c.Param("id")
+	rule := AnalysisRule{Active: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateAnalysisRule(&rule); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	conditions, _ := json.Marshal(rule.Conditions)
+	result, err := db.Exec(
+		`UPDATE analysis_rules SET
+		 name = ?, conditions = ?, action = ?, kind = ?, priority = ?, message = ?, sort_order = ?, active = ?,
+		 updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND user_id = ?`,
+		rule.Name, string(conditions), rule.Action, rule.Kind, rule.Priority, rule.Message, rule.SortOrder, rule.Active, id, currentUserID(c),
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Analysis rule not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Analysis rule updated successfully"})
+}
+
+// Delete analysis rule
+func deleteAnalysisRule(c *gin.Context) {
+	result, err := db.Exec("DELETE FROM analysis_rules WHERE id = ? AND user_id = ?", c.Param("id"), currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Analysis rule not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Analysis rule deleted successfully"})
+}
+
+// Compute the metrics rules can refer to for each position. Weight is the
+// share of the position in the current value of the portfolio, days held
+// counts from the first purchase and drawdown compares the current price
+// with the highest recorded price. Current values are keyed by investment
+// and already converted into the base currency so weights add up.
+func positionMetrics(investments []Investment, currentValues map[int]float64) (map[int]map[string]float64, error) {
+	var totalCurrentValue float64
+	for _, value := range currentValues {
+		totalCurrentValue += value
+	}
+
+	ids := make([]interface{}, len(investments))
+	for i, inv := range investments {
+		ids[i] = inv.ID
+	}
+	inIDs := "investment_id IN (" + strings.TrimSuffix(strings.Repeat("?, ", len(ids)), ", ") + ")"
+
+	firstBuy := make(map[int]string)
+	rows, err := db.Query("SELECT investment_id, MIN(date) FROM investment_movements WHERE "+inIDs+" GROUP BY investment_id", ids...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var date string
+		if err := rows.Scan(&id, &date); err == nil {
+			firstBuy[id] = date
+		}
+	}
+	rows.Close()
+
+	peakPrice := make(map[int]float64)
+	rows, err = db.Query("SELECT investment_id, MAX(price) FROM investment_snapshots WHERE "+inIDs+" GROUP BY investment_id", ids...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var price sql.NullFloat64
+		if err := rows.Scan(&id, &price); err == nil && price.Valid {
+			peakPrice[id] = price.Float64
+		}
+	}
+	rows.Close()
+
+	today := time.Now()
+	result := make(map[int]map[string]float64)
+	for _, inv := range investments {
+		m := make(map[string]float64)
+		if inv.ProfitLossPercent != nil {
+			m["profit_loss_percent"] = *inv.ProfitLossPercent
+		}
+		if inv.ProfitLoss != nil {
+			m["profit_loss"] = *inv.ProfitLoss
+		}
+		if inv.CurrentValue != nil {
+			m["current_value"] = *inv.CurrentValue
+			if totalCurrentValue > 0 {
+				m["weight"] = currentValues[inv.ID] / totalCurrentValue * 100
+			}
+		}
+		if date, ok := firstBuy[inv.ID]; ok {
+			if t, err := time.ParseInLocation("2006-01-02", date, time.Local); err == nil {
+				m["days_held"] = math.Floor(today.Sub(t).Hours() / 24)
+			}
+		}
+		if inv.CurrentPrice != nil {
+			peak := math.Max(peakPrice[inv.ID], *inv.CurrentPrice)
+			if peak > 0 {
+				m["drawdown_from_peak"] = (*inv.CurrentPrice/peak - 1) * 100
+			}
+		}
+		result[inv.ID] = m
+	}
+	return result, nil
+}
+
+func compareMetric(actual float64, operator string, value float64) bool {
+	switch operator {
+	case "<":
+		return actual < value
+	case "<=":
+		return actual <= value
+	case ">":
+		return actual > value
+	case ">=":
+		return actual >= value
+	case "==":
+		return actual == value
+	case "!=":
+		return actual != value
+	}
+	return false
+}
+
+// Fill {placeholders} in a rule message with the position's data
+func formatRuleMessage(message string, inv Investment, metrics map[string]float64) string {
+	replacements := []string{"{ticker}", inv.Ticker, "{name}", inv.Name}
+	for _, metric := range analysisMetrics {
+		if value, ok := metrics[metric]; ok {
+			replacements = append(replacements, "{"+metric+"}", fmt.Sprintf("%.1f", value))
+		}
+	}
+	return strings.NewReplacer(replacements...).Replace(message)
+}
+
+// Evaluate the active rules against each position. Every match carries an
+// explain field with the rule and the values that satisfied its conditions.
+func evaluateAnalysisRules(userID int, investments []Investment, currentValues map[int]float64) ([]gin.H, []gin.H, error) {
+	rules, err := loadAnalysisRules(userID, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	metrics, err := positionMetrics(investments, currentValues)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	suggestions := []gin.H{}
+	warnings := []gin.H{}
+	for _, inv := range investments {
+		m := metrics[inv.ID]
+		for _, rule := range rules {
+			matched := true
+			explained := []gin.H{}
+			for _, cond := range rule.Conditions {
+				actual, ok := m[cond.Metric]
+				if !ok || !compareMetric(actual, cond.Operator, cond.Value) {
+					matched = false
+					break
+				}
+				explained = append(explained, gin.H{
+					"metric":   cond.Metric,
+					"operator": cond.Operator,
+					"value":    cond.Value,
+					"actual":   actual,
+				})
+			}
+			if !matched {
+				continue
+			}
+
+			message := formatRuleMessage(rule.Message, inv, m)
+			explain := gin.H{
+				"rule_id":    rule.ID,
+				"rule":       rule.Name,
+				"conditions": explained,
+			}
+
+			if rule.Kind == "warning" {
+				warnings = append(warnings, gin.H{
+					"ticker":              inv.Ticker,
+					"name":                inv.Name,
+					"message":             message,
+					"priority":            rule.Priority,
+					"profit_loss_percent": m["profit_loss_percent"],
+					"explain":             explain,
+				})
+				continue
+			}
+
+			suggestions = append(suggestions, gin.H{
+				"action":              rule.Action,
+				"ticker":              inv.Ticker,
+				"name":                inv.Name,
+				"reason":              message,
+				"priority":            rule.Priority,
+				"profit_loss_percent": m["profit_loss_percent"],
+				"current_value":       inv.CurrentValue,
+				"explain":             explain,
+			})
+		}
+	}
+	return suggestions, warnings, nil
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Files attached to transactions, like the receipts kept for the income tax
+// deductions. Files are stored on disk by the SHA-256 of their content, so
+// the same receipt attached twice is stored once; the attachments table has
+// their names and the transactions they belong to. A file is removed once no
+// attachment refers to it: when its last attachment is deleted, or when the
+// transactions are purged from the trash.
+
+type Attachment struct {
+	ID            int    `json:"id"`
+	TransactionID int    `json:"transaction_id"`
+	FileName      string `json:"file_name"`
+	ContentType   string `json:"content_type"`
+	Size          int64  `json:"size"`
+	SHA256        string `json:"sha256"`
+	CreatedAt     string `json:"created_at"`
+}
+
+const attachmentColumns = "id, transaction_id, file_name, content_type, size, sha256, created_at"
+
+// Files are written, linked and removed one at a time, so a file is never
+// removed while an upload of the same content links to it
+var attachmentMutex sync.Mutex
+
+func attachmentsDir() string {
+	return config.Attachments.Dir
+}
+
+func maxAttachmentSize() int64 {
+	return int64(config.Attachments.MaxSizeMB) << 20
+}
+
+// Files are spread in subdirectories by the first characters of their hash
+func attachmentPath(hash string) string {
+	return filepath.Join(attachmentsDir(), hash[:2], hash)
+}
+
+// Hashes as attachmentPath expects them: SHA-256 in lowercase hex
+func isAttachmentHash(hash string) bool {
+	if len(hash) != sha256.Size*2 || strings.ToLower(hash) != hash {
+		return false
+	}
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+func scanAttachment(scanner interface{ Scan(...interface{}) error }) (Attachment, error) {
+	var a Attachment
+	err := scanner.Scan(&a.ID, &a.TransactionID, &a.FileName, &a.ContentType, &a.Size, &a.SHA256, &a.CreatedAt)
+	return a, err
+}
+
+// Account of a transaction the user can see, answering the request when the
+// transaction is missing or the user lacks the required role on its account
+func authorizeTransaction(c *gin.Context, transactionID interface{}, required string) bool {
+	userID := currentUserID(c)
+	var accountID int
+	err := db.QueryRow(
+		"SELECT account_id FROM transactions WHERE id = ? AND deleted_at IS NULL AND account_id IN ("+visibleAccountsQuery+")",
+		transactionID, userID, userID,
+	).Scan(&accountID)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Transaction not found"})
+		return false
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return false
+	}
+	return authorizeAccount(c, accountID, required, 404)
+}
+
+// Get the attachments of a transaction
+func getTransactionAttachments(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeTransaction(c, id, roleViewer) {
+		return
+	}
+
+	rows, err := db.Query("SELECT "+attachmentColumns+" FROM attachments WHERE transaction_id = ? ORDER BY id", id)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	attachments := []Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		attachments = append(attachments, a)
+	}
+	c.JSON(200, attachments)
+}
+
+// Store an uploaded file by its hash, returning the hash and the size. The
+// file is written under a temporary name first, so a failed upload leaves
+// nothing behind.
+func storeAttachmentFile(src io.Reader) (string, int64, error) {
+	if err := os.MkdirAll(attachmentsDir(), 0o750); err != nil {
+		return "", 0, err
+	}
+	tmp, err := os.CreateTemp(attachmentsDir(), "upload-*")
+	if err != nil {
+		return "", 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hasher), src)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", 0, err
+	}
+
+	hash := hex.EncodeToString(hasher.Sum(nil))
+	path := attachmentPath(hash)
+	if _, err := os.Stat(path); err == nil {
+		// Same content stored already
+		return hash, size, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return "", 0, err
+	}
+	return hash, size, os.Rename(tmp.Name(), path)
+}
+
+// Attach a file to a transaction, uploaded as the "file" field of a
+// multipart form. Attaching the same content to the same transaction again
+// returns the existing attachment.
+func uploadAttachment(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeTransaction(c, id, roleEditor) {
+		return
+	}
+
+	// Room for the rest of the form besides the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAttachmentSize()+1<<20)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(413, gin.H{"error": fmt.Sprintf("Files can have up to %d MB", config.Attachments.MaxSizeMB)})
+			return
+		}
+		c.JSON(400, gin.H{"error": "A file is required in the 'file' field"})
+		return
+	}
+	if header.Size > maxAttachmentSize() {
+		c.JSON(413, gin.H{"error": fmt.Sprintf("Files can have up to %d MB", config.Attachments.MaxSizeMB)})
+		return
+	}
+	if header.Size == 0 {
+		c.JSON(400, gin.H{"error": "The file is empty"})
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	// The type is told by the content, not by what the browser claims
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	contentType := http.DetectContentType(head[:n])
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	fileName := strings.Map(func(r rune) rune {
+		if r < 32 || r == 127 {
+			return -1
+		}
+		return r
+	}, filepath.Base(strings.ReplaceAll(header.Filename, `\`, "/")))
+	if fileName == "" || fileName == "." || fileName == "/" {
+		fileName = "attachment"
+	}
+
+	attachmentMutex.Lock()
+	defer attachmentMutex.Unlock()
+
+	hash, size, err := storeAttachmentFile(file)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	existing, err := scanAttachment(db.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE transaction_id = ? AND sha256 = ?", id, hash))
+	if err == nil {
+		c.JSON(200, existing)
+		return
+	}
+	if err != sql.ErrNoRows {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO attachments (user_id, transaction_id, file_name, content_type, size, sha256) VALUES (?, ?, ?, ?, ?, ?)",
+		currentUserID(c), id, fileName, contentType, size, hash,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	attachmentID, _ := result.LastInsertId()
+	attachment, err := scanAttachment(db.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", attachmentID))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(201, attachment)
+}
+
+// Find an attachment of a transaction the user can see, answering the
+// request when it is missing or the user lacks the required role
+func authorizedAttachment(c *gin.Context, required string) (Attachment, bool) {
+	attachment, err := scanAttachment(db.QueryRow("SELECT "+attachmentColumns+" FROM attachments WHERE id = ?", c.Param("id")))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Attachment not found"})
+		return attachment, false
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return attachment, false
+	}
+	return attachment, authorizeTransaction(c, attachment.TransactionID, required)
+}
+
+// Download an attachment. Files are always sent as downloads, so an
+// uploaded page can't run in the app's origin.
+func downloadAttachment(c *gin.Context) {
+	attachment, ok := authorizedAttachment(c, roleViewer)
+	if !ok {
+		return
+	}
+	path := attachmentPath(attachment.SHA256)
+	if _, err := os.Stat(path); err != nil {
+		c.JSON(404, gin.H{"error": "Attachment file is missing"})
+		return
+	}
+	c.Header("Content-Type", attachment.ContentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.FileAttachment(path, attachment.FileName)
+}
+
+// Delete an attachment, and its file when no other attachment has the same
+// content
+func deleteAttachment(c *gin.Context) {
+	attachment, ok := authorizedAttachment(c, roleEditor)
+	if !ok {
+		return
+	}
+
+	attachmentMutex.Lock()
+	defer attachmentMutex.Unlock()
+
+	if _, err := db.Exec("DELETE FROM attachments WHERE id = ?", attachment.ID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := removeUnusedAttachmentFile(attachment.SHA256); err != nil {
+		log.Printf("Error removing attachment file %s: %v", attachment.SHA256, err)
+	}
+	c.JSON(200, gin.H{"message": "Attachment deleted successfully"})
+}
+
+// Call with attachmentMutex held
+func removeUnusedAttachmentFile(hash string) error {
+	var used bool
+	if err := db.QueryRow("SELECT EXISTS(SELECT 1 FROM attachments WHERE sha256 = ?)", hash).Scan(&used); err != nil || used {
+		return err
+	}
+	err := os.Remove(attachmentPath(hash))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// Remove the files no attachment refers to anymore. The attachments of
+// deleted transactions go with them (by a trigger), so this runs after
+// transactions are deleted for good.
+func removeOrphanAttachmentFiles() {
+	attachmentMutex.Lock()
+	defer attachmentMutex.Unlock()
+
+	rows, err := db.Query("SELECT DISTINCT sha256 FROM attachments")
+	if err != nil {
+		log.Printf("Error cleaning up attachments: %v", err)
+		return
+	}
+	used := make(map[string]bool)
+	for rows.Next() {
+		var hash string
+		if rows.Scan(&hash) == nil {
+			used[hash] = true
+		}
+	}
+	rows.Close()
+
+	removed := 0
+	dirs, _ := os.ReadDir(attachmentsDir())
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+		files, _ := os.ReadDir(filepath.Join(attachmentsDir(), dir.Name()))
+		for _, file := range files {
+			if used[file.Name()] || len(file.Name()) != sha256.Size*2 {
+				continue
+			}
+			if err := os.Remove(filepath.Join(attachmentsDir(), dir.Name(), file.Name())); err != nil {
+				log.Printf("Error removing attachment file %s: %v", file.Name(), err)
+				continue
+			}
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("Removed %d attachment files no longer used", removed)
+	}
+}
+
+// Number of attachments of each of the transactions, by transaction ID
+func attachmentCounts(transactionIDs []int) (map[int]int, error) {
+	counts := make(map[int]int)
+	const batch = 500
+	for start := 0; start < len(transactionIDs); start += batch {
+		end := start + batch
+		if end > len(transactionIDs) {
+			end = len(transactionIDs)
+		}
+		args := make([]interface{}, 0, end-start)
+		for _, id := range transactionIDs[start:end] {
+			args = append(args, id)
+		}
+
+		rows, err := db.Query(
+			"SELECT transaction_id, COUNT(*) FROM attachments WHERE transaction_id IN ("+placeholders(end-start)+") GROUP BY transaction_id",
+			args...,
+		)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var transactionID, count int
+			if err := rows.Scan(&transactionID, &count); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			counts[transactionID] = count
+		}
+		rows.Close()
+	}
+	return counts, nil
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Every create, update and delete of accounts, transactions, investments,
+// installments and the salary config is written to audit_log with the row
+// before and after the change. The table is append-only: triggers reject
+// updates and deletes. Quote refreshes are market data, not user changes, and
+// are not audited.
+
+// Who made a change
+const (
+	actorSession  = "session"
+	actorAPIToken = "api_token"
+	actorSystem   = "system"
+)
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type AuditEntry struct {
+	ID         int             `json:"id"`
+	UserID     *int            `json:"user_id"`
+	Username   *string         `json:"username"`
+	Actor      string          `json:"actor"`
+	APITokenID *int            `json:"api_token_id"`
+	TokenName  *string         `json:"token_name"`
+	Endpoint   string          `json:"endpoint"`
+	Entity     string          `json:"entity"`
+	EntityID   *int            `json:"entity_id"`
+	Action     string          `json:"action"`
+	Before     json.RawMessage `json:"before"`
+	After      json.RawMessage `json:"after"`
+	CreatedAt  string          `json:"created_at"`
+}
+
+// Rows of a table matching a condition, as column -> value maps
+func auditRows(q queryer, table, condition string, args ...interface{}) []map[string]interface{} {
+	rows, err := queryRows(q, fmt.Sprintf("SELECT * FROM %s WHERE %s", table, condition), args...)
+	if err != nil {
+		log.Printf("Failed to read %s for the audit log: %v", table, err)
+		return nil
+	}
+	return rows
+}
+
+// Rows of a query as column -> value maps
+func queryRows(q queryer, query string, args ...interface{}) ([]map[string]interface{}, error) {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	var result []map[string]interface{}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, column := range columns {
+			if b, ok := values[i].([]byte); ok {
+				values[i] = string(b)
+			}
+			row[column] = values[i]
+		}
+		result = append(result, row)
+	}
+	return result, rows.Err()
+}
+
+// A single row by ID, nil when it does not exist
+func auditRow(q queryer, table string, id interface{}) map[string]interface{} {
+	rows := auditRows(q, table, "id = ?", id)
+	if len(rows) == 0 {
+		return nil
+	}
+	return rows[0]
+}
+
+func auditJSON(row map[string]interface{}) interface{} {
+	if row == nil {
+		return nil
+	}
+	data, err := json.Marshal(row)
+	if err != nil {
+		return nil
+	}
+	return string(data)
+}
+
+func writeAudit(e execer, userID int, actor string, tokenID interface{}, endpoint, entity string, entityID interface{}, before, after map[string]interface{}) {
+	action := "update"
+	switch {
+	case before == nil:
+		action = "create"
+	case after == nil:
+		action = "delete"
+	case before["deleted_at"] == nil && after["deleted_at"] != nil:
+		// Moved to the trash
+		action = "delete"
+	}
+	if entityID == nil {
+		if after != nil {
+			entityID = after["id"]
+		} else if before != nil {
+			entityID = before["id"]
+		}
+	}
+
+	var user interface{}
+	if userID != 0 {
+		user = userID
+	}
+
+	// Changes made by others to an account (its members, through sharing) are
+	// also shown to the account's owner
+	row := after
+	if row == nil {
+		row = before
+	}
+	var accountID interface{}
+	if entity == "account" {
+		accountID = entityID
+	} else if row != nil {
+		accountID = row["account_id"]
+	}
+
+	_, err := e.Exec(`
+		INSERT INTO audit_log (user_id, account_owner_id, actor, api_token_id, endpoint, entity, entity_id, action, before_json, after_json)
+		VALUES (?, (SELECT user_id FROM accounts WHERE id = ?), ?, ?, ?, ?, ?, ?, ?, ?)
+	`, user, accountID, actor, tokenID, endpoint, entity, entityID, action, auditJSON(before), auditJSON(after))
+	if err != nil {
+		log.Printf("Failed to write audit log for %s %v: %v", entity, entityID, err)
+	}
+}
+
+// Record a change made by a request. Pass the transaction the change was made
+// in, if any, so the entry is committed or rolled back with it.
+func recordAudit(c *gin.Context, e execer, entity string, entityID interface{}, before, after map[string]interface{}) {
+	actor := actorSession
+	var tokenID interface{}
+	if id, ok := c.Get("apiTokenID"); ok {
+		actor = actorAPIToken
+		tokenID = id
+	}
+	writeAudit(e, currentUserID(c), actor, tokenID, c.Request.Method+" "+c.FullPath(), entity, entityID, before, after)
+}
+
+// Record a change made by a background job
+func recordSystemAudit(e execer, userID int, job, entity string, entityID interface{}, before, after map[string]interface{}) {
+	writeAudit(e, userID, actorSystem, nil, job, entity, entityID, before, after)
+}
+
+// Get the audit log of the signed in user, newest first: their own changes
+// and the changes others made to the accounts they own. Filters: entity,
+// entity_id, action, start and end (dates); paginated with limit and offset.
+func getAuditLog(c *gin.Context) {
+	query := `
+		SELECT l.id, l.user_id, u.username, l.actor, l.api_token_id, t.name, l.endpoint, l.entity, l.entity_id,
+			l.action, l.before_json, l.after_json, l.created_at
+		FROM audit_log l
+		LEFT JOIN users u ON u.id = l.user_id
+		LEFT JOIN api_tokens t ON t.id = l.api_token_id
+		WHERE (l.user_id = ? OR l.account_owner_id = ?)
+	`
+	args := []interface{}{currentUserID(c), currentUserID(c)}
+
+	if entity := c.Query("entity"); entity != "" {
+		query += " AND l.entity = ?"
+		args = append(args, entity)
+	}
+	if entityID := c.Query("entity_id"); entityID != "" {
+		query += " AND l.entity_id = ?"
+		args = append(args, entityID)
+	}
+	if action := c.Query("action"); action != "" {
+		query += " AND l.action = ?"
+		args = append(args, action)
+	}
+	if start := c.Query("start"); start != "" {
+		query += " AND date(l.created_at) >= ?"
+		args = append(args, start)
+	}
+	if end := c.Query("end"); end != "" {
+		query += " AND date(l.created_at) <= ?"
+		args = append(args, end)
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "100"))
+	if err != nil || limit <= 0 || limit > 1000 {
+		limit = 100
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		offset = 0
+	}
+	query += " ORDER BY l.id DESC LIMIT ? OFFSET ?"
+	args = append(args, limit, offset)
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	entries := []AuditEntry{}
+	for rows.Next() {
+		var e AuditEntry
+		var before, after sql.NullString
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Username, &e.Actor, &e.APITokenID, &e.TokenName, &e.Endpoint,
+			&e.Entity, &e.EntityID, &e.Action, &before, &after, &e.CreatedAt); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if before.Valid {
+			e.Before = json.RawMessage(before.String)
+		}
+		if after.Valid {
+			e.After = json.RawMessage(after.String)
+		}
+		entries = append(entries, e)
+	}
+
+	c.JSON(200, entries)
+}
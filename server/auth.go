@@ -0,0 +1,395 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	sessionCookieName = "mm_session"
+	sessionDuration   = 30 * 24 * time.Hour
+	minPasswordLength = 8
+)
+
+// Tables holding data of a single user. Rows created before users existed
+// have no owner and are handed to the first user that registers.
+var userScopedTables = []string{
+	"accounts", "transactions", "salary_config", "investments", "installments",
+	"installment_payments", "investment_movements", "investment_snapshots",
+	"allocation_targets", "settings", "investor_profile", "analysis_rules",
+}
+
+type User struct {
+	ID        int    `json:"id"`
+	Username  string `json:"username"`
+	Name      string `json:"name"`
+	IsAdmin   bool   `json:"is_admin"`
+	CreatedAt string `json:"created_at"`
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// Sessions are looked up by the SHA-256 of their token so a leaked database
+// does not expose usable tokens
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newSessionToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// Token sent by the client, from the session cookie or an
+// "Authorization: Bearer" header
+func requestToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if cookie, err := c.Cookie(sessionCookieName); err == nil {
+		return cookie
+	}
+	return ""
+}
+
+// User of a valid session token, or 0
+func sessionUserID(token string) int {
+	if token == "" {
+		return 0
+	}
+	var userID int
+	var expiresAt time.Time
+	err := db.QueryRow(
+		"SELECT user_id, expires_at FROM sessions WHERE token_hash = ?",
+		hashToken(token),
+	).Scan(&userID, &expiresAt)
+	if err != nil || time.Now().After(expiresAt) {
+		return 0
+	}
+	return userID
+}
+
+// Reject requests without a valid session or API token and make the user
+// available to the handlers through currentUserID
+func authRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := requestToken(c)
+		if strings.HasPrefix(token, apiTokenPrefix) {
+			authenticateAPIToken(c, token)
+			return
+		}
+		userID := sessionUserID(token)
+		if userID == 0 {
+			c.AbortWithStatusJSON(401, gin.H{"error": "Authentication required"})
+			return
+		}
+		db.Exec("UPDATE sessions SET last_used_at = CURRENT_TIMESTAMP WHERE token_hash = ?", hashToken(token))
+		c.Set("userID", userID)
+		c.Next()
+	}
+}
+
+func currentUserID(c *gin.Context) int {
+	return c.GetInt("userID")
+}
+
+// Whether a row of a user-scoped table belongs to the user
+func userOwns(table string, id interface{}, userID int) bool {
+	var exists bool
+	db.QueryRow(
+		fmt.Sprintf("SELECT EXISTS(SELECT 1 FROM %s WHERE id = ? AND user_id = ?)", table),
+		id, userID,
+	).Scan(&exists)
+	return exists
+}
+
+// Categories without an owner are the shared defaults every user can use
+func categoryVisible(categoryID *int, userID int) bool {
+	if categoryID == nil {
+		return true
+	}
+	var exists bool
+	db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM categories WHERE id = ? AND (user_id IS NULL OR user_id = ?))",
+		*categoryID, userID,
+	).Scan(&exists)
+	return exists
+}
+
+// Give rows created before users existed to a user
+func claimUnownedData(exec execer, userID int) error {
+	for _, table := range userScopedTables {
+		if _, err := exec.Exec(fmt.Sprintf("UPDATE %s SET user_id = ? WHERE user_id IS NULL", table), userID); err != nil {
+			return fmt.Errorf("assign existing %s to user %d: %w", table, userID, err)
+		}
+	}
+	return nil
+}
+
+func createSession(c *gin.Context, userID int) (string, time.Time, error) {
+	token, err := newSessionToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	expiresAt := time.Now().Add(sessionDuration).UTC()
+
+	// Expired sessions are dropped whenever someone logs in
+	db.Exec("DELETE FROM sessions WHERE expires_at < ?", time.Now().UTC())
+
+	_, err = db.Exec(
+		"INSERT INTO sessions (user_id, token_hash, expires_at) VALUES (?, ?, ?)",
+		userID, hashToken(token), expiresAt,
+	)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookieName, token, int(sessionDuration.Seconds()), "/", "", false, true)
+	return token, expiresAt, nil
+}
+
+func loadUser(userID int) (User, error) {
+	var u User
+	err := db.QueryRow("SELECT id, username, name, created_at FROM users WHERE id = ?", userID).Scan(
+		&u.ID, &u.Username, &u.Name, &u.CreatedAt,
+	)
+	u.IsAdmin = isAdmin(u.ID)
+	return u, err
+}
+
+// The first user to register runs the server: they add the other users and
+// are the only one who can change the server-wide reference data (exchange
+// and index rates, the recommendation catalog and its rules)
+func isAdmin(userID int) bool {
+	var firstID int
+	db.QueryRow("SELECT COALESCE(MIN(id), 0) FROM users").Scan(&firstID)
+	return userID != 0 && userID == firstID
+}
+
+// Reject requests of users other than the administrator
+func adminRequired() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAdmin(currentUserID(c)) {
+			c.AbortWithStatusJSON(403, gin.H{"error": "Only the server administrator can change shared data"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// Register a user. The first user can sign up freely and receives the data
+// created before authentication existed; after that only the administrator
+// can add new members.
+func register(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Name     string `json:"name"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.Username = strings.ToLower(strings.TrimSpace(req.Username))
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Username == "" {
+		c.JSON(400, gin.H{"error": "username is required"})
+		return
+	}
+	if len(req.Password) < minPasswordLength {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("password must have at least %d characters", minPasswordLength)})
+		return
+	}
+	if req.Name == "" {
+		req.Name = req.Username
+	}
+
+	callerID := sessionUserID(requestToken(c))
+	callerIsAdmin := isAdmin(callerID)
+	hash, err := hashPassword(req.Password)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Count, insert and claim in one transaction so two first registrations
+	// can't both become the administrator
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	var userCount int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM users").Scan(&userCount); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if userCount > 0 && callerID == 0 {
+		c.JSON(401, gin.H{"error": "Authentication required"})
+		return
+	}
+	if userCount > 0 && !callerIsAdmin {
+		c.JSON(403, gin.H{"error": "Only the server administrator can add users"})
+		return
+	}
+
+	var exists bool
+	if err := tx.QueryRow("SELECT EXISTS(SELECT 1 FROM users WHERE username = ?)", req.Username).Scan(&exists); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if exists {
+		c.JSON(409, gin.H{"error": "Username already taken"})
+		return
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO users (username, name, password_hash) VALUES (?, ?, ?)",
+		req.Username, req.Name, hash,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	if userCount == 0 {
+		if err := claimUnownedData(tx, int(id)); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	seedUserAnalysisRules(int(id))
+
+	user, err := loadUser(int(id))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "User created successfully", "user": user})
+}
+
+// Log in with username and password. The session token is set as an HttpOnly
+// cookie and also returned for clients that prefer a Bearer header.
+func login(c *gin.Context) {
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	var userID int
+	var hash string
+	err := db.QueryRow(
+		"SELECT id, password_hash FROM users WHERE username = ?",
+		strings.ToLower(strings.TrimSpace(req.Username)),
+	).Scan(&userID, &hash)
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err == sql.ErrNoRows || bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.Password)) != nil {
+		c.JSON(401, gin.H{"error": "Invalid username or password"})
+		return
+	}
+
+	token, expiresAt, err := createSession(c, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	user, err := loadUser(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"token": token, "expires_at": expiresAt, "user": user})
+}
+
+// End the current session
+func logout(c *gin.Context) {
+	db.Exec("DELETE FROM sessions WHERE token_hash = ?", hashToken(requestToken(c)))
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(sessionCookieName, "", -1, "/", "", false, true)
+	c.JSON(200, gin.H{"message": "Logged out successfully"})
+}
+
+// Get the signed in user
+func getCurrentUser(c *gin.Context) {
+	user, err := loadUser(currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, user)
+}
+
+// Change the password of the signed in user, ending their other sessions
+func changePassword(c *gin.Context) {
+	var req struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(req.NewPassword) < minPasswordLength {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("password must have at least %d characters", minPasswordLength)})
+		return
+	}
+
+	userID := currentUserID(c)
+	var hash string
+	if err := db.QueryRow("SELECT password_hash FROM users WHERE id = ?", userID).Scan(&hash); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if bcrypt.CompareHashAndPassword([]byte(hash), []byte(req.CurrentPassword)) != nil {
+		c.JSON(400, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	newHash, err := hashPassword(req.NewPassword)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := db.Exec("UPDATE users SET password_hash = ? WHERE id = ?", newHash, userID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	db.Exec("DELETE FROM sessions WHERE user_id = ? AND token_hash <> ?", userID, hashToken(requestToken(c)))
+
+	c.JSON(200, gin.H{"message": "Password changed successfully"})
+}
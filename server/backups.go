@@ -0,0 +1,257 @@
+package main
+
+import (
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Snapshots of the whole database, written with VACUUM INTO so they are
+// consistent even while the server is writing: copying database.sqlite while
+// it runs in WAL mode can miss the pages still in the WAL. Every snapshot is
+// checked with PRAGMA integrity_check before it is kept, and only the newest
+// backup.keep are kept. See the backup section of the configuration.
+
+const (
+	backupPrefix     = "database-"
+	backupTimeFormat = "20060102-150405"
+)
+
+// One backup runs at a time
+var backupMutex sync.Mutex
+
+type BackupFile struct {
+	Name       string `json:"name"`
+	Size       int64  `json:"size"`
+	Compressed bool   `json:"compressed"`
+	CreatedAt  string `json:"created_at"`
+}
+
+func backupDir() string {
+	return config.Backup.Dir
+}
+
+func backupInterval() time.Duration {
+	return time.Duration(config.Backup.IntervalHours) * time.Hour
+}
+
+// Backups in the directory, newest first. Other files are ignored.
+func listBackups() ([]BackupFile, error) {
+	entries, err := os.ReadDir(backupDir())
+	if os.IsNotExist(err) {
+		return []BackupFile{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	backups := []BackupFile{}
+	for _, entry := range entries {
+		name := entry.Name()
+		compressed := strings.HasSuffix(name, ".sqlite.gz")
+		if entry.IsDir() || !strings.HasPrefix(name, backupPrefix) || !(compressed || strings.HasSuffix(name, ".sqlite")) {
+			continue
+		}
+		stamp := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(name, backupPrefix), ".gz"), ".sqlite")
+		createdAt, err := time.Parse(backupTimeFormat, stamp)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		backups = append(backups, BackupFile{
+			Name:       name,
+			Size:       info.Size(),
+			Compressed: compressed,
+			CreatedAt:  createdAt.Format(time.RFC3339),
+		})
+	}
+	// The timestamp in the name sorts chronologically
+	sort.Slice(backups, func(i, j int) bool { return backups[i].Name > backups[j].Name })
+	return backups, nil
+}
+
+// Write a backup of the database, check it and rotate the old ones
+func createBackup() (BackupFile, error) {
+	backupMutex.Lock()
+	defer backupMutex.Unlock()
+
+	dir := backupDir()
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return BackupFile{}, err
+	}
+
+	now := time.Now().UTC()
+	name := backupPrefix + now.Format(backupTimeFormat) + ".sqlite"
+	tmpPath := filepath.Join(dir, name+".tmp")
+	os.Remove(tmpPath)
+	defer os.Remove(tmpPath)
+
+	if _, err := db.Exec("VACUUM INTO ?", tmpPath); err != nil {
+		return BackupFile{}, fmt.Errorf("snapshot failed: %v", err)
+	}
+	if err := checkBackupIntegrity(tmpPath); err != nil {
+		return BackupFile{}, err
+	}
+
+	compressed := config.Backup.Gzip
+	if compressed {
+		name += ".gz"
+		if err := gzipFile(tmpPath, filepath.Join(dir, name)); err != nil {
+			return BackupFile{}, fmt.Errorf("compression failed: %v", err)
+		}
+	} else if err := os.Rename(tmpPath, filepath.Join(dir, name)); err != nil {
+		return BackupFile{}, err
+	}
+
+	info, err := os.Stat(filepath.Join(dir, name))
+	if err != nil {
+		return BackupFile{}, err
+	}
+	rotateBackups()
+
+	return BackupFile{
+		Name:       name,
+		Size:       info.Size(),
+		Compressed: compressed,
+		CreatedAt:  now.Format(time.RFC3339),
+	}, nil
+}
+
+// Open a snapshot on its own and run SQLite's integrity check on it
+func checkBackupIntegrity(path string) error {
+	snapshot, err := sql.Open("sqlite3", path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer snapshot.Close()
+
+	var result string
+	if err := snapshot.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return fmt.Errorf("integrity check failed: %v", err)
+	}
+	if result != "ok" {
+		return fmt.Errorf("integrity check failed: %s", result)
+	}
+	return nil
+}
+
+func gzipFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmp := dst + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	writer := gzip.NewWriter(out)
+	if _, err := io.Copy(writer, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := writer.Close(); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Delete the backups beyond the newest backup.keep
+func rotateBackups() {
+	backups, err := listBackups()
+	if err != nil {
+		log.Printf("Error listing backups: %v", err)
+		return
+	}
+	keep := config.Backup.Keep
+	for i := keep; i < len(backups); i++ {
+		if err := os.Remove(filepath.Join(backupDir(), backups[i].Name)); err != nil {
+			log.Printf("Error removing old backup %s: %v", backups[i].Name, err)
+		}
+	}
+}
+
+func autoBackup() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in autoBackup: %v", r)
+		}
+	}()
+
+	interval := backupInterval()
+	if interval == 0 {
+		log.Println("Scheduled backups are disabled")
+		return
+	}
+
+	runBackup := func() {
+		backup, err := createBackup()
+		if err != nil {
+			log.Printf("Error creating backup: %v", err)
+			return
+		}
+		log.Printf("Backup %s created (%d bytes)", backup.Name, backup.Size)
+	}
+
+	// Back up at startup unless the last backup is recent
+	backups, err := listBackups()
+	if err != nil || len(backups) == 0 {
+		runBackup()
+	} else if last, err := time.Parse(time.RFC3339, backups[0].CreatedAt); err != nil || time.Since(last) >= interval {
+		runBackup()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		runBackup()
+	}
+}
+
+// List the backups of the database. Backups hold every user's data, so
+// only the administrator can list or trigger them.
+func getBackups(c *gin.Context) {
+	backups, err := listBackups()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{
+		"backups":        backups,
+		"directory":      backupDir(),
+		"keep":           config.Backup.Keep,
+		"interval_hours": config.Backup.IntervalHours,
+		"gzip":           config.Backup.Gzip,
+	})
+}
+
+// Back up the database now
+func triggerBackup(c *gin.Context) {
+	backup, err := createBackup()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Backup created successfully", "backup": backup})
+}
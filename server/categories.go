@@ -0,0 +1,305 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Categories without an owner are the shared defaults, which users can see
+// and pick but not change. A category can have a parent of the same type,
+// one level deep ("Alimentação" > "Restaurantes"); reports by category roll
+// the subcategories up into their parent. Names are unique per type among
+// the categories a user can see, ignoring case.
+
+const categoryColumns = "id, parent_id, name, type, color, icon, user_id IS NULL, created_at"
+
+func scanCategory(scanner interface{ Scan(...interface{}) error }) (Category, error) {
+	var cat Category
+	err := scanner.Scan(&cat.ID, &cat.ParentID, &cat.Name, &cat.Type, &cat.Color, &cat.Icon, &cat.IsDefault, &cat.CreatedAt)
+	return cat, err
+}
+
+func getCategories(c *gin.Context) {
+	rows, err := db.Query(
+		"SELECT "+categoryColumns+" FROM categories WHERE user_id IS NULL OR user_id = ? ORDER BY type, name",
+		currentUserID(c),
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		cat, err := scanCategory(rows)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		categories = append(categories, cat)
+	}
+
+	c.JSON(200, categories)
+}
+
+// Check a category about to be saved, returning what is wrong with it
+func validateCategory(cat Category, userID int) string {
+	if cat.Name == "" {
+		return "name is required"
+	}
+	if cat.Type != "income" && cat.Type != "expense" {
+		return "type must be income or expense"
+	}
+	if categoryNameTaken(userID, cat.Name, cat.Type, cat.ID) {
+		return fmt.Sprintf("Category %s already exists", cat.Name)
+	}
+	if cat.ParentID == nil {
+		return ""
+	}
+
+	var parentType string
+	var grandparentID *int
+	err := db.QueryRow(
+		"SELECT type, parent_id FROM categories WHERE id = ? AND (user_id IS NULL OR user_id = ?)",
+		*cat.ParentID, userID,
+	).Scan(&parentType, &grandparentID)
+	if err != nil {
+		return "Parent category not found"
+	}
+	if *cat.ParentID == cat.ID {
+		return "A category can't be its own parent"
+	}
+	if parentType != cat.Type {
+		return "Parent category must have the same type"
+	}
+	if grandparentID != nil {
+		return "Parent category is already a subcategory"
+	}
+	var children int
+	db.QueryRow("SELECT COUNT(*) FROM categories WHERE parent_id = ?", cat.ID).Scan(&children)
+	if children > 0 {
+		return "A category with subcategories can't have a parent"
+	}
+	return ""
+}
+
+// Category names are unique per type among the user's categories and the
+// shared defaults, ignoring case
+func categoryNameTaken(userID int, name, categoryType string, exceptID int) bool {
+	var taken bool
+	db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM categories WHERE (user_id IS NULL OR user_id = ?) AND type = ? AND name = ? COLLATE NOCASE AND id != ?)",
+		userID, categoryType, name, exceptID,
+	).Scan(&taken)
+	return taken
+}
+
+func createCategory(c *gin.Context) {
+	var cat Category
+	if err := c.ShouldBindJSON(&cat); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID := currentUserID(c)
+
+	cat.Name = strings.TrimSpace(cat.Name)
+	if cat.Color == "" {
+		cat.Color = "#6B7280"
+	}
+	if cat.Icon == "" {
+		cat.Icon = "💰"
+	}
+	if message := validateCategory(cat, userID); message != "" {
+		c.JSON(400, gin.H{"error": message})
+		return
+	}
+
+	result, err := db.Exec(
+		"INSERT INTO categories (user_id, parent_id, name, type, color, icon) VALUES (?, ?, ?, ?, ?, ?)",
+		userID, cat.ParentID, cat.Name, cat.Type, cat.Color, cat.Icon,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	cat, err = scanCategory(db.QueryRow("SELECT "+categoryColumns+" FROM categories WHERE id = ?", id))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, cat)
+}
+
+// Find one of the user's own categories for changing it, answering the
+// request when it is missing or a shared default
+func ownCategory(c *gin.Context, userID int) (Category, bool) {
+	cat, err := scanCategory(db.QueryRow(
+		"SELECT "+categoryColumns+" FROM categories WHERE id = ? AND (user_id IS NULL OR user_id = ?)",
+		c.Param("id"), userID,
+	))
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Category not found"})
+		return cat, false
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return cat, false
+	}
+	if cat.IsDefault {
+		c.JSON(403, gin.H{"error": "Default categories can't be changed"})
+		return cat, false
+	}
+	return cat, true
+}
+
+// Update a category. Fields left out of the request keep their values; the
+// type can't change, since the category's transactions have it.
+func updateCategory(c *gin.Context) {
+	var update struct {
+		Name     *string `json:"name"`
+		Type     *string `json:"type"`
+		Color    *string `json:"color"`
+		Icon     *string `json:"icon"`
+		ParentID *int    `json:"parent_id"`
+		// Without a parent_id in the request the parent is kept; true
+		// makes the category a top-level one
+		RemoveParent bool `json:"remove_parent"`
+	}
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID := currentUserID(c)
+
+	cat, ok := ownCategory(c, userID)
+	if !ok {
+		return
+	}
+	if update.Type != nil && *update.Type != cat.Type {
+		c.JSON(400, gin.H{"error": "The type of a category can't be changed"})
+		return
+	}
+	if update.Name != nil {
+		cat.Name = strings.TrimSpace(*update.Name)
+	}
+	if update.Color != nil && *update.Color != "" {
+		cat.Color = *update.Color
+	}
+	if update.Icon != nil && *update.Icon != "" {
+		cat.Icon = *update.Icon
+	}
+	if update.RemoveParent {
+		cat.ParentID = nil
+	} else if update.ParentID != nil {
+		cat.ParentID = update.ParentID
+	}
+	if message := validateCategory(cat, userID); message != "" {
+		c.JSON(400, gin.H{"error": message})
+		return
+	}
+
+	_, err := db.Exec(
+		"UPDATE categories SET parent_id = ?, name = ?, color = ?, icon = ? WHERE id = ?",
+		cat.ParentID, cat.Name, cat.Color, cat.Icon, cat.ID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, cat)
+}
+
+// Delete a category. Its transactions (split lines, installments and salary
+// included) move to the category given as ?reassign_to=, of the same type,
+// or are left uncategorized; its categorization rules move along or are
+// deleted. Its subcategories become top-level categories.
+func deleteCategory(c *gin.Context) {
+	userID := currentUserID(c)
+	cat, ok := ownCategory(c, userID)
+	if !ok {
+		return
+	}
+
+	var reassignTo *int
+	if value := c.Query("reassign_to"); value != "" {
+		id, err := strconv.Atoi(value)
+		if err != nil || id == cat.ID {
+			c.JSON(400, gin.H{"error": "Invalid reassign_to"})
+			return
+		}
+		var targetType string
+		err = db.QueryRow(
+			"SELECT type FROM categories WHERE id = ? AND (user_id IS NULL OR user_id = ?)", id, userID,
+		).Scan(&targetType)
+		if err != nil {
+			c.JSON(400, gin.H{"error": "Category to reassign to not found"})
+			return
+		}
+		if targetType != cat.Type {
+			c.JSON(400, gin.H{"error": "Category to reassign to must have the same type"})
+			return
+		}
+		reassignTo = &id
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	// Transactions in the trash are moved too, so they are restored with a
+	// category that exists
+	audited := []struct{ table, entity string }{
+		{"transactions", "transaction"},
+		{"installments", "installment"},
+		{"salary_config", "salary_config"},
+	}
+	reassigned := 0
+	for _, t := range audited {
+		previous := auditRows(tx, t.table, "category_id = ?", cat.ID)
+		if _, err := tx.Exec(fmt.Sprintf("UPDATE %s SET category_id = ? WHERE category_id = ?", t.table), reassignTo, cat.ID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		for _, row := range previous {
+			recordAudit(c, tx, t.entity, row["id"], row, auditRow(tx, t.table, row["id"]))
+		}
+		if t.table == "transactions" {
+			reassigned = len(previous)
+		}
+	}
+
+	rules := "DELETE FROM categorization_rules WHERE category_id = ?2"
+	if reassignTo != nil {
+		rules = "UPDATE categorization_rules SET category_id = ?1 WHERE category_id = ?2"
+	}
+	statements := []string{
+		"UPDATE transaction_splits SET category_id = ?1 WHERE category_id = ?2",
+		rules,
+		"UPDATE categories SET parent_id = NULL WHERE parent_id = ?2",
+		"DELETE FROM categories WHERE id = ?2",
+	}
+	for _, stmt := range statements {
+		if _, err := tx.Exec(stmt, reassignTo, cat.ID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	resetCategoryModels()
+
+	c.JSON(200, gin.H{"message": "Category deleted successfully", "transactions_reassigned": reassigned})
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Categorization rules pick the category of transactions created or imported
+// without one. A rule matches when all of its conditions hold: the
+// description contains a text or matches a regular expression (both ignoring
+// case), the amount is within a range and the transaction is on an account.
+// Rules only categorize transactions of their category's type, and the one
+// with the highest priority wins (the oldest on a tie).
+
+type CategorizationRule struct {
+	ID                  int      `json:"id"`
+	Name                string   `json:"name"`
+	DescriptionContains *string  `json:"description_contains"`
+	DescriptionRegex    *string  `json:"description_regex"`
+	MinAmount           *float64 `json:"min_amount"`
+	MaxAmount           *float64 `json:"max_amount"`
+	AccountID           *int     `json:"account_id"`
+	CategoryID          int      `json:"category_id"`
+	CategoryName        string   `json:"category_name"`
+	CategoryType        string   `json:"category_type"`
+	Priority            int      `json:"priority"`
+	Active              bool     `json:"active"`
+	CreatedAt           string   `json:"created_at"`
+	UpdatedAt           string   `json:"updated_at"`
+
+	regex *regexp.Regexp
+}
+
+const categorizationRuleColumns = `r.id, r.name, r.description_contains, r.description_regex, r.min_amount, r.max_amount,
+	r.account_id, r.category_id, c.name, c.type, r.priority, r.active, r.created_at, r.updated_at`
+
+func scanCategorizationRule(scanner interface{ Scan(...interface{}) error }) (CategorizationRule, error) {
+	var rule CategorizationRule
+	err := scanner.Scan(
+		&rule.ID, &rule.Name, &rule.DescriptionContains, &rule.DescriptionRegex, &rule.MinAmount, &rule.MaxAmount,
+		&rule.AccountID, &rule.CategoryID, &rule.CategoryName, &rule.CategoryType, &rule.Priority, &rule.Active,
+		&rule.CreatedAt, &rule.UpdatedAt,
+	)
+	if err != nil {
+		return rule, err
+	}
+	if rule.DescriptionRegex != nil {
+		if rule.regex, err = compileRuleRegex(*rule.DescriptionRegex); err != nil {
+			return rule, fmt.Errorf("rule %d has an invalid regex: %v", rule.ID, err)
+		}
+	}
+	return rule, nil
+}
+
+func compileRuleRegex(pattern string) (*regexp.Regexp, error) {
+	return regexp.Compile("(?i)" + pattern)
+}
+
+// The user's rules in the order they are tried. Rules whose category is gone
+// are left out.
+func loadCategorizationRules(q queryer, userID int, activeOnly bool) ([]CategorizationRule, error) {
+	query := "SELECT " + categorizationRuleColumns + `
+		FROM categorization_rules r
+		JOIN categories c ON c.id = r.category_id
+		WHERE r.user_id = ?`
+	if activeOnly {
+		query += " AND r.active = 1"
+	}
+	query += " ORDER BY r.priority DESC, r.id"
+
+	rows, err := q.Query(query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []CategorizationRule{}
+	for rows.Next() {
+		rule, err := scanCategorizationRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func validateCategorizationRule(rule *CategorizationRule, userID int) string {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" {
+		return "name is required"
+	}
+	for _, text := range []**string{&rule.DescriptionContains, &rule.DescriptionRegex} {
+		if *text != nil && strings.TrimSpace(**text) == "" {
+			*text = nil
+		}
+	}
+	if rule.DescriptionContains == nil && rule.DescriptionRegex == nil &&
+		rule.MinAmount == nil && rule.MaxAmount == nil && rule.AccountID == nil {
+		return "at least one condition is required"
+	}
+	if rule.DescriptionRegex != nil {
+		if _, err := compileRuleRegex(*rule.DescriptionRegex); err != nil {
+			return fmt.Sprintf("invalid description_regex: %v", err)
+		}
+	}
+	if rule.MinAmount != nil && rule.MaxAmount != nil && *rule.MinAmount > *rule.MaxAmount {
+		return "min_amount cannot be greater than max_amount"
+	}
+	if rule.AccountID != nil && accountRole(*rule.AccountID, userID) == "" {
+		return "Account not found"
+	}
+	if !categoryVisible(&rule.CategoryID, userID) {
+		return "Category not found"
+	}
+	return ""
+}
+
+// Whether a transaction meets every condition of a rule
+func (rule CategorizationRule) matches(description string, amount float64, accountID int, transactionType string) bool {
+	if rule.CategoryType != transactionType {
+		return false
+	}
+	if rule.AccountID != nil && *rule.AccountID != accountID {
+		return false
+	}
+	if rule.MinAmount != nil && amount < *rule.MinAmount {
+		return false
+	}
+	if rule.MaxAmount != nil && amount > *rule.MaxAmount {
+		return false
+	}
+	if rule.DescriptionContains != nil && !strings.Contains(strings.ToLower(description), strings.ToLower(*rule.DescriptionContains)) {
+		return false
+	}
+	if rule.regex != nil && !rule.regex.MatchString(description) {
+		return false
+	}
+	return true
+}
+
+// The first rule matching a transaction, nil when none does
+func matchCategorizationRule(rules []CategorizationRule, description string, amount float64, accountID int, transactionType string) *CategorizationRule {
+	for i := range rules {
+		if rules[i].matches(description, amount, accountID, transactionType) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
+
+// Get the user's categorization rules, in the order they are tried
+func getCategorizationRules(c *gin.Context) {
+	rules, err := loadCategorizationRules(db, currentUserID(c), false)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, rules)
+}
+
+// Create categorization rule
+func createCategorizationRule(c *gin.Context) {
+	rule := CategorizationRule{Active: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID := currentUserID(c)
+	if msg := validateCategorizationRule(&rule, userID); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	result, err := db.Exec(
+		`INSERT INTO categorization_rules
+		 (user_id, name, description_contains, description_regex, min_amount, max_amount, account_id, category_id, priority, active)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, rule.Name, rule.DescriptionContains, rule.DescriptionRegex, rule.MinAmount, rule.MaxAmount,
+		rule.AccountID, rule.CategoryID, rule.Priority, rule.Active,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	created, err := scanCategorizationRule(db.QueryRow(
+		"SELECT "+categorizationRuleColumns+" FROM categorization_rules r JOIN categories c ON c.id = r.category_id WHERE r.id = ?", id,
+	))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, created)
+}
+
+// Update categorization rule
+func updateCategorizationRule(c *gin.Context) {
+	rule := CategorizationRule{Active: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID := currentUserID(c)
+	if msg := validateCategorizationRule(&rule, userID); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	result, err := db.Exec(
+		`UPDATE categorization_rules SET
+		 name = ?, description_contains = ?, description_regex = ?, min_amount = ?, max_amount = ?,
+		 account_id = ?, category_id = ?, priority = ?, active = ?, updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ? AND user_id = ?`,
+		rule.Name, rule.DescriptionContains, rule.DescriptionRegex, rule.MinAmount, rule.MaxAmount,
+		rule.AccountID, rule.CategoryID, rule.Priority, rule.Active, c.Param("id"), userID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Categorization rule not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Categorization rule updated successfully"})
+}
+
+// Delete categorization rule
+func deleteCategorizationRule(c *gin.Context) {
+	result, err := db.Exec("DELETE FROM categorization_rules WHERE id = ? AND user_id = ?", c.Param("id"), currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Categorization rule not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Categorization rule deleted successfully"})
+}
+
+// Run the active rules over the uncategorized transactions the user can
+// change and categorize the ones a rule matches. Split transactions are left
+// alone: their lines have the categories. With ?dry_run=true nothing is
+// changed and the response tells what would be.
+func applyCategorizationRules(c *gin.Context) {
+	userID := currentUserID(c)
+	dryRun := c.Query("dry_run") == "true"
+
+	rules, err := loadCategorizationRules(db, userID, true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := db.Query(`
+		SELECT t.id, t.account_id, t.type, t.amount, COALESCE(t.description, ''), t.date
+		FROM transactions t
+		WHERE t.category_id IS NULL AND t.deleted_at IS NULL
+			AND t.account_id IN (`+editableAccountsQuery+`)
+			AND NOT EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = t.id)
+		ORDER BY t.date, t.id
+	`, userID, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	type change struct {
+		TransactionID int     `json:"transaction_id"`
+		Date          string  `json:"date"`
+		Description   string  `json:"description"`
+		Amount        float64 `json:"amount"`
+		RuleID        int     `json:"rule_id"`
+		RuleName      string  `json:"rule_name"`
+		CategoryID    int     `json:"category_id"`
+		CategoryName  string  `json:"category_name"`
+	}
+	changes := []change{}
+	examined := 0
+	for rows.Next() {
+		var id, accountID int
+		var transactionType, description, date string
+		var amount float64
+		if err := rows.Scan(&id, &accountID, &transactionType, &amount, &description, &date); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		examined++
+		if rule := matchCategorizationRule(rules, description, amount, accountID, transactionType); rule != nil {
+			changes = append(changes, change{
+				TransactionID: id,
+				Date:          date,
+				Description:   description,
+				Amount:        amount,
+				RuleID:        rule.ID,
+				RuleName:      rule.Name,
+				CategoryID:    rule.CategoryID,
+				CategoryName:  rule.CategoryName,
+			})
+		}
+	}
+	rows.Close()
+
+	if !dryRun && len(changes) > 0 {
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		defer tx.Rollback()
+
+		for _, ch := range changes {
+			before := auditRow(tx, "transactions", ch.TransactionID)
+			if _, err := tx.Exec("UPDATE transactions SET category_id = ? WHERE id = ?", ch.CategoryID, ch.TransactionID); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(c, tx, "transaction", ch.TransactionID, before, auditRow(tx, "transactions", ch.TransactionID))
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		for _, ch := range changes {
+			learnTransaction(ch.TransactionID, 1)
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"dry_run":     dryRun,
+		"examined":    examined,
+		"categorized": len(changes),
+		"changes":     changes,
+	})
+}
+
+// Pick the category of a transaction given without one with the user's
+// rules. Returns the rule that matched, nil when none did.
+func categorizeTransaction(q queryer, userID int, t *Transaction) (*CategorizationRule, error) {
+	if t.CategoryID != nil || len(t.Splits) > 0 {
+		return nil, nil
+	}
+	rules, err := loadCategorizationRules(q, userID, true)
+	if err != nil {
+		return nil, err
+	}
+	description := ""
+	if t.Description != nil {
+		description = *t.Description
+	}
+	rule := matchCategorizationRule(rules, description, t.Amount, t.AccountID, t.Type)
+	if rule != nil {
+		categoryID := rule.CategoryID
+		t.CategoryID = &categoryID
+	}
+	return rule, nil
+}
+
+// Category ID picked by the rules for a row being imported, nil when no
+// rule matches
+func categorizeImportedRow(rules []CategorizationRule, values map[string]interface{}) interface{} {
+	description, _ := values["description"].(string)
+	transactionType, _ := values["type"].(string)
+	var amount float64
+	switch v := values["amount"].(type) {
+	case float64:
+		amount = v
+	case int64:
+		amount = float64(v)
+	}
+	var accountID int
+	if id, ok := values["account_id"].(int64); ok {
+		accountID = int(id)
+	}
+	if rule := matchCategorizationRule(rules, description, amount, accountID, transactionType); rule != nil {
+		return int64(rule.CategoryID)
+	}
+	return nil
+}
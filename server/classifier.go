@@ -0,0 +1,337 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Category suggestions learned from the user's history: a multinomial naive
+// Bayes classifier over the words of the descriptions of their categorized
+// transactions (the lines of split ones). Every user has a model, trained
+// from the database the first time it is needed and then kept up to date:
+// new transactions are learned before each suggestion, edits and deletes
+// through the API adjust it in place, and bulk changes (restoring from the
+// trash, clearing, removing an account) drop it to be trained again.
+
+const maxCategorySuggestions = 5
+
+type categoryModel struct {
+	// Transactions (lines) per category
+	docs map[int]int
+	// Word counts per category, and their totals
+	words      map[int]map[string]int
+	wordTotals map[int]int
+	// Number of categories each word was seen in
+	vocabulary map[string]int
+	totalDocs  int
+	// Highest transaction ID learned; newer ones are learned on the next use
+	lastID int
+}
+
+var (
+	categoryModels     = make(map[int]*categoryModel)
+	categoryModelMutex sync.Mutex
+)
+
+var accentFolder = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n",
+)
+
+// Words of a description, lowercased and without accents. Numbers and
+// single letters (card digits, installment counters...) are left out.
+func descriptionWords(description string) []string {
+	folded := accentFolder.Replace(strings.ToLower(description))
+	fields := strings.FieldsFunc(folded, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	words := fields[:0]
+	for _, word := range fields {
+		if len(word) < 2 {
+			continue
+		}
+		if _, err := strconv.Atoi(word); err == nil {
+			continue
+		}
+		words = append(words, word)
+	}
+	return words
+}
+
+func newCategoryModel() *categoryModel {
+	return &categoryModel{
+		docs:       make(map[int]int),
+		words:      make(map[int]map[string]int),
+		wordTotals: make(map[int]int),
+		vocabulary: make(map[string]int),
+	}
+}
+
+// Add (weight 1) or remove (weight -1) a categorized description
+func (m *categoryModel) learn(categoryID int, description string, weight int) {
+	words := descriptionWords(description)
+	if len(words) == 0 {
+		return
+	}
+	counts := m.words[categoryID]
+	if counts == nil {
+		counts = make(map[string]int)
+		m.words[categoryID] = counts
+	}
+	m.docs[categoryID] += weight
+	m.totalDocs += weight
+	for _, word := range words {
+		if counts[word] == 0 && weight > 0 {
+			m.vocabulary[word]++
+		}
+		counts[word] += weight
+		m.wordTotals[categoryID] += weight
+		if counts[word] <= 0 {
+			delete(counts, word)
+			if m.vocabulary[word]--; m.vocabulary[word] <= 0 {
+				delete(m.vocabulary, word)
+			}
+		}
+	}
+	if m.docs[categoryID] <= 0 {
+		delete(m.docs, categoryID)
+		delete(m.words, categoryID)
+		delete(m.wordTotals, categoryID)
+	}
+}
+
+type categoryLine struct {
+	id          int
+	categoryID  int
+	description string
+}
+
+// Categorized lines of the user's transactions matching a condition on t
+func categorizedLines(userID int, condition string, args ...interface{}) ([]categoryLine, error) {
+	rows, err := db.Query(`
+		SELECT t.id, t.category_id, t.description
+		FROM (`+transactionLinesQuery+`) t
+		JOIN accounts a ON a.id = t.account_id
+		WHERE t.user_id = ? AND t.deleted_at IS NULL AND a.deleted_at IS NULL
+			AND t.category_id IS NOT NULL AND t.description IS NOT NULL AND `+condition+`
+		ORDER BY t.id
+	`, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var lines []categoryLine
+	for rows.Next() {
+		var line categoryLine
+		if err := rows.Scan(&line.id, &line.categoryID, &line.description); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, rows.Err()
+}
+
+// The user's model, trained or brought up to date with the transactions
+// created since it was last used. Call with categoryModelMutex held.
+func userCategoryModel(userID int) (*categoryModel, error) {
+	model := categoryModels[userID]
+	if model == nil {
+		model = newCategoryModel()
+	}
+
+	// Transactions are only created, never renumbered, so the ones above
+	// lastID are the ones the model has not seen
+	var lastID int
+	db.QueryRow("SELECT COALESCE(MAX(id), 0) FROM transactions WHERE user_id = ?", userID).Scan(&lastID)
+	if lastID > model.lastID {
+		lines, err := categorizedLines(userID, "t.id > ? AND t.id <= ?", model.lastID, lastID)
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range lines {
+			model.learn(line.categoryID, line.description, 1)
+		}
+		model.lastID = lastID
+	}
+
+	categoryModels[userID] = model
+	return model, nil
+}
+
+// Add (weight 1) or remove (weight -1) a transaction from its owner's model,
+// if the model has already seen it. Call with the transaction as it is in the
+// database: before changing it to remove it, after to learn it again.
+func learnTransaction(id interface{}, weight int) {
+	categoryModelMutex.Lock()
+	defer categoryModelMutex.Unlock()
+
+	var ownerID, transactionID int
+	if err := db.QueryRow("SELECT user_id, id FROM transactions WHERE id = ?", id).Scan(&ownerID, &transactionID); err != nil {
+		return
+	}
+	model := categoryModels[ownerID]
+	if model == nil || transactionID > model.lastID {
+		return
+	}
+	lines, err := categorizedLines(ownerID, "t.id = ?", transactionID)
+	if err != nil {
+		// Better to train again than to drift
+		delete(categoryModels, ownerID)
+		return
+	}
+	for _, line := range lines {
+		model.learn(line.categoryID, line.description, weight)
+	}
+}
+
+// Drop the models after changes too broad to follow, so they are trained
+// again from the database when next needed. Bulk changes can touch the
+// transactions of several users (on a shared account), so all of them go.
+func resetCategoryModels() {
+	categoryModelMutex.Lock()
+	categoryModels = make(map[int]*categoryModel)
+	categoryModelMutex.Unlock()
+}
+
+type categoryScore struct {
+	categoryID int
+	score      float64
+}
+
+// Log-probability of each category given the words, with add-one smoothing.
+// Words the model has never seen carry no information and are skipped.
+func (m *categoryModel) scores(words []string) []categoryScore {
+	var known []string
+	for _, word := range words {
+		if m.vocabulary[word] > 0 {
+			known = append(known, word)
+		}
+	}
+	if len(known) == 0 || m.totalDocs == 0 {
+		return nil
+	}
+
+	vocabularySize := float64(len(m.vocabulary))
+	scores := make([]categoryScore, 0, len(m.docs))
+	for categoryID, docs := range m.docs {
+		score := math.Log(float64(docs) / float64(m.totalDocs))
+		denominator := float64(m.wordTotals[categoryID]) + vocabularySize
+		for _, word := range known {
+			score += math.Log((float64(m.words[categoryID][word]) + 1) / denominator)
+		}
+		scores = append(scores, categoryScore{categoryID, score})
+	}
+	return scores
+}
+
+type CategorySuggestion struct {
+	CategoryID    int     `json:"category_id"`
+	CategoryName  string  `json:"category_name"`
+	CategoryType  string  `json:"category_type"`
+	CategoryColor string  `json:"category_color"`
+	CategoryIcon  string  `json:"category_icon"`
+	Confidence    float64 `json:"confidence"`
+}
+
+// Suggest categories for a description, most likely first. Confidence is the
+// probability the model gives each category, among the ones of the type when
+// type is given.
+func suggestCategory(c *gin.Context) {
+	description := strings.TrimSpace(c.Query("description"))
+	if description == "" {
+		c.JSON(400, gin.H{"error": "Query parameter 'description' is required"})
+		return
+	}
+	transactionType := c.Query("type")
+	if transactionType != "" && transactionType != "income" && transactionType != "expense" {
+		c.JSON(400, gin.H{"error": "type must be income or expense"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "3"))
+	if err != nil || limit <= 0 || limit > maxCategorySuggestions {
+		limit = 3
+	}
+	userID := currentUserID(c)
+
+	categoryModelMutex.Lock()
+	model, err := userCategoryModel(userID)
+	var scores []categoryScore
+	trainedOn := 0
+	if err == nil {
+		scores = model.scores(descriptionWords(description))
+		trainedOn = model.totalDocs
+	}
+	categoryModelMutex.Unlock()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Categories the user can still see, which may have been deleted since
+	// the model learned them
+	categories := make(map[int]CategorySuggestion)
+	rows, err := db.Query("SELECT id, name, type, color, icon FROM categories WHERE user_id IS NULL OR user_id = ?", userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for rows.Next() {
+		var s CategorySuggestion
+		if err := rows.Scan(&s.CategoryID, &s.CategoryName, &s.CategoryType, &s.CategoryColor, &s.CategoryIcon); err == nil {
+			categories[s.CategoryID] = s
+		}
+	}
+	rows.Close()
+
+	var candidates []categoryScore
+	for _, s := range scores {
+		category, ok := categories[s.categoryID]
+		if ok && (transactionType == "" || category.CategoryType == transactionType) {
+			candidates = append(candidates, s)
+		}
+	}
+
+	// Normalize the log-probabilities into probabilities (softmax)
+	suggestions := []CategorySuggestion{}
+	if len(candidates) > 0 {
+		best := candidates[0].score
+		for _, s := range candidates {
+			best = math.Max(best, s.score)
+		}
+		total := 0.0
+		for _, s := range candidates {
+			total += math.Exp(s.score - best)
+		}
+		for _, s := range candidates {
+			suggestion := categories[s.categoryID]
+			suggestion.Confidence = math.Round(math.Exp(s.score-best)/total*1000) / 1000
+			suggestions = append(suggestions, suggestion)
+		}
+		sort.SliceStable(suggestions, func(i, j int) bool {
+			if suggestions[i].Confidence != suggestions[j].Confidence {
+				return suggestions[i].Confidence > suggestions[j].Confidence
+			}
+			return suggestions[i].CategoryID < suggestions[j].CategoryID
+		})
+		if len(suggestions) > limit {
+			suggestions = suggestions[:limit]
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"description": description,
+		"suggestions": suggestions,
+		"trained_on":  trainedOn,
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+type trainingLine struct {
+	categoryID  int
+	description string
+}
+
+func trainedModel(lines []trainingLine) *categoryModel {
+	m := newCategoryModel()
+	for _, line := range lines {
+		m.learn(line.categoryID, line.description, 1)
+	}
+	return m
+}
+
+func TestDescriptionWords(t *testing.T) {
+	tests := []struct {
+		description string
+		want        []string
+	}{
+		{"UBER *TRIP", []string{"uber", "trip"}},
+		{"Padaria São João", []string{"padaria", "sao", "joao"}},
+		{"PAG*IFOOD 3/12", []string{"pag", "ifood"}},
+		{"Cartão final 1234 - Posto Shell", []string{"cartao", "final", "posto", "shell"}},
+		{"a 1 2", []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.description, func(t *testing.T) {
+			got := descriptionWords(tt.description)
+			if len(got) == 0 && len(tt.want) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("descriptionWords(%q) = %v, want %v", tt.description, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCategoryModelScores(t *testing.T) {
+	training := []trainingLine{
+		{1, "Uber trip"},
+		{1, "Uber trip help"},
+		{1, "99 Taxi"},
+		{2, "iFood pizza"},
+		{2, "iFood restaurante"},
+		{3, "Posto Shell"},
+	}
+
+	tests := []struct {
+		name        string
+		lines       []trainingLine
+		description string
+		wantBest    int
+		wantNone    bool
+	}{
+		{name: "seen word", lines: training, description: "UBER *TRIP", wantBest: 1},
+		{name: "case and accents are ignored", lines: []trainingLine{{1, "Padaria Pão Quente"}, {2, "Posto Shell"}}, description: "PADARIA PAO DE ACUCAR", wantBest: 1},
+		{name: "unknown words are skipped", lines: training, description: "Posto Shell Ipiranga", wantBest: 3},
+		{name: "only unknown words", lines: training, description: "Farmácia", wantNone: true},
+		{name: "empty model", description: "Uber", wantNone: true},
+		{
+			name: "more frequent category wins a tie on words",
+			lines: []trainingLine{
+				{1, "mercado"}, {2, "mercado"}, {2, "mercado"},
+			},
+			description: "Mercado",
+			wantBest:    2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scores := trainedModel(tt.lines).scores(descriptionWords(tt.description))
+			if tt.wantNone {
+				if len(scores) != 0 {
+					t.Errorf("scores(%q) = %v, want none", tt.description, scores)
+				}
+				return
+			}
+			if len(scores) == 0 {
+				t.Fatalf("scores(%q) returned nothing", tt.description)
+			}
+			sort.Slice(scores, func(i, j int) bool { return scores[i].score > scores[j].score })
+			if scores[0].categoryID != tt.wantBest {
+				t.Errorf("best category for %q = %d, want %d (scores %v)", tt.description, scores[0].categoryID, tt.wantBest, scores)
+			}
+		})
+	}
+}
+
+func TestCategoryModelScoreValues(t *testing.T) {
+	m := trainedModel([]trainingLine{{1, "uber trip"}, {2, "ifood pizza"}})
+
+	// Each category has one of the two lines and two of the four words
+	want := map[int]float64{
+		1: math.Log(0.5) + math.Log(2.0/6),
+		2: math.Log(0.5) + math.Log(1.0/6),
+	}
+	scores := m.scores([]string{"uber"})
+	if len(scores) != len(want) {
+		t.Fatalf("scores = %v, want %d categories", scores, len(want))
+	}
+	for _, s := range scores {
+		if math.Abs(s.score-want[s.categoryID]) > 1e-12 {
+			t.Errorf("score of category %d = %v, want %v", s.categoryID, s.score, want[s.categoryID])
+		}
+	}
+}
+
+func TestCategoryModelUnlearn(t *testing.T) {
+	lines := []trainingLine{{1, "Uber trip"}, {2, "iFood pizza"}, {2, "iFood lanche"}}
+	m := trainedModel(lines)
+	m.learn(1, "Uber Eats lanche", 1)
+	m.learn(1, "Uber Eats lanche", -1)
+
+	expected := trainedModel(lines)
+	if !reflect.DeepEqual(m, expected) {
+		t.Errorf("model after learning and removing a line = %+v, want %+v", m, expected)
+	}
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Server settings. They are read at startup from a YAML or TOML file (the one
+// named by CONFIG_FILE, or config.yaml, config.yml or config.toml in the
+// working directory), then overridden by environment variables, and checked
+// before the server starts. See config.example.yaml for every setting.
+
+type Config struct {
+	Port         int      `yaml:"port" toml:"port"`
+	DatabasePath string   `yaml:"database_path" toml:"database_path"`
+	CORSOrigins  []string `yaml:"cors_origins" toml:"cors_origins"`
+
+	Updaters struct {
+		SalaryCheckInterval Duration `yaml:"salary_check_interval" toml:"salary_check_interval"`
+		PriceUpdateInterval Duration `yaml:"price_update_interval" toml:"price_update_interval"`
+		// Wait before the first price update after startup
+		PriceUpdateDelay Duration `yaml:"price_update_delay" toml:"price_update_delay"`
+	} `yaml:"updaters" toml:"updaters"`
+
+	Providers struct {
+		StatusInvestTimeout Duration `yaml:"statusinvest_timeout" toml:"statusinvest_timeout"`
+		YahooTimeout        Duration `yaml:"yahoo_timeout" toml:"yahoo_timeout"`
+	} `yaml:"providers" toml:"providers"`
+
+	Trash struct {
+		RetentionDays int `yaml:"retention_days" toml:"retention_days"`
+	} `yaml:"trash" toml:"trash"`
+
+	Backup struct {
+		Dir string `yaml:"dir" toml:"dir"`
+		// Hours between scheduled backups, 0 disables them
+		IntervalHours int  `yaml:"interval_hours" toml:"interval_hours"`
+		Keep          int  `yaml:"keep" toml:"keep"`
+		Gzip          bool `yaml:"gzip" toml:"gzip"`
+	} `yaml:"backup" toml:"backup"`
+
+	Attachments struct {
+		Dir       string `yaml:"dir" toml:"dir"`
+		MaxSizeMB int    `yaml:"max_size_mb" toml:"max_size_mb"`
+	} `yaml:"attachments" toml:"attachments"`
+
+	// Shared categories every user can pick, created when missing
+	DefaultCategories []DefaultCategory `yaml:"default_categories" toml:"default_categories"`
+}
+
+type DefaultCategory struct {
+	Name  string `yaml:"name" toml:"name"`
+	Type  string `yaml:"type" toml:"type"`
+	Color string `yaml:"color" toml:"color"`
+	Icon  string `yaml:"icon" toml:"icon"`
+}
+
+// A time.Duration written as "10m", "1h30m"...
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	value, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(value)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	var cfg Config
+	cfg.Port = 5000
+	cfg.DatabasePath = "database.sqlite"
+	cfg.CORSOrigins = []string{"http://localhost:3001", "http://localhost:3000"}
+	cfg.Updaters.SalaryCheckInterval = Duration(time.Hour)
+	cfg.Updaters.PriceUpdateInterval = Duration(10 * time.Minute)
+	cfg.Updaters.PriceUpdateDelay = Duration(2 * time.Minute)
+	cfg.Providers.StatusInvestTimeout = Duration(10 * time.Second)
+	cfg.Providers.YahooTimeout = Duration(15 * time.Second)
+	cfg.Trash.RetentionDays = 30
+	cfg.Backup.Dir = "backups"
+	cfg.Backup.IntervalHours = 24
+	cfg.Backup.Keep = 7
+	cfg.Attachments.Dir = "attachments"
+	cfg.Attachments.MaxSizeMB = 10
+	cfg.DefaultCategories = []DefaultCategory{
+		{"Salário", "income", "#10B981", "💼"},
+		{"Freelance", "income", "#10B981", "💻"},
+		{"Investimentos", "income", "#10B981", "📈"},
+		{"Presentes", "income", "#10B981", "🎁"},
+		{"Dividendos", "income", "#10B981", "💵"},
+		{"JCP", "income", "#10B981", "🏦"},
+		{"Outros", "income", "#10B981", "💰"},
+		{"Alimentação", "expense", "#EF4444", "🍔"},
+		{"Transporte", "expense", "#EF4444", "🚗"},
+		{"Moradia", "expense", "#EF4444", "🏠"},
+		{"Saúde", "expense", "#EF4444", "🏥"},
+		{"Educação", "expense", "#EF4444", "📚"},
+		{"Lazer", "expense", "#EF4444", "🎮"},
+		{"Vestuário", "expense", "#EF4444", "👕"},
+		{"Contas", "expense", "#EF4444", "💳"},
+		{"Outros", "expense", "#EF4444", "💸"},
+	}
+	return cfg
+}
+
+// Load the configuration, exiting when it is invalid
+func loadConfig() Config {
+	cfg := defaultConfig()
+
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		for _, candidate := range []string{"config.yaml", "config.yml", "config.toml"} {
+			if _, err := os.Stat(candidate); err == nil {
+				path = candidate
+				break
+			}
+		}
+	}
+	if path != "" {
+		if err := readConfigFile(path, &cfg); err != nil {
+			log.Fatalf("Failed to read config file %s: %v", path, err)
+		}
+		log.Printf("Loaded config from %s", path)
+	}
+
+	if err := applyEnvOverrides(&cfg); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if errs := cfg.validate(); len(errs) > 0 {
+		log.Fatalf("Invalid configuration:\n  %s", strings.Join(errs, "\n  "))
+	}
+	return cfg
+}
+
+// Settings missing from the file keep their defaults; unknown settings are
+// rejected so typos do not go unnoticed
+func readConfigFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	// Lists in the file replace the default ones instead of extending them
+	defaults := *cfg
+	cfg.CORSOrigins = nil
+	cfg.DefaultCategories = nil
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		decoder := yaml.NewDecoder(bytes.NewReader(data))
+		decoder.KnownFields(true)
+		// An empty file is a valid one
+		if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return err
+		}
+	case ".toml":
+		err := toml.NewDecoder(bytes.NewReader(data)).DisallowUnknownFields().Decode(cfg)
+		var strictErr *toml.StrictMissingError
+		if errors.As(err, &strictErr) {
+			return fmt.Errorf("unknown settings:\n%s", strictErr.String())
+		}
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unsupported config format %q (use .yaml, .yml or .toml)", filepath.Ext(path))
+	}
+
+	if cfg.CORSOrigins == nil {
+		cfg.CORSOrigins = defaults.CORSOrigins
+	}
+	if cfg.DefaultCategories == nil {
+		cfg.DefaultCategories = defaults.DefaultCategories
+	}
+	return nil
+}
+
+// Environment variables override the file
+func applyEnvOverrides(cfg *Config) error {
+	str := func(name string, target *string) {
+		if value := os.Getenv(name); value != "" {
+			*target = value
+		}
+	}
+	integer := func(name string, target *int) error {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("%s: %q is not a number", name, value)
+			}
+			*target = n
+		}
+		return nil
+	}
+	duration := func(name string, target *Duration) error {
+		if value := os.Getenv(name); value != "" {
+			if err := target.UnmarshalText([]byte(value)); err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+		}
+		return nil
+	}
+
+	str("DATABASE_PATH", &cfg.DatabasePath)
+	str("BACKUP_DIR", &cfg.Backup.Dir)
+	str("ATTACHMENTS_DIR", &cfg.Attachments.Dir)
+	if value := os.Getenv("CORS_ORIGINS"); value != "" {
+		cfg.CORSOrigins = nil
+		for _, origin := range strings.Split(value, ",") {
+			if origin = strings.TrimSpace(origin); origin != "" {
+				cfg.CORSOrigins = append(cfg.CORSOrigins, origin)
+			}
+		}
+	}
+	if value := os.Getenv("BACKUP_GZIP"); value != "" {
+		gzip, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("BACKUP_GZIP: %q is not a boolean", value)
+		}
+		cfg.Backup.Gzip = gzip
+	}
+
+	for _, err := range []error{
+		integer("PORT", &cfg.Port),
+		integer("TRASH_RETENTION_DAYS", &cfg.Trash.RetentionDays),
+		integer("BACKUP_INTERVAL_HOURS", &cfg.Backup.IntervalHours),
+		integer("BACKUP_KEEP", &cfg.Backup.Keep),
+		integer("ATTACHMENTS_MAX_SIZE_MB", &cfg.Attachments.MaxSizeMB),
+		duration("SALARY_CHECK_INTERVAL", &cfg.Updaters.SalaryCheckInterval),
+		duration("PRICE_UPDATE_INTERVAL", &cfg.Updaters.PriceUpdateInterval),
+		duration("PRICE_UPDATE_DELAY", &cfg.Updaters.PriceUpdateDelay),
+		duration("STATUSINVEST_TIMEOUT", &cfg.Providers.StatusInvestTimeout),
+		duration("YAHOO_TIMEOUT", &cfg.Providers.YahooTimeout),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Problems with the configuration, empty when it is valid
+func (cfg Config) validate() []string {
+	var errs []string
+	if cfg.Port <= 0 || cfg.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("port: %d is not a valid port", cfg.Port))
+	}
+	if strings.TrimSpace(cfg.DatabasePath) == "" {
+		errs = append(errs, "database_path is required")
+	}
+	if len(cfg.CORSOrigins) == 0 {
+		errs = append(errs, "cors_origins: at least one origin is required")
+	}
+	for _, origin := range cfg.CORSOrigins {
+		if origin != "*" && !strings.HasPrefix(origin, "http://") && !strings.HasPrefix(origin, "https://") {
+			errs = append(errs, fmt.Sprintf("cors_origins: %q must start with http:// or https://", origin))
+		}
+	}
+
+	for _, setting := range []struct {
+		name  string
+		value Duration
+	}{
+		{"updaters.salary_check_interval", cfg.Updaters.SalaryCheckInterval},
+		{"updaters.price_update_interval", cfg.Updaters.PriceUpdateInterval},
+		{"providers.statusinvest_timeout", cfg.Providers.StatusInvestTimeout},
+		{"providers.yahoo_timeout", cfg.Providers.YahooTimeout},
+	} {
+		if setting.value <= 0 {
+			errs = append(errs, fmt.Sprintf("%s must be positive", setting.name))
+		}
+	}
+	if cfg.Updaters.PriceUpdateDelay < 0 {
+		errs = append(errs, "updaters.price_update_delay cannot be negative")
+	}
+
+	if cfg.Trash.RetentionDays <= 0 {
+		errs = append(errs, "trash.retention_days must be positive")
+	}
+	if strings.TrimSpace(cfg.Backup.Dir) == "" {
+		errs = append(errs, "backup.dir is required")
+	}
+	if cfg.Backup.IntervalHours < 0 {
+		errs = append(errs, "backup.interval_hours cannot be negative")
+	}
+	if cfg.Backup.Keep <= 0 {
+		errs = append(errs, "backup.keep must be positive")
+	}
+	if strings.TrimSpace(cfg.Attachments.Dir) == "" {
+		errs = append(errs, "attachments.dir is required")
+	}
+	if cfg.Attachments.MaxSizeMB <= 0 {
+		errs = append(errs, "attachments.max_size_mb must be positive")
+	}
+
+	for i, category := range cfg.DefaultCategories {
+		if strings.TrimSpace(category.Name) == "" {
+			errs = append(errs, fmt.Sprintf("default_categories[%d]: name is required", i))
+		}
+		if category.Type != "income" && category.Type != "expense" {
+			errs = append(errs, fmt.Sprintf("default_categories[%d]: type must be income or expense", i))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Amounts without an explicit currency are in reais
+const defaultCurrency = "BRL"
+
+var currencyCodePattern = regexp.MustCompile(`^[A-Z]{3}$`)
+
+var currencySymbols = map[string]string{
+	"BRL": "R$",
+	"USD": "US$",
+	"EUR": "€",
+	"GBP": "£",
+}
+
+func normalizeCurrency(code string) string {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return defaultCurrency
+	}
+	return code
+}
+
+func isValidCurrency(code string) bool {
+	return currencyCodePattern.MatchString(code)
+}
+
+func currencySymbol(code string) string {
+	if symbol, ok := currencySymbols[code]; ok {
+		return symbol
+	}
+	return code
+}
+
+func getSetting(userID int, key, fallback string) string {
+	var value string
+	if err := db.QueryRow("SELECT value FROM settings WHERE user_id = ? AND key = ?", userID, key).Scan(&value); err != nil {
+		return fallback
+	}
+	return value
+}
+
+func setSetting(userID int, key, value string) error {
+	_, err := db.Exec(`
+		INSERT INTO settings (user_id, key, value) VALUES (?, ?, ?)
+		ON CONFLICT(user_id, key) DO UPDATE SET value = excluded.value, updated_at = CURRENT_TIMESTAMP
+	`, userID, key, value)
+	return err
+}
+
+// Currency the user's reports are converted into
+func baseCurrency(userID int) string {
+	return getSetting(userID, "base_currency", defaultCurrency)
+}
+
+// fxConverter converts amounts between currencies using the stored rates
+// (price of one unit of the currency in BRL). Rates are cached for the
+// lifetime of the converter, so create one per request.
+type fxConverter struct {
+	cache   map[string]float64
+	missing map[string]bool
+}
+
+func newFXConverter() *fxConverter {
+	return &fxConverter{cache: make(map[string]float64), missing: make(map[string]bool)}
+}
+
+// BRL price of one unit of currency on date: the latest rate on or before the
+// date or, failing that, the first one after it.
+func (fx *fxConverter) rate(currency, date string) (float64, error) {
+	if currency == defaultCurrency {
+		return 1, nil
+	}
+	key := currency + "|" + date
+	if rate, ok := fx.cache[key]; ok {
+		return rate, nil
+	}
+
+	var rate float64
+	err := db.QueryRow(
+		"SELECT rate FROM fx_rates WHERE currency = ? AND date <= ? ORDER BY date DESC LIMIT 1",
+		currency, date,
+	).Scan(&rate)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow(
+			"SELECT rate FROM fx_rates WHERE currency = ? ORDER BY date LIMIT 1",
+			currency,
+		).Scan(&rate)
+	}
+	if err == sql.ErrNoRows {
+		fx.missing[currency] = true
+		return 0, &missingRateError{currency: currency}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	fx.cache[key] = rate
+	return rate, nil
+}
+
+// Error of a conversion without a stored rate for the currency
+type missingRateError struct {
+	currency string
+}
+
+func (e *missingRateError) Error() string {
+	return fmt.Sprintf("No exchange rate for %s", e.currency)
+}
+
+// Status of a failed conversion: a missing rate is for the user to add, any
+// other failure is the server's
+func fxErrorStatus(err error) int {
+	var missing *missingRateError
+	if errors.As(err, &missing) {
+		return 400
+	}
+	return 500
+}
+
+// Convert an amount between currencies at the rate of the given date, failing
+// when a rate is missing. Anything that moves balances or stores amounts
+// converts this way.
+func (fx *fxConverter) convertExact(amount float64, from, to, date string) (float64, error) {
+	if from == to || amount == 0 {
+		return amount, nil
+	}
+	fromRate, err := fx.rate(from, date)
+	if err != nil {
+		return 0, err
+	}
+	toRate, err := fx.rate(to, date)
+	if err != nil {
+		return 0, err
+	}
+	if toRate == 0 {
+		return 0, fmt.Errorf("Exchange rate for %s is zero", to)
+	}
+	return amount * fromRate / toRate, nil
+}
+
+// Convert an amount between currencies for a report. When a rate is missing
+// the amount is returned unconverted and the currency is reported by
+// missingCurrencies, which reports must return with their results.
+func (fx *fxConverter) convert(amount float64, from, to, date string) float64 {
+	converted, err := fx.convertExact(amount, from, to, date)
+	if err != nil {
+		return amount
+	}
+	return converted
+}
+
+func (fx *fxConverter) missingCurrencies() []string {
+	missing := []string{}
+	for currency := range fx.missing {
+		missing = append(missing, currency)
+	}
+	return missing
+}
+
+// Sum the rows of a query selecting (currency, date, amount), converting each
+// row into the target currency at its date.
+func (fx *fxConverter) sumQuery(to string, query string, args ...interface{}) (float64, error) {
+	type row struct {
+		currency, date string
+		amount         float64
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return 0, err
+	}
+	var data []row
+	for rows.Next() {
+		var r row
+		if err := rows.Scan(&r.currency, &r.date, &r.amount); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		data = append(data, r)
+	}
+	rows.Close()
+
+	total := 0.0
+	for _, r := range data {
+		total += fx.convert(r.amount, r.currency, to, r.date)
+	}
+	return total, nil
+}
+
+// Sum transactions of a type matching a condition, converted into a currency.
+// Deleted transactions are left out.
+func (fx *fxConverter) sumTransactions(to, txType, condition string, args ...interface{}) float64 {
+	query := "SELECT currency, date, SUM(amount) FROM transactions WHERE type = ? AND deleted_at IS NULL AND " + condition + " GROUP BY currency, date"
+	total, err := fx.sumQuery(to, query, append([]interface{}{txType}, args...)...)
+	if err != nil {
+		return 0
+	}
+	return total
+}
+
+// Currency of an account, used for transactions recorded against it
+func accountCurrency(q interface {
+	QueryRow(string, ...interface{}) *sql.Row
+}, accountID int) string {
+	var currency string
+	if err := q.QueryRow("SELECT currency FROM accounts WHERE id = ?", accountID).Scan(&currency); err != nil {
+		return defaultCurrency
+	}
+	return currency
+}
+
+type FXRate struct {
+	Currency string  `json:"currency"`
+	Date     string  `json:"date"`
+	Rate     float64 `json:"rate"`
+}
+
+// Get stored exchange rates
+func getFXRates(c *gin.Context) {
+	query := "SELECT currency, date, rate FROM fx_rates WHERE 1 = 1"
+	args := []interface{}{}
+	if currency := c.Query("currency"); currency != "" {
+		query += " AND currency = ?"
+		args = append(args, normalizeCurrency(currency))
+	}
+	if start := c.Query("start"); start != "" {
+		query += " AND date >= ?"
+		args = append(args, start)
+	}
+	if end := c.Query("end"); end != "" {
+		query += " AND date <= ?"
+		args = append(args, end)
+	}
+	query += " ORDER BY currency, date"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	rates := []FXRate{}
+	for rows.Next() {
+		var r FXRate
+		if err := rows.Scan(&r.Currency, &r.Date, &r.Rate); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		rates = append(rates, r)
+	}
+
+	c.JSON(200, rates)
+}
+
+func saveFXRates(rates []FXRate) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO fx_rates (currency, date, rate) VALUES (?, ?, ?)
+		ON CONFLICT(currency, date) DO UPDATE SET rate = excluded.rate
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, r := range rates {
+		if _, err := stmt.Exec(r.Currency, r.Date, r.Rate); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Save a single exchange rate
+func createFXRate(c *gin.Context) {
+	var r FXRate
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	r.Currency = normalizeCurrency(r.Currency)
+	if !isValidCurrency(r.Currency) || r.Currency == defaultCurrency {
+		c.JSON(400, gin.H{"error": "Invalid currency"})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", r.Date); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
+		return
+	}
+	if r.Rate <= 0 {
+		c.JSON(400, gin.H{"error": "rate must be greater than zero"})
+		return
+	}
+
+	if err := saveFXRates([]FXRate{r}); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, r)
+}
+
+// Import exchange rates of a currency (BRL per unit, e.g. PTAX) from a CSV in
+// the SGS "data;valor" layout, as raw body or multipart "file" field
+func importFXRates(c *gin.Context) {
+	currency := normalizeCurrency(c.Param("currency"))
+	if !isValidCurrency(currency) || currency == defaultCurrency {
+		c.JSON(400, gin.H{"error": "Invalid currency"})
+		return
+	}
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, _, ferr := c.Request.FormFile("file")
+		if ferr != nil {
+			c.JSON(400, gin.H{"error": ferr.Error()})
+			return
+		}
+		defer file.Close()
+		data, err = io.ReadAll(file)
+	} else {
+		data, err = io.ReadAll(c.Request.Body)
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		c.JSON(400, gin.H{"error": "Empty CSV"})
+		return
+	}
+
+	parsed, errors := parseSGSCSV(data, EconomicIndex{Code: currency, Periodicity: "daily"})
+	if errors == nil {
+		errors = []string{}
+	}
+	rates := make([]FXRate, 0, len(parsed))
+	for _, p := range parsed {
+		if p.Value <= 0 {
+			errors = append(errors, fmt.Sprintf("%s: taxa inválida %v", p.Date, p.Value))
+			continue
+		}
+		rates = append(rates, FXRate{Currency: currency, Date: p.Date, Rate: p.Value})
+	}
+
+	if err := saveFXRates(rates); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message":  "Import completed",
+		"currency": currency,
+		"imported": len(rates),
+		"failed":   len(errors),
+		"errors":   errors,
+	})
+}
+
+// Get base currency
+func getBaseCurrency(c *gin.Context) {
+	c.JSON(200, gin.H{"base_currency": baseCurrency(currentUserID(c))})
+}
+
+// Set base currency used by stats and summaries
+func saveBaseCurrency(c *gin.Context) {
+	var req struct {
+		BaseCurrency string `json:"base_currency"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	currency := normalizeCurrency(req.BaseCurrency)
+	if !isValidCurrency(currency) {
+		c.JSON(400, gin.H{"error": "Invalid currency"})
+		return
+	}
+
+	if err := setSetting(currentUserID(c), "base_currency", currency); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Base currency saved successfully", "base_currency": currency})
+}
@@ -0,0 +1,450 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Backups of a user's data as a JSON document. The export has every row the
+// user owns, including the ones in the trash; the import adds a document to
+// the signed in user's data, giving every row a new ID and rewriting the
+// references between them, so it works on an empty database as well as on
+// one that already has data. Household sharing and the audit log are not
+// part of the backup, and attachments are listed without their files, which
+// stay in the attachments directory.
+
+const (
+	exportFormat  = "money-manager"
+	exportVersion = 1
+)
+
+type exportReference struct {
+	table string
+	// Movements and snapshots of closed positions outlive their investment,
+	// so these references can point to rows that are not in the document
+	dangling bool
+}
+
+type exportTable struct {
+	name string
+	// Audit log entity of the rows, empty when the table is not audited
+	entity     string
+	references map[string]exportReference
+	// Configs the user has a single set of: the imported rows replace the
+	// existing ones instead of being added to them
+	replace bool
+	// Rows of the user, when they are not picked by their user_id column (?1
+	// is the user)
+	condition string
+}
+
+// Tables in the document, in the order they are imported: every table comes
+// after the ones it references
+var exportTables = []exportTable{
+	{name: "accounts", entity: "account"},
+	{name: "categories", references: map[string]exportReference{
+		"parent_id": {table: "categories"},
+	}},
+	{name: "categorization_rules", references: map[string]exportReference{
+		"account_id":  {table: "accounts"},
+		"category_id": {table: "categories"},
+	}},
+	{name: "payees"},
+	{name: "payee_rules", condition: "payee_id IN (SELECT id FROM payees WHERE user_id = ?1)", references: map[string]exportReference{
+		"payee_id": {table: "payees"},
+	}},
+	{name: "transactions", entity: "transaction", references: map[string]exportReference{
+		"account_id":  {table: "accounts"},
+		"category_id": {table: "categories"},
+		"payee_id":    {table: "payees"},
+	}},
+	{name: "installments", entity: "installment", references: map[string]exportReference{
+		"account_id":  {table: "accounts"},
+		"category_id": {table: "categories"},
+	}},
+	{name: "installment_payments", entity: "installment_payment", references: map[string]exportReference{
+		"installment_id": {table: "installments"},
+		"transaction_id": {table: "transactions"},
+	}},
+	{name: "investments", entity: "investment", references: map[string]exportReference{
+		"transaction_id": {table: "transactions"},
+	}},
+	{name: "investment_movements", references: map[string]exportReference{
+		"investment_id": {table: "investments", dangling: true},
+	}},
+	{name: "investment_snapshots", references: map[string]exportReference{
+		"investment_id": {table: "investments", dangling: true},
+	}},
+	{name: "salary_config", entity: "salary_config", replace: true, references: map[string]exportReference{
+		"account_id":  {table: "accounts"},
+		"category_id": {table: "categories"},
+	}},
+	{name: "allocation_targets", replace: true},
+	{name: "settings", replace: true},
+	{name: "investor_profile", replace: true},
+	{name: "analysis_rules", replace: true},
+	{name: "transaction_splits", condition: "transaction_id IN (SELECT id FROM transactions WHERE user_id = ?1)", references: map[string]exportReference{
+		"transaction_id": {table: "transactions"},
+		"category_id":    {table: "categories"},
+	}},
+	{name: "tags"},
+	{name: "transaction_tags", condition: "tag_id IN (SELECT id FROM tags WHERE user_id = ?1) AND transaction_id IN (SELECT id FROM transactions WHERE user_id = ?1)", references: map[string]exportReference{
+		"transaction_id": {table: "transactions"},
+		"tag_id":         {table: "tags"},
+	}},
+	{name: "attachments", condition: "transaction_id IN (SELECT id FROM transactions WHERE user_id = ?1)", references: map[string]exportReference{
+		"transaction_id": {table: "transactions"},
+	}},
+}
+
+type exportDocument struct {
+	Format     string                              `json:"format"`
+	Version    int                                 `json:"version"`
+	ExportedAt string                              `json:"exported_at"`
+	Data       map[string][]map[string]interface{} `json:"data"`
+}
+
+func isExportTable(name string) bool {
+	for _, table := range exportTables {
+		if table.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Timestamps are written the way SQLite stores them, so imported rows sort
+// and compare like the ones created by the server
+func exportValue(value interface{}) interface{} {
+	t, ok := value.(time.Time)
+	if !ok {
+		return value
+	}
+	if t.Nanosecond() != 0 {
+		return t.UTC().Format(deletedAtFormat)
+	}
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// Export all the data of the signed in user. Categories include the shared
+// defaults the user's rows may point to.
+func exportData(c *gin.Context) {
+	userID := currentUserID(c)
+	doc := exportDocument{
+		Format:     exportFormat,
+		Version:    exportVersion,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Data:       make(map[string][]map[string]interface{}),
+	}
+
+	for _, table := range exportTables {
+		condition := "user_id = ?"
+		if table.name == "categories" {
+			condition = "user_id IS NULL OR user_id = ?"
+		}
+		if table.condition != "" {
+			condition = table.condition
+		}
+		rows, err := queryRows(db, fmt.Sprintf("SELECT * FROM %s WHERE %s ORDER BY rowid", table.name, condition), userID)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if rows == nil {
+			rows = []map[string]interface{}{}
+		}
+		for _, row := range rows {
+			delete(row, "user_id")
+			for column, value := range row {
+				row[column] = exportValue(value)
+			}
+		}
+		doc.Data[table.name] = rows
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="money-manager-%s.json"`, time.Now().Format("2006-01-02")))
+	c.JSON(200, doc)
+}
+
+// Import a document produced by exportData into the signed in user's data.
+// The whole document is imported in one transaction: nothing is written when
+// any row is invalid.
+func importData(c *gin.Context) {
+	var doc exportDocument
+	decoder := json.NewDecoder(c.Request.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid export file: %v", err)})
+		return
+	}
+	if doc.Format != exportFormat {
+		c.JSON(400, gin.H{"error": "Invalid export file: unknown format"})
+		return
+	}
+	if doc.Version < 1 || doc.Version > exportVersion {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Unsupported export version %d (supported up to %d)", doc.Version, exportVersion)})
+		return
+	}
+	for name := range doc.Data {
+		if !isExportTable(name) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid export file: unknown table %s", name)})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	imported, err := importDocument(c, tx, doc)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Data imported successfully", "imported": imported})
+}
+
+func importDocument(c *gin.Context, tx *sql.Tx, doc exportDocument) (map[string]int, error) {
+	userID := currentUserID(c)
+	imported := make(map[string]int)
+	// Old ID -> new ID of every imported row, by table
+	ids := make(map[string]map[int64]int64)
+
+	// Old IDs of the rows matched with existing ones instead of imported
+	matched := make(map[string]map[int64]bool)
+
+	// Uncategorized transactions are categorized by the rules, the imported
+	// ones included, unless the document splits them. Transactions without
+	// a payee get one from the payee rules.
+	var rules []CategorizationRule
+	var payeeMatchers []payeeMatcher
+	split := make(map[int64]bool)
+	for _, row := range doc.Data["transaction_splits"] {
+		if id, ok := importValue(row["transaction_id"]).(int64); ok {
+			split[id] = true
+		}
+	}
+
+	for _, table := range exportTables {
+		ids[table.name] = make(map[int64]int64)
+		matched[table.name] = make(map[int64]bool)
+		rows := doc.Data[table.name]
+		if len(rows) == 0 {
+			continue
+		}
+
+		required, err := tableColumns(tx, table.name)
+		if err != nil {
+			return nil, err
+		}
+
+		if table.replace {
+			previous := auditRows(tx, table.name, "user_id = ?", userID)
+			if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE user_id = ?", table.name), userID); err != nil {
+				return nil, err
+			}
+			if table.entity != "" {
+				for _, row := range previous {
+					recordAudit(c, tx, table.entity, row["id"], row, nil)
+				}
+			}
+		}
+
+		for i, row := range rows {
+			label := fmt.Sprintf("%s[%d]", table.name, i)
+
+			values := make(map[string]interface{})
+			for column, value := range row {
+				// Columns of newer versions of the server are skipped
+				if _, known := required[column]; !known || column == "id" || column == "user_id" {
+					continue
+				}
+				values[column] = importValue(value)
+			}
+
+			for column, ref := range table.references {
+				value := values[column]
+				if value == nil {
+					continue
+				}
+				oldID, ok := value.(int64)
+				if !ok {
+					return nil, fmt.Errorf("%s: invalid %s", label, column)
+				}
+				newID, found := ids[ref.table][oldID]
+				switch {
+				case found:
+					values[column] = newID
+				case ref.dangling:
+					if newID, err = reserveID(tx, ref.table); err != nil {
+						return nil, err
+					}
+					ids[ref.table][oldID] = newID
+					values[column] = newID
+				case required[column]:
+					return nil, fmt.Errorf("%s: %s %d is not in the file", label, column, oldID)
+				default:
+					values[column] = nil
+				}
+			}
+
+			for column, isRequired := range required {
+				if isRequired && values[column] == nil && column != "user_id" {
+					return nil, fmt.Errorf("%s: %s is required", label, column)
+				}
+			}
+
+			// Attachment hashes name the files on disk
+			if hash, _ := values["sha256"].(string); table.name == "attachments" && !isAttachmentHash(hash) {
+				return nil, fmt.Errorf("%s: invalid sha256", label)
+			}
+
+			oldID, hasID := importValue(row["id"]).(int64)
+
+			// An existing payee keeps its own rules
+			if payeeID, ok := importValue(row["payee_id"]).(int64); ok && table.name == "payee_rules" && matched["payees"][payeeID] {
+				continue
+			}
+
+			if table.name == "transactions" && values["category_id"] == nil && !(hasID && split[oldID]) {
+				if rules == nil {
+					if rules, err = loadCategorizationRules(tx, userID, true); err != nil {
+						return nil, err
+					}
+				}
+				values["category_id"] = categorizeImportedRow(rules, values)
+			}
+			if table.name == "transactions" && values["payee_id"] == nil {
+				if payeeMatchers == nil {
+					if payeeMatchers, err = loadPayeeMatchers(tx, userID); err != nil {
+						return nil, err
+					}
+				}
+				values["payee_id"] = payeeForImportedRow(payeeMatchers, values)
+			}
+
+			// Categories are matched by name and type with the ones the user
+			// can already see, which include the shared defaults, and tags and
+			// payees by name with the user's
+			match := ""
+			var matchArgs []interface{}
+			switch table.name {
+			case "categories":
+				match = "SELECT id FROM categories WHERE name = ? AND type = ? AND (user_id IS NULL OR user_id = ?)"
+				matchArgs = []interface{}{values["name"], values["type"], userID}
+			case "tags", "payees":
+				match = fmt.Sprintf("SELECT id FROM %s WHERE name = ? AND user_id = ?", table.name)
+				matchArgs = []interface{}{values["name"], userID}
+			}
+			if match != "" {
+				var existingID int64
+				err := tx.QueryRow(match, matchArgs...).Scan(&existingID)
+				if err == nil {
+					if hasID {
+						ids[table.name][oldID] = existingID
+						matched[table.name][oldID] = true
+					}
+					continue
+				}
+				if err != sql.ErrNoRows {
+					return nil, err
+				}
+			}
+
+			if _, owned := required["user_id"]; owned {
+				values["user_id"] = userID
+			}
+			columns := make([]string, 0, len(values))
+			for column := range values {
+				columns = append(columns, column)
+			}
+			sort.Strings(columns)
+			args := make([]interface{}, len(columns))
+			for j, column := range columns {
+				args[j] = values[column]
+			}
+
+			result, err := tx.Exec(
+				fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table.name, strings.Join(columns, ", "), strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")),
+				args...,
+			)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", label, err)
+			}
+			newID, _ := result.LastInsertId()
+			if hasID {
+				ids[table.name][oldID] = newID
+			}
+			if table.entity != "" {
+				recordAudit(c, tx, table.entity, newID, nil, auditRow(tx, table.name, newID))
+			}
+			imported[table.name]++
+		}
+	}
+
+	return imported, nil
+}
+
+// Numbers are decoded as json.Number: IDs and counts become integers, the
+// rest floats
+func importValue(value interface{}) interface{} {
+	number, ok := value.(json.Number)
+	if !ok {
+		return value
+	}
+	if i, err := number.Int64(); err == nil {
+		return i
+	}
+	f, _ := number.Float64()
+	return f
+}
+
+// Columns of a table, mapped to whether a row must have a value for them
+// (NOT NULL without a default)
+func tableColumns(q queryer, table string) (map[string]bool, error) {
+	rows, err := q.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err != nil {
+			return nil, err
+		}
+		columns[name] = notNull == 1 && !defaultValue.Valid && pk == 0
+	}
+	return columns, rows.Err()
+}
+
+// Take the next ID of an AUTOINCREMENT table without inserting a row, so no
+// row created later is given the same ID
+func reserveID(tx *sql.Tx, table string) (int64, error) {
+	_, err := tx.Exec(
+		fmt.Sprintf("INSERT INTO sqlite_sequence (name, seq) SELECT ?, (SELECT COALESCE(MAX(id), 0) FROM %s) WHERE NOT EXISTS (SELECT 1 FROM sqlite_sequence WHERE name = ?)", table),
+		table, table,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	err = tx.QueryRow("UPDATE sqlite_sequence SET seq = seq + 1 WHERE name = ? RETURNING seq", table).Scan(&id)
+	return id, err
+}
@@ -0,0 +1,572 @@
+package main
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Roles of a household member. Owners manage the household and its members,
+// editors can record transactions on the shared accounts and viewers can only
+// read them.
+const (
+	roleOwner  = "owner"
+	roleEditor = "editor"
+	roleViewer = "viewer"
+)
+
+var roleRank = map[string]int{roleViewer: 1, roleEditor: 2, roleOwner: 3}
+
+// Accounts a user can see: their own and the ones shared with a household
+// they belong to, leaving out deleted ones. Takes the user ID twice.
+const visibleAccountsQuery = `SELECT id FROM accounts WHERE user_id = ? AND deleted_at IS NULL
+	UNION SELECT ha.account_id FROM household_accounts ha
+	JOIN household_members hm ON hm.household_id = ha.household_id
+	JOIN accounts a ON a.id = ha.account_id
+	WHERE hm.user_id = ? AND a.deleted_at IS NULL`
+
+// Accounts whose transactions a user can change: their own and the ones
+// shared with them as owner or editor. Takes the user ID twice.
+const editableAccountsQuery = `SELECT id FROM accounts WHERE user_id = ? AND deleted_at IS NULL
+	UNION SELECT ha.account_id FROM household_accounts ha
+	JOIN household_members hm ON hm.household_id = ha.household_id
+	JOIN accounts a ON a.id = ha.account_id
+	WHERE hm.user_id = ? AND hm.role IN ('owner', 'editor') AND a.deleted_at IS NULL`
+
+type Household struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	Role      string            `json:"role"`
+	CreatedAt string            `json:"created_at"`
+	Members   []HouseholdMember `json:"members"`
+	Accounts  []SharedAccount   `json:"accounts"`
+}
+
+type HouseholdMember struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+}
+
+type SharedAccount struct {
+	AccountID int    `json:"account_id"`
+	Name      string `json:"name"`
+	OwnerID   int    `json:"owner_id"`
+}
+
+func isValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// Whether a role grants at least the required one
+func roleAllows(role, required string) bool {
+	return roleRank[role] >= roleRank[required]
+}
+
+// Role of a user on an account: owner when it is theirs, otherwise the best
+// role they have in a household the account is shared with. Household owners
+// are editors of accounts they do not own, so only the account owner can
+// delete it or set its balance; household owners can still unshare it from
+// their household. Empty when the user cannot see the account or it was
+// deleted.
+func accountRole(accountID interface{}, userID int) string {
+	var ownerID sql.NullInt64
+	if err := db.QueryRow("SELECT user_id FROM accounts WHERE id = ? AND deleted_at IS NULL", accountID).Scan(&ownerID); err != nil {
+		return ""
+	}
+	if ownerID.Valid && int(ownerID.Int64) == userID {
+		return roleOwner
+	}
+
+	var role string
+	err := db.QueryRow(`
+		SELECT hm.role FROM household_accounts ha
+		JOIN household_members hm ON hm.household_id = ha.household_id
+		WHERE ha.account_id = ? AND hm.user_id = ?
+		ORDER BY CASE hm.role WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END
+		LIMIT 1
+	`, accountID, userID).Scan(&role)
+	if err != nil {
+		return ""
+	}
+	if role == roleOwner {
+		return roleEditor
+	}
+	return role
+}
+
+// Check the signed in user has at least the required role on an account,
+// answering the request when they do not. Accounts the user cannot see are
+// reported with missingStatus so their existence is not leaked.
+func authorizeAccount(c *gin.Context, accountID interface{}, required string, missingStatus int) bool {
+	role := accountRole(accountID, currentUserID(c))
+	if role == "" {
+		c.JSON(missingStatus, gin.H{"error": "Account not found"})
+		return false
+	}
+	if !roleAllows(role, required) {
+		c.JSON(403, gin.H{"error": "You do not have permission to change this account"})
+		return false
+	}
+	return true
+}
+
+// Role of a user in a household, empty when they are not a member
+func householdRole(householdID interface{}, userID int) string {
+	var role string
+	db.QueryRow(
+		"SELECT role FROM household_members WHERE household_id = ? AND user_id = ?",
+		householdID, userID,
+	).Scan(&role)
+	return role
+}
+
+// Check the signed in user has at least the required role in a household,
+// answering the request when they do not
+func authorizeHousehold(c *gin.Context, householdID interface{}, required string) bool {
+	role := householdRole(householdID, currentUserID(c))
+	if role == "" {
+		c.JSON(404, gin.H{"error": "Household not found"})
+		return false
+	}
+	if !roleAllows(role, required) {
+		c.JSON(403, gin.H{"error": "Only household owners can do this"})
+		return false
+	}
+	return true
+}
+
+func countHouseholdOwners(householdID interface{}) int {
+	var owners int
+	db.QueryRow(
+		"SELECT COUNT(*) FROM household_members WHERE household_id = ? AND role = 'owner'",
+		householdID,
+	).Scan(&owners)
+	return owners
+}
+
+func loadHouseholdMembers(householdID int) ([]HouseholdMember, error) {
+	rows, err := db.Query(`
+		SELECT u.id, u.username, u.name, hm.role
+		FROM household_members hm
+		JOIN users u ON u.id = hm.user_id
+		WHERE hm.household_id = ?
+		ORDER BY CASE hm.role WHEN 'owner' THEN 0 WHEN 'editor' THEN 1 ELSE 2 END, u.name
+	`, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []HouseholdMember{}
+	for rows.Next() {
+		var m HouseholdMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Name, &m.Role); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func loadSharedAccounts(householdID int) ([]SharedAccount, error) {
+	rows, err := db.Query(`
+		SELECT a.id, a.name, a.user_id
+		FROM household_accounts ha
+		JOIN accounts a ON a.id = ha.account_id
+		WHERE ha.household_id = ? AND a.deleted_at IS NULL
+		ORDER BY a.name
+	`, householdID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	accounts := []SharedAccount{}
+	for rows.Next() {
+		var a SharedAccount
+		if err := rows.Scan(&a.AccountID, &a.Name, &a.OwnerID); err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, a)
+	}
+	return accounts, rows.Err()
+}
+
+// Get the households of the signed in user with their members and shared
+// accounts
+func getHouseholds(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT h.id, h.name, hm.role, h.created_at
+		FROM households h
+		JOIN household_members hm ON hm.household_id = h.id
+		WHERE hm.user_id = ?
+		ORDER BY h.name
+	`, currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	households := []Household{}
+	for rows.Next() {
+		var h Household
+		if err := rows.Scan(&h.ID, &h.Name, &h.Role, &h.CreatedAt); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		households = append(households, h)
+	}
+	rows.Close()
+
+	for i := range households {
+		if households[i].Members, err = loadHouseholdMembers(households[i].ID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if households[i].Accounts, err = loadSharedAccounts(households[i].ID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(200, households)
+}
+
+// Create a household with the signed in user as its owner
+func createHousehold(c *gin.Context) {
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+
+	userID := currentUserID(c)
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO households (name, created_by) VALUES (?, ?)", req.Name, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	if _, err := tx.Exec(
+		"INSERT INTO household_members (household_id, user_id, role) VALUES (?, ?, ?)",
+		id, userID, roleOwner,
+	); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"id": id, "message": "Household created successfully"})
+}
+
+// Rename a household
+func updateHousehold(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeHousehold(c, id, roleOwner) {
+		return
+	}
+
+	var req struct {
+		Name string `json:"name"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+
+	if _, err := db.Exec("UPDATE households SET name = ? WHERE id = ?", req.Name, id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Household updated successfully"})
+}
+
+// Delete a household. Shared accounts go back to being visible only to their
+// owners.
+func deleteHousehold(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeHousehold(c, id, roleOwner) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	for _, query := range []string{
+		"DELETE FROM household_accounts WHERE household_id = ?",
+		"DELETE FROM household_members WHERE household_id = ?",
+		"DELETE FROM households WHERE id = ?",
+	} {
+		if _, err := tx.Exec(query, id); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Household deleted successfully"})
+}
+
+// Add an existing user to a household
+func addHouseholdMember(c *gin.Context) {
+	id := c.Param("id")
+	if !authorizeHousehold(c, id, roleOwner) {
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Role     string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Role == "" {
+		req.Role = roleViewer
+	}
+	if !isValidRole(req.Role) {
+		c.JSON(400, gin.H{"error": "role must be owner, editor or viewer"})
+		return
+	}
+
+	var userID int
+	err := db.QueryRow(
+		"SELECT id FROM users WHERE username = ?",
+		strings.ToLower(strings.TrimSpace(req.Username)),
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "User not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if householdRole(id, userID) != "" {
+		c.JSON(409, gin.H{"error": "User is already a member of this household"})
+		return
+	}
+
+	if _, err := db.Exec(
+		"INSERT INTO household_members (household_id, user_id, role) VALUES (?, ?, ?)",
+		id, userID, req.Role,
+	); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Member added successfully"})
+}
+
+// Change the role of a household member. A household always keeps at least
+// one owner.
+func updateHouseholdMember(c *gin.Context) {
+	id := c.Param("id")
+	memberID := c.Param("userId")
+	if !authorizeHousehold(c, id, roleOwner) {
+		return
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !isValidRole(req.Role) {
+		c.JSON(400, gin.H{"error": "role must be owner, editor or viewer"})
+		return
+	}
+
+	var current string
+	err := db.QueryRow(
+		"SELECT role FROM household_members WHERE household_id = ? AND user_id = ?",
+		id, memberID,
+	).Scan(&current)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Member not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if current == roleOwner && req.Role != roleOwner && countHouseholdOwners(id) == 1 {
+		c.JSON(400, gin.H{"error": "A household needs at least one owner"})
+		return
+	}
+
+	if _, err := db.Exec(
+		"UPDATE household_members SET role = ? WHERE household_id = ? AND user_id = ?",
+		req.Role, id, memberID,
+	); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Member updated successfully"})
+}
+
+// Remove a member from a household. Owners can remove anyone and members can
+// leave on their own. Accounts the member shared with the household stop
+// being shared.
+func removeHouseholdMember(c *gin.Context) {
+	id := c.Param("id")
+	memberID := c.Param("userId")
+	userID := currentUserID(c)
+
+	role := householdRole(id, userID)
+	if role == "" {
+		c.JSON(404, gin.H{"error": "Household not found"})
+		return
+	}
+	if memberID != strconv.Itoa(userID) && role != roleOwner {
+		c.JSON(403, gin.H{"error": "Only household owners can do this"})
+		return
+	}
+
+	var memberRole string
+	err := db.QueryRow(
+		"SELECT role FROM household_members WHERE household_id = ? AND user_id = ?",
+		id, memberID,
+	).Scan(&memberRole)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Member not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if memberRole == roleOwner && countHouseholdOwners(id) == 1 {
+		c.JSON(400, gin.H{"error": "A household needs at least one owner"})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(
+		"DELETE FROM household_accounts WHERE household_id = ? AND account_id IN (SELECT id FROM accounts WHERE user_id = ?)",
+		id, memberID,
+	); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec(
+		"DELETE FROM household_members WHERE household_id = ? AND user_id = ?",
+		id, memberID,
+	); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Member removed successfully"})
+}
+
+// Share one of the signed in user's accounts with a household they belong to
+func shareAccount(c *gin.Context) {
+	id := c.Param("id")
+	userID := currentUserID(c)
+	if householdRole(id, userID) == "" {
+		c.JSON(404, gin.H{"error": "Household not found"})
+		return
+	}
+
+	var req struct {
+		AccountID int `json:"account_id"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if accountRole(req.AccountID, userID) != roleOwner {
+		c.JSON(400, gin.H{"error": "Account not found"})
+		return
+	}
+
+	if _, err := db.Exec(
+		"INSERT OR IGNORE INTO household_accounts (household_id, account_id, shared_by) VALUES (?, ?, ?)",
+		id, req.AccountID, userID,
+	); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Account shared successfully"})
+}
+
+// Stop sharing an account with a household. Allowed to the account owner and
+// to household owners.
+func unshareAccount(c *gin.Context) {
+	id := c.Param("id")
+	accountID := c.Param("accountId")
+	userID := currentUserID(c)
+
+	role := householdRole(id, userID)
+	if role == "" {
+		c.JSON(404, gin.H{"error": "Household not found"})
+		return
+	}
+	if role != roleOwner && !userOwns("accounts", accountID, userID) {
+		c.JSON(403, gin.H{"error": "Only the account owner or a household owner can do this"})
+		return
+	}
+
+	result, err := db.Exec(
+		"DELETE FROM household_accounts WHERE household_id = ? AND account_id = ?",
+		id, accountID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Account is not shared with this household"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Account unshared successfully"})
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// EconomicIndex describes a benchmark series that can be imported from
+// Banco Central's SGS (Sistema Gerenciador de Séries Temporais).
+type EconomicIndex struct {
+	Code        string `json:"code"`
+	Name        string `json:"name"`
+	Periodicity string `json:"periodicity"`
+	Kind        string `json:"kind"`
+	SGSCode     int    `json:"sgs_code"`
+}
+
+type IndexRate struct {
+	IndexCode string  `json:"index_code"`
+	Date      string  `json:"date"`
+	Value     float64 `json:"value"`
+}
+
+// Supported series. Rate series are stored as published by SGS: percentage
+// rate for the period (a business day for CDI/Selic, a month for IPCA).
+// Level series (Ibovespa) store the closing points of each day and use the
+// same "data;valor" CSV layout.
+var economicIndexes = []EconomicIndex{
+	{Code: "CDI", Name: "CDI", Periodicity: "daily", Kind: "rate", SGSCode: 12},
+	{Code: "SELIC", Name: "Taxa Selic", Periodicity: "daily", Kind: "rate", SGSCode: 11},
+	{Code: "IPCA", Name: "IPCA", Periodicity: "monthly", Kind: "rate", SGSCode: 433},
+	{Code: "IBOV", Name: "Ibovespa", Periodicity: "daily", Kind: "level"},
+}
+
+func getEconomicIndex(code string) (EconomicIndex, bool) {
+	for _, idx := range economicIndexes {
+		if strings.EqualFold(idx.Code, code) {
+			return idx, true
+		}
+	}
+	return EconomicIndex{}, false
+}
+
+// Parse a date as published by SGS (dd/mm/yyyy) or in ISO format.
+func parseIndexDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if t, err := time.Parse("02/01/2006", value); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// Parse a decimal number in Brazilian (0,043739) or international format.
+func parseIndexValue(value string) (float64, error) {
+	value = strings.TrimSpace(value)
+	if strings.Contains(value, ",") {
+		value = strings.ReplaceAll(value, ".", "")
+		value = strings.Replace(value, ",", ".", 1)
+	}
+	return strconv.ParseFloat(value, 64)
+}
+
+// Parse an SGS CSV export ("data";"valor"). Monthly series are normalized to
+// the first day of the month. Lines that cannot be parsed are reported back
+// instead of aborting the whole import.
+func parseSGSCSV(data []byte, idx EconomicIndex) ([]IndexRate, []string) {
+	reader := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	reader.Comma = ';'
+	reader.LazyQuotes = true
+	reader.FieldsPerRecord = -1
+
+	var rates []IndexRate
+	var errors []string
+	line := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		line++
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("linha %d: %v", line, err))
+			continue
+		}
+		if len(record) < 2 || strings.TrimSpace(strings.Join(record, "")) == "" {
+			continue
+		}
+
+		date, err := parseIndexDate(record[0])
+		if err != nil {
+			// Header line
+			if line == 1 {
+				continue
+			}
+			errors = append(errors, fmt.Sprintf("linha %d: data inválida %q", line, record[0]))
+			continue
+		}
+		value, err := parseIndexValue(record[1])
+		if err != nil {
+			errors = append(errors, fmt.Sprintf("linha %d: valor inválido %q", line, record[1]))
+			continue
+		}
+
+		if idx.Periodicity == "monthly" {
+			date = time.Date(date.Year(), date.Month(), 1, 0, 0, 0, 0, time.UTC)
+		}
+
+		rates = append(rates, IndexRate{
+			IndexCode: idx.Code,
+			Date:      date.Format("2006-01-02"),
+			Value:     value,
+		})
+	}
+
+	return rates, errors
+}
+
+// Get rates of an index between two dates (inclusive, YYYY-MM-DD). Empty
+// bounds are open. For monthly series the start bound matches the whole
+// month it falls in.
+func getIndexRates(code, start, end string) ([]IndexRate, error) {
+	idx, ok := getEconomicIndex(code)
+	if !ok {
+		return nil, fmt.Errorf("unknown index %s", code)
+	}
+
+	query := "SELECT index_code, date, value FROM index_rates WHERE index_code = ?"
+	args := []interface{}{idx.Code}
+	if start != "" {
+		if idx.Periodicity == "monthly" && len(start) >= 7 {
+			start = start[:7] + "-01"
+		}
+		query += " AND date >= ?"
+		args = append(args, start)
+	}
+	if end != "" {
+		query += " AND date <= ?"
+		args = append(args, end)
+	}
+	query += " ORDER BY date"
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []IndexRate
+	for rows.Next() {
+		var r IndexRate
+		if err := rows.Scan(&r.IndexCode, &r.Date, &r.Value); err != nil {
+			return nil, err
+		}
+		rates = append(rates, r)
+	}
+	return rates, rows.Err()
+}
+
+// Compound a list of period rates (in percent) into an accumulated factor,
+// e.g. 1.1075 for 10.75% over the period.
+func compoundRates(rates []IndexRate) float64 {
+	factor := 1.0
+	for _, r := range rates {
+		factor *= 1 + r.Value/100
+	}
+	return factor
+}
+
+// Accumulated factor of an index over a date range. Returns the factor and
+// how many periods were available to compute it.
+func accumulatedIndex(code, start, end string) (float64, int, error) {
+	idx, ok := getEconomicIndex(code)
+	if !ok {
+		return 0, 0, fmt.Errorf("unknown index %s", code)
+	}
+	if idx.Kind == "level" {
+		return accumulatedLevel(idx, start, end)
+	}
+
+	rates, err := getIndexRates(code, start, end)
+	if err != nil {
+		return 0, 0, err
+	}
+	return compoundRates(rates), len(rates), nil
+}
+
+// Variation of a level series: last close on or before end divided by the
+// last close on or before start (or the first close after it).
+func accumulatedLevel(idx EconomicIndex, start, end string) (float64, int, error) {
+	var startValue, endValue float64
+	err := db.QueryRow(
+		"SELECT value FROM index_rates WHERE index_code = ? AND date <= ? ORDER BY date DESC LIMIT 1",
+		idx.Code, start,
+	).Scan(&startValue)
+	if err == sql.ErrNoRows {
+		err = db.QueryRow(
+			"SELECT value FROM index_rates WHERE index_code = ? AND date >= ? ORDER BY date LIMIT 1",
+			idx.Code, start,
+		).Scan(&startValue)
+	}
+	if err == sql.ErrNoRows {
+		return 1, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	err = db.QueryRow(
+		"SELECT value FROM index_rates WHERE index_code = ? AND date <= ? ORDER BY date DESC LIMIT 1",
+		idx.Code, end,
+	).Scan(&endValue)
+	if err == sql.ErrNoRows {
+		return 1, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var periods int
+	err = db.QueryRow(
+		"SELECT COUNT(*) FROM index_rates WHERE index_code = ? AND date > ? AND date <= ?",
+		idx.Code, start, end,
+	).Scan(&periods)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if startValue == 0 {
+		return 1, 0, nil
+	}
+	return endValue / startValue, periods, nil
+}
+
+// List supported indexes with coverage of stored data
+func getIndexes(c *gin.Context) {
+	result := []gin.H{}
+	for _, idx := range economicIndexes {
+
+		var count int
+		var firstDate, lastDate sql.NullString
+		err := db.QueryRow(
+			"SELECT COUNT(*), MIN(date), MAX(date) FROM index_rates WHERE index_code = ?",
+			idx.Code,
+		).Scan(&count, &firstDate, &lastDate)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		result = append(result, gin.H{
+			"code":        idx.Code,
+			"name":        idx.Name,
+			"periodicity": idx.Periodicity,
+			"kind":        idx.Kind,
+			"sgs_code":    idx.SGSCode,
+			"count":       count,
+			"first_date":  firstDate.String,
+			"last_date":   lastDate.String,
+		})
+	}
+
+	c.JSON(200, result)
+}
+
+// Get stored rates of an index
+func getIndexRatesHandler(c *gin.Context) {
+	code := c.Param("code")
+	if _, ok := getEconomicIndex(code); !ok {
+		c.JSON(404, gin.H{"error": "Index not found"})
+		return
+	}
+
+	rates, err := getIndexRates(code, c.Query("start"), c.Query("end"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if rates == nil {
+		rates = []IndexRate{}
+	}
+
+	c.JSON(200, rates)
+}
+
+// Get accumulated index over a date range
+func getAccumulatedIndex(c *gin.Context) {
+	code := c.Param("code")
+	idx, ok := getEconomicIndex(code)
+	if !ok {
+		c.JSON(404, gin.H{"error": "Index not found"})
+		return
+	}
+
+	start := c.Query("start")
+	end := c.Query("end")
+	if start == "" || end == "" {
+		c.JSON(400, gin.H{"error": "start and end parameters are required"})
+		return
+	}
+
+	factor, periods, err := accumulatedIndex(idx.Code, start, end)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"index":       idx.Code,
+		"start":       start,
+		"end":         end,
+		"periods":     periods,
+		"factor":      factor,
+		"accumulated": math.Round((factor-1)*100*1e6) / 1e6,
+	})
+}
+
+// Import index rates from an SGS CSV export. The file can be sent as the raw
+// request body or as a multipart "file" field.
+func importIndexRates(c *gin.Context) {
+	idx, ok := getEconomicIndex(c.Param("code"))
+	if !ok {
+		c.JSON(404, gin.H{"error": "Index not found"})
+		return
+	}
+
+	var data []byte
+	var err error
+	if strings.HasPrefix(c.ContentType(), "multipart/") {
+		file, _, ferr := c.Request.FormFile("file")
+		if ferr != nil {
+			c.JSON(400, gin.H{"error": ferr.Error()})
+			return
+		}
+		defer file.Close()
+		data, err = io.ReadAll(file)
+	} else {
+		data, err = io.ReadAll(c.Request.Body)
+	}
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		c.JSON(400, gin.H{"error": "Empty CSV"})
+		return
+	}
+
+	rates, errors := parseSGSCSV(data, idx)
+	if errors == nil {
+		errors = []string{}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`
+		INSERT INTO index_rates (index_code, date, value) VALUES (?, ?, ?)
+		ON CONFLICT(index_code, date) DO UPDATE SET value = excluded.value
+	`)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer stmt.Close()
+
+	for _, r := range rates {
+		if _, err := stmt.Exec(r.IndexCode, r.Date, r.Value); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{
+		"message":  "Import completed",
+		"index":    idx.Code,
+		"imported": len(rates),
+		"failed":   len(errors),
+		"errors":   errors,
+	})
+}
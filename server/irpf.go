@@ -0,0 +1,492 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Yearly report with what the income tax declaration (IRPF) asks for:
+// deductible health and education expenses by payee, the investment
+// positions at 31/12 with their acquisition cost (bens e direitos), the
+// dividends and JCP received and the gains realized on sales.
+//
+// Expenses and income are found by category: the categories named below,
+// ignoring case and accents, and their subcategories. Amounts are in the
+// user's base currency, except for positions, which are listed in the
+// currency of the investment.
+
+const (
+	irpfHealth    = "health"
+	irpfEducation = "education"
+	irpfDividends = "dividends"
+	irpfJCP       = "jcp"
+)
+
+var irpfCategoryNames = []struct {
+	section, categoryType string
+	names                 []string
+}{
+	{irpfHealth, "expense", []string{"saude"}},
+	{irpfEducation, "expense", []string{"educacao"}},
+	{irpfDividends, "income", []string{"dividendos"}},
+	{irpfJCP, "income", []string{"jcp", "juros sobre capital proprio"}},
+}
+
+type irpfItem struct {
+	TransactionID   int     `json:"transaction_id"`
+	Date            string  `json:"date"`
+	Description     string  `json:"description"`
+	Amount          float64 `json:"amount"`
+	AttachmentCount int     `json:"attachment_count"`
+}
+
+// Items of a section paid to (or received from) a payee
+type irpfPayeeTotal struct {
+	PayeeID *int       `json:"payee_id"`
+	Name    string     `json:"name"`
+	Total   float64    `json:"total"`
+	Items   []irpfItem `json:"items"`
+}
+
+type irpfSection struct {
+	Total  float64           `json:"total"`
+	Payees []*irpfPayeeTotal `json:"payees"`
+}
+
+type irpfPosition struct {
+	InvestmentID int     `json:"investment_id"`
+	Ticker       string  `json:"ticker"`
+	Name         string  `json:"name"`
+	Type         string  `json:"type"`
+	Currency     string  `json:"currency"`
+	Quantity     float64 `json:"quantity"`
+	// Acquisition cost at 31/12 of the year before and of the year
+	PreviousCost float64 `json:"previous_cost"`
+	Cost         float64 `json:"cost"`
+	// Market value at 31/12 of the year, for reference
+	Value float64 `json:"value"`
+}
+
+type irpfSale struct {
+	Date     string  `json:"date"`
+	Ticker   string  `json:"ticker"`
+	Type     string  `json:"type"`
+	Quantity float64 `json:"quantity"`
+	Amount   float64 `json:"amount"`
+	Cost     float64 `json:"cost"`
+	Gain     float64 `json:"gain"`
+}
+
+// Sales of a month by asset type, as the monthly gains are declared
+type irpfMonthGains struct {
+	Month string  `json:"month"`
+	Type  string  `json:"type"`
+	Sales float64 `json:"sales"`
+	Gain  float64 `json:"gain"`
+}
+
+type irpfGains struct {
+	Total  float64          `json:"total"`
+	Months []irpfMonthGains `json:"months"`
+	Sales  []irpfSale       `json:"sales"`
+}
+
+type IncomeTaxReport struct {
+	Year                int                     `json:"year"`
+	Currency            string                  `json:"currency"`
+	DeductibleExpenses  map[string]*irpfSection `json:"deductible_expenses"`
+	Assets              []irpfPosition          `json:"assets"`
+	Dividends           *irpfSection            `json:"dividends"`
+	JCP                 *irpfSection            `json:"jcp"`
+	RealizedGains       irpfGains               `json:"realized_gains"`
+	MissingRates        []string                `json:"missingRates,omitempty"`
+	sections            map[string]*irpfSection
+	categoriesBySection map[int]string
+}
+
+// Section of each category the user can see that the report reads, by
+// category ID. Subcategories belong to the section of their parent.
+func irpfCategorySections(userID int) (map[int]string, error) {
+	rows, err := db.Query("SELECT id, parent_id, name, type FROM categories WHERE user_id IS NULL OR user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sectionOf := func(name, categoryType string) string {
+		folded := accentFolder.Replace(strings.ToLower(strings.TrimSpace(name)))
+		for _, s := range irpfCategoryNames {
+			if s.categoryType != categoryType {
+				continue
+			}
+			for _, n := range s.names {
+				if folded == n {
+					return s.section
+				}
+			}
+		}
+		return ""
+	}
+
+	sections := make(map[int]string)
+	parents := make(map[int]int)
+	for rows.Next() {
+		var id int
+		var parentID *int
+		var name, categoryType string
+		if err := rows.Scan(&id, &parentID, &name, &categoryType); err != nil {
+			return nil, err
+		}
+		if section := sectionOf(name, categoryType); section != "" {
+			sections[id] = section
+		} else if parentID != nil {
+			parents[id] = *parentID
+		}
+	}
+	for id, parentID := range parents {
+		if section, ok := sections[parentID]; ok {
+			sections[id] = section
+		}
+	}
+	return sections, nil
+}
+
+// Add the transactions of the year in the report's categories, split lines
+// counted by their own category
+func (r *IncomeTaxReport) addTransactions(userID int, fx *fxConverter) error {
+	if len(r.categoriesBySection) == 0 {
+		return nil
+	}
+	categoryIDs := make([]interface{}, 0, len(r.categoriesBySection))
+	for id := range r.categoriesBySection {
+		categoryIDs = append(categoryIDs, id)
+	}
+
+	args := append([]interface{}{userID, userID, fmt.Sprintf("%d-01-01", r.Year), fmt.Sprintf("%d-01-01", r.Year+1)}, categoryIDs...)
+	rows, err := db.Query(`
+		SELECT t.id, t.category_id, t.payee_id, p.name, t.currency, t.date, COALESCE(t.description, ''), t.amount
+		FROM (`+transactionLinesQuery+`) t
+		LEFT JOIN payees p ON p.id = t.payee_id
+		WHERE t.deleted_at IS NULL AND t.account_id IN (`+visibleAccountsQuery+`)
+			AND t.date >= ? AND t.date < ? AND t.category_id IN (`+placeholders(len(categoryIDs))+`)
+		ORDER BY t.date, t.id
+	`, args...)
+	if err != nil {
+		return err
+	}
+
+	type line struct {
+		id, categoryID       int
+		payeeID              *int
+		payeeName            *string
+		currency, date, desc string
+		amount               float64
+	}
+	var lines []line
+	for rows.Next() {
+		var l line
+		if err := rows.Scan(&l.id, &l.categoryID, &l.payeeID, &l.payeeName, &l.currency, &l.date, &l.desc, &l.amount); err != nil {
+			rows.Close()
+			return err
+		}
+		lines = append(lines, l)
+	}
+	rows.Close()
+
+	ids := make([]int, len(lines))
+	for i, l := range lines {
+		ids[i] = l.id
+	}
+	attachments, err := attachmentCounts(ids)
+	if err != nil {
+		return err
+	}
+
+	byPayee := make(map[string]map[int]*irpfPayeeTotal)
+	for _, l := range lines {
+		sectionName := r.categoriesBySection[l.categoryID]
+		section := r.sections[sectionName]
+		if byPayee[sectionName] == nil {
+			byPayee[sectionName] = make(map[int]*irpfPayeeTotal)
+		}
+
+		// Without a payee (or with one deleted since) items go together
+		key, name := 0, ""
+		if l.payeeID != nil && l.payeeName != nil {
+			key, name = *l.payeeID, *l.payeeName
+		}
+		total, ok := byPayee[sectionName][key]
+		if !ok {
+			total = &irpfPayeeTotal{Name: name, Items: []irpfItem{}}
+			if key != 0 {
+				total.PayeeID = l.payeeID
+			}
+			byPayee[sectionName][key] = total
+			section.Payees = append(section.Payees, total)
+		}
+
+		amount := fx.convert(l.amount, l.currency, r.Currency, l.date)
+		total.Items = append(total.Items, irpfItem{l.id, l.date, l.desc, amount, attachments[l.id]})
+		total.Total += amount
+		section.Total += amount
+	}
+
+	for _, section := range r.sections {
+		sort.Slice(section.Payees, func(i, j int) bool { return section.Payees[i].Total > section.Payees[j].Total })
+	}
+	return nil
+}
+
+// Positions held at 31/12 of the year or of the year before, valued by the
+// last snapshot up to each date
+func (r *IncomeTaxReport) addAssets(userID int) error {
+	positionsAt := func(date string) (map[int]irpfPosition, error) {
+		rows, err := db.Query(`
+			SELECT s.investment_id, s.ticker, COALESCE(i.name, s.ticker), s.type, COALESCE(s.currency, 'BRL'),
+				s.quantity, s.invested, s.value
+			FROM investment_snapshots s
+			LEFT JOIN investments i ON i.id = s.investment_id
+			WHERE s.user_id = ? AND s.date = (
+				SELECT MAX(date) FROM investment_snapshots WHERE investment_id = s.investment_id AND date <= ?
+			)
+		`, userID, date)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		positions := make(map[int]irpfPosition)
+		for rows.Next() {
+			var p irpfPosition
+			if err := rows.Scan(&p.InvestmentID, &p.Ticker, &p.Name, &p.Type, &p.Currency, &p.Quantity, &p.Cost, &p.Value); err != nil {
+				return nil, err
+			}
+			if p.Quantity > 0 {
+				positions[p.InvestmentID] = p
+			}
+		}
+		return positions, nil
+	}
+
+	current, err := positionsAt(fmt.Sprintf("%d-12-31", r.Year))
+	if err != nil {
+		return err
+	}
+	previous, err := positionsAt(fmt.Sprintf("%d-12-31", r.Year-1))
+	if err != nil {
+		return err
+	}
+
+	// Positions closed during the year are declared with a zero cost
+	for id, p := range previous {
+		if _, ok := current[id]; !ok {
+			current[id] = irpfPosition{
+				InvestmentID: id, Ticker: p.Ticker, Name: p.Name, Type: p.Type, Currency: p.Currency,
+			}
+		}
+	}
+	for id, p := range current {
+		p.PreviousCost = previous[id].Cost
+		r.Assets = append(r.Assets, p)
+	}
+	sort.Slice(r.Assets, func(i, j int) bool {
+		if r.Assets[i].Type != r.Assets[j].Type {
+			return r.Assets[i].Type < r.Assets[j].Type
+		}
+		return r.Assets[i].Ticker < r.Assets[j].Ticker
+	})
+	return nil
+}
+
+// Sales of the year with the gain over their average cost, in total and by
+// month and asset type
+func (r *IncomeTaxReport) addRealizedGains(userID int, fx *fxConverter) error {
+	rows, err := db.Query(`
+		SELECT m.date, m.ticker, m.type, COALESCE(m.currency, 'BRL'), m.quantity, m.amount, COALESCE(m.profit_loss, 0)
+		FROM investment_movements m
+		WHERE m.user_id = ? AND m.movement_type = 'sell' AND m.date >= ? AND m.date < ?
+		ORDER BY m.date, m.id
+	`, userID, fmt.Sprintf("%d-01-01", r.Year), fmt.Sprintf("%d-01-01", r.Year+1))
+	if err != nil {
+		return err
+	}
+
+	type sale struct {
+		irpfSale
+		currency string
+	}
+	var sales []sale
+	for rows.Next() {
+		var s sale
+		if err := rows.Scan(&s.Date, &s.Ticker, &s.Type, &s.currency, &s.Quantity, &s.Amount, &s.Gain); err != nil {
+			rows.Close()
+			return err
+		}
+		sales = append(sales, s)
+	}
+	rows.Close()
+
+	months := make(map[[2]string]*irpfMonthGains)
+	var keys [][2]string
+	for _, s := range sales {
+		s.Amount = fx.convert(s.Amount, s.currency, r.Currency, s.Date)
+		s.Gain = fx.convert(s.Gain, s.currency, r.Currency, s.Date)
+		s.Cost = s.Amount - s.Gain
+		r.RealizedGains.Sales = append(r.RealizedGains.Sales, s.irpfSale)
+		r.RealizedGains.Total += s.Gain
+
+		if len(s.Date) < 7 {
+			continue
+		}
+		key := [2]string{s.Date[:7], s.Type}
+		month, ok := months[key]
+		if !ok {
+			month = &irpfMonthGains{Month: key[0], Type: key[1]}
+			months[key] = month
+			keys = append(keys, key)
+		}
+		month.Sales += s.Amount
+		month.Gain += s.Gain
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i][0] != keys[j][0] {
+			return keys[i][0] < keys[j][0]
+		}
+		return keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		r.RealizedGains.Months = append(r.RealizedGains.Months, *months[key])
+	}
+	return nil
+}
+
+func buildIncomeTaxReport(userID, year int) (*IncomeTaxReport, error) {
+	r := &IncomeTaxReport{
+		Year:     year,
+		Currency: baseCurrency(userID),
+		Assets:   []irpfPosition{},
+		RealizedGains: irpfGains{
+			Months: []irpfMonthGains{},
+			Sales:  []irpfSale{},
+		},
+		sections: make(map[string]*irpfSection),
+	}
+	for _, s := range irpfCategoryNames {
+		r.sections[s.section] = &irpfSection{Payees: []*irpfPayeeTotal{}}
+	}
+	r.DeductibleExpenses = map[string]*irpfSection{
+		irpfHealth:    r.sections[irpfHealth],
+		irpfEducation: r.sections[irpfEducation],
+	}
+	r.Dividends = r.sections[irpfDividends]
+	r.JCP = r.sections[irpfJCP]
+
+	var err error
+	if r.categoriesBySection, err = irpfCategorySections(userID); err != nil {
+		return nil, err
+	}
+	fx := newFXConverter()
+	if err := r.addTransactions(userID, fx); err != nil {
+		return nil, err
+	}
+	if err := r.addAssets(userID); err != nil {
+		return nil, err
+	}
+	if err := r.addRealizedGains(userID, fx); err != nil {
+		return nil, err
+	}
+	r.MissingRates = fx.missingCurrencies()
+	return r, nil
+}
+
+// Rows of the report as a single table, one per item, position or sale
+func (r *IncomeTaxReport) csvRecords() [][]string {
+	money := func(value float64) string { return strconv.FormatFloat(value, 'f', 2, 64) }
+	quantity := func(value float64) string { return strconv.FormatFloat(value, 'f', -1, 64) }
+
+	records := [][]string{{
+		"Seção", "Nome", "Data", "Descrição", "Quantidade", "Moeda", "Valor", "Custo", "Resultado",
+		fmt.Sprintf("Situação em 31/12/%d", r.Year-1), fmt.Sprintf("Situação em 31/12/%d", r.Year),
+	}}
+	sections := []struct {
+		title   string
+		section *irpfSection
+	}{
+		{"Despesas médicas", r.sections[irpfHealth]},
+		{"Despesas com instrução", r.sections[irpfEducation]},
+		{"Dividendos", r.sections[irpfDividends]},
+		{"Juros sobre capital próprio", r.sections[irpfJCP]},
+	}
+	for _, s := range sections {
+		for _, payee := range s.section.Payees {
+			name := payee.Name
+			if name == "" {
+				name = "Sem favorecido"
+			}
+			for _, item := range payee.Items {
+				records = append(records, []string{s.title, name, item.Date, item.Description, "", r.Currency, money(item.Amount), "", "", "", ""})
+			}
+		}
+	}
+	// Positions are valued at market in "Valor" and at acquisition cost in
+	// the columns of each 31/12
+	for _, p := range r.Assets {
+		name := p.Ticker
+		if p.Name != p.Ticker {
+			name += " - " + p.Name
+		}
+		records = append(records, []string{
+			"Bens e direitos", name, fmt.Sprintf("%d-12-31", r.Year), p.Type,
+			quantity(p.Quantity), p.Currency, money(p.Value), "", "", money(p.PreviousCost), money(p.Cost),
+		})
+	}
+	for _, s := range r.RealizedGains.Sales {
+		records = append(records, []string{
+			"Ganhos em vendas", s.Ticker, s.Date, s.Type,
+			quantity(s.Quantity), r.Currency, money(s.Amount), money(s.Cost), money(s.Gain), "", "",
+		})
+	}
+	return records
+}
+
+// Get the income tax report of a year (the previous one by default), as JSON
+// or, with format=csv, as a CSV file
+func getIncomeTaxReport(c *gin.Context) {
+	year := time.Now().Year() - 1
+	if value := c.Query("year"); value != "" {
+		var err error
+		if year, err = strconv.Atoi(value); err != nil || year < 1900 || year > 9999 {
+			c.JSON(400, gin.H{"error": "Invalid year"})
+			return
+		}
+	}
+	format := c.DefaultQuery("format", "json")
+	if format != "json" && format != "csv" {
+		c.JSON(400, gin.H{"error": "format must be json or csv"})
+		return
+	}
+
+	report, err := buildIncomeTaxReport(currentUserID(c), year)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if format == "json" {
+		c.JSON(200, report)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="irpf-%d.csv"`, year))
+	c.Header("Content-Type", "text/csv; charset=utf-8")
+	c.Status(200)
+	// A BOM so spreadsheets read the accents right
+	c.Writer.WriteString("\ufeff")
+	w := csv.NewWriter(c.Writer)
+	w.WriteAll(report.csvRecords())
+}
@@ -7,9 +7,9 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"math"
 	"net/http"
-	"os"
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -25,15 +25,19 @@ type Account struct {
 	Type      string  `json:"type"`
 	Balance   float64 `json:"balance"`
 	Color     string  `json:"color"`
+	Currency  string  `json:"currency"`
 	CreatedAt string  `json:"created_at"`
+	Role      string  `json:"role,omitempty"`
 }
 
 type Category struct {
 	ID        int    `json:"id"`
+	ParentID  *int   `json:"parent_id"`
 	Name      string `json:"name"`
 	Type      string `json:"type"`
 	Color     string `json:"color"`
 	Icon      string `json:"icon"`
+	IsDefault bool   `json:"is_default"`
 	CreatedAt string `json:"created_at"`
 }
 
@@ -41,8 +45,10 @@ type Transaction struct {
 	ID            int     `json:"id"`
 	AccountID     int     `json:"account_id"`
 	CategoryID    *int    `json:"category_id"`
+	PayeeID       *int    `json:"payee_id"`
 	Type          string  `json:"type"`
 	Amount        float64 `json:"amount"`
+	Currency      string  `json:"currency"`
 	Description   *string `json:"description"`
 	Date          string  `json:"date"`
 	CreatedAt     string  `json:"created_at"`
@@ -51,30 +57,39 @@ type Transaction struct {
 	CategoryName  *string `json:"category_name"`
 	CategoryColor *string `json:"category_color"`
 	CategoryIcon  *string `json:"category_icon"`
+	PayeeName     *string `json:"payee_name"`
+	Tags          []Tag   `json:"tags"`
+	// Lines with their own category the amount is split into, if any
+	Splits          []TransactionSplit `json:"splits"`
+	AttachmentCount int                `json:"attachment_count"`
 }
 
 type Stats struct {
-	TotalBalance    float64 `json:"totalBalance"`
-	MonthlyIncome   float64 `json:"monthlyIncome"`
-	MonthlyExpenses float64 `json:"monthlyExpenses"`
-	MonthlyBalance  float64 `json:"monthlyBalance"`
+	TotalBalance    float64  `json:"totalBalance"`
+	MonthlyIncome   float64  `json:"monthlyIncome"`
+	MonthlyExpenses float64  `json:"monthlyExpenses"`
+	MonthlyBalance  float64  `json:"monthlyBalance"`
+	Currency        string   `json:"currency"`
+	MissingRates    []string `json:"missingRates,omitempty"`
 }
 
 type Investment struct {
-	ID              int     `json:"id"`
-	Ticker          string  `json:"ticker"`
-	Name            string  `json:"name"`
-	Type            string  `json:"type"`
-	Quantity        float64 `json:"quantity"`
-	AveragePrice    float64 `json:"average_price"`
-	TotalInvested   float64 `json:"total_invested"`
-	CurrentPrice    *float64 `json:"current_price"`
-	CurrentValue    *float64 `json:"current_value"`
-	ProfitLoss      *float64 `json:"profit_loss"`
+	ID                int      `json:"id"`
+	Ticker            string   `json:"ticker"`
+	Name              string   `json:"name"`
+	Type              string   `json:"type"`
+	Market            string   `json:"market"`
+	Quantity          float64  `json:"quantity"`
+	AveragePrice      float64  `json:"average_price"`
+	TotalInvested     float64  `json:"total_invested"`
+	Currency          string   `json:"currency"`
+	CurrentPrice      *float64 `json:"current_price"`
+	CurrentValue      *float64 `json:"current_value"`
+	ProfitLoss        *float64 `json:"profit_loss"`
 	ProfitLossPercent *float64 `json:"profit_loss_percent"`
-	Notes           *string `json:"notes"`
-	CreatedAt       string  `json:"created_at"`
-	UpdatedAt       string  `json:"updated_at"`
+	Notes             *string  `json:"notes"`
+	CreatedAt         string   `json:"created_at"`
+	UpdatedAt         string   `json:"updated_at"`
 }
 
 type Installment struct {
@@ -93,57 +108,154 @@ type Installment struct {
 }
 
 type InstallmentPayment struct {
-	ID               int     `json:"id"`
-	InstallmentID    int     `json:"installment_id"`
-	InstallmentNumber int    `json:"installment_number"`
-	Amount           float64 `json:"amount"`
-	DueDate          string  `json:"due_date"`
-	PaidDate         *string `json:"paid_date"`
-	TransactionID    *int    `json:"transaction_id"`
-	CreatedAt        string  `json:"created_at"`
+	ID                int     `json:"id"`
+	InstallmentID     int     `json:"installment_id"`
+	InstallmentNumber int     `json:"installment_number"`
+	Amount            float64 `json:"amount"`
+	DueDate           string  `json:"due_date"`
+	PaidDate          *string `json:"paid_date"`
+	TransactionID     *int    `json:"transaction_id"`
+	CreatedAt         string  `json:"created_at"`
 }
 
 var db *sql.DB
 
 func initDB() {
-	dbPath := filepath.Join(".", "database.sqlite")
 	var err error
-	
-	db, err = sql.Open("sqlite3", dbPath+"?_journal_mode=WAL&_busy_timeout=5000")
+
+	db, err = sql.Open("sqlite3", config.DatabasePath+"?_journal_mode=WAL&_busy_timeout=5000")
 	if err != nil {
 		log.Fatal("Failed to open database:", err)
 	}
-	
+
 	db.SetMaxOpenConns(1)
 	db.SetMaxIdleConns(1)
 	db.SetConnMaxLifetime(0)
-	
+
 	_, err = db.Exec("PRAGMA journal_mode=WAL")
 	if err != nil {
 		log.Printf("Warning: Could not enable WAL mode: %v", err)
 	}
-	
+
 	_, err = db.Exec("PRAGMA busy_timeout=5000")
 	if err != nil {
 		log.Printf("Warning: Could not set busy timeout: %v", err)
 	}
-	createTables := []string{
-		`CREATE TABLE IF NOT EXISTS accounts (
+	// Tables whose constraints changed after release are rebuilt below, so
+	// their definitions are shared with the migration
+	allocationTargetsTable := `CREATE TABLE IF NOT EXISTS allocation_targets (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER REFERENCES users(id),
+			scope TEXT NOT NULL,
+			key TEXT NOT NULL,
+			type TEXT,
+			target_percent REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, scope, key)
+		)`
+	// Category names were unique across types and users at first
+	categoriesTable := `CREATE TABLE IF NOT EXISTS categories (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER REFERENCES users(id),
+			parent_id INTEGER REFERENCES categories(id),
 			name TEXT NOT NULL,
 			type TEXT NOT NULL,
-			balance REAL DEFAULT 0,
-			color TEXT DEFAULT '#3B82F6',
+			color TEXT DEFAULT '#6B7280',
+			icon TEXT DEFAULT '💰',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, type, name)
+		)`
+	settingsTable := `CREATE TABLE IF NOT EXISTS settings (
+			user_id INTEGER REFERENCES users(id),
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user_id, key)
+		)`
+
+	createTables := []string{
+		`CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			password_hash TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS sessions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			expires_at DATETIME NOT NULL,
+			last_used_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+		)`,
+		`CREATE TABLE IF NOT EXISTS api_tokens (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			name TEXT NOT NULL,
+			token_hash TEXT NOT NULL UNIQUE,
+			prefix TEXT NOT NULL,
+			scopes TEXT NOT NULL,
+			last_used_at DATETIME,
+			revoked_at DATETIME,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
-		`CREATE TABLE IF NOT EXISTS categories (
+		`CREATE TABLE IF NOT EXISTS audit_log (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
-			name TEXT NOT NULL UNIQUE,
+			user_id INTEGER REFERENCES users(id),
+			account_owner_id INTEGER REFERENCES users(id),
+			actor TEXT NOT NULL,
+			api_token_id INTEGER,
+			endpoint TEXT NOT NULL,
+			entity TEXT NOT NULL,
+			entity_id INTEGER,
+			action TEXT NOT NULL CHECK (action IN ('create', 'update', 'delete')),
+			before_json TEXT,
+			after_json TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_user ON audit_log(user_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_account_owner ON audit_log(account_owner_id, id)`,
+		`CREATE INDEX IF NOT EXISTS idx_audit_log_entity ON audit_log(entity, entity_id)`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_update BEFORE UPDATE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS audit_log_no_delete BEFORE DELETE ON audit_log
+		BEGIN
+			SELECT RAISE(ABORT, 'audit_log is append-only');
+		END`,
+		`CREATE TABLE IF NOT EXISTS households (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			created_by INTEGER REFERENCES users(id),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS household_members (
+			household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			role TEXT NOT NULL CHECK (role IN ('owner', 'editor', 'viewer')),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (household_id, user_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS household_accounts (
+			household_id INTEGER NOT NULL REFERENCES households(id) ON DELETE CASCADE,
+			account_id INTEGER NOT NULL REFERENCES accounts(id) ON DELETE CASCADE,
+			shared_by INTEGER REFERENCES users(id),
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (household_id, account_id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS accounts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
 			type TEXT NOT NULL,
-			color TEXT DEFAULT '#6B7280',
-			icon TEXT DEFAULT '💰',
+			balance REAL DEFAULT 0,
+			color TEXT DEFAULT '#3B82F6',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
+		categoriesTable,
 		`CREATE TABLE IF NOT EXISTS transactions (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			account_id INTEGER NOT NULL,
@@ -209,6 +321,174 @@ func initDB() {
 			FOREIGN KEY (installment_id) REFERENCES installments(id) ON DELETE CASCADE,
 			FOREIGN KEY (transaction_id) REFERENCES transactions(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS index_rates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			index_code TEXT NOT NULL,
+			date TEXT NOT NULL,
+			value REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (index_code, date)
+		)`,
+		`CREATE TABLE IF NOT EXISTS investment_movements (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			investment_id INTEGER NOT NULL,
+			ticker TEXT NOT NULL,
+			type TEXT NOT NULL,
+			movement_type TEXT NOT NULL,
+			date TEXT NOT NULL,
+			quantity REAL NOT NULL,
+			price REAL NOT NULL,
+			amount REAL NOT NULL,
+			profit_loss REAL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS investment_snapshots (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			investment_id INTEGER NOT NULL,
+			ticker TEXT NOT NULL,
+			type TEXT NOT NULL,
+			date TEXT NOT NULL,
+			quantity REAL NOT NULL,
+			price REAL NOT NULL,
+			value REAL NOT NULL,
+			invested REAL NOT NULL,
+			UNIQUE (investment_id, date)
+		)`,
+		allocationTargetsTable,
+		`CREATE TABLE IF NOT EXISTS recommendation_catalog (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			ticker TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			type TEXT NOT NULL,
+			reason TEXT NOT NULL DEFAULT '',
+			risk_level TEXT NOT NULL DEFAULT 'moderado',
+			profiles TEXT NOT NULL DEFAULT '[]',
+			tags TEXT NOT NULL DEFAULT '[]',
+			active INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS recommendation_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			asset_type TEXT,
+			tag TEXT,
+			max_type_percent REAL,
+			only_when_empty INTEGER NOT NULL DEFAULT 0,
+			profiles TEXT NOT NULL DEFAULT '[]',
+			category TEXT NOT NULL,
+			priority TEXT NOT NULL DEFAULT 'média',
+			reason TEXT,
+			max_items INTEGER NOT NULL DEFAULT 0,
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			active INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS analysis_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL,
+			conditions TEXT NOT NULL,
+			action TEXT NOT NULL,
+			kind TEXT NOT NULL DEFAULT 'suggestion',
+			priority TEXT NOT NULL DEFAULT 'média',
+			message TEXT NOT NULL DEFAULT '',
+			sort_order INTEGER NOT NULL DEFAULT 0,
+			active INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS fx_rates (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			currency TEXT NOT NULL,
+			date TEXT NOT NULL,
+			rate REAL NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (currency, date)
+		)`,
+		settingsTable,
+		`CREATE TABLE IF NOT EXISTS investor_profile (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			risk_profile TEXT NOT NULL,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS tags (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			name TEXT NOT NULL COLLATE NOCASE,
+			color TEXT NOT NULL DEFAULT '#6B7280',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS transaction_tags (
+			transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+			tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			PRIMARY KEY (transaction_id, tag_id)
+		)`,
+		// Foreign keys are not enforced, so the links are removed by triggers
+		`CREATE TRIGGER IF NOT EXISTS transactions_tags_delete AFTER DELETE ON transactions BEGIN
+			DELETE FROM transaction_tags WHERE transaction_id = old.id;
+		END`,
+		`CREATE TABLE IF NOT EXISTS transaction_splits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+			category_id INTEGER REFERENCES categories(id),
+			amount REAL NOT NULL,
+			description TEXT,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS categorization_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			name TEXT NOT NULL,
+			description_contains TEXT,
+			description_regex TEXT,
+			min_amount REAL,
+			max_amount REAL,
+			account_id INTEGER REFERENCES accounts(id),
+			category_id INTEGER NOT NULL REFERENCES categories(id),
+			priority INTEGER NOT NULL DEFAULT 0,
+			active INTEGER NOT NULL DEFAULT 1,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS transactions_splits_delete AFTER DELETE ON transactions BEGIN
+			DELETE FROM transaction_splits WHERE transaction_id = old.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS tags_delete AFTER DELETE ON tags BEGIN
+			DELETE FROM transaction_tags WHERE tag_id = old.id;
+		END`,
+		`CREATE TABLE IF NOT EXISTS payees (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			name TEXT NOT NULL COLLATE NOCASE,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (user_id, name)
+		)`,
+		`CREATE TABLE IF NOT EXISTS payee_rules (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			payee_id INTEGER NOT NULL REFERENCES payees(id) ON DELETE CASCADE,
+			match_type TEXT NOT NULL DEFAULT 'contains',
+			pattern TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS payees_delete AFTER DELETE ON payees BEGIN
+			DELETE FROM payee_rules WHERE payee_id = old.id;
+			UPDATE transactions SET payee_id = NULL WHERE payee_id = old.id;
+		END`,
+		`CREATE TABLE IF NOT EXISTS attachments (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			transaction_id INTEGER NOT NULL REFERENCES transactions(id) ON DELETE CASCADE,
+			file_name TEXT NOT NULL,
+			content_type TEXT NOT NULL,
+			size INTEGER NOT NULL,
+			sha256 TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TRIGGER IF NOT EXISTS transactions_attachments_delete AFTER DELETE ON transactions BEGIN
+			DELETE FROM attachments WHERE transaction_id = old.id;
+		END`,
 	}
 
 	for _, table := range createTables {
@@ -218,136 +498,391 @@ func initDB() {
 		}
 	}
 
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM categories").Scan(&count)
-	if err != nil {
-		log.Fatal("Failed to count categories:", err)
-	}
-
-	if count == 0 {
-		defaultCategories := []struct {
-			name, categoryType, color, icon string
-		}{
-			{"Salário", "income", "#10B981", "💼"},
-			{"Freelance", "income", "#10B981", "💻"},
-			{"Investimentos", "income", "#10B981", "📈"},
-			{"Presentes", "income", "#10B981", "🎁"},
-			{"Outros", "income", "#10B981", "💰"},
-			{"Alimentação", "expense", "#EF4444", "🍔"},
-			{"Transporte", "expense", "#EF4444", "🚗"},
-			{"Moradia", "expense", "#EF4444", "🏠"},
-			{"Saúde", "expense", "#EF4444", "🏥"},
-			{"Educação", "expense", "#EF4444", "📚"},
-			{"Lazer", "expense", "#EF4444", "🎮"},
-			{"Vestuário", "expense", "#EF4444", "👕"},
-			{"Contas", "expense", "#EF4444", "💳"},
-			{"Outros", "expense", "#EF4444", "💸"},
-		}
-
-		stmt, err := db.Prepare("INSERT INTO categories (name, type, color, icon) VALUES (?, ?, ?, ?)")
+	// Columns added after the tables were first released
+	addColumnIfMissing("accounts", "currency", "TEXT NOT NULL DEFAULT 'BRL'")
+	addColumnIfMissing("transactions", "currency", "TEXT NOT NULL DEFAULT 'BRL'")
+	addColumnIfMissing("investments", "currency", "TEXT NOT NULL DEFAULT 'BRL'")
+	addColumnIfMissing("investments", "market", "TEXT NOT NULL DEFAULT 'B3'")
+	// History keeps the currency of its investment, which is gone once the
+	// position is closed
+	addColumnIfMissing("investment_movements", "currency", "TEXT")
+	addColumnIfMissing("investment_snapshots", "currency", "TEXT")
+	// Expense that paid for the investment, reversed when it is deleted
+	addColumnIfMissing("investments", "transaction_id", "INTEGER REFERENCES transactions(id)")
+	addColumnIfMissing("accounts", "deleted_at", "DATETIME")
+	addColumnIfMissing("transactions", "deleted_at", "DATETIME")
+	addColumnIfMissing("transactions", "payee_id", "INTEGER REFERENCES payees(id)")
+
+	// Per-user data. Categories without an owner are shared defaults; index
+	// rates, exchange rates and the recommendation catalog and rules are
+	// server-wide reference data.
+	for _, table := range userScopedTables {
+		if table == "allocation_targets" || table == "settings" {
+			continue
+		}
+		addColumnIfMissing(table, "user_id", "INTEGER REFERENCES users(id)")
+	}
+	addColumnIfMissing("categories", "user_id", "INTEGER REFERENCES users(id)")
+	rebuildTableIfMissing("categories", "UNIQUE (user_id, type, name)", categoriesTable)
+	rebuildTableIfMissing("allocation_targets", "UNIQUE (user_id, scope, key)", allocationTargetsTable)
+	rebuildTableIfMissing("settings", "PRIMARY KEY (user_id, key)", settingsTable)
+
+	userIndexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_accounts_user ON accounts(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_user_date ON transactions(user_id, date)",
+		"CREATE INDEX IF NOT EXISTS idx_investments_user ON investments(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_installments_user ON installments(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_investment_snapshots_user ON investment_snapshots(user_id, date)",
+		"CREATE INDEX IF NOT EXISTS idx_investment_movements_user ON investment_movements(user_id, date)",
+		"CREATE INDEX IF NOT EXISTS idx_analysis_rules_user ON analysis_rules(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_household_members_user ON household_members(user_id)",
+		"CREATE INDEX IF NOT EXISTS idx_household_accounts_account ON household_accounts(account_id)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_account_date ON transactions(account_id, date)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_account_amount ON transactions(account_id, amount)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_category_date ON transactions(category_id, date)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_date ON transactions(date)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_deleted ON transactions(deleted_at) WHERE deleted_at IS NOT NULL",
+		"CREATE INDEX IF NOT EXISTS idx_accounts_deleted ON accounts(deleted_at) WHERE deleted_at IS NOT NULL",
+		"CREATE INDEX IF NOT EXISTS idx_transaction_tags_tag ON transaction_tags(tag_id, transaction_id)",
+		"CREATE INDEX IF NOT EXISTS idx_transaction_splits_transaction ON transaction_splits(transaction_id)",
+		"CREATE INDEX IF NOT EXISTS idx_transaction_splits_category ON transaction_splits(category_id)",
+		"CREATE INDEX IF NOT EXISTS idx_categorization_rules_user ON categorization_rules(user_id, priority)",
+		"CREATE INDEX IF NOT EXISTS idx_categories_parent ON categories(parent_id)",
+		"CREATE INDEX IF NOT EXISTS idx_transactions_payee_date ON transactions(payee_id, date)",
+		"CREATE INDEX IF NOT EXISTS idx_payee_rules_payee ON payee_rules(payee_id)",
+		"CREATE INDEX IF NOT EXISTS idx_attachments_transaction ON attachments(transaction_id)",
+		"CREATE INDEX IF NOT EXISTS idx_attachments_sha256 ON attachments(sha256)",
+		// The shared defaults have no user_id, which UNIQUE treats as distinct
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_categories_default_name ON categories(type, name) WHERE user_id IS NULL",
+	}
+	for _, index := range userIndexes {
+		if _, err := db.Exec(index); err != nil {
+			log.Fatal("Failed to create index:", err)
+		}
+	}
+
+	initSearchIndex()
+	backfillInvestmentHistory()
+	seedRecommendationCatalog()
+	seedRecommendationRules()
+	seedAnalysisRules()
+
+	// Default categories missing from the database are added on every
+	// start. This brings in the ones that could not be created while names
+	// were unique across types, like the expense "Outros".
+	stmt, err := db.Prepare(`
+		INSERT INTO categories (name, type, color, icon)
+		SELECT ?1, ?2, ?3, ?4
+		WHERE NOT EXISTS (SELECT 1 FROM categories WHERE user_id IS NULL AND type = ?2 AND name = ?1)
+	`)
+	if err != nil {
+		log.Fatal("Failed to prepare statement:", err)
+	}
+	defer stmt.Close()
+
+	for _, cat := range config.DefaultCategories {
+		if cat.Color == "" {
+			cat.Color = "#6B7280"
+		}
+		if cat.Icon == "" {
+			cat.Icon = "💰"
+		}
+		_, err = stmt.Exec(cat.Name, cat.Type, cat.Color, cat.Icon)
 		if err != nil {
-			log.Fatal("Failed to prepare statement:", err)
+			log.Printf("Failed to insert category %s: %v", cat.Name, err)
 		}
-		defer stmt.Close()
+	}
+}
 
-		for _, cat := range defaultCategories {
-			_, err = stmt.Exec(cat.name, cat.categoryType, cat.color, cat.icon)
-			if err != nil {
-				log.Printf("Failed to insert category %s: %v", cat.name, err)
+// Add a column to a table created by an older version of the server
+func addColumnIfMissing(table, column, definition string) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		log.Fatal("Failed to inspect table:", err)
+	}
+	exists := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk); err == nil && name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+
+	if exists {
+		return
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition)); err != nil {
+		log.Fatalf("Failed to add column %s.%s: %v", table, column, err)
+	}
+}
+
+// Recreate a table whose constraints changed since it was first released
+// (detected by a fragment missing from its stored definition), copying the
+// columns both versions share
+func rebuildTableIfMissing(table, fragment, createSQL string) {
+	var current string
+	if err := db.QueryRow("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&current); err != nil {
+		log.Fatal("Failed to inspect table:", err)
+	}
+	if strings.Contains(current, fragment) {
+		return
+	}
+
+	columnsOf := func(name string) map[string]bool {
+		rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", name))
+		if err != nil {
+			log.Fatal("Failed to inspect table:", err)
+		}
+		defer rows.Close()
+		columns := make(map[string]bool)
+		for rows.Next() {
+			var cid, notNull, pk int
+			var colName, colType string
+			var defaultValue sql.NullString
+			if err := rows.Scan(&cid, &colName, &colType, &notNull, &defaultValue, &pk); err == nil {
+				columns[colName] = true
 			}
 		}
+		return columns
+	}
+	oldColumns := columnsOf(table)
+
+	// With the legacy behaviour, renaming leaves the references of other
+	// tables and triggers to the table alone, so they point to the new one
+	db.Exec("PRAGMA legacy_alter_table = ON")
+	defer db.Exec("PRAGMA legacy_alter_table = OFF")
+
+	statements := []string{fmt.Sprintf("ALTER TABLE %s RENAME TO %s_old", table, table), createSQL}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatalf("Failed to rebuild table %s: %v", table, err)
+		}
+	}
+
+	var shared []string
+	for column := range columnsOf(table) {
+		if oldColumns[column] {
+			shared = append(shared, column)
+		}
+	}
+	sort.Strings(shared)
+	columns := strings.Join(shared, ", ")
+	statements = []string{
+		fmt.Sprintf("INSERT INTO %s (%s) SELECT %s FROM %s_old", table, columns, columns, table),
+		fmt.Sprintf("DROP TABLE %s_old", table),
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatalf("Failed to rebuild table %s: %v", table, err)
+		}
 	}
 }
 
 func main() {
+	config = loadConfig()
 	initDB()
 	defer db.Close()
 
 	go checkAndProcessSalary()
 	go autoUpdateInvestmentPrices()
-
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "5000"
-	}
+	go autoPurgeTrash()
+	go autoBackup()
 
 	r := gin.Default()
 
 	r.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{"http://localhost:3001", "http://localhost:3000"},
+		AllowOrigins:     config.CORSOrigins,
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Accept"},
-		ExposeHeaders:    []string{"Content-Length"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Accept", "Authorization"},
+		ExposeHeaders:    []string{"Content-Length", "X-Next-Cursor", "X-Total-Count", "X-Missing-Rates"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Routes - Authentication
+	r.POST("/api/auth/register", register)
+	r.POST("/api/auth/login", login)
+
+	// Every other route requires a signed in user
+	api := r.Group("/api", authRequired())
+	api.POST("/auth/logout", logout)
+	api.GET("/auth/me", getCurrentUser)
+	api.PUT("/auth/password", changePassword)
+
+	// Routes - Trash
+	api.GET("/trash", getTrash)
+	api.POST("/trash/transactions/:id/restore", restoreTransaction)
+	api.POST("/trash/accounts/:id/restore", restoreAccount)
+
+	// Routes - Audit log
+	api.GET("/audit", getAuditLog)
+
+	// Routes - Search
+	api.GET("/search", searchTransactions)
+
+	// Backup
+	api.GET("/export", exportData)
+	api.POST("/import", importData)
+	api.GET("/backups", adminRequired(), getBackups)
+	api.POST("/backups", adminRequired(), triggerBackup)
+
+	// Routes - Personal API tokens
+	api.GET("/tokens", getAPITokens)
+	api.POST("/tokens", createAPIToken)
+	api.DELETE("/tokens/:id", revokeAPIToken)
+
 	// Routes - Accounts
-	r.GET("/api/accounts", getAccounts)
-	r.POST("/api/accounts", createAccount)
-	r.PUT("/api/accounts/:id", updateAccount)
-	r.DELETE("/api/accounts/:id", deleteAccount)
-
-	r.GET("/api/categories", getCategories)
-	r.POST("/api/categories", createCategory)
-
-	r.GET("/api/transactions", getTransactions)
-	r.POST("/api/transactions", createTransaction)
-	r.PUT("/api/transactions/:id", updateTransaction)
-	r.DELETE("/api/transactions/clear", clearAllTransactions)
-	r.DELETE("/api/transactions/:id", deleteTransaction)
-	
-	r.GET("/api/salary", getSalaryConfig)
-	r.POST("/api/salary", saveSalaryConfig)
-	r.POST("/api/salary/process", processSalaryManually)
-
-	r.GET("/api/stats", getStats)
-	r.GET("/api/stats/period", getStatsByPeriod)
-	r.GET("/api/stats/comparison", getMonthlyComparison)
-	r.GET("/api/stats/top-expenses", getTopExpenses)
-	r.GET("/api/stats/balance-history", getBalanceHistory)
-	r.GET("/api/stats/expenses-by-category", getExpensesByCategory)
-	r.GET("/api/investments", getInvestments)
-	r.POST("/api/investments", createInvestment)
-	r.PUT("/api/investments/:id", updateInvestment)
-	r.DELETE("/api/investments/:id", deleteInvestment)
-	r.GET("/api/investments/summary", getInvestmentsSummary)
-	r.POST("/api/investments/:id/update-price", updateInvestmentPrice)
-	r.POST("/api/investments/update-all-prices", updateAllInvestmentPrices)
-	r.GET("/api/investments/fetch-price", fetchPriceForTicker)
-	r.GET("/api/investments/search", searchInvestmentSuggestions)
-	r.GET("/api/investments/analysis", getInvestmentAnalysis)
-	r.GET("/api/investments/recommendations", getInvestmentRecommendations)
-	r.POST("/api/investments/:id/sell", sellInvestment)
-
-	r.GET("/api/installments", getInstallments)
-	r.POST("/api/installments", createInstallment)
-	r.GET("/api/installments/:id/payments", getInstallmentPayments)
-	r.POST("/api/installments/:id/pay", payInstallment)
-	r.DELETE("/api/installments/:id", deleteInstallment)
-
-	log.Printf("Server running on port %s", port)
-	r.Run(":" + port)
+	api.GET("/accounts", getAccounts)
+	api.POST("/accounts", createAccount)
+	api.PUT("/accounts/:id", updateAccount)
+	api.DELETE("/accounts/:id", deleteAccount)
+
+	// Routes - Households (shared accounts)
+	api.GET("/households", getHouseholds)
+	api.POST("/households", createHousehold)
+	api.PUT("/households/:id", updateHousehold)
+	api.DELETE("/households/:id", deleteHousehold)
+	api.POST("/households/:id/members", addHouseholdMember)
+	api.PUT("/households/:id/members/:userId", updateHouseholdMember)
+	api.DELETE("/households/:id/members/:userId", removeHouseholdMember)
+	api.POST("/households/:id/accounts", shareAccount)
+	api.DELETE("/households/:id/accounts/:accountId", unshareAccount)
+
+	api.GET("/categories", getCategories)
+	api.POST("/categories", createCategory)
+	api.PUT("/categories/:id", updateCategory)
+	api.DELETE("/categories/:id", deleteCategory)
+
+	api.GET("/payees", getPayees)
+	api.POST("/payees", createPayee)
+	api.POST("/payees/apply", applyPayeeRules)
+	api.PUT("/payees/:id", updatePayee)
+	api.DELETE("/payees/:id", deletePayee)
+
+	api.GET("/categorization-rules", getCategorizationRules)
+	api.POST("/categorization-rules", createCategorizationRule)
+	api.POST("/categorization-rules/apply", applyCategorizationRules)
+	api.PUT("/categorization-rules/:id", updateCategorizationRule)
+	api.DELETE("/categorization-rules/:id", deleteCategorizationRule)
+
+	api.GET("/tags", getTags)
+	api.POST("/tags", createTag)
+	api.PUT("/tags/:id", updateTag)
+	api.DELETE("/tags/:id", deleteTag)
+
+	api.GET("/transactions", getTransactions)
+	api.POST("/transactions", createTransaction)
+	api.GET("/transactions/suggest-category", suggestCategory)
+	api.PUT("/transactions/:id", updateTransaction)
+	api.DELETE("/transactions/clear", clearAllTransactions)
+	api.DELETE("/transactions/:id", deleteTransaction)
+	api.GET("/transactions/:id/attachments", getTransactionAttachments)
+	api.POST("/transactions/:id/attachments", uploadAttachment)
+	api.GET("/attachments/:id", downloadAttachment)
+	api.DELETE("/attachments/:id", deleteAttachment)
+
+	api.GET("/salary", getSalaryConfig)
+	api.POST("/salary", saveSalaryConfig)
+	api.POST("/salary/process", processSalaryManually)
+
+	api.GET("/stats", getStats)
+	api.GET("/stats/period", getStatsByPeriod)
+	api.GET("/stats/comparison", getMonthlyComparison)
+	api.GET("/stats/top-expenses", getTopExpenses)
+	api.GET("/stats/balance-history", getBalanceHistory)
+	api.GET("/stats/expenses-by-category", getExpensesByCategory)
+	api.GET("/stats/by-tag", getStatsByTag)
+	api.GET("/stats/by-payee", getStatsByPayee)
+	api.GET("/reports/irpf", getIncomeTaxReport)
+	api.GET("/investments", getInvestments)
+	api.POST("/investments", createInvestment)
+	api.PUT("/investments/:id", updateInvestment)
+	api.DELETE("/investments/:id", deleteInvestment)
+	api.GET("/investments/summary", getInvestmentsSummary)
+	api.POST("/investments/:id/update-price", updateInvestmentPrice)
+	api.POST("/investments/update-all-prices", updateAllInvestmentPrices)
+	api.GET("/investments/fetch-price", fetchPriceForTicker)
+	api.GET("/investments/search", searchInvestmentSuggestions)
+	api.GET("/investments/analysis", getInvestmentAnalysis)
+	api.GET("/investments/analysis-rules", getAnalysisRules)
+	api.POST("/investments/analysis-rules", createAnalysisRule)
+	api.PUT("/investments/analysis-rules/:id", updateAnalysisRule)
+	api.DELETE("/investments/analysis-rules/:id", deleteAnalysisRule)
+	api.GET("/investments/recommendations", getInvestmentRecommendations)
+	api.GET("/investments/performance", getInvestmentPerformance)
+	api.GET("/investments/targets", getAllocationTargets)
+	api.PUT("/investments/targets", saveAllocationTargets)
+	api.POST("/investments/rebalance", getRebalancePlan)
+	api.GET("/investments/profile", getInvestorProfile)
+	api.PUT("/investments/profile", saveInvestorProfile)
+	// The catalog and its rules are shared by every user, so only the
+	// administrator changes them
+	api.GET("/investments/catalog", getRecommendationCatalog)
+	api.POST("/investments/catalog", adminRequired(), createCatalogItem)
+	api.PUT("/investments/catalog/:id", adminRequired(), updateCatalogItem)
+	api.DELETE("/investments/catalog/:id", adminRequired(), deleteCatalogItem)
+	api.GET("/investments/recommendation-rules", getRecommendationRules)
+	api.POST("/investments/recommendation-rules", adminRequired(), createRecommendationRule)
+	api.PUT("/investments/recommendation-rules/:id", adminRequired(), updateRecommendationRule)
+	api.DELETE("/investments/recommendation-rules/:id", adminRequired(), deleteRecommendationRule)
+	api.POST("/investments/:id/sell", sellInvestment)
+
+	api.GET("/installments", getInstallments)
+	api.POST("/installments", createInstallment)
+	api.GET("/installments/:id/payments", getInstallmentPayments)
+	api.POST("/installments/:id/pay", payInstallment)
+	api.DELETE("/installments/:id", deleteInstallment)
+
+	// Exchange rates are shared by every user, so only the administrator
+	// changes them
+	api.GET("/fx-rates", getFXRates)
+	api.POST("/fx-rates", adminRequired(), createFXRate)
+	api.POST("/fx-rates/:currency/import", adminRequired(), importFXRates)
+	api.GET("/settings/base-currency", getBaseCurrency)
+	api.PUT("/settings/base-currency", saveBaseCurrency)
+
+	api.GET("/indexes", getIndexes)
+	api.GET("/indexes/:code", getIndexRatesHandler)
+	api.GET("/indexes/:code/accumulated", getAccumulatedIndex)
+	// Index rates are shared by every user, so only the administrator imports them
+	api.POST("/indexes/:code/import", adminRequired(), importIndexRates)
+
+	log.Printf("Server running on port %d", config.Port)
+	r.Run(fmt.Sprintf(":%d", config.Port))
 }
 
+// Get the user's accounts and the ones shared with them, with the role they
+// have on each
 func getAccounts(c *gin.Context) {
-	rows, err := db.Query("SELECT * FROM accounts ORDER BY created_at DESC")
+	userID := currentUserID(c)
+	rows, err := db.Query(
+		"SELECT id, name, type, balance, color, currency, created_at FROM accounts WHERE id IN ("+visibleAccountsQuery+") ORDER BY created_at DESC",
+		userID, userID,
+	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
 	var accounts []Account
 	for rows.Next() {
 		var acc Account
-		err := rows.Scan(&acc.ID, &acc.Name, &acc.Type, &acc.Balance, &acc.Color, &acc.CreatedAt)
+		err := rows.Scan(&acc.ID, &acc.Name, &acc.Type, &acc.Balance, &acc.Color, &acc.Currency, &acc.CreatedAt)
 		if err != nil {
+			rows.Close()
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 		accounts = append(accounts, acc)
 	}
+	rows.Close()
+
+	for i := range accounts {
+		accounts[i].Role = accountRole(accounts[i].ID, userID)
+	}
 
 	c.JSON(200, accounts)
 }
@@ -365,10 +900,15 @@ func createAccount(c *gin.Context) {
 	if acc.Color == "" {
 		acc.Color = "#3B82F6"
 	}
+	acc.Currency = normalizeCurrency(acc.Currency)
+	if !isValidCurrency(acc.Currency) {
+		c.JSON(400, gin.H{"error": "Invalid currency"})
+		return
+	}
 
 	result, err := db.Exec(
-		"INSERT INTO accounts (name, type, balance, color) VALUES (?, ?, ?, ?)",
-		acc.Name, acc.Type, acc.Balance, acc.Color,
+		"INSERT INTO accounts (user_id, name, type, balance, color, currency) VALUES (?, ?, ?, ?, ?, ?)",
+		currentUserID(c), acc.Name, acc.Type, acc.Balance, acc.Color, acc.Currency,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
@@ -377,6 +917,7 @@ func createAccount(c *gin.Context) {
 
 	id, _ := result.LastInsertId()
 	acc.ID = int(id)
+	recordAudit(c, db, "account", id, nil, auditRow(db, "accounts", id))
 	c.JSON(200, acc)
 }
 
@@ -388,112 +929,182 @@ func updateAccount(c *gin.Context) {
 		return
 	}
 
+	// Currency is optional on update; the current one is kept when omitted
+	if acc.Currency != "" {
+		acc.Currency = normalizeCurrency(acc.Currency)
+		if !isValidCurrency(acc.Currency) {
+			c.JSON(400, gin.H{"error": "Invalid currency"})
+			return
+		}
+	}
+
+	if !authorizeAccount(c, id, roleEditor, 404) {
+		return
+	}
+
+	// Members the account is shared with can rename it but not set its
+	// balance, which only moves with their transactions
+	before := auditRow(db, "accounts", id)
+	if accountRole(id, currentUserID(c)) != roleOwner {
+		var balance float64
+		if err := db.QueryRow("SELECT balance FROM accounts WHERE id = ?", id).Scan(&balance); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if balance != acc.Balance {
+			c.JSON(403, gin.H{"error": "Only the account owner can change its balance"})
+			return
+		}
+	}
 	_, err := db.Exec(
-		"UPDATE accounts SET name = ?, type = ?, balance = ?, color = ? WHERE id = ?",
-		acc.Name, acc.Type, acc.Balance, acc.Color, id,
+		"UPDATE accounts SET name = ?, type = ?, balance = ?, color = ?, currency = COALESCE(NULLIF(?, ''), currency) WHERE id = ?",
+		acc.Name, acc.Type, acc.Balance, acc.Color, acc.Currency, id,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, db, "account", id, before, auditRow(db, "accounts", id))
 
 	c.JSON(200, gin.H{"message": "Account updated successfully"})
 }
 
 func deleteAccount(c *gin.Context) {
 	id := c.Param("id")
+	// Only the owner can delete an account, even when it is shared
+	if !authorizeAccount(c, id, roleOwner, 404) {
+		return
+	}
+
+	// The account goes to the trash with its transactions, all marked with
+	// the same deleted_at so they are restored together. Balances are left
+	// as they are. Household sharing is kept for when it is restored.
+	deletedAt := deletedAtNow()
+	before := auditRow(db, "accounts", id)
+	trashed := auditRows(db, "transactions", "account_id = ? AND deleted_at IS NULL", id)
 
-	_, err := db.Exec("DELETE FROM transactions WHERE account_id = ?", id)
+	tx, err := db.Begin()
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	defer tx.Rollback()
 
-	_, err = db.Exec("DELETE FROM accounts WHERE id = ?", id)
+	_, err = tx.Exec("UPDATE transactions SET deleted_at = ? WHERE account_id = ? AND deleted_at IS NULL", deletedAt, id)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-
-	c.JSON(200, gin.H{"message": "Account deleted successfully"})
-}
-
-func getCategories(c *gin.Context) {
-	rows, err := db.Query("SELECT * FROM categories ORDER BY type, name")
+	_, err = tx.Exec("UPDATE accounts SET deleted_at = ? WHERE id = ?", deletedAt, id)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
+	for _, t := range trashed {
+		recordAudit(c, tx, "transaction", t["id"], t, auditRow(tx, "transactions", t["id"]))
+	}
+	recordAudit(c, tx, "account", id, before, auditRow(tx, "accounts", id))
 
-	var categories []Category
-	for rows.Next() {
-		var cat Category
-		err := rows.Scan(&cat.ID, &cat.Name, &cat.Type, &cat.Color, &cat.Icon, &cat.CreatedAt)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
-		categories = append(categories, cat)
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
 	}
+	resetCategoryModels()
 
-	c.JSON(200, categories)
+	c.JSON(200, gin.H{"message": "Account moved to trash successfully"})
 }
 
-func createCategory(c *gin.Context) {
-	var cat Category
-	if err := c.ShouldBindJSON(&cat); err != nil {
+// Get the transactions of the accounts the user can see, filtered, sorted and
+// paginated by the query parameters described in transaction_filters.go
+func getTransactions(c *gin.Context) {
+	userID := currentUserID(c)
+
+	conditions, filterArgs, err := transactionFilters(c)
+	if err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-
-	if cat.Color == "" {
-		cat.Color = "#6B7280"
+	sortName := c.DefaultQuery("sort", "-date")
+	order, ok := transactionSorts[sortName]
+	if !ok {
+		c.JSON(400, gin.H{"error": "sort must be date, -date, amount or -amount"})
+		return
 	}
-	if cat.Icon == "" {
-		cat.Icon = "💰"
+
+	where := "t.deleted_at IS NULL AND t.account_id IN (" + visibleAccountsQuery + ")"
+	for _, condition := range conditions {
+		where += " AND " + condition
 	}
+	args := append([]interface{}{userID, userID}, filterArgs...)
 
-	result, err := db.Exec(
-		"INSERT INTO categories (name, type, color, icon) VALUES (?, ?, ?, ?)",
-		cat.Name, cat.Type, cat.Color, cat.Icon,
-	)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxTransactionPage {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxTransactionPage)})
+			return
+		}
+
+		var total int
+		if err := db.QueryRow("SELECT COUNT(*) FROM transactions t WHERE "+where, args...).Scan(&total); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		c.Header("X-Total-Count", strconv.Itoa(total))
 	}
 
-	id, _ := result.LastInsertId()
-	cat.ID = int(id)
-	c.JSON(200, cat)
-}
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := decodeTransactionCursor(value, sortName)
+		if err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+		comparison := ">"
+		if order.desc {
+			comparison = "<"
+		}
+		where += fmt.Sprintf(" AND (%s %s ? OR (%s = ? AND t.id %s ?))", order.column, comparison, order.column, comparison)
+		args = append(args, cursor.Value, cursor.Value, cursor.ID)
+	}
 
-func getTransactions(c *gin.Context) {
-	query := `
-		SELECT 
-			t.id, t.account_id, t.category_id, t.type, t.amount, t.description, t.date, t.created_at,
+	direction := "ASC"
+	if order.desc {
+		direction = "DESC"
+	}
+	query := fmt.Sprintf(`
+		SELECT
+			t.id, t.account_id, t.category_id, t.type, t.amount, t.currency, t.description, t.date, t.created_at,
 			a.name as account_name, a.color as account_color,
-			c.name as category_name, c.color as category_color, c.icon as category_icon
+			c.name as category_name, c.color as category_color, c.icon as category_icon,
+			t.payee_id, p.name as payee_name
 		FROM transactions t
 		LEFT JOIN accounts a ON t.account_id = a.id
 		LEFT JOIN categories c ON t.category_id = c.id
-		ORDER BY t.date DESC, t.created_at DESC
-	`
+		LEFT JOIN payees p ON t.payee_id = p.id
+		WHERE %s
+		ORDER BY %s %s, t.id %s
+	`, where, order.column, direction, direction)
+	if limit > 0 {
+		// One more than the page tells whether there is a next page
+		query += " LIMIT ?"
+		args = append(args, limit+1)
+	}
 
-	rows, err := db.Query(query)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 	defer rows.Close()
 
-	var transactions []Transaction
+	transactions := []Transaction{}
 	for rows.Next() {
 		var t Transaction
 		err := rows.Scan(
-			&t.ID, &t.AccountID, &t.CategoryID, &t.Type, &t.Amount, &t.Description, &t.Date, &t.CreatedAt,
+			&t.ID, &t.AccountID, &t.CategoryID, &t.Type, &t.Amount, &t.Currency, &t.Description, &t.Date, &t.CreatedAt,
 			&t.AccountName, &t.AccountColor,
 			&t.CategoryName, &t.CategoryColor, &t.CategoryIcon,
+			&t.PayeeID, &t.PayeeName,
 		)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
@@ -501,10 +1112,61 @@ func getTransactions(c *gin.Context) {
 		}
 		transactions = append(transactions, t)
 	}
+	rows.Close()
+
+	if limit > 0 && len(transactions) > limit {
+		transactions = transactions[:limit]
+		last := transactions[limit-1]
+		cursor := transactionCursor{Sort: sortName, ID: last.ID, Value: last.Date}
+		if order.column == "t.amount" {
+			cursor.Value = last.Amount
+		}
+		c.Header("X-Next-Cursor", encodeTransactionCursor(cursor))
+	}
+	listed := make([]*Transaction, len(transactions))
+	for i := range transactions {
+		listed[i] = &transactions[i]
+	}
+	if err := attachTransactionDetails(userID, listed); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(200, transactions)
 }
 
+// Fill the user's tags and the split lines of listed transactions
+func attachTransactionDetails(userID int, transactions []*Transaction) error {
+	ids := make([]int, len(transactions))
+	for i, t := range transactions {
+		ids[i] = t.ID
+	}
+	tags, err := transactionTags(userID, ids)
+	if err != nil {
+		return err
+	}
+	splits, err := transactionSplits(ids)
+	if err != nil {
+		return err
+	}
+	attachments, err := attachmentCounts(ids)
+	if err != nil {
+		return err
+	}
+	for _, t := range transactions {
+		t.AttachmentCount = attachments[t.ID]
+		t.Tags = tags[t.ID]
+		if t.Tags == nil {
+			t.Tags = []Tag{}
+		}
+		t.Splits = splits[t.ID]
+		if t.Splits == nil {
+			t.Splits = []TransactionSplit{}
+		}
+	}
+	return nil
+}
+
 func createTransaction(c *gin.Context) {
 	var t Transaction
 	if err := c.ShouldBindJSON(&t); err != nil {
@@ -512,9 +1174,57 @@ func createTransaction(c *gin.Context) {
 		return
 	}
 
-	result, err := db.Exec(
-		"INSERT INTO transactions (account_id, category_id, type, amount, description, date) VALUES (?, ?, ?, ?, ?, ?)",
-		t.AccountID, t.CategoryID, t.Type, t.Amount, t.Description, t.Date,
+	userID := currentUserID(c)
+	if !authorizeAccount(c, t.AccountID, roleEditor, 400) {
+		return
+	}
+	if !categoryVisible(t.CategoryID, userID) {
+		c.JSON(400, gin.H{"error": "Category not found"})
+		return
+	}
+
+	// Amounts move the account balance directly, so they must be in the
+	// account's currency
+	currency := accountCurrency(db, t.AccountID)
+	if t.Currency != "" && normalizeCurrency(t.Currency) != currency {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Transaction currency must match the account currency (%s)", currency)})
+		return
+	}
+	t.Currency = currency
+
+	if err := validateSplits(t.Splits, t.Amount, userID); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	// Transactions given without a category get one from the rules
+	rule, err := categorizeTransaction(db, userID, &t)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	// And without a payee, one from the payee rules
+	if err := resolveTransactionPayee(db, userID, &t); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The row, the balance, the tags and the splits are saved together
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	tagIDs, err := resolveTags(tx, userID, t.Tags)
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := tx.Exec(
+		"INSERT INTO transactions (user_id, account_id, category_id, payee_id, type, amount, currency, description, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, t.AccountID, t.CategoryID, t.PayeeID, t.Type, t.Amount, t.Currency, t.Description, t.Date,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
@@ -530,7 +1240,7 @@ func createTransaction(c *gin.Context) {
 		balanceChange = -t.Amount
 	}
 
-	_, err = db.Exec(
+	_, err = tx.Exec(
 		"UPDATE accounts SET balance = balance + ? WHERE id = ?",
 		balanceChange, t.AccountID,
 	)
@@ -538,8 +1248,29 @@ func createTransaction(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	if err := setTransactionTags(tx, userID, id, tagIDs); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := setTransactionSplits(tx, id, t.Splits); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, tx, "transaction", id, nil, auditRow(tx, "transactions", id))
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 
-	c.JSON(200, gin.H{"id": id, "message": "Transaction created successfully"})
+	response := gin.H{"id": id, "message": "Transaction created successfully"}
+	if rule != nil {
+		response["category_id"] = rule.CategoryID
+		response["rule_id"] = rule.ID
+	}
+	if t.PayeeID != nil {
+		response["payee_id"] = *t.PayeeID
+	}
+	c.JSON(200, response)
 }
 
 func deleteTransaction(c *gin.Context) {
@@ -548,74 +1279,79 @@ func deleteTransaction(c *gin.Context) {
 	var tID, accountID int
 	var transactionType string
 	var amount float64
-	err := db.QueryRow("SELECT id, account_id, type, amount FROM transactions WHERE id = ?", id).Scan(
-		&tID, &accountID, &transactionType, &amount,
-	)
-	if err != nil && err != sql.ErrNoRows {
+	userID := currentUserID(c)
+	err := db.QueryRow(
+		"SELECT id, account_id, type, amount FROM transactions WHERE id = ? AND deleted_at IS NULL AND account_id IN ("+visibleAccountsQuery+")",
+		id, userID, userID,
+	).Scan(&tID, &accountID, &transactionType, &amount)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Transaction not found"})
+		return
+	}
+	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	if !authorizeAccount(c, accountID, roleEditor, 404) {
+		return
+	}
+	before := auditRow(db, "transactions", id)
 
-	if err == nil {
-		// Reverse balance change
-		var balanceChange float64
-		if transactionType == "income" {
-			balanceChange = -amount
-		} else {
-			balanceChange = amount
-		}
+	// Reverse balance change
+	var balanceChange float64
+	if transactionType == "income" {
+		balanceChange = -amount
+	} else {
+		balanceChange = amount
+	}
 
-		_, err = db.Exec(
-			"UPDATE accounts SET balance = balance + ? WHERE id = ?",
-			balanceChange, accountID,
-		)
-		if err != nil {
-			c.JSON(500, gin.H{"error": err.Error()})
-			return
-		}
+	_, err = db.Exec(
+		"UPDATE accounts SET balance = balance + ? WHERE id = ?",
+		balanceChange, accountID,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
 	}
 
-	// Delete transaction
-	_, err = db.Exec("DELETE FROM transactions WHERE id = ?", id)
+	// Move transaction to the trash
+	learnTransaction(id, -1)
+	_, err = db.Exec("UPDATE transactions SET deleted_at = ? WHERE id = ?", deletedAtNow(), id)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, db, "transaction", id, before, auditRow(db, "transactions", id))
 
-	c.JSON(200, gin.H{"message": "Transaction deleted successfully"})
+	c.JSON(200, gin.H{"message": "Transaction moved to trash successfully"})
 }
 
 func getStats(c *gin.Context) {
-	var stats Stats
+	// Amounts are converted into the base currency
+	userID := currentUserID(c)
+	stats := Stats{Currency: baseCurrency(userID)}
+	fx := newFXConverter()
 
 	// Total balance
-	err := db.QueryRow("SELECT SUM(balance) FROM accounts").Scan(&stats.TotalBalance)
-	if err != nil {
-		stats.TotalBalance = 0
+	totalBalance, err := fx.sumQuery(stats.Currency,
+		"SELECT currency, ?, balance FROM accounts WHERE id IN ("+visibleAccountsQuery+")", time.Now().Format("2006-01-02"), userID, userID)
+	if err == nil {
+		stats.TotalBalance = totalBalance
 	}
 
 	// Current month
 	currentMonth := time.Now().Format("2006-01")
 
 	// Monthly income
-	err = db.QueryRow(
-		"SELECT SUM(amount) FROM transactions WHERE type = 'income' AND date LIKE ?",
-		currentMonth+"%",
-	).Scan(&stats.MonthlyIncome)
-	if err != nil {
-		stats.MonthlyIncome = 0
-	}
+	stats.MonthlyIncome = fx.sumTransactions(stats.Currency, "income", "account_id IN ("+visibleAccountsQuery+") AND date LIKE ?", userID, userID, currentMonth+"%")
 
 	// Monthly expenses
-	err = db.QueryRow(
-		"SELECT SUM(amount) FROM transactions WHERE type = 'expense' AND date LIKE ?",
-		currentMonth+"%",
-	).Scan(&stats.MonthlyExpenses)
-	if err != nil {
-		stats.MonthlyExpenses = 0
-	}
+	stats.MonthlyExpenses = fx.sumTransactions(stats.Currency, "expense", "account_id IN ("+visibleAccountsQuery+") AND date LIKE ?", userID, userID, currentMonth+"%")
 
 	stats.MonthlyBalance = stats.MonthlyIncome - stats.MonthlyExpenses
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		stats.MissingRates = missing
+	}
 
 	c.JSON(200, stats)
 }
@@ -628,13 +1364,85 @@ func updateTransaction(c *gin.Context) {
 	var oldTID, oldAccountID int
 	var oldType string
 	var oldAmount float64
-	err := db.QueryRow("SELECT id, account_id, type, amount FROM transactions WHERE id = ?", id).Scan(
-		&oldTID, &oldAccountID, &oldType, &oldAmount,
-	)
+	userID := currentUserID(c)
+	err := db.QueryRow(
+		"SELECT id, account_id, type, amount FROM transactions WHERE id = ? AND deleted_at IS NULL AND account_id IN ("+visibleAccountsQuery+")",
+		id, userID, userID,
+	).Scan(&oldTID, &oldAccountID, &oldType, &oldAmount)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Transaction not found"})
 		return
 	}
+	if !authorizeAccount(c, oldAccountID, roleEditor, 404) {
+		return
+	}
+	before := auditRow(db, "transactions", id)
+
+	// Get new transaction data
+	var t Transaction
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorizeAccount(c, t.AccountID, roleEditor, 400) {
+		return
+	}
+	if !categoryVisible(t.CategoryID, userID) {
+		c.JSON(400, gin.H{"error": "Category not found"})
+		return
+	}
+
+	currency := accountCurrency(db, t.AccountID)
+	if t.Currency != "" && normalizeCurrency(t.Currency) != currency {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Transaction currency must match the account currency (%s)", currency)})
+		return
+	}
+	t.Currency = currency
+
+	// Splits are left as they are when the request has none, so they must
+	// still add up to the amount
+	if t.Splits != nil {
+		if err := validateSplits(t.Splits, t.Amount, userID); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	} else {
+		var lines int
+		var splitTotal float64
+		db.QueryRow("SELECT COUNT(*), COALESCE(SUM(amount), 0) FROM transaction_splits WHERE transaction_id = ?", id).Scan(&lines, &splitTotal)
+		if lines > 0 && !sameAmount(splitTotal, t.Amount) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Splits add up to %.2f, not to the amount of %.2f", splitTotal, t.Amount)})
+			return
+		}
+	}
+
+	// The payee follows the description unless one is given
+	if err := resolveTransactionPayee(db, userID, &t); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+
+	// What the category suggestions learned from the transaction is updated
+	// with it, outside of the database transaction below
+	learnTransaction(id, -1)
+	defer learnTransaction(id, 1)
+
+	// The row, the balances, the tags and the splits are saved together
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	// Tags are left as they are when the request has none
+	var tagIDs []int
+	if t.Tags != nil {
+		if tagIDs, err = resolveTags(tx, userID, t.Tags); err != nil {
+			c.JSON(400, gin.H{"error": err.Error()})
+			return
+		}
+	}
 
 	// Reverse old balance
 	var oldBalanceChange float64
@@ -643,19 +1451,16 @@ func updateTransaction(c *gin.Context) {
 	} else {
 		oldBalanceChange = oldAmount
 	}
-	db.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", oldBalanceChange, oldAccountID)
-
-	// Get new transaction data
-	var t Transaction
-	if err := c.ShouldBindJSON(&t); err != nil {
-		c.JSON(400, gin.H{"error": err.Error()})
+	_, err = tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", oldBalanceChange, oldAccountID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 
 	// Update transaction
-	_, err = db.Exec(
-		"UPDATE transactions SET account_id = ?, category_id = ?, type = ?, amount = ?, description = ?, date = ? WHERE id = ?",
-		t.AccountID, t.CategoryID, t.Type, t.Amount, t.Description, t.Date, id,
+	_, err = tx.Exec(
+		"UPDATE transactions SET account_id = ?, category_id = ?, payee_id = ?, type = ?, amount = ?, currency = ?, description = ?, date = ? WHERE id = ?",
+		t.AccountID, t.CategoryID, t.PayeeID, t.Type, t.Amount, t.Currency, t.Description, t.Date, id,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
@@ -670,7 +1475,7 @@ func updateTransaction(c *gin.Context) {
 		newBalanceChange = -t.Amount
 	}
 
-	_, err = db.Exec(
+	_, err = tx.Exec(
 		"UPDATE accounts SET balance = balance + ? WHERE id = ?",
 		newBalanceChange, t.AccountID,
 	)
@@ -678,6 +1483,23 @@ func updateTransaction(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	if t.Tags != nil {
+		if err := setTransactionTags(tx, userID, id, tagIDs); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if t.Splits != nil {
+		if err := setTransactionSplits(tx, id, t.Splits); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	recordAudit(c, tx, "transaction", id, before, auditRow(tx, "transactions", id))
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 
 	c.JSON(200, gin.H{"message": "Transaction updated successfully"})
 }
@@ -687,22 +1509,35 @@ func getStatsByPeriod(c *gin.Context) {
 	startDate := c.Query("start")
 	endDate := c.Query("end")
 
-	var income, expenses float64
-	db.QueryRow(
-		"SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE type = 'income' AND date >= ? AND date <= ?",
-		startDate, endDate,
-	).Scan(&income)
+	userID := currentUserID(c)
+	condition := "account_id IN (" + visibleAccountsQuery + ") AND date >= ? AND date <= ?"
+	args := []interface{}{userID, userID, startDate, endDate}
+	// Optionally only the transactions with some tags
+	tagCondition, tagArgs, err := tagFilter(c, "id")
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if tagCondition != "" {
+		condition += " AND " + tagCondition
+		args = append(args, tagArgs...)
+	}
 
-	db.QueryRow(
-		"SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE type = 'expense' AND date >= ? AND date <= ?",
-		startDate, endDate,
-	).Scan(&expenses)
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+	income := fx.sumTransactions(currency, "income", condition, args...)
+	expenses := fx.sumTransactions(currency, "expense", condition, args...)
 
-	c.JSON(200, gin.H{
+	response := gin.H{
 		"income":   income,
 		"expenses": expenses,
 		"balance":  income - expenses,
-	})
+		"currency": currency,
+	}
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		response["missingRates"] = missing
+	}
+	c.JSON(200, response)
 }
 
 // Get monthly comparison
@@ -711,14 +1546,16 @@ func getMonthlyComparison(c *gin.Context) {
 	currentMonth := now.Format("2006-01")
 	lastMonth := now.AddDate(0, -1, 0).Format("2006-01")
 
-	var currentIncome, currentExpenses, lastIncome, lastExpenses float64
+	userID := currentUserID(c)
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+	currentIncome := fx.sumTransactions(currency, "income", "account_id IN ("+visibleAccountsQuery+") AND date LIKE ?", userID, userID, currentMonth+"%")
+	currentExpenses := fx.sumTransactions(currency, "expense", "account_id IN ("+visibleAccountsQuery+") AND date LIKE ?", userID, userID, currentMonth+"%")
+	lastIncome := fx.sumTransactions(currency, "income", "account_id IN ("+visibleAccountsQuery+") AND date LIKE ?", userID, userID, lastMonth+"%")
+	lastExpenses := fx.sumTransactions(currency, "expense", "account_id IN ("+visibleAccountsQuery+") AND date LIKE ?", userID, userID, lastMonth+"%")
 
-	db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE type = 'income' AND date LIKE ?", currentMonth+"%").Scan(&currentIncome)
-	db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE type = 'expense' AND date LIKE ?", currentMonth+"%").Scan(&currentExpenses)
-	db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE type = 'income' AND date LIKE ?", lastMonth+"%").Scan(&lastIncome)
-	db.QueryRow("SELECT COALESCE(SUM(amount), 0) FROM transactions WHERE type = 'expense' AND date LIKE ?", lastMonth+"%").Scan(&lastExpenses)
-
-	c.JSON(200, gin.H{
+	response := gin.H{
+		"currency": currency,
 		"current": gin.H{
 			"income":   currentIncome,
 			"expenses": currentExpenses,
@@ -729,20 +1566,26 @@ func getMonthlyComparison(c *gin.Context) {
 			"expenses": lastExpenses,
 			"balance":  lastIncome - lastExpenses,
 		},
-	})
+	}
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		response["missingRates"] = missing
+	}
+	c.JSON(200, response)
 }
 
-// Get top expenses
+// Get top expenses. Split transactions are counted as their lines.
 func getTopExpenses(c *gin.Context) {
 	limit := c.DefaultQuery("limit", "10")
+	userID := currentUserID(c)
 	rows, err := db.Query(`
-		SELECT t.amount, t.description, t.date, c.name as category_name, c.icon as category_icon
-		FROM transactions t
+		SELECT t.amount, t.description, t.date, c.name as category_name, c.icon as category_icon, p.name as payee_name
+		FROM (`+transactionLinesQuery+`) t
 		LEFT JOIN categories c ON t.category_id = c.id
-		WHERE t.type = 'expense'
+		LEFT JOIN payees p ON t.payee_id = p.id
+		WHERE t.type = 'expense' AND t.deleted_at IS NULL AND t.account_id IN (`+visibleAccountsQuery+`)
 		ORDER BY t.amount DESC
 		LIMIT ?
-	`, limit)
+	`, userID, userID, limit)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -752,14 +1595,15 @@ func getTopExpenses(c *gin.Context) {
 	var expenses []gin.H
 	for rows.Next() {
 		var amount float64
-		var description, date, categoryName, categoryIcon sql.NullString
-		rows.Scan(&amount, &description, &date, &categoryName, &categoryIcon)
+		var description, date, categoryName, categoryIcon, payeeName sql.NullString
+		rows.Scan(&amount, &description, &date, &categoryName, &categoryIcon, &payeeName)
 		expenses = append(expenses, gin.H{
 			"amount":        amount,
 			"description":   description.String,
 			"date":          date.String,
 			"category_name": categoryName.String,
 			"category_icon": categoryIcon.String,
+			"payee_name":    payeeName.String,
 		})
 	}
 
@@ -769,57 +1613,106 @@ func getTopExpenses(c *gin.Context) {
 // Get balance history
 func getBalanceHistory(c *gin.Context) {
 	months := c.DefaultQuery("months", "6")
+	userID := currentUserID(c)
 	rows, err := db.Query(`
 		SELECT 
-			strftime('%Y-%m', date) as month,
+			strftime('%Y-%m', date) as month, currency, date,
 			SUM(CASE WHEN type = 'income' THEN amount ELSE 0 END) as income,
 			SUM(CASE WHEN type = 'expense' THEN amount ELSE 0 END) as expenses
 		FROM transactions
-		WHERE date >= date('now', '-' || ? || ' months')
-		GROUP BY month
+		WHERE deleted_at IS NULL AND account_id IN (`+visibleAccountsQuery+`) AND date >= date('now', '-' || ? || ' months')
+		GROUP BY month, currency, date
 		ORDER BY month
-	`, months)
+	`, userID, userID, months)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	var history []gin.H
+	// Convert each day's totals into the base currency at that day's rate
+	type monthTotals struct {
+		month            string
+		income, expenses float64
+	}
+	var totals []*monthTotals
+	byMonth := make(map[string]*monthTotals)
+	type dayTotals struct {
+		month, currency, date string
+		income, expenses      float64
+	}
+	var days []dayTotals
 	for rows.Next() {
-		var month string
-		var income, expenses float64
-		rows.Scan(&month, &income, &expenses)
+		var d dayTotals
+		rows.Scan(&d.month, &d.currency, &d.date, &d.income, &d.expenses)
+		days = append(days, d)
+	}
+	rows.Close()
+
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+	for _, d := range days {
+		m, ok := byMonth[d.month]
+		if !ok {
+			m = &monthTotals{month: d.month}
+			byMonth[d.month] = m
+			totals = append(totals, m)
+		}
+		m.income += fx.convert(d.income, d.currency, currency, d.date)
+		m.expenses += fx.convert(d.expenses, d.currency, currency, d.date)
+	}
+
+	var history []gin.H
+	for _, m := range totals {
 		history = append(history, gin.H{
-			"month":    month,
-			"income":   income,
-			"expenses": expenses,
-			"balance":  income - expenses,
+			"month":    m.month,
+			"income":   m.income,
+			"expenses": m.expenses,
+			"balance":  m.income - m.expenses,
+			"currency": currency,
 		})
 	}
 
+	// The history is a list, so the currencies without a rate go in a header
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		c.Header("X-Missing-Rates", strings.Join(missing, ","))
+	}
 	c.JSON(200, history)
 }
 
-// Get expenses by category
+// Get expenses by category. The lines of split transactions count towards
+// their own categories. Subcategories are rolled up into their parent, which
+// lists them with their own totals; what is in the parent itself is listed
+// under it too when it also has subcategories.
 func getExpensesByCategory(c *gin.Context) {
 	startDate := c.DefaultQuery("start", "")
 	endDate := c.DefaultQuery("end", "")
 
 	query := `
-		SELECT c.name, c.icon, c.color, SUM(t.amount) as total
-		FROM transactions t
+		SELECT COALESCE(p.id, c.id), COALESCE(p.name, c.name), COALESCE(p.icon, c.icon), COALESCE(p.color, c.color),
+			c.id, c.name, c.icon, c.color, SUM(t.amount) as total
+		FROM (` + transactionLinesQuery + `) t
 		JOIN categories c ON t.category_id = c.id
-		WHERE t.type = 'expense'
+		LEFT JOIN categories p ON p.id = c.parent_id
+		WHERE t.type = 'expense' AND t.deleted_at IS NULL AND t.account_id IN (` + visibleAccountsQuery + `)
 	`
-	args := []interface{}{}
+	userID := currentUserID(c)
+	args := []interface{}{userID, userID}
 
 	if startDate != "" && endDate != "" {
 		query += " AND t.date >= ? AND t.date <= ?"
 		args = append(args, startDate, endDate)
 	}
+	tagCondition, tagArgs, err := tagFilter(c, "t.id")
+	if err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if tagCondition != "" {
+		query += " AND " + tagCondition
+		args = append(args, tagArgs...)
+	}
 
-	query += " GROUP BY c.id, c.name, c.icon, c.color ORDER BY total DESC"
+	query += " GROUP BY c.id ORDER BY total DESC"
 
 	rows, err := db.Query(query, args...)
 	if err != nil {
@@ -828,72 +1721,133 @@ func getExpensesByCategory(c *gin.Context) {
 	}
 	defer rows.Close()
 
-	var categories []gin.H
+	categories := []gin.H{}
+	byID := make(map[int]gin.H)
 	for rows.Next() {
-		var name, icon, color string
+		var id, subID int
+		var name, icon, color, subName, subIcon, subColor string
 		var total float64
-		rows.Scan(&name, &icon, &color, &total)
-		categories = append(categories, gin.H{
-			"name":  name,
-			"icon":  icon,
-			"color": color,
+		rows.Scan(&id, &name, &icon, &color, &subID, &subName, &subIcon, &subColor, &total)
+
+		category, ok := byID[id]
+		if !ok {
+			category = gin.H{
+				"id":            id,
+				"name":          name,
+				"icon":          icon,
+				"color":         color,
+				"total":         0.0,
+				"subcategories": []gin.H{},
+			}
+			byID[id] = category
+			categories = append(categories, category)
+		}
+		category["total"] = category["total"].(float64) + total
+		category["subcategories"] = append(category["subcategories"].([]gin.H), gin.H{
+			"id":    subID,
+			"name":  subName,
+			"icon":  subIcon,
+			"color": subColor,
 			"total": total,
 		})
 	}
 
+	// A category without subcategories only lists itself
+	for _, category := range categories {
+		if subs := category["subcategories"].([]gin.H); len(subs) == 1 && subs[0]["id"] == category["id"] {
+			category["subcategories"] = []gin.H{}
+		}
+	}
+	sort.SliceStable(categories, func(i, j int) bool {
+		return categories[i]["total"].(float64) > categories[j]["total"].(float64)
+	})
+
 	c.JSON(200, categories)
 }
 
-// Clear all transactions
-func clearAllTransactions(c *gin.Context) {
-	// Get all transactions to reverse balances
-	rows, err := db.Query("SELECT account_id, type, amount FROM transactions")
+// Clear all transactions, moving them to the trash where they can be
+// restored one by one
+func clearAllTransactions(c *gin.Context) {
+	userID := currentUserID(c)
+	condition := "deleted_at IS NULL AND account_id IN (SELECT id FROM accounts WHERE user_id = ? AND deleted_at IS NULL)"
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	// Net effect of the transactions on each of the user's own accounts, to
+	// reverse it. Accounts shared with the user are left alone.
+	rows, err := tx.Query(`
+		SELECT account_id, SUM(CASE WHEN type = 'income' THEN amount ELSE -amount END)
+		FROM transactions WHERE `+condition+`
+		GROUP BY account_id
+	`, userID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
-	// Reverse all balance changes
+	changes := make(map[int]float64)
 	for rows.Next() {
 		var accountID int
-		var transactionType string
-		var amount float64
-		rows.Scan(&accountID, &transactionType, &amount)
+		var net float64
+		if err := rows.Scan(&accountID, &net); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		changes[accountID] = -net
+	}
+	if err := rows.Err(); err != nil {
+		rows.Close()
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	rows.Close()
 
-		var balanceChange float64
-		if transactionType == "income" {
-			balanceChange = -amount
-		} else {
-			balanceChange = amount
+	// Reverse all balance changes
+	for accountID, balanceChange := range changes {
+		if _, err := tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", balanceChange, accountID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
 		}
+	}
 
-		db.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", balanceChange, accountID)
+	// Move all transactions to the trash
+	trashed := auditRows(tx, "transactions", condition, userID)
+	if _, err := tx.Exec("UPDATE transactions SET deleted_at = ? WHERE "+condition, deletedAtNow(), userID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, t := range trashed {
+		recordAudit(c, tx, "transaction", t["id"], t, auditRow(tx, "transactions", t["id"]))
 	}
 
-	// Delete all transactions
-	_, err = db.Exec("DELETE FROM transactions")
-	if err != nil {
+	if err := tx.Commit(); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	resetCategoryModels()
 
-	c.JSON(200, gin.H{"message": "All transactions deleted successfully"})
+	c.JSON(200, gin.H{"message": "All transactions moved to trash successfully"})
 }
 
 // Salary configuration
 type SalaryConfig struct {
-	ID           int     `json:"id"`
-	Amount       float64 `json:"amount"`
-	AccountID    int     `json:"account_id"`
-	CategoryID   *int    `json:"category_id"`
-	LastPaidMonth string `json:"last_paid_month"`
+	ID            int     `json:"id"`
+	Amount        float64 `json:"amount"`
+	AccountID     int     `json:"account_id"`
+	CategoryID    *int    `json:"category_id"`
+	LastPaidMonth string  `json:"last_paid_month"`
 }
 
 // Get first business day of month
 func getFirstBusinessDay(year int, month time.Month) time.Time {
 	firstDay := time.Date(year, month, 1, 0, 0, 0, 0, time.Local)
-	
+
 	// If Saturday (6), move to Monday
 	if firstDay.Weekday() == time.Saturday {
 		firstDay = firstDay.AddDate(0, 0, 2)
@@ -902,7 +1856,7 @@ func getFirstBusinessDay(year int, month time.Month) time.Time {
 	if firstDay.Weekday() == time.Sunday {
 		firstDay = firstDay.AddDate(0, 0, 1)
 	}
-	
+
 	return firstDay
 }
 
@@ -913,55 +1867,68 @@ func checkAndProcessSalary() {
 			log.Printf("Panic in checkAndProcessSalary: %v", r)
 		}
 	}()
-	
-	ticker := time.NewTicker(1 * time.Hour)
+
+	ticker := time.NewTicker(time.Duration(config.Updaters.SalaryCheckInterval))
 	defer ticker.Stop()
-	
+
 	for range ticker.C {
 		func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 			defer cancel()
-			
+
 			now := time.Now()
 			currentMonth := now.Format("2006-01")
-			
-			var config SalaryConfig
-			var lastPaidMonth sql.NullString
-			err := db.QueryRowContext(ctx, "SELECT id, amount, account_id, category_id, last_paid_month FROM salary_config LIMIT 1").Scan(
-				&config.ID, &config.Amount, &config.AccountID, &config.CategoryID, &lastPaidMonth,
-			)
-			
-			if err == sql.ErrNoRows {
+
+			firstBusinessDay := getFirstBusinessDay(now.Year(), now.Month())
+			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+			if today.Before(firstBusinessDay) {
 				return
 			}
-			
+
+			// Each user has their own salary config
+			rows, err := db.QueryContext(ctx,
+				"SELECT id, user_id, amount, account_id, category_id FROM salary_config WHERE last_paid_month IS NULL OR last_paid_month <> ?",
+				currentMonth,
+			)
 			if err != nil {
 				log.Printf("Error checking salary config: %v", err)
 				return
 			}
-			
-			config.LastPaidMonth = lastPaidMonth.String
-			
-			if config.LastPaidMonth == currentMonth {
-				return
+
+			type pendingSalary struct {
+				config SalaryConfig
+				userID sql.NullInt64
 			}
-			
-			firstBusinessDay := getFirstBusinessDay(now.Year(), now.Month())
-			today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
-			
-			if today.Equal(firstBusinessDay) || today.After(firstBusinessDay) {
-				todayStr := now.Format("2006-01-02")
-				
-				_, err = db.ExecContext(ctx,
-					"INSERT INTO transactions (account_id, category_id, type, amount, description, date) VALUES (?, ?, ?, ?, ?, ?)",
-					config.AccountID, config.CategoryID, "income", config.Amount, "Salário mensal", todayStr,
+			var pending []pendingSalary
+			for rows.Next() {
+				var p pendingSalary
+				if err := rows.Scan(&p.config.ID, &p.userID, &p.config.Amount, &p.config.AccountID, &p.config.CategoryID); err != nil {
+					log.Printf("Error reading salary config: %v", err)
+					continue
+				}
+				pending = append(pending, p)
+			}
+			rows.Close()
+
+			todayStr := now.Format("2006-01-02")
+			for _, p := range pending {
+				config := p.config
+				result, err := db.ExecContext(ctx,
+					"INSERT INTO transactions (user_id, account_id, category_id, type, amount, currency, description, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+					p.userID, config.AccountID, config.CategoryID, "income", config.Amount, accountCurrency(db, config.AccountID), "Salário mensal", todayStr,
 				)
-				
+
 				if err != nil {
 					log.Printf("Error creating salary transaction: %v", err)
 				} else {
+					id, _ := result.LastInsertId()
+					userID := int(p.userID.Int64)
+					recordSystemAudit(db, userID, "salary", "transaction", id, nil, auditRow(db, "transactions", id))
+
 					db.ExecContext(ctx, "UPDATE accounts SET balance = balance + ? WHERE id = ?", config.Amount, config.AccountID)
+					before := auditRow(db, "salary_config", config.ID)
 					db.ExecContext(ctx, "UPDATE salary_config SET last_paid_month = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", currentMonth, config.ID)
+					recordSystemAudit(db, userID, "salary", "salary_config", config.ID, before, auditRow(db, "salary_config", config.ID))
 					log.Printf("Salary of %.2f processed for account %d", config.Amount, config.AccountID)
 				}
 			}
@@ -973,20 +1940,20 @@ func checkAndProcessSalary() {
 func getSalaryConfig(c *gin.Context) {
 	var config SalaryConfig
 	var lastPaidMonth sql.NullString
-	err := db.QueryRow("SELECT id, amount, account_id, category_id, last_paid_month FROM salary_config LIMIT 1").Scan(
+	err := db.QueryRow("SELECT id, amount, account_id, category_id, last_paid_month FROM salary_config WHERE user_id = ? LIMIT 1", currentUserID(c)).Scan(
 		&config.ID, &config.Amount, &config.AccountID, &config.CategoryID, &lastPaidMonth,
 	)
-	
+
 	if err == sql.ErrNoRows {
 		c.JSON(200, gin.H{"exists": false})
 		return
 	}
-	
+
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	config.LastPaidMonth = lastPaidMonth.String
 	c.JSON(200, gin.H{"exists": true, "config": config})
 }
@@ -998,21 +1965,31 @@ func saveSalaryConfig(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	
+
+	userID := currentUserID(c)
+	if !authorizeAccount(c, config.AccountID, roleEditor, 400) {
+		return
+	}
+	if !categoryVisible(config.CategoryID, userID) {
+		c.JSON(400, gin.H{"error": "Category not found"})
+		return
+	}
+
 	// Check if config exists
 	var exists bool
-	db.QueryRow("SELECT EXISTS(SELECT 1 FROM salary_config)").Scan(&exists)
-	
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM salary_config WHERE user_id = ?)", userID).Scan(&exists)
+
 	if exists {
 		// Get current ID first
 		var currentID int
-		err := db.QueryRow("SELECT id FROM salary_config LIMIT 1").Scan(&currentID)
+		err := db.QueryRow("SELECT id FROM salary_config WHERE user_id = ? LIMIT 1", userID).Scan(&currentID)
 		if err != nil {
 			c.JSON(500, gin.H{"error": "Error finding salary config: " + err.Error()})
 			return
 		}
-		
+
 		// Update
+		before := auditRow(db, "salary_config", currentID)
 		_, err = db.Exec(
 			"UPDATE salary_config SET amount = ?, account_id = ?, category_id = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
 			config.Amount, config.AccountID, config.CategoryID, currentID,
@@ -1021,15 +1998,16 @@ func saveSalaryConfig(c *gin.Context) {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		
+		recordAudit(c, db, "salary_config", currentID, before, auditRow(db, "salary_config", currentID))
+
 		// Return updated config
 		config.ID = currentID
 		c.JSON(200, gin.H{"message": "Salary config updated successfully", "config": config})
 	} else {
 		// Create
 		result, err := db.Exec(
-			"INSERT INTO salary_config (amount, account_id, category_id) VALUES (?, ?, ?)",
-			config.Amount, config.AccountID, config.CategoryID,
+			"INSERT INTO salary_config (user_id, amount, account_id, category_id) VALUES (?, ?, ?, ?)",
+			userID, config.Amount, config.AccountID, config.CategoryID,
 		)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
@@ -1037,55 +2015,67 @@ func saveSalaryConfig(c *gin.Context) {
 		}
 		id, _ := result.LastInsertId()
 		config.ID = int(id)
+		recordAudit(c, db, "salary_config", id, nil, auditRow(db, "salary_config", id))
 		c.JSON(200, gin.H{"message": "Salary config created successfully", "id": config.ID})
 	}
 }
 
 // Process salary manually
 func processSalaryManually(c *gin.Context) {
+	userID := currentUserID(c)
 	var config SalaryConfig
-	err := db.QueryRow("SELECT id, amount, account_id, category_id FROM salary_config LIMIT 1").Scan(
+	err := db.QueryRow("SELECT id, amount, account_id, category_id FROM salary_config WHERE user_id = ? LIMIT 1", userID).Scan(
 		&config.ID, &config.Amount, &config.AccountID, &config.CategoryID,
 	)
-	
+
 	if err == sql.ErrNoRows {
 		c.JSON(404, gin.H{"error": "Salary config not found"})
 		return
 	}
-	
+
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+	if !authorizeAccount(c, config.AccountID, roleEditor, 400) {
+		return
+	}
+
 	now := time.Now()
 	todayStr := now.Format("2006-01-02")
 	currentMonth := now.Format("2006-01")
-	
+
 	// Create transaction
 	result, err := db.Exec(
-		"INSERT INTO transactions (account_id, category_id, type, amount, description, date) VALUES (?, ?, ?, ?, ?, ?)",
-		config.AccountID, config.CategoryID, "income", config.Amount, "Salário mensal", todayStr,
+		"INSERT INTO transactions (user_id, account_id, category_id, type, amount, currency, description, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, config.AccountID, config.CategoryID, "income", config.Amount, accountCurrency(db, config.AccountID), "Salário mensal", todayStr,
 	)
-	
+
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+	id, _ := result.LastInsertId()
+	recordAudit(c, db, "transaction", id, nil, auditRow(db, "transactions", id))
+
 	// Update account balance
 	db.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", config.Amount, config.AccountID)
-	
+
 	// Update last paid month
+	before := auditRow(db, "salary_config", config.ID)
 	db.Exec("UPDATE salary_config SET last_paid_month = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?", currentMonth, config.ID)
-	
-	id, _ := result.LastInsertId()
+	recordAudit(c, db, "salary_config", config.ID, before, auditRow(db, "salary_config", config.ID))
+
 	c.JSON(200, gin.H{"id": id, "message": "Salary processed successfully"})
 }
 
 // Investments handlers
 func getInvestments(c *gin.Context) {
-	rows, err := db.Query("SELECT * FROM investments ORDER BY created_at DESC")
+	rows, err := db.Query(`
+		SELECT id, ticker, name, type, market, quantity, average_price, total_invested, currency,
+		       current_price, current_value, profit_loss, profit_loss_percent, notes, created_at, updated_at
+		FROM investments WHERE user_id = ? ORDER BY created_at DESC
+	`, currentUserID(c))
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
@@ -1097,17 +2087,17 @@ func getInvestments(c *gin.Context) {
 		var inv Investment
 		var currentPrice, currentValue, profitLoss, profitLossPercent sql.NullFloat64
 		var notes sql.NullString
-		
+
 		err := rows.Scan(
-			&inv.ID, &inv.Ticker, &inv.Name, &inv.Type, &inv.Quantity,
-			&inv.AveragePrice, &inv.TotalInvested, &currentPrice, &currentValue,
+			&inv.ID, &inv.Ticker, &inv.Name, &inv.Type, &inv.Market, &inv.Quantity,
+			&inv.AveragePrice, &inv.TotalInvested, &inv.Currency, &currentPrice, &currentValue,
 			&profitLoss, &profitLossPercent, &notes, &inv.CreatedAt, &inv.UpdatedAt,
 		)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
-		
+
 		if currentPrice.Valid {
 			inv.CurrentPrice = &currentPrice.Float64
 		}
@@ -1123,7 +2113,7 @@ func getInvestments(c *gin.Context) {
 		if notes.Valid {
 			inv.Notes = &notes.String
 		}
-		
+
 		investments = append(investments, inv)
 	}
 
@@ -1137,27 +2127,72 @@ func createInvestment(c *gin.Context) {
 		return
 	}
 
+	userID := currentUserID(c)
+	inv.Market = normalizeMarket(inv.Market, inv.Ticker)
+	if !isValidMarket(inv.Market) {
+		c.JSON(400, gin.H{"error": "Invalid market"})
+		return
+	}
+	if inv.Currency == "" {
+		inv.Currency = marketCurrency(inv.Market)
+	}
+	inv.Currency = normalizeCurrency(inv.Currency)
+	if !isValidCurrency(inv.Currency) {
+		c.JSON(400, gin.H{"error": "Invalid currency"})
+		return
+	}
+	inv.Quantity = roundQuantity(inv.Quantity, inv.Market)
+
 	// Calculate total invested
 	inv.TotalInvested = inv.Quantity * inv.AveragePrice
-	
+
 	// Calculate current value and profit/loss if current price is provided
 	var currentValue, profitLoss, profitLossPercent *float64
 	if inv.CurrentPrice != nil {
 		val := inv.Quantity * *inv.CurrentPrice
 		currentValue = &val
-		
+
 		pl := val - inv.TotalInvested
 		profitLoss = &pl
-		
+
 		percent := (pl / inv.TotalInvested) * 100
 		profitLossPercent = &percent
 	}
 
-	result, err := db.Exec(
-		`INSERT INTO investments (ticker, name, type, quantity, average_price, total_invested, 
+	// The purchase is paid from the user's first account, in its currency.
+	// Converted before the transaction starts since the converter reads
+	// outside of it.
+	todayStr := time.Now().Format("2006-01-02")
+	var accountID int
+	var amount float64
+	var accountCurrencyCode string
+	err := db.QueryRow("SELECT id FROM accounts WHERE user_id = ? AND deleted_at IS NULL ORDER BY id LIMIT 1", userID).Scan(&accountID)
+	hasAccount := err == nil
+	if err != nil && err != sql.ErrNoRows {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if hasAccount {
+		accountCurrencyCode = accountCurrency(db, accountID)
+		amount, err = newFXConverter().convertExact(inv.TotalInvested, inv.Currency, accountCurrencyCode, todayStr)
+		if err != nil {
+			c.JSON(fxErrorStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(
+		`INSERT INTO investments (user_id, ticker, name, type, market, quantity, average_price, total_invested, currency,
 		 current_price, current_value, profit_loss, profit_loss_percent, notes) 
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-		inv.Ticker, inv.Name, inv.Type, inv.Quantity, inv.AveragePrice, inv.TotalInvested,
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, inv.Ticker, inv.Name, inv.Type, inv.Market, inv.Quantity, inv.AveragePrice, inv.TotalInvested, inv.Currency,
 		inv.CurrentPrice, currentValue, profitLoss, profitLossPercent, inv.Notes,
 	)
 	if err != nil {
@@ -1172,55 +2207,66 @@ func createInvestment(c *gin.Context) {
 		inv.ProfitLoss = profitLoss
 		inv.ProfitLossPercent = profitLossPercent
 	}
-	
+
+	if err := recordInvestmentMovement(tx, userID, inv.ID, inv.Ticker, inv.Type, inv.Currency, "buy", todayStr, inv.Quantity, inv.AveragePrice, inv.TotalInvested, nil); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := snapshotInvestment(tx, inv.ID, todayStr); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
 	// Create transaction to deduct from account balance
-	// Get or create "Investimento" category for expenses
-	var categoryID int
-	err = db.QueryRow("SELECT id FROM categories WHERE name = 'Investimento' AND type = 'expense'").Scan(&categoryID)
-	if err == sql.ErrNoRows {
-		// Create category if it doesn't exist
-		catResult, err := db.Exec(
-			"INSERT INTO categories (name, type, color, icon) VALUES (?, ?, ?, ?)",
-			"Investimento", "expense", "#3B82F6", "📊",
-		)
-		if err != nil {
-			log.Printf("Error creating Investimento category: %v", err)
-		} else {
-			catID, _ := catResult.LastInsertId()
-			categoryID = int(catID)
+	if hasAccount {
+		// Get or create "Investimento" category for expenses
+		var categoryID int64
+		err = tx.QueryRow(
+			"SELECT id FROM categories WHERE name = 'Investimento' AND type = 'expense' AND (user_id IS NULL OR user_id = ?)",
+			userID,
+		).Scan(&categoryID)
+		if err == sql.ErrNoRows {
+			catResult, err := tx.Exec(
+				"INSERT INTO categories (user_id, name, type, color, icon) VALUES (?, ?, ?, ?, ?)",
+				userID, "Investimento", "expense", "#3B82F6", "📊",
+			)
+			if err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			categoryID, _ = catResult.LastInsertId()
+		} else if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
 		}
-	} else if err != nil {
-		log.Printf("Error finding Investimento category: %v", err)
-	}
-	
-	// Get first account (Conta Principal)
-	var accountID int
-	err = db.QueryRow("SELECT id FROM accounts ORDER BY id LIMIT 1").Scan(&accountID)
-	if err != nil {
-		log.Printf("Error finding account: %v", err)
-	} else {
-		// Create transaction
+
 		description := fmt.Sprintf("Investimento: %s (%s)", inv.Ticker, inv.Name)
-		todayStr := time.Now().Format("2006-01-02")
-		
-		_, err = db.Exec(
-			"INSERT INTO transactions (account_id, category_id, type, amount, description, date) VALUES (?, ?, ?, ?, ?, ?)",
-			accountID, categoryID, "expense", inv.TotalInvested, description, todayStr,
+		txResult, err := tx.Exec(
+			"INSERT INTO transactions (user_id, account_id, category_id, type, amount, currency, description, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+			userID, accountID, categoryID, "expense", amount, accountCurrencyCode, description, todayStr,
 		)
 		if err != nil {
-			log.Printf("Error creating investment transaction: %v", err)
-		} else {
-			// Update account balance (deduct expense)
-			_, err = db.Exec(
-				"UPDATE accounts SET balance = balance - ? WHERE id = ?",
-				inv.TotalInvested, accountID,
-			)
-			if err != nil {
-				log.Printf("Error updating account balance: %v", err)
-			}
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		transactionID, _ := txResult.LastInsertId()
+		recordAudit(c, tx, "transaction", transactionID, nil, auditRow(tx, "transactions", transactionID))
+
+		if _, err := tx.Exec("UPDATE investments SET transaction_id = ? WHERE id = ?", transactionID, inv.ID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := tx.Exec("UPDATE accounts SET balance = balance - ? WHERE id = ?", amount, accountID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
 		}
 	}
-	
+	recordAudit(c, tx, "investment", id, nil, auditRow(tx, "investments", id))
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
 	c.JSON(200, inv)
 }
 
@@ -1233,136 +2279,208 @@ func updateInvestment(c *gin.Context) {
 	}
 
 	// Recalculate if quantity or average price changed
+	userID := currentUserID(c)
 	var existingInv Investment
-	err := db.QueryRow("SELECT quantity, average_price, total_invested FROM investments WHERE id = ?", id).Scan(
-		&existingInv.Quantity, &existingInv.AveragePrice, &existingInv.TotalInvested,
+	err := db.QueryRow("SELECT id, quantity, average_price, total_invested, currency, market FROM investments WHERE id = ? AND user_id = ?", id, userID).Scan(
+		&existingInv.ID, &existingInv.Quantity, &existingInv.AveragePrice, &existingInv.TotalInvested, &existingInv.Currency, &existingInv.Market,
 	)
-	
+
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Investment not found"})
 		return
 	}
 
+	if inv.Currency == "" {
+		inv.Currency = existingInv.Currency
+	}
+	inv.Currency = normalizeCurrency(inv.Currency)
+	if !isValidCurrency(inv.Currency) {
+		c.JSON(400, gin.H{"error": "Invalid currency"})
+		return
+	}
+	if inv.Market == "" {
+		inv.Market = existingInv.Market
+	}
+	inv.Market = normalizeMarket(inv.Market, inv.Ticker)
+	if !isValidMarket(inv.Market) {
+		c.JSON(400, gin.H{"error": "Invalid market"})
+		return
+	}
+	inv.Quantity = roundQuantity(inv.Quantity, inv.Market)
+
 	// Update total invested if quantity or average price changed
 	if inv.Quantity != existingInv.Quantity || inv.AveragePrice != existingInv.AveragePrice {
 		inv.TotalInvested = inv.Quantity * inv.AveragePrice
 	} else {
 		inv.TotalInvested = existingInv.TotalInvested
 	}
-	
+
 	// Calculate current value and profit/loss if current price is provided
 	var currentValue, profitLoss, profitLossPercent *float64
 	if inv.CurrentPrice != nil {
 		val := inv.Quantity * *inv.CurrentPrice
 		currentValue = &val
-		
+
 		pl := val - inv.TotalInvested
 		profitLoss = &pl
-		
+
 		percent := (pl / inv.TotalInvested) * 100
 		profitLossPercent = &percent
 	}
 
+	before := auditRow(db, "investments", id)
 	_, err = db.Exec(
 		`UPDATE investments SET 
-		 ticker = ?, name = ?, type = ?, quantity = ?, average_price = ?, total_invested = ?,
+		 ticker = ?, name = ?, type = ?, market = ?, quantity = ?, average_price = ?, total_invested = ?, currency = ?,
 		 current_price = ?, current_value = ?, profit_loss = ?, profit_loss_percent = ?, notes = ?,
 		 updated_at = CURRENT_TIMESTAMP
 		 WHERE id = ?`,
-		inv.Ticker, inv.Name, inv.Type, inv.Quantity, inv.AveragePrice, inv.TotalInvested,
+		inv.Ticker, inv.Name, inv.Type, inv.Market, inv.Quantity, inv.AveragePrice, inv.TotalInvested, inv.Currency,
 		inv.CurrentPrice, currentValue, profitLoss, profitLossPercent, inv.Notes, id,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
+	recordAudit(c, db, "investment", id, before, auditRow(db, "investments", id))
+
+	// Corrections to quantity or cost are tracked as contributions (or
+	// withdrawals) so performance metrics stay consistent
+	todayStr := time.Now().Format("2006-01-02")
+	if delta := inv.TotalInvested - existingInv.TotalInvested; delta != 0 {
+		err = recordInvestmentMovement(db, userID, existingInv.ID, inv.Ticker, inv.Type, inv.Currency, "adjust", todayStr,
+			inv.Quantity-existingInv.Quantity, inv.AveragePrice, delta, nil)
+		if err != nil {
+			log.Printf("Error recording investment movement: %v", err)
+		}
+	}
+	if err := snapshotInvestment(db, id, todayStr); err != nil {
+		log.Printf("Error recording investment snapshot: %v", err)
+	}
 
 	c.JSON(200, gin.H{"message": "Investment updated successfully"})
 }
 
 func deleteInvestment(c *gin.Context) {
 	id := c.Param("id")
-	
+
 	// Get investment to find related transaction
-	var totalInvested float64
-	err := db.QueryRow("SELECT total_invested FROM investments WHERE id = ?", id).Scan(&totalInvested)
+	userID := currentUserID(c)
+	var transactionID sql.NullInt64
+	err := db.QueryRow("SELECT transaction_id FROM investments WHERE id = ? AND user_id = ?", id, userID).Scan(&transactionID)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Investment not found"})
 		return
 	}
-	
-	// Delete investment
-	_, err = db.Exec("DELETE FROM investments WHERE id = ?", id)
+
+	// Move the expense that paid for it to the trash, giving its amount (in
+	// the account's currency) back to the account. Investments created before
+	// they were linked to their transaction leave it for the user to delete.
+	var accountID int
+	var amount float64
+	trashTransaction := false
+	if transactionID.Valid {
+		err = db.QueryRow(
+			"SELECT account_id, amount FROM transactions WHERE id = ? AND deleted_at IS NULL",
+			transactionID.Int64,
+		).Scan(&accountID, &amount)
+		if err != nil && err != sql.ErrNoRows {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		trashTransaction = err == nil
+	}
+	if trashTransaction {
+		learnTransaction(transactionID.Int64, -1)
+	}
+
+	tx, err := db.Begin()
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
-	// Find and delete related transaction (if exists)
-	// Get first account
-	var accountID int
-	err = db.QueryRow("SELECT id FROM accounts ORDER BY id LIMIT 1").Scan(&accountID)
-	if err == nil {
-		// Find transaction with matching description and amount
-		var transactionID int
-		err = db.QueryRow(
-			"SELECT id FROM transactions WHERE account_id = ? AND type = 'expense' AND amount = ? AND description LIKE 'Investimento:%' ORDER BY id DESC LIMIT 1",
-			accountID, totalInvested,
-		).Scan(&transactionID)
-		
-		if err == nil {
-			// Reverse balance change
-			_, err = db.Exec(
-				"UPDATE accounts SET balance = balance + ? WHERE id = ?",
-				totalInvested, accountID,
-			)
-			if err != nil {
-				log.Printf("Error reversing account balance: %v", err)
-			}
-			
-			// Delete transaction
-			_, err = db.Exec("DELETE FROM transactions WHERE id = ?", transactionID)
-			if err != nil {
-				log.Printf("Error deleting investment transaction: %v", err)
-			}
+	defer tx.Rollback()
+
+	// Delete investment
+	before := auditRow(tx, "investments", id)
+	if _, err := tx.Exec("DELETE FROM investments WHERE id = ?", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, tx, "investment", id, before, nil)
+
+	// Deleting undoes the investment, so its history goes with it
+	if _, err := tx.Exec("DELETE FROM investment_movements WHERE investment_id = ?", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM investment_snapshots WHERE investment_id = ?", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if trashTransaction {
+		if _, err := tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", amount, accountID); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
 		}
+		transaction := auditRow(tx, "transactions", transactionID.Int64)
+		if _, err := tx.Exec("UPDATE transactions SET deleted_at = ? WHERE id = ?", deletedAtNow(), transactionID.Int64); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		recordAudit(c, tx, "transaction", transactionID.Int64, transaction, auditRow(tx, "transactions", transactionID.Int64))
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
 	}
-	
 	c.JSON(200, gin.H{"message": "Investment deleted successfully"})
 }
 
 func sellInvestment(c *gin.Context) {
 	id := c.Param("id")
-	
+
 	var sellData struct {
-		Quantity   float64 `json:"quantity"`
-		SellPrice  float64 `json:"sell_price"`
-		AccountID  int     `json:"account_id"`
+		Quantity  float64 `json:"quantity"`
+		SellPrice float64 `json:"sell_price"`
+		AccountID int     `json:"account_id"`
 	}
-	
+
 	if err := c.ShouldBindJSON(&sellData); err != nil {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	if sellData.Quantity <= 0 {
 		c.JSON(400, gin.H{"error": "Quantidade deve ser maior que zero"})
 		return
 	}
-	
+
 	if sellData.SellPrice <= 0 {
 		c.JSON(400, gin.H{"error": "Preço de venda deve ser maior que zero"})
 		return
 	}
-	
+
+	userID := currentUserID(c)
+	switch role := accountRole(sellData.AccountID, userID); {
+	case role == "":
+		c.JSON(400, gin.H{"error": "Conta não encontrada"})
+		return
+	case !roleAllows(role, roleEditor):
+		c.JSON(403, gin.H{"error": "Sem permissão para movimentar esta conta"})
+		return
+	}
+
 	var inv Investment
 	err := db.QueryRow(`
-		SELECT id, ticker, name, type, quantity, average_price, total_invested, current_price
-		FROM investments WHERE id = ?
-	`, id).Scan(
-		&inv.ID, &inv.Ticker, &inv.Name, &inv.Type, &inv.Quantity, &inv.AveragePrice, &inv.TotalInvested, &inv.CurrentPrice,
+		SELECT id, ticker, name, type, market, quantity, average_price, total_invested, currency, current_price
+		FROM investments WHERE id = ? AND user_id = ?
+	`, id, userID).Scan(
+		&inv.ID, &inv.Ticker, &inv.Name, &inv.Type, &inv.Market, &inv.Quantity, &inv.AveragePrice, &inv.TotalInvested, &inv.Currency, &inv.CurrentPrice,
 	)
-	
+
 	if err == sql.ErrNoRows {
 		c.JSON(404, gin.H{"error": "Investimento não encontrado"})
 		return
@@ -1371,39 +2489,68 @@ func sellInvestment(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
-	if sellData.Quantity > inv.Quantity {
-		c.JSON(400, gin.H{"error": fmt.Sprintf("Quantidade a vender (%.2f) é maior que a quantidade disponível (%.2f)", sellData.Quantity, inv.Quantity)})
+
+	sellData.Quantity = roundQuantity(sellData.Quantity, inv.Market)
+	if sellData.Quantity > inv.Quantity && !isDustQuantity(sellData.Quantity-inv.Quantity) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Quantidade a vender (%s) é maior que a quantidade disponível (%s)", formatQuantity(sellData.Quantity), formatQuantity(inv.Quantity))})
 		return
 	}
-	
+	// Selling everything but floating point dust closes the position
+	if isDustQuantity(inv.Quantity - sellData.Quantity) {
+		sellData.Quantity = inv.Quantity
+	}
+
 	sellValue := sellData.Quantity * sellData.SellPrice
 	averageCost := (inv.TotalInvested / inv.Quantity) * sellData.Quantity
 	profitLoss := sellValue - averageCost
 	profitLossPercent := (profitLoss / averageCost) * 100
-	
+
+	todayStr := time.Now().Format("2006-01-02")
+
+	// The proceeds are credited in the account's currency. Converted before
+	// the transaction starts since the converter reads outside of it.
+	accountCurrencyCode := accountCurrency(db, sellData.AccountID)
+	creditValue, err := newFXConverter().convertExact(sellValue, inv.Currency, accountCurrencyCode, todayStr)
+	if err != nil {
+		c.JSON(fxErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
 	tx, err := db.Begin()
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 	defer tx.Rollback()
-	
+
+	before := auditRow(tx, "investments", id)
+	err = recordInvestmentMovement(tx, userID, inv.ID, inv.Ticker, inv.Type, inv.Currency, "sell", todayStr,
+		sellData.Quantity, sellData.SellPrice, sellValue, &profitLoss)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
 	if sellData.Quantity == inv.Quantity {
 		_, err = tx.Exec("DELETE FROM investments WHERE id = ?", id)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
+		err = snapshotClosedInvestment(tx, userID, inv.ID, inv.Ticker, inv.Type, inv.Currency, todayStr)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
 	} else {
-		newQuantity := inv.Quantity - sellData.Quantity
+		newQuantity := roundQuantity(inv.Quantity-sellData.Quantity, inv.Market)
 		newTotalInvested := inv.TotalInvested - averageCost
-		
+
 		if inv.CurrentPrice != nil {
 			newCurrentValue := newQuantity * (*inv.CurrentPrice)
 			newProfitLoss := newCurrentValue - newTotalInvested
 			newProfitLossPercent := (newProfitLoss / newTotalInvested) * 100
-			
+
 			_, err = tx.Exec(`
 				UPDATE investments SET 
 					quantity = ?, total_invested = ?, current_value = ?, 
@@ -1417,118 +2564,166 @@ func sellInvestment(c *gin.Context) {
 				WHERE id = ?
 			`, newQuantity, newTotalInvested, id)
 		}
-		
+
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = snapshotInvestment(tx, id, todayStr)
 		if err != nil {
 			c.JSON(500, gin.H{"error": err.Error()})
 			return
 		}
 	}
-	
+	recordAudit(c, tx, "investment", id, before, auditRow(tx, "investments", id))
+
 	var categoryID *int
-	err = db.QueryRow("SELECT id FROM categories WHERE name = 'Investimentos' AND type = 'income' LIMIT 1").Scan(&categoryID)
+	err = tx.QueryRow(
+		"SELECT id FROM categories WHERE name = 'Investimentos' AND type = 'income' AND (user_id IS NULL OR user_id = ?) LIMIT 1",
+		userID,
+	).Scan(&categoryID)
 	if err != nil {
 		log.Printf("Category 'Investimentos' not found, using NULL")
 	}
-	
-	todayStr := time.Now().Format("2006-01-02")
-	description := fmt.Sprintf("Venda de %s: %.2f x %s @ R$ %.2f", inv.Ticker, sellData.Quantity, inv.Name, sellData.SellPrice)
-	
-	_, err = tx.Exec(
-		"INSERT INTO transactions (account_id, category_id, type, amount, description, date) VALUES (?, ?, ?, ?, ?, ?)",
-		sellData.AccountID, categoryID, "income", sellValue, description, todayStr,
+
+	description := fmt.Sprintf("Venda de %s: %s x %s @ %s %.2f", inv.Ticker, formatQuantity(sellData.Quantity), inv.Name, currencySymbol(inv.Currency), sellData.SellPrice)
+
+	result, err := tx.Exec(
+		"INSERT INTO transactions (user_id, account_id, category_id, type, amount, currency, description, date) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		userID, sellData.AccountID, categoryID, "income", creditValue, accountCurrencyCode, description, todayStr,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+	transactionID, _ := result.LastInsertId()
+	recordAudit(c, tx, "transaction", transactionID, nil, auditRow(tx, "transactions", transactionID))
+
 	_, err = tx.Exec(
 		"UPDATE accounts SET balance = balance + ? WHERE id = ?",
-		sellValue, sellData.AccountID,
+		creditValue, sellData.AccountID,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	if err = tx.Commit(); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	c.JSON(200, gin.H{
-		"message": "Venda realizada com sucesso",
-		"sell_value": sellValue,
-		"profit_loss": profitLoss,
+		"message":             "Venda realizada com sucesso",
+		"sell_value":          sellValue,
+		"profit_loss":         profitLoss,
 		"profit_loss_percent": profitLossPercent,
-		"remaining_quantity": inv.Quantity - sellData.Quantity,
+		"remaining_quantity":  roundQuantity(inv.Quantity-sellData.Quantity, inv.Market),
 	})
 }
 
 func getInvestmentsSummary(c *gin.Context) {
+	userID := currentUserID(c)
 	var totalInvested, totalCurrentValue, totalProfitLoss float64
 	var count int
-	
-	err := db.QueryRow(`
+
+	rows, err := db.Query(`
 		SELECT 
+			currency,
 			COUNT(*) as count,
 			COALESCE(SUM(total_invested), 0) as total_invested,
 			COALESCE(SUM(current_value), 0) as total_current_value,
 			COALESCE(SUM(profit_loss), 0) as total_profit_loss
 		FROM investments
-	`).Scan(&count, &totalInvested, &totalCurrentValue, &totalProfitLoss)
-	
+		WHERE user_id = ?
+		GROUP BY currency
+	`, userID)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
+	type currencyTotals struct {
+		currency                       string
+		count                          int
+		invested, currentValue, profit float64
+	}
+	var totals []currencyTotals
+	for rows.Next() {
+		var t currencyTotals
+		if err := rows.Scan(&t.currency, &t.count, &t.invested, &t.currentValue, &t.profit); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		totals = append(totals, t)
+	}
+	rows.Close()
+
+	// Positions in other currencies are converted at today's rate
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+	today := time.Now().Format("2006-01-02")
+	byCurrency := gin.H{}
+	for _, t := range totals {
+		count += t.count
+		totalInvested += fx.convert(t.invested, t.currency, currency, today)
+		totalCurrentValue += fx.convert(t.currentValue, t.currency, currency, today)
+		totalProfitLoss += fx.convert(t.profit, t.currency, currency, today)
+		byCurrency[t.currency] = gin.H{
+			"count":               t.count,
+			"total_invested":      t.invested,
+			"total_current_value": t.currentValue,
+			"total_profit_loss":   t.profit,
+		}
+	}
+
 	totalProfitLossPercent := 0.0
 	if totalInvested > 0 {
 		totalProfitLossPercent = (totalProfitLoss / totalInvested) * 100
 	}
-	
+
 	c.JSON(200, gin.H{
-		"count": count,
-		"total_invested": totalInvested,
-		"total_current_value": totalCurrentValue,
-		"total_profit_loss": totalProfitLoss,
+		"count":                     count,
+		"total_invested":            totalInvested,
+		"total_current_value":       totalCurrentValue,
+		"total_profit_loss":         totalProfitLoss,
 		"total_profit_loss_percent": totalProfitLossPercent,
+		"currency":                  currency,
+		"by_currency":               byCurrency,
+		"missing_rates":             fx.missingCurrencies(),
 	})
 }
 
 // Get investment analysis and suggestions
 func getInvestmentAnalysis(c *gin.Context) {
 	rows, err := db.Query(`
-		SELECT id, ticker, name, type, quantity, average_price, total_invested, 
+		SELECT id, ticker, name, type, currency, quantity, average_price, total_invested, 
 		       current_price, current_value, profit_loss, profit_loss_percent
 		FROM investments
-		WHERE current_price IS NOT NULL
+		WHERE current_price IS NOT NULL AND user_id = ?
 		ORDER BY total_invested DESC
-	`)
+	`, currentUserID(c))
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
 
 	var investments []Investment
-	var totalInvested, totalCurrentValue float64
-	typeDistribution := make(map[string]float64) // type -> total invested
-	
 	for rows.Next() {
 		var inv Investment
 		var currentPrice, currentValue, profitLoss, profitLossPercent sql.NullFloat64
-		
+
 		err := rows.Scan(
-			&inv.ID, &inv.Ticker, &inv.Name, &inv.Type, &inv.Quantity,
+			&inv.ID, &inv.Ticker, &inv.Name, &inv.Type, &inv.Currency, &inv.Quantity,
 			&inv.AveragePrice, &inv.TotalInvested, &currentPrice, &currentValue,
 			&profitLoss, &profitLossPercent,
 		)
 		if err != nil {
 			continue
 		}
-		
+
 		if currentPrice.Valid {
 			inv.CurrentPrice = &currentPrice.Float64
 		}
@@ -1541,103 +2736,101 @@ func getInvestmentAnalysis(c *gin.Context) {
 		if profitLossPercent.Valid {
 			inv.ProfitLossPercent = &profitLossPercent.Float64
 		}
-		
+
 		investments = append(investments, inv)
-		totalInvested += inv.TotalInvested
+	}
+	rows.Close()
+
+	// Totals and the type distribution are in the base currency
+	currency := baseCurrency(currentUserID(c))
+	fx := newFXConverter()
+	today := time.Now().Format("2006-01-02")
+	var totalInvested, totalCurrentValue float64
+	typeDistribution := make(map[string]float64) // type -> total invested
+	currentValues := make(map[int]float64)       // investment -> current value
+	for _, inv := range investments {
+		invested := fx.convert(inv.TotalInvested, inv.Currency, currency, today)
+		totalInvested += invested
 		if inv.CurrentValue != nil {
-			totalCurrentValue += *inv.CurrentValue
+			currentValues[inv.ID] = fx.convert(*inv.CurrentValue, inv.Currency, currency, today)
+			totalCurrentValue += currentValues[inv.ID]
 		}
-		
+
 		// Track type distribution
-		typeDistribution[inv.Type] += inv.TotalInvested
+		typeDistribution[inv.Type] += invested
 	}
-	
+
 	if len(investments) == 0 {
 		c.JSON(200, gin.H{
-			"suggestions": []gin.H{},
-			"warnings": []gin.H{},
+			"suggestions":     []gin.H{},
+			"warnings":        []gin.H{},
 			"diversification": gin.H{},
 		})
 		return
 	}
-	
-	var suggestions []gin.H
-	var warnings []gin.H
-	
-	// Analyze each investment
-	for _, inv := range investments {
-		if inv.ProfitLossPercent == nil {
-			continue
-		}
-		
-		profitPercent := *inv.ProfitLossPercent
-		
-		// SUGGEST SELL: High loss (>15%) - consider cutting losses
-		if profitPercent < -15 {
-			suggestions = append(suggestions, gin.H{
-				"action": "vender",
-				"ticker": inv.Ticker,
-				"name": inv.Name,
-				"reason": fmt.Sprintf("Perda significativa de %.1f%%. Considere realizar o prejuízo para evitar maiores perdas.", profitPercent),
-				"priority": "alta",
-				"profit_loss_percent": profitPercent,
-				"current_value": inv.CurrentValue,
-			})
-		}
-		
-		// SUGGEST SELL: High profit (>30%) - consider taking profits
-		if profitPercent > 30 {
-			suggestions = append(suggestions, gin.H{
-				"action": "vender_parcial",
-				"ticker": inv.Ticker,
-				"name": inv.Name,
-				"reason": fmt.Sprintf("Ganho significativo de +%.1f%%. Considere realizar parte dos lucros para proteger ganhos.", profitPercent),
-				"priority": "média",
-				"profit_loss_percent": profitPercent,
-				"current_value": inv.CurrentValue,
-			})
-		}
-		
-		// SUGGEST BUY: Good performance (5-20% gain) - consider adding more
-		if profitPercent > 5 && profitPercent < 20 {
-			suggestions = append(suggestions, gin.H{
-				"action": "comprar",
-				"ticker": inv.Ticker,
-				"name": inv.Name,
-				"reason": fmt.Sprintf("Performance positiva de +%.1f%%. Pode ser uma boa oportunidade para aumentar a posição.", profitPercent),
-				"priority": "baixa",
-				"profit_loss_percent": profitPercent,
-				"current_value": inv.CurrentValue,
+
+	// Analyze each investment against the configured rules
+	suggestions, warnings, err := evaluateAnalysisRules(currentUserID(c), investments, currentValues)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Diversification analysis: compare with the user's targets when defined,
+	// otherwise warn about concentration above 50% in one type
+	typeTargets, err := loadTypeTargets(currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	var diversificationWarnings []gin.H
+	if totalInvested > 0 && len(typeTargets) > 0 {
+		for invType, target := range typeTargets {
+			percentage := (typeDistribution[invType] / totalInvested) * 100
+			drift := percentage - target
+			if math.Abs(drift) <= allocationDriftTolerance {
+				continue
+			}
+			message := fmt.Sprintf("%s está %.1f pontos acima da meta (%.1f%% vs %.1f%%). Priorize outros tipos nos próximos aportes.", invType, drift, percentage, target)
+			if drift < 0 {
+				message = fmt.Sprintf("%s está %.1f pontos abaixo da meta (%.1f%% vs %.1f%%). Considere reforçar nos próximos aportes.", invType, -drift, percentage, target)
+			}
+			diversificationWarnings = append(diversificationWarnings, gin.H{
+				"type":       invType,
+				"percentage": percentage,
+				"target":     target,
+				"drift":      drift,
+				"message":    message,
 			})
 		}
-		
-		// WARNING: Moderate loss (5-15%)
-		if profitPercent < -5 && profitPercent >= -15 {
-			warnings = append(warnings, gin.H{
-				"ticker": inv.Ticker,
-				"name": inv.Name,
-				"message": fmt.Sprintf("Atenção: perda de %.1f%%. Monitore de perto.", profitPercent),
-				"profit_loss_percent": profitPercent,
+		for invType, amount := range typeDistribution {
+			if _, ok := typeTargets[invType]; ok {
+				continue
+			}
+			percentage := (amount / totalInvested) * 100
+			diversificationWarnings = append(diversificationWarnings, gin.H{
+				"type":       invType,
+				"percentage": percentage,
+				"target":     0,
+				"drift":      percentage,
+				"message":    fmt.Sprintf("%s não faz parte da alocação alvo (%.1f%% da carteira).", invType, percentage),
 			})
 		}
-	}
-	
-	// Diversification analysis
-	var diversificationWarnings []gin.H
-	if totalInvested > 0 {
+	} else if totalInvested > 0 {
 		for invType, amount := range typeDistribution {
 			percentage := (amount / totalInvested) * 100
 			// Warning if more than 50% in one type
 			if percentage > 50 {
 				diversificationWarnings = append(diversificationWarnings, gin.H{
-					"type": invType,
+					"type":       invType,
 					"percentage": percentage,
-					"message": fmt.Sprintf("Carteira muito concentrada em %s (%.1f%%). Considere diversificar.", invType, percentage),
+					"message":    fmt.Sprintf("Carteira muito concentrada em %s (%.1f%%). Considere diversificar.", invType, percentage),
 				})
 			}
 		}
 	}
-	
+
 	// Overall portfolio health
 	portfolioHealth := "boa"
 	if totalInvested > 0 {
@@ -1648,282 +2841,110 @@ func getInvestmentAnalysis(c *gin.Context) {
 			portfolioHealth = "atenção"
 		}
 	}
-	
-	c.JSON(200, gin.H{
+
+	response := gin.H{
 		"suggestions": suggestions,
-		"warnings": warnings,
+		"warnings":    warnings,
 		"diversification": gin.H{
 			"distribution": typeDistribution,
-			"warnings": diversificationWarnings,
+			"targets":      typeTargets,
+			"warnings":     diversificationWarnings,
 		},
-		"portfolio_health": portfolioHealth,
-		"total_invested": totalInvested,
+		"portfolio_health":    portfolioHealth,
+		"currency":            currency,
+		"total_invested":      totalInvested,
 		"total_current_value": totalCurrentValue,
-	})
-}
-
-// Get investment recommendations based on portfolio analysis
-func getInvestmentRecommendations(c *gin.Context) {
-	// Get current investments to analyze what's missing
-	rows, err := db.Query(`
-		SELECT ticker, type, total_invested
-		FROM investments
-	`)
-	if err != nil {
-		c.JSON(500, gin.H{"error": err.Error()})
-		return
-	}
-	defer rows.Close()
-
-	ownedTickers := make(map[string]bool)
-	typeDistribution := make(map[string]float64)
-	totalInvested := 0.0
-	
-	for rows.Next() {
-		var ticker, invType string
-		var invested float64
-		rows.Scan(&ticker, &invType, &invested)
-		ownedTickers[ticker] = true
-		typeDistribution[invType] += invested
-		totalInvested += invested
-	}
-	
-	// Calculate percentages
-	typePercentages := make(map[string]float64)
-	if totalInvested > 0 {
-		for invType, amount := range typeDistribution {
-			typePercentages[invType] = (amount / totalInvested) * 100
-		}
-	}
-	
-	// Recommended investments with justifications
-	recommendations := []gin.H{}
-	
-	// FII Recommendations (if user has less than 40% in FIIs or none)
-	if typePercentages["FII"] < 40 || totalInvested == 0 {
-		fiiRecommendations := []struct {
-			ticker, name, reason string
-		}{
-			{
-				"MXRF11",
-				"Maxi Renda",
-				"Um dos maiores FIIs do Brasil, alta liquidez e histórico consistente de dividendos. Ideal para começar ou diversificar em FIIs.",
-			},
-			{
-				"HGLG11",
-				"CSHG Logística",
-				"Foco em galpões logísticos, setor em crescimento. Boa distribuição de dividendos e gestão sólida.",
-			},
-			{
-				"XPLG11",
-				"XP Log",
-				"FII de logística com forte gestão da XP. Boa diversificação geográfica e histórico de valorização.",
-			},
-			{
-				"KNRI11",
-				"Kinea Rendimentos Imobiliários",
-				"FII diversificado com foco em renda. Boa gestão e distribuição regular de proventos.",
-			},
-			{
-				"VISC11",
-				"Vinci Shopping Centers",
-				"Exposição ao setor de shoppings, setor em recuperação. Potencial de valorização e dividendos.",
-			},
-		}
-		
-		for _, rec := range fiiRecommendations {
-			if !ownedTickers[rec.ticker] {
-				recommendations = append(recommendations, gin.H{
-					"ticker": rec.ticker,
-					"name": rec.name,
-					"type": "FII",
-					"reason": rec.reason,
-					"priority": "alta",
-					"category": "Diversificação em FIIs",
-				})
-			}
-		}
-	}
-	
-	// Stock Recommendations (if user has less than 30% in stocks or none)
-	if typePercentages["Ação"] < 30 || totalInvested == 0 {
-		stockRecommendations := []struct {
-			ticker, name, reason string
-		}{
-			{
-				"ITUB4",
-				"Itaú Unibanco",
-				"Maior banco privado do Brasil, alta liquidez e dividendos consistentes. Ação blue chip, ideal para carteira conservadora.",
-			},
-			{
-				"PETR4",
-				"Petrobras",
-				"Maior empresa do Brasil, alta liquidez. Boa para diversificação e exposição ao setor de energia.",
-			},
-			{
-				"VALE3",
-				"Vale",
-				"Líder mundial em mineração de ferro, alta liquidez e dividendos. Exposta ao ciclo de commodities.",
-			},
-			{
-				"WEGE3",
-				"WEG",
-				"Empresa de tecnologia industrial, forte crescimento e boa gestão. Boa para crescimento de longo prazo.",
-			},
-			{
-				"BBDC4",
-				"Bradesco",
-				"Um dos maiores bancos do Brasil, histórico sólido de dividendos. Boa para renda e crescimento.",
-			},
-		}
-		
-		for _, rec := range stockRecommendations {
-			if !ownedTickers[rec.ticker] {
-				recommendations = append(recommendations, gin.H{
-					"ticker": rec.ticker,
-					"name": rec.name,
-					"type": "Ação",
-					"reason": rec.reason,
-					"priority": "alta",
-					"category": "Diversificação em Ações",
-				})
-			}
-		}
-	}
-	
-	// ETF Recommendations (if user has less than 20% in ETFs or none)
-	if typePercentages["ETF"] < 20 || totalInvested == 0 {
-		etfRecommendations := []struct {
-			ticker, name, reason string
-		}{
-			{
-				"BOVA11",
-				"iShares Ibovespa",
-				"ETF que replica o Ibovespa, diversificação automática nas principais ações. Ideal para quem quer exposição ampla ao mercado.",
-			},
-			{
-				"IVVB11",
-				"iShares S&P 500",
-				"Exposição ao mercado americano, diversificação internacional. Boa para reduzir risco geográfico.",
-			},
-			{
-				"SMAL11",
-				"iShares Small Cap",
-				"ETF de pequenas empresas, maior potencial de crescimento. Boa para quem busca mais risco/retorno.",
-			},
-		}
-		
-		for _, rec := range etfRecommendations {
-			if !ownedTickers[rec.ticker] {
-				recommendations = append(recommendations, gin.H{
-					"ticker": rec.ticker,
-					"name": rec.name,
-					"type": "ETF",
-					"reason": rec.reason,
-					"priority": "média",
-					"category": "Diversificação com ETFs",
-				})
-			}
-		}
-	}
-	
-	// If portfolio is empty, add general recommendations
-	if totalInvested == 0 {
-		recommendations = append(recommendations, gin.H{
-			"ticker": "MXRF11",
-			"name": "Maxi Renda",
-			"type": "FII",
-			"reason": "Excelente para começar: um dos maiores e mais líquidos FIIs do Brasil, com histórico consistente de dividendos.",
-			"priority": "alta",
-			"category": "Primeiro Investimento",
-		})
 	}
-	
-	// Limit to top 10 recommendations
-	if len(recommendations) > 10 {
-		recommendations = recommendations[:10]
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		response["missingRates"] = missing
 	}
-	
-	c.JSON(200, gin.H{
-		"recommendations": recommendations,
-		"portfolio_analysis": gin.H{
-			"total_invested": totalInvested,
-			"type_distribution": typePercentages,
-			"owned_count": len(ownedTickers),
-		},
-	})
+	c.JSON(200, response)
 }
 
-// Get quote with retry logic and fallback APIs
-func getYahooFinanceQuote(ticker string) (float64, error) {
-	// Try multiple APIs in order
-	apis := []struct {
+// Get quote of a ticker on a market with retry logic and fallback APIs.
+// currency is the quote currency wanted for crypto pairs (BTC-USD, BTC-BRL).
+func getMarketQuote(ticker, market, currency string) (Quote, error) {
+	ticker = strings.ToUpper(strings.TrimSpace(ticker))
+	symbol := quoteSymbol(ticker, market, currency)
+
+	// Try multiple APIs in order. StatusInvest only covers B3.
+	type quoteAPI struct {
 		name string
-		fn   func(string) (float64, error)
-	}{
-		{"StatusInvest", tryStatusInvestQuote},
-		{"Yahoo Finance", tryYahooFinanceQuote},
+		fn   func() (float64, string, error)
+	}
+	var apis []quoteAPI
+	if market == marketB3 {
+		apis = append(apis, quoteAPI{"StatusInvest", func() (float64, string, error) {
+			price, err := tryStatusInvestQuote(strings.TrimSuffix(ticker, ".SA"))
+			return price, "BRL", err
+		}})
 	}
-	
+	apis = append(apis, quoteAPI{"Yahoo Finance", func() (float64, string, error) {
+		return tryYahooFinanceQuote(symbol)
+	}})
+
 	var lastErr error
 	for _, api := range apis {
-		price, err := api.fn(ticker)
+		price, quoteCurrency, err := api.fn()
 		if err == nil {
-			log.Printf("Successfully fetched %s price from %s", ticker, api.name)
-			return price, nil
+			log.Printf("Successfully fetched %s price from %s", symbol, api.name)
+			if quoteCurrency == "" {
+				quoteCurrency = marketCurrency(market)
+			}
+			return Quote{Symbol: symbol, Price: price, Currency: quoteCurrency, Source: api.name}, nil
 		}
 		lastErr = err
-		log.Printf("%s failed for %s: %v", api.name, ticker, err)
+		log.Printf("%s failed for %s: %v", api.name, symbol, err)
 		// Small delay between API attempts
 		time.Sleep(1 * time.Second)
 	}
-	
-	return 0, fmt.Errorf("todas as APIs falharam: %v", lastErr)
+
+	return Quote{}, fmt.Errorf("todas as APIs falharam: %v", lastErr)
 }
 
 // Try StatusInvest API (Brazilian, no auth needed)
 func tryStatusInvestQuote(ticker string) (float64, error) {
 	// StatusInvest public API endpoint
 	url := fmt.Sprintf("https://statusinvest.com.br/home/mainsearchquery?q=%s", ticker)
-	
+
 	client := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout: time.Duration(config.Providers.StatusInvestTimeout),
 	}
-	
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		return 0, fmt.Errorf("failed to create request: %v", err)
 	}
-	
+
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Referer", "https://statusinvest.com.br/")
-	
+
 	resp, err := client.Do(req)
 	if err != nil {
 		return 0, fmt.Errorf("failed to fetch from StatusInvest: %v", err)
 	}
 	defer resp.Body.Close()
-	
+
 	if resp.StatusCode != http.StatusOK {
 		return 0, fmt.Errorf("statusinvest returned status %d", resp.StatusCode)
 	}
-	
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		return 0, fmt.Errorf("failed to read StatusInvest response: %v", err)
 	}
-	
+
 	// StatusInvest returns array of results
 	var results []map[string]interface{}
 	if err := json.Unmarshal(body, &results); err != nil {
 		return 0, fmt.Errorf("failed to parse StatusInvest JSON: %v", err)
 	}
-	
+
 	if len(results) == 0 {
 		return 0, fmt.Errorf("statusinvest: no results found")
 	}
-	
+
 	// Find matching ticker (case insensitive)
 	for _, result := range results {
 		// Check both "ticker" and "code" fields
@@ -1933,7 +2954,7 @@ func tryStatusInvestQuote(ticker string) (float64, error) {
 		} else if c, ok := result["code"].(string); ok {
 			resultTicker = c
 		}
-		
+
 		if strings.EqualFold(resultTicker, ticker) {
 			// Try price field - StatusInvest returns price as string with comma
 			if price, ok := result["price"].(float64); ok {
@@ -1949,134 +2970,136 @@ func tryStatusInvestQuote(ticker string) (float64, error) {
 			}
 		}
 	}
-	
+
 	return 0, fmt.Errorf("statusinvest: price not found for ticker %s", ticker)
 }
 
 // Try Yahoo Finance with retry
-func tryYahooFinanceQuote(ticker string) (float64, error) {
+func tryYahooFinanceQuote(symbol string) (float64, string, error) {
 	maxRetries := 2
 	var lastErr error
-	
+
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		if attempt > 0 {
 			time.Sleep(3 * time.Second)
 		}
-		
-		price, err := tryGetQuote(ticker)
+
+		price, currency, err := tryGetQuote(symbol)
 		if err == nil {
-			return price, nil
+			return price, currency, nil
 		}
-		
+
 		lastErr = err
 		// If it's not a rate limit error, don't retry
 		if !strings.Contains(err.Error(), "429") && !strings.Contains(err.Error(), "rate limit") {
-			return 0, err
+			return 0, "", err
 		}
 	}
-	
-	return 0, fmt.Errorf("yahoo finance: %v", lastErr)
+
+	return 0, "", fmt.Errorf("yahoo finance: %v", lastErr)
 }
 
-// Try to get quote from Yahoo Finance
-func tryGetQuote(ticker string) (float64, error) {
-	// For Brazilian tickers, add .SA suffix
-	yahooTicker := ticker
-	if !strings.Contains(ticker, ".") {
-		yahooTicker = ticker + ".SA"
-	}
-	
+// Try to get quote and its currency from Yahoo Finance. The symbol must
+// already be resolved for its market (see quoteSymbol).
+func tryGetQuote(symbol string) (float64, string, error) {
 	// Use Yahoo Finance API v8 with different endpoint to avoid rate limiting
-	url := fmt.Sprintf("https://query2.finance.yahoo.com/v8/finance/chart/%s?interval=1d&range=1d", yahooTicker)
-	
+	url := fmt.Sprintf("https://query2.finance.yahoo.com/v8/finance/chart/%s?interval=1d&range=1d", symbol)
+
 	client := &http.Client{
-		Timeout: 15 * time.Second,
+		Timeout: time.Duration(config.Providers.YahooTimeout),
 	}
-	
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return 0, fmt.Errorf("failed to create request: %v", err)
+		return 0, "", fmt.Errorf("failed to create request: %v", err)
 	}
-	
+
 	// Add headers to avoid rate limiting - rotate user agents
 	userAgents := []string{
 		"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
 		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
 		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36",
 	}
-	
+
 	req.Header.Set("User-Agent", userAgents[time.Now().Unix()%int64(len(userAgents))])
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.9")
 	req.Header.Set("Referer", "https://finance.yahoo.com/")
-	
+
 	resp, err := client.Do(req)
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch quote: %v", err)
+		return 0, "", fmt.Errorf("failed to fetch quote: %v", err)
 	}
 	defer resp.Body.Close()
-	
+
 	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == 429 {
-		return 0, fmt.Errorf("rate limit exceeded")
+		return 0, "", fmt.Errorf("rate limit exceeded")
 	}
-	
+
 	if resp.StatusCode != http.StatusOK {
-		return 0, fmt.Errorf("yahoo finance returned status %d", resp.StatusCode)
+		return 0, "", fmt.Errorf("yahoo finance returned status %d", resp.StatusCode)
 	}
-	
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return 0, fmt.Errorf("failed to read response: %v", err)
+		return 0, "", fmt.Errorf("failed to read response: %v", err)
 	}
-	
+
 	var result map[string]interface{}
 	if err := json.Unmarshal(body, &result); err != nil {
-		return 0, fmt.Errorf("failed to parse JSON: %v", err)
+		return 0, "", fmt.Errorf("failed to parse JSON: %v", err)
 	}
-	
+
 	// Navigate through the JSON structure
 	chart, ok := result["chart"].(map[string]interface{})
 	if !ok {
-		return 0, fmt.Errorf("invalid response structure: chart not found")
+		return 0, "", fmt.Errorf("invalid response structure: chart not found")
 	}
-	
+
 	resultArray, ok := chart["result"].([]interface{})
 	if !ok || len(resultArray) == 0 {
-		return 0, fmt.Errorf("invalid response structure: result array empty")
+		return 0, "", fmt.Errorf("invalid response structure: result array empty")
 	}
-	
+
 	resultObj, ok := resultArray[0].(map[string]interface{})
 	if !ok {
-		return 0, fmt.Errorf("invalid response structure: result object not found")
+		return 0, "", fmt.Errorf("invalid response structure: result object not found")
 	}
-	
+
 	meta, ok := resultObj["meta"].(map[string]interface{})
 	if !ok {
-		return 0, fmt.Errorf("invalid response structure: meta not found")
+		return 0, "", fmt.Errorf("invalid response structure: meta not found")
 	}
-	
+
+	currency, _ := meta["currency"].(string)
+
 	regularPrice, ok := meta["regularMarketPrice"].(float64)
 	if !ok {
 		// Try previousClose as fallback
 		if prevClose, ok := meta["previousClose"].(float64); ok {
-			return prevClose, nil
+			regularPrice = prevClose
+		} else {
+			return 0, "", fmt.Errorf("price not found in response")
 		}
-		return 0, fmt.Errorf("price not found in response")
 	}
-	
-	return regularPrice, nil
+
+	// London listings are quoted in pence
+	if currency == "GBp" {
+		return regularPrice / 100, "GBP", nil
+	}
+	return regularPrice, strings.ToUpper(currency), nil
 }
 
 // Update investment price from Yahoo Finance
 func updateInvestmentPrice(c *gin.Context) {
 	id := c.Param("id")
-	
+
 	// Get investment
 	var inv Investment
-	err := db.QueryRow("SELECT id, ticker, quantity, average_price, total_invested FROM investments WHERE id = ?", id).Scan(
-		&inv.ID, &inv.Ticker, &inv.Quantity, &inv.AveragePrice, &inv.TotalInvested,
+	err := db.QueryRow("SELECT id, ticker, market, currency, quantity, average_price, total_invested FROM investments WHERE id = ? AND user_id = ?", id, currentUserID(c)).Scan(
+		&inv.ID, &inv.Ticker, &inv.Market, &inv.Currency, &inv.Quantity, &inv.AveragePrice, &inv.TotalInvested,
 	)
-	
+
 	if err == sql.ErrNoRows {
 		c.JSON(404, gin.H{"error": "Investment not found"})
 		return
@@ -2085,19 +3108,24 @@ func updateInvestmentPrice(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	// Fetch current price
-	currentPrice, err := getYahooFinanceQuote(inv.Ticker)
+	quote, err := getMarketQuote(inv.Ticker, inv.Market, inv.Currency)
 	if err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to fetch quote: %v", err)})
 		return
 	}
-	
+	currentPrice, err := quote.priceIn(inv.Currency)
+	if err != nil {
+		c.JSON(fxErrorStatus(err), gin.H{"error": err.Error()})
+		return
+	}
+
 	// Calculate values
 	currentValue := inv.Quantity * currentPrice
 	profitLoss := currentValue - inv.TotalInvested
 	profitLossPercent := (profitLoss / inv.TotalInvested) * 100
-	
+
 	// Update database
 	_, err = db.Exec(
 		`UPDATE investments SET 
@@ -2110,51 +3138,65 @@ func updateInvestmentPrice(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
+	if err := snapshotInvestment(db, id, time.Now().Format("2006-01-02")); err != nil {
+		log.Printf("Error recording investment snapshot: %v", err)
+	}
+
 	c.JSON(200, gin.H{
-		"message": "Price updated successfully",
-		"current_price": currentPrice,
-		"current_value": currentValue,
-		"profit_loss": profitLoss,
+		"message":             "Price updated successfully",
+		"quote":               quote,
+		"current_price":       currentPrice,
+		"current_value":       currentValue,
+		"profit_loss":         profitLoss,
 		"profit_loss_percent": profitLossPercent,
 	})
 }
 
 // Update all investment prices
 func updateAllInvestmentPrices(c *gin.Context) {
-	rows, err := db.Query("SELECT id, ticker, quantity, average_price, total_invested FROM investments")
+	rows, err := db.Query("SELECT id, ticker, market, currency, quantity, average_price, total_invested FROM investments WHERE user_id = ?", currentUserID(c))
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	defer rows.Close()
-	
+
 	updated := 0
 	failed := 0
 	errors := []string{}
-	
+
+	// Read every position first: the single database connection is needed
+	// for the updates below
+	var investments []Investment
 	for rows.Next() {
 		var inv Investment
-		err := rows.Scan(&inv.ID, &inv.Ticker, &inv.Quantity, &inv.AveragePrice, &inv.TotalInvested)
+		err := rows.Scan(&inv.ID, &inv.Ticker, &inv.Market, &inv.Currency, &inv.Quantity, &inv.AveragePrice, &inv.TotalInvested)
 		if err != nil {
 			failed++
 			errors = append(errors, fmt.Sprintf("Erro ao ler investimento: %v", err))
 			continue
 		}
-		
+		investments = append(investments, inv)
+	}
+	rows.Close()
+
+	for _, inv := range investments {
 		// Skip if total_invested is 0 (invalid investment)
 		if inv.TotalInvested == 0 {
 			log.Printf("Skipping investment %s: total_invested is 0", inv.Ticker)
 			continue
 		}
-		
+
 		// Fetch current price with retry
 		var currentPrice float64
 		var priceErr error
 		maxRetries := 2
 		for retry := 0; retry < maxRetries; retry++ {
-			currentPrice, priceErr = getYahooFinanceQuote(inv.Ticker)
+			var quote Quote
+			quote, priceErr = getMarketQuote(inv.Ticker, inv.Market, inv.Currency)
 			if priceErr == nil {
+				// A missing rate is not fixed by retrying
+				currentPrice, priceErr = quote.priceIn(inv.Currency)
 				break
 			}
 			if retry < maxRetries-1 {
@@ -2162,7 +3204,7 @@ func updateAllInvestmentPrices(c *gin.Context) {
 				time.Sleep(2 * time.Second) // Wait before retry
 			}
 		}
-		
+
 		if priceErr != nil {
 			failed++
 			errorMsg := fmt.Sprintf("%s: %v", inv.Ticker, priceErr)
@@ -2170,7 +3212,7 @@ func updateAllInvestmentPrices(c *gin.Context) {
 			log.Printf("Failed to update %s: %v", inv.Ticker, priceErr)
 			continue
 		}
-		
+
 		// Validate price
 		if currentPrice <= 0 {
 			failed++
@@ -2179,7 +3221,7 @@ func updateAllInvestmentPrices(c *gin.Context) {
 			log.Printf("Invalid price for %s: %.2f", inv.Ticker, currentPrice)
 			continue
 		}
-		
+
 		// Calculate values
 		currentValue := inv.Quantity * currentPrice
 		profitLoss := currentValue - inv.TotalInvested
@@ -2187,7 +3229,7 @@ func updateAllInvestmentPrices(c *gin.Context) {
 		if inv.TotalInvested > 0 {
 			profitLossPercent = (profitLoss / inv.TotalInvested) * 100
 		}
-		
+
 		// Update database with retry for "database is locked" errors
 		var updateErr error
 		maxDBRetries := 3
@@ -2202,7 +3244,7 @@ func updateAllInvestmentPrices(c *gin.Context) {
 			if updateErr == nil {
 				break
 			}
-			
+
 			// Check if it's a "database is locked" error
 			if strings.Contains(updateErr.Error(), "database is locked") || strings.Contains(updateErr.Error(), "locked") {
 				if dbRetry < maxDBRetries-1 {
@@ -2216,7 +3258,7 @@ func updateAllInvestmentPrices(c *gin.Context) {
 				break
 			}
 		}
-		
+
 		if updateErr != nil {
 			failed++
 			errorMsg := fmt.Sprintf("%s: erro ao salvar no banco: %v", inv.Ticker, updateErr)
@@ -2224,19 +3266,23 @@ func updateAllInvestmentPrices(c *gin.Context) {
 			log.Printf("Database error for %s: %v", inv.Ticker, updateErr)
 			continue
 		}
-		
+
 		updated++
-		log.Printf("Successfully updated %s: R$ %.2f", inv.Ticker, currentPrice)
-		
+		log.Printf("Successfully updated %s: %s %.2f", inv.Ticker, currencySymbol(inv.Currency), currentPrice)
+
+		if err := snapshotInvestment(db, inv.ID, time.Now().Format("2006-01-02")); err != nil {
+			log.Printf("Error recording snapshot for %s: %v", inv.Ticker, err)
+		}
+
 		// Small delay to avoid rate limiting
 		time.Sleep(1 * time.Second)
 	}
-	
+
 	c.JSON(200, gin.H{
 		"message": "Update completed",
 		"updated": updated,
-		"failed": failed,
-		"errors": errors,
+		"failed":  failed,
+		"errors":  errors,
 	})
 }
 
@@ -2247,32 +3293,45 @@ func fetchPriceForTicker(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Ticker parameter is required"})
 		return
 	}
-	
+	market := normalizeMarket(c.Query("market"), ticker)
+	if !isValidMarket(market) {
+		c.JSON(400, gin.H{"error": "Invalid market"})
+		return
+	}
+	currency := strings.ToUpper(c.Query("currency"))
+
 	// Add a small delay to avoid rate limiting
 	time.Sleep(2 * time.Second)
-	
-	price, err := getYahooFinanceQuote(ticker)
+
+	quote, err := getMarketQuote(ticker, market, currency)
 	if err != nil {
 		// Check if it's a rate limit error
 		if strings.Contains(err.Error(), "rate limit") || strings.Contains(err.Error(), "429") {
 			c.JSON(429, gin.H{
-				"error": "Muitas requisições ao Yahoo Finance. Aguarde alguns minutos e tente novamente, ou preencha o preço médio manualmente.",
-				"ticker": ticker,
+				"error":       "Muitas requisições ao Yahoo Finance. Aguarde alguns minutos e tente novamente, ou preencha o preço médio manualmente.",
+				"ticker":      ticker,
 				"retry_after": 60, // seconds
 			})
 			return
 		}
-		
+
 		// For other errors, return 200 but with error info (don't block user)
 		c.JSON(200, gin.H{
 			"ticker": ticker,
-			"error": fmt.Sprintf("Não foi possível buscar o preço: %v. Por favor, preencha o preço médio manualmente.", err),
-			"price": nil,
+			"error":  fmt.Sprintf("Não foi possível buscar o preço: %v. Por favor, preencha o preço médio manualmente.", err),
+			"price":  nil,
 		})
 		return
 	}
-	
-	c.JSON(200, gin.H{"ticker": ticker, "price": price})
+
+	c.JSON(200, gin.H{
+		"ticker":   ticker,
+		"market":   market,
+		"symbol":   quote.Symbol,
+		"price":    quote.Price,
+		"currency": quote.Currency,
+		"source":   quote.Source,
+	})
 }
 
 // Search investment suggestions from StatusInvest
@@ -2282,49 +3341,49 @@ func searchInvestmentSuggestions(c *gin.Context) {
 		c.JSON(400, gin.H{"error": "Query parameter 'q' is required"})
 		return
 	}
-	
+
 	// StatusInvest public API endpoint
 	url := fmt.Sprintf("https://statusinvest.com.br/home/mainsearchquery?q=%s", query)
-	
+
 	client := &http.Client{
-		Timeout: 10 * time.Second,
+		Timeout: time.Duration(config.Providers.StatusInvestTimeout),
 	}
-	
+
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to create request: %v", err)})
 		return
 	}
-	
+
 	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36")
 	req.Header.Set("Accept", "application/json")
 	req.Header.Set("Referer", "https://statusinvest.com.br/")
-	
+
 	resp, err := client.Do(req)
 	if err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to fetch suggestions: %v", err)})
 		return
 	}
 	defer resp.Body.Close()
-	
+
 	if resp.StatusCode != http.StatusOK {
 		c.JSON(resp.StatusCode, gin.H{"error": fmt.Sprintf("StatusInvest returned status %d", resp.StatusCode)})
 		return
 	}
-	
+
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to read response: %v", err)})
 		return
 	}
-	
+
 	// StatusInvest returns array of results
 	var results []map[string]interface{}
 	if err := json.Unmarshal(body, &results); err != nil {
 		c.JSON(500, gin.H{"error": fmt.Sprintf("Failed to parse JSON: %v", err)})
 		return
 	}
-	
+
 	// Format results for frontend
 	suggestions := []map[string]interface{}{}
 	for _, result := range results {
@@ -2336,34 +3395,29 @@ func searchInvestmentSuggestions(c *gin.Context) {
 		} else if t, ok := result["ticker"].(string); ok {
 			ticker = t
 		}
-		
+
 		if ticker == "" {
 			continue
 		}
-		
+
 		name := ""
 		if n, ok := result["nameFormated"].(string); ok {
 			name = n
 		} else if n, ok := result["name"].(string); ok {
 			name = n
 		}
-		
-		invType := "Outro"
-		if t, ok := result["type"].(float64); ok {
-			if t == 2 {
-				invType = "FII"
-			} else if t == 1 {
-				invType = "Ação"
-			}
-		}
-		
+
+		invType, market, currency := statusInvestAssetType(result)
+
 		suggestions = append(suggestions, map[string]interface{}{
-			"ticker": ticker,
-			"name":   name,
-			"type":   invType,
+			"ticker":   ticker,
+			"name":     name,
+			"type":     invType,
+			"market":   market,
+			"currency": currency,
 		})
 	}
-	
+
 	c.JSON(200, suggestions)
 }
 
@@ -2377,42 +3431,42 @@ func autoUpdateInvestmentPrices() {
 			go autoUpdateInvestmentPrices()
 		}
 	}()
-	
-	// Wait 2 minutes before first update
-	time.Sleep(2 * time.Minute)
-	
-	ticker := time.NewTicker(10 * time.Minute)
+
+	// Wait before the first update so startup is not slowed down
+	time.Sleep(time.Duration(config.Updaters.PriceUpdateDelay))
+
+	ticker := time.NewTicker(time.Duration(config.Updaters.PriceUpdateInterval))
 	defer ticker.Stop()
-	
+
 	for range ticker.C {
 		func() {
 			ctx, cancel := context.WithTimeout(context.Background(), 3*time.Minute)
 			defer cancel()
-			
-			rows, err := db.QueryContext(ctx, "SELECT id, ticker, quantity, average_price, total_invested FROM investments LIMIT 50")
+
+			rows, err := db.QueryContext(ctx, "SELECT id, ticker, market, currency, quantity, average_price, total_invested FROM investments LIMIT 50")
 			if err != nil {
 				log.Printf("Error fetching investments for auto-update: %v", err)
 				return
 			}
 			defer rows.Close()
-			
+
 			var investments []Investment
 			for rows.Next() {
 				var inv Investment
-				err := rows.Scan(&inv.ID, &inv.Ticker, &inv.Quantity, &inv.AveragePrice, &inv.TotalInvested)
+				err := rows.Scan(&inv.ID, &inv.Ticker, &inv.Market, &inv.Currency, &inv.Quantity, &inv.AveragePrice, &inv.TotalInvested)
 				if err != nil {
 					continue
 				}
 				investments = append(investments, inv)
 			}
-			
+
 			if len(investments) == 0 {
 				return
 			}
-			
+
 			updated := 0
 			failed := 0
-			
+
 			for i, inv := range investments {
 				select {
 				case <-ctx.Done():
@@ -2420,22 +3474,27 @@ func autoUpdateInvestmentPrices() {
 					return
 				default:
 				}
-				
+
 				priceCtx, priceCancel := context.WithTimeout(context.Background(), 20*time.Second)
-				
+
 				priceChan := make(chan float64, 1)
 				errChan := make(chan error, 1)
-				
-				go func(ticker string) {
+
+				go func(inv Investment) {
 					defer priceCancel()
-					price, err := getYahooFinanceQuote(ticker)
+					quote, err := getMarketQuote(inv.Ticker, inv.Market, inv.Currency)
+					if err != nil {
+						errChan <- err
+						return
+					}
+					price, err := quote.priceIn(inv.Currency)
 					if err != nil {
 						errChan <- err
 						return
 					}
 					priceChan <- price
-				}(inv.Ticker)
-				
+				}(inv)
+
 				select {
 				case currentPrice := <-priceChan:
 					currentValue := inv.Quantity * currentPrice
@@ -2444,9 +3503,9 @@ func autoUpdateInvestmentPrices() {
 					if inv.TotalInvested > 0 {
 						profitLossPercent = (profitLoss / inv.TotalInvested) * 100
 					}
-					
+
 					updateCtx, updateCancel := context.WithTimeout(context.Background(), 5*time.Second)
-					
+
 					maxRetries := 3
 					var updateErr error
 					for retry := 0; retry < maxRetries; retry++ {
@@ -2467,12 +3526,15 @@ func autoUpdateInvestmentPrices() {
 						break
 					}
 					updateCancel()
-					
+
 					if updateErr != nil {
 						log.Printf("Error updating investment %s: %v", inv.Ticker, updateErr)
 						failed++
 					} else {
 						updated++
+						if err := snapshotInvestment(db, inv.ID, time.Now().Format("2006-01-02")); err != nil {
+							log.Printf("Error recording snapshot for %s: %v", inv.Ticker, err)
+						}
 					}
 				case err := <-errChan:
 					log.Printf("Error fetching quote for %s: %v", inv.Ticker, err)
@@ -2481,12 +3543,12 @@ func autoUpdateInvestmentPrices() {
 					log.Printf("Timeout fetching quote for %s", inv.Ticker)
 					failed++
 				}
-				
+
 				if i < len(investments)-1 {
 					time.Sleep(2 * time.Second)
 				}
 			}
-			
+
 			if updated > 0 {
 				log.Printf("Investment prices auto-updated: %d success, %d failed", updated, failed)
 			}
@@ -2504,16 +3566,17 @@ func getInstallments(c *gin.Context) {
 		FROM installments i
 		LEFT JOIN accounts a ON i.account_id = a.id
 		LEFT JOIN categories c ON i.category_id = c.id
+		WHERE i.user_id = ?
 		ORDER BY i.created_at DESC
 	`
-	
-	rows, err := db.Query(query)
+
+	rows, err := db.Query(query, currentUserID(c))
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 	defer rows.Close()
-	
+
 	var installments []Installment
 	for rows.Next() {
 		var inst Installment
@@ -2528,7 +3591,7 @@ func getInstallments(c *gin.Context) {
 		}
 		installments = append(installments, inst)
 	}
-	
+
 	c.JSON(200, installments)
 }
 
@@ -2538,68 +3601,78 @@ func createInstallment(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	if inst.Description == "" || inst.TotalAmount <= 0 || inst.InstallmentsCount <= 0 {
 		c.JSON(400, gin.H{"error": "Description, total_amount and installments_count are required"})
 		return
 	}
-	
+
+	userID := currentUserID(c)
+	if !authorizeAccount(c, inst.AccountID, roleEditor, 400) {
+		return
+	}
+	if !categoryVisible(inst.CategoryID, userID) {
+		c.JSON(400, gin.H{"error": "Category not found"})
+		return
+	}
+
 	inst.InstallmentAmount = inst.TotalAmount / float64(inst.InstallmentsCount)
-	
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	
+
 	result, err := db.ExecContext(ctx,
-		`INSERT INTO installments (description, total_amount, installments_count, installment_amount, start_date, account_id, category_id, status)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, 'active')`,
-		inst.Description, inst.TotalAmount, inst.InstallmentsCount, inst.InstallmentAmount,
+		`INSERT INTO installments (user_id, description, total_amount, installments_count, installment_amount, start_date, account_id, category_id, status)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, 'active')`,
+		userID, inst.Description, inst.TotalAmount, inst.InstallmentsCount, inst.InstallmentAmount,
 		inst.StartDate, inst.AccountID, inst.CategoryID,
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	id, _ := result.LastInsertId()
 	inst.ID = int(id)
-	
+
 	startDate, err := time.Parse("2006-01-02", inst.StartDate)
 	if err != nil {
 		c.JSON(500, gin.H{"error": "Invalid start_date format. Use YYYY-MM-DD"})
 		return
 	}
-	
+
 	for i := 0; i < inst.InstallmentsCount; i++ {
 		dueDate := startDate.AddDate(0, i, 0)
 		_, err = db.ExecContext(ctx,
-			`INSERT INTO installment_payments (installment_id, installment_number, amount, due_date)
-			 VALUES (?, ?, ?, ?)`,
-			inst.ID, i+1, inst.InstallmentAmount, dueDate.Format("2006-01-02"),
+			`INSERT INTO installment_payments (user_id, installment_id, installment_number, amount, due_date)
+			 VALUES (?, ?, ?, ?, ?)`,
+			userID, inst.ID, i+1, inst.InstallmentAmount, dueDate.Format("2006-01-02"),
 		)
 		if err != nil {
 			log.Printf("Error creating payment %d for installment %d: %v", i+1, inst.ID, err)
 		}
 	}
-	
+	recordAudit(c, db, "installment", id, nil, auditRow(db, "installments", id))
+
 	c.JSON(200, inst)
 }
 
 func getInstallmentPayments(c *gin.Context) {
 	installmentID := c.Param("id")
-	
+
 	rows, err := db.Query(
 		`SELECT id, installment_id, installment_number, amount, due_date, paid_date, transaction_id, created_at
 		 FROM installment_payments
-		 WHERE installment_id = ?
+		 WHERE installment_id = ? AND user_id = ?
 		 ORDER BY installment_number`,
-		installmentID,
+		installmentID, currentUserID(c),
 	)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 	defer rows.Close()
-	
+
 	var payments []InstallmentPayment
 	for rows.Next() {
 		var pay InstallmentPayment
@@ -2613,13 +3686,13 @@ func getInstallmentPayments(c *gin.Context) {
 		}
 		payments = append(payments, pay)
 	}
-	
+
 	c.JSON(200, payments)
 }
 
 func payInstallment(c *gin.Context) {
 	installmentID := c.Param("id")
-	
+
 	var req struct {
 		PaymentID int    `json:"payment_id"`
 		Date      string `json:"date"`
@@ -2628,31 +3701,32 @@ func payInstallment(c *gin.Context) {
 		c.JSON(400, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	
+
+	userID := currentUserID(c)
 	var payment InstallmentPayment
 	err := db.QueryRowContext(ctx,
-		`SELECT id, installment_id, amount, due_date FROM installment_payments WHERE id = ?`,
-		req.PaymentID,
+		`SELECT id, installment_id, amount, due_date FROM installment_payments WHERE id = ? AND user_id = ?`,
+		req.PaymentID, userID,
 	).Scan(&payment.ID, &payment.InstallmentID, &payment.Amount, &payment.DueDate)
 	if err != nil {
 		c.JSON(404, gin.H{"error": "Payment not found"})
 		return
 	}
-	
+
 	installmentIDInt, err := strconv.Atoi(installmentID)
 	if err != nil {
 		c.JSON(400, gin.H{"error": "Invalid installment ID"})
 		return
 	}
-	
+
 	if payment.InstallmentID != installmentIDInt {
 		c.JSON(400, gin.H{"error": "Payment does not belong to this installment"})
 		return
 	}
-	
+
 	var inst Installment
 	err = db.QueryRowContext(ctx,
 		`SELECT account_id, category_id FROM installments WHERE id = ?`,
@@ -2662,24 +3736,27 @@ func payInstallment(c *gin.Context) {
 		c.JSON(404, gin.H{"error": "Installment not found"})
 		return
 	}
-	
+	if !authorizeAccount(c, inst.AccountID, roleEditor, 400) {
+		return
+	}
+
 	var instDesc string
 	err = db.QueryRowContext(ctx, "SELECT description FROM installments WHERE id = ?", installmentID).Scan(&instDesc)
 	if err != nil {
 		instDesc = "Compra Parcelada"
 	}
-	
+
 	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
 	defer tx.Rollback()
-	
+
 	result, err := tx.ExecContext(ctx,
-		`INSERT INTO transactions (account_id, category_id, type, amount, description, date)
-		 VALUES (?, ?, 'expense', ?, ?, ?)`,
-		inst.AccountID, inst.CategoryID, payment.Amount,
+		`INSERT INTO transactions (user_id, account_id, category_id, type, amount, currency, description, date)
+		 VALUES (?, ?, ?, 'expense', ?, ?, ?, ?)`,
+		userID, inst.AccountID, inst.CategoryID, payment.Amount, accountCurrency(tx, inst.AccountID),
 		fmt.Sprintf("%s - Parcela %d", instDesc, payment.InstallmentNumber),
 		req.Date,
 	)
@@ -2687,9 +3764,10 @@ func payInstallment(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	transactionID, _ := result.LastInsertId()
-	
+	recordAudit(c, tx, "transaction", transactionID, nil, auditRow(tx, "transactions", transactionID))
+
 	_, err = tx.ExecContext(ctx,
 		`UPDATE accounts SET balance = balance - ? WHERE id = ?`,
 		payment.Amount, inst.AccountID,
@@ -2698,7 +3776,8 @@ func payInstallment(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
+	before := auditRow(tx, "installment_payments", req.PaymentID)
 	_, err = tx.ExecContext(ctx,
 		`UPDATE installment_payments SET paid_date = ?, transaction_id = ? WHERE id = ?`,
 		req.Date, transactionID, req.PaymentID,
@@ -2707,7 +3786,8 @@ func payInstallment(c *gin.Context) {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+	recordAudit(c, tx, "installment_payment", req.PaymentID, before, auditRow(tx, "installment_payments", req.PaymentID))
+
 	var paidCount int
 	err = tx.QueryRowContext(ctx,
 		`SELECT COUNT(*) FROM installment_payments WHERE installment_id = ? AND paid_date IS NOT NULL`,
@@ -2719,35 +3799,44 @@ func payInstallment(c *gin.Context) {
 			`SELECT installments_count FROM installments WHERE id = ?`,
 			installmentID,
 		).Scan(&totalCount)
-		
+
 		if paidCount >= totalCount {
+			before := auditRow(tx, "installments", installmentID)
 			_, err = tx.ExecContext(ctx,
 				`UPDATE installments SET status = 'completed' WHERE id = ?`,
 				installmentID,
 			)
+			if err == nil {
+				recordAudit(c, tx, "installment", installmentID, before, auditRow(tx, "installments", installmentID))
+			}
 		}
 	}
-	
+
 	if err = tx.Commit(); err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+
 	c.JSON(200, gin.H{"message": "Payment processed successfully", "transaction_id": transactionID})
 }
 
 func deleteInstallment(c *gin.Context) {
 	installmentID := c.Param("id")
-	
+
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	
-	_, err := db.ExecContext(ctx, "DELETE FROM installments WHERE id = ?", installmentID)
+
+	before := auditRow(db, "installments", installmentID)
+	result, err := db.ExecContext(ctx, "DELETE FROM installments WHERE id = ? AND user_id = ?", installmentID, currentUserID(c))
 	if err != nil {
 		c.JSON(500, gin.H{"error": err.Error()})
 		return
 	}
-	
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Installment not found"})
+		return
+	}
+	recordAudit(c, db, "installment", installmentID, before, nil)
+
 	c.JSON(200, gin.H{"message": "Installment deleted successfully"})
 }
-
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Markets an investment can be traded on
+const (
+	marketB3     = "B3"
+	marketNYSE   = "NYSE"
+	marketNASDAQ = "NASDAQ"
+	marketCrypto = "CRYPTO"
+)
+
+// Crypto quantities are kept with satoshi precision
+const cryptoQuantityDecimals = 8
+
+type marketInfo struct {
+	Currency   string
+	Fractional bool
+}
+
+var markets = map[string]marketInfo{
+	marketB3:     {Currency: "BRL"},
+	marketNYSE:   {Currency: "USD", Fractional: true},
+	marketNASDAQ: {Currency: "USD", Fractional: true},
+	marketCrypto: {Currency: "USD", Fractional: true},
+}
+
+var knownCryptos = map[string]bool{
+	"BTC": true, "ETH": true, "SOL": true, "ADA": true, "XRP": true, "DOGE": true,
+	"DOT": true, "LTC": true, "BNB": true, "USDT": true, "USDC": true, "AVAX": true,
+	"LINK": true, "MATIC": true,
+}
+
+func isValidMarket(market string) bool {
+	_, ok := markets[market]
+	return ok
+}
+
+// Market of an investment. When none is given it is guessed from the ticker,
+// falling back to B3 which is where every investment lived before markets
+// were tracked.
+func normalizeMarket(market, ticker string) string {
+	market = strings.ToUpper(strings.TrimSpace(market))
+	if market != "" {
+		return market
+	}
+
+	ticker = strings.ToUpper(strings.TrimSpace(ticker))
+	base := ticker
+	if i := strings.Index(ticker, "-"); i > 0 {
+		base = ticker[:i]
+	}
+	if knownCryptos[base] {
+		return marketCrypto
+	}
+	return marketB3
+}
+
+// Default currency of the quotes of a market
+func marketCurrency(market string) string {
+	if info, ok := markets[market]; ok {
+		return info.Currency
+	}
+	return defaultCurrency
+}
+
+// Symbol used to query Yahoo Finance for a ticker: B3 tickers get the ".SA"
+// suffix, US class shares use a dash (BRK.B -> BRK-B) and cryptos are quoted
+// as a pair against the investment currency (BTC-USD, ETH-BRL).
+func quoteSymbol(ticker, market, currency string) string {
+	ticker = strings.ToUpper(strings.TrimSpace(ticker))
+	switch market {
+	case marketB3:
+		return strings.TrimSuffix(ticker, ".SA") + ".SA"
+	case marketNYSE, marketNASDAQ:
+		return strings.ReplaceAll(ticker, ".", "-")
+	case marketCrypto:
+		if i := strings.Index(ticker, "-"); i > 0 {
+			return ticker
+		}
+		if currency == "" {
+			currency = marketCurrency(market)
+		}
+		return ticker + "-" + currency
+	}
+	return ticker
+}
+
+// Round a quantity to the precision of its market. Crypto keeps up to 8
+// decimals, other markets are stored as given.
+func roundQuantity(quantity float64, market string) float64 {
+	if market != marketCrypto {
+		return quantity
+	}
+	factor := math.Pow(10, cryptoQuantityDecimals)
+	return math.Round(quantity*factor) / factor
+}
+
+// Whether a quantity is small enough to be considered zero (dust left over by
+// floating point arithmetic when selling fractional positions)
+func isDustQuantity(quantity float64) bool {
+	return math.Abs(quantity) < 1e-9
+}
+
+// Format a quantity without trailing zeros, so 0.00012 BTC is not shown as 0.00
+func formatQuantity(quantity float64) string {
+	return strconv.FormatFloat(quantity, 'f', -1, 64)
+}
+
+// Quote is a price in the currency it was quoted in
+type Quote struct {
+	Symbol   string  `json:"symbol"`
+	Price    float64 `json:"price"`
+	Currency string  `json:"currency"`
+	Source   string  `json:"source"`
+}
+
+// Price of the quote in the currency of the investment, converted at
+// today's rate when they differ
+func (q Quote) priceIn(currency string) (float64, error) {
+	if q.Currency == "" || q.Currency == currency {
+		return q.Price, nil
+	}
+	return newFXConverter().convertExact(q.Price, q.Currency, currency, time.Now().Format("2006-01-02"))
+}
+
+// Map a StatusInvest search result to an investment type, market and
+// currency. The result URL (/acoes/petr4, /bdrs/aapl34, /acoes/eua/aapl...)
+// tells the asset class; the numeric type is only used when it is missing.
+func statusInvestAssetType(result map[string]interface{}) (string, string, string) {
+	url, _ := result["url"].(string)
+	url = strings.ToLower(url)
+
+	switch {
+	case strings.HasPrefix(url, "/fundos-imobiliarios/"):
+		return "FII", marketB3, "BRL"
+	case strings.HasPrefix(url, "/fiagros/"):
+		return "Fiagro", marketB3, "BRL"
+	case strings.HasPrefix(url, "/bdrs/"):
+		return "BDR", marketB3, "BRL"
+	case strings.HasPrefix(url, "/etfs/eua/"), strings.HasPrefix(url, "/etf/eua/"):
+		return "ETF", marketNYSE, "USD"
+	case strings.HasPrefix(url, "/etfs/"):
+		return "ETF", marketB3, "BRL"
+	case strings.HasPrefix(url, "/acoes/eua/"):
+		return "Stock", marketNASDAQ, "USD"
+	case strings.HasPrefix(url, "/reits/"):
+		return "REIT", marketNYSE, "USD"
+	case strings.HasPrefix(url, "/criptomoedas/"):
+		return "Cripto", marketCrypto, "USD"
+	case strings.HasPrefix(url, "/tesouro/"), strings.HasPrefix(url, "/tesouro-direto/"):
+		return "Tesouro Direto", marketB3, "BRL"
+	case strings.HasPrefix(url, "/fundos-de-investimento/"):
+		return "Fundo", marketB3, "BRL"
+	case strings.HasPrefix(url, "/acoes/"):
+		return "Ação", marketB3, "BRL"
+	}
+
+	if t, ok := result["type"].(float64); ok {
+		switch t {
+		case 1:
+			return "Ação", marketB3, "BRL"
+		case 2:
+			return "FII", marketB3, "BRL"
+		}
+	}
+	return "Outro", marketB3, "BRL"
+}
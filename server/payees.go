@@ -0,0 +1,623 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Payees are who the money goes to or comes from ("Uber", "Pão de Açúcar"),
+// recognised in the descriptions banks write in many ways ("UBER *TRIP 1234",
+// "Uber Trip"). Every user has their own payees, each with rules that map
+// descriptions to it. Descriptions are compared normalized: lowercase,
+// without accents, punctuation, numbers or single letters, so "UBER *TRIP
+// 1234" reads "uber trip". A payee's name works as a rule matching the
+// descriptions that start with it.
+
+const (
+	payeeMatchContains   = "contains"
+	payeeMatchStartsWith = "starts_with"
+	payeeMatchRegex      = "regex"
+)
+
+type PayeeRule struct {
+	ID        int    `json:"id"`
+	MatchType string `json:"match_type"`
+	Pattern   string `json:"pattern"`
+}
+
+type Payee struct {
+	ID               int         `json:"id"`
+	Name             string      `json:"name"`
+	Rules            []PayeeRule `json:"rules"`
+	TransactionCount int         `json:"transaction_count"`
+	CreatedAt        string      `json:"created_at"`
+}
+
+// A rule ready to be tried on descriptions
+type payeeMatcher struct {
+	payeeID   int
+	matchType string
+	pattern   string
+	regex     *regexp.Regexp
+}
+
+// Normalized form of a description, or of a pattern compared with one
+func normalizePayeeText(text string) string {
+	return strings.Join(descriptionWords(text), " ")
+}
+
+func (m payeeMatcher) matches(description, normalized string) bool {
+	switch m.matchType {
+	case payeeMatchRegex:
+		return m.regex.MatchString(description)
+	case payeeMatchStartsWith:
+		return normalized == m.pattern || strings.HasPrefix(normalized, m.pattern+" ")
+	default:
+		return strings.Contains(" "+normalized+" ", " "+m.pattern+" ")
+	}
+}
+
+// The user's rules in the order they are tried: the longest patterns first,
+// being the most specific, then the payee names
+func loadPayeeMatchers(q queryer, userID int) ([]payeeMatcher, error) {
+	rows, err := q.Query(`
+		SELECT r.payee_id, r.match_type, r.pattern
+		FROM payee_rules r
+		JOIN payees p ON p.id = r.payee_id
+		WHERE p.user_id = ?
+		ORDER BY LENGTH(r.pattern) DESC, r.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	matchers := []payeeMatcher{}
+	for rows.Next() {
+		var m payeeMatcher
+		if err := rows.Scan(&m.payeeID, &m.matchType, &m.pattern); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if m.matchType == payeeMatchRegex {
+			// Patterns are checked when saved
+			if m.regex, err = compileRuleRegex(m.pattern); err != nil {
+				continue
+			}
+		} else {
+			m.pattern = normalizePayeeText(m.pattern)
+		}
+		matchers = append(matchers, m)
+	}
+	rows.Close()
+
+	rows, err = q.Query("SELECT id, name FROM payees WHERE user_id = ? ORDER BY LENGTH(name) DESC, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		m := payeeMatcher{matchType: payeeMatchStartsWith}
+		if err := rows.Scan(&m.payeeID, &m.pattern); err != nil {
+			return nil, err
+		}
+		if m.pattern = normalizePayeeText(m.pattern); m.pattern != "" {
+			matchers = append(matchers, m)
+		}
+	}
+	return matchers, rows.Err()
+}
+
+// ID of the payee of a description, nil when no rule matches
+func matchPayee(matchers []payeeMatcher, description string) *int {
+	normalized := normalizePayeeText(description)
+	for _, m := range matchers {
+		if normalized != "" || m.matchType == payeeMatchRegex {
+			if m.matches(description, normalized) {
+				id := m.payeeID
+				return &id
+			}
+		}
+	}
+	return nil
+}
+
+// Check the payee given on a transaction, or pick it from the description
+// with the user's rules when none is given
+func resolveTransactionPayee(q queryer, userID int, t *Transaction) error {
+	if t.PayeeID != nil {
+		if !payeeOwned(*t.PayeeID, userID) {
+			return fmt.Errorf("Payee not found")
+		}
+		return nil
+	}
+	if t.Description == nil {
+		return nil
+	}
+	matchers, err := loadPayeeMatchers(q, userID)
+	if err != nil {
+		return err
+	}
+	t.PayeeID = matchPayee(matchers, *t.Description)
+	return nil
+}
+
+func payeeOwned(payeeID, userID int) bool {
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM payees WHERE id = ? AND user_id = ?)", payeeID, userID).Scan(&exists)
+	return exists
+}
+
+// Payee names are unique per user, ignoring case
+func payeeNameTaken(userID int, name string, exceptID int) bool {
+	var taken bool
+	db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM payees WHERE user_id = ? AND name = ? COLLATE NOCASE AND id != ?)",
+		userID, name, exceptID,
+	).Scan(&taken)
+	return taken
+}
+
+// Check the rules given for a payee, returning what is wrong with them
+func validatePayeeRules(rules []PayeeRule) string {
+	for i := range rules {
+		rule := &rules[i]
+		rule.Pattern = strings.TrimSpace(rule.Pattern)
+		if rule.MatchType == "" {
+			rule.MatchType = payeeMatchContains
+		}
+		switch rule.MatchType {
+		case payeeMatchContains, payeeMatchStartsWith:
+			if normalizePayeeText(rule.Pattern) == "" {
+				return fmt.Sprintf("rules[%d]: pattern needs at least one word", i)
+			}
+		case payeeMatchRegex:
+			if rule.Pattern == "" {
+				return fmt.Sprintf("rules[%d]: pattern is required", i)
+			}
+			if _, err := compileRuleRegex(rule.Pattern); err != nil {
+				return fmt.Sprintf("rules[%d]: invalid pattern: %v", i, err)
+			}
+		default:
+			return fmt.Sprintf("rules[%d]: match_type must be contains, starts_with or regex", i)
+		}
+	}
+	return ""
+}
+
+// Replace the rules of a payee
+func setPayeeRules(e execer, payeeID int, rules []PayeeRule) error {
+	if _, err := e.Exec("DELETE FROM payee_rules WHERE payee_id = ?", payeeID); err != nil {
+		return err
+	}
+	for _, rule := range rules {
+		_, err := e.Exec("INSERT INTO payee_rules (payee_id, match_type, pattern) VALUES (?, ?, ?)", payeeID, rule.MatchType, rule.Pattern)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The user's payees with their rules and the number of transactions of each.
+// With an id, only that payee.
+func loadPayees(userID int, id interface{}) ([]Payee, error) {
+	query := `
+		SELECT p.id, p.name, p.created_at, COUNT(t.id)
+		FROM payees p
+		LEFT JOIN transactions t ON t.payee_id = p.id AND t.deleted_at IS NULL
+		WHERE p.user_id = ?`
+	args := []interface{}{userID}
+	if id != nil {
+		query += " AND p.id = ?"
+		args = append(args, id)
+	}
+	rows, err := db.Query(query+" GROUP BY p.id ORDER BY p.name COLLATE NOCASE", args...)
+	if err != nil {
+		return nil, err
+	}
+	payees := []Payee{}
+	byID := make(map[int]int)
+	for rows.Next() {
+		p := Payee{Rules: []PayeeRule{}}
+		if err := rows.Scan(&p.ID, &p.Name, &p.CreatedAt, &p.TransactionCount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		byID[p.ID] = len(payees)
+		payees = append(payees, p)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT r.payee_id, r.id, r.match_type, r.pattern
+		FROM payee_rules r
+		JOIN payees p ON p.id = r.payee_id
+		WHERE p.user_id = ?
+		ORDER BY r.id
+	`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var payeeID int
+		var rule PayeeRule
+		if err := rows.Scan(&payeeID, &rule.ID, &rule.MatchType, &rule.Pattern); err != nil {
+			return nil, err
+		}
+		if i, ok := byID[payeeID]; ok {
+			payees[i].Rules = append(payees[i].Rules, rule)
+		}
+	}
+	return payees, rows.Err()
+}
+
+// Get the user's payees
+func getPayees(c *gin.Context) {
+	payees, err := loadPayees(currentUserID(c), nil)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, payees)
+}
+
+// Create a payee with its rules
+func createPayee(c *gin.Context) {
+	var p Payee
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID := currentUserID(c)
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	if payeeNameTaken(userID, p.Name, 0) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Payee %s already exists", p.Name)})
+		return
+	}
+	if message := validatePayeeRules(p.Rules); message != "" {
+		c.JSON(400, gin.H{"error": message})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("INSERT INTO payees (user_id, name) VALUES (?, ?)", userID, p.Name)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+	if err := setPayeeRules(tx, int(id), p.Rules); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	payees, err := loadPayees(userID, id)
+	if err != nil || len(payees) == 0 {
+		c.JSON(500, gin.H{"error": "Failed to load payee"})
+		return
+	}
+	c.JSON(200, payees[0])
+}
+
+// Rename a payee or replace its rules. Rules left out of the request are
+// kept. Transactions already assigned keep their payee: apply the rules again
+// to reassign them.
+func updatePayee(c *gin.Context) {
+	var update struct {
+		Name  *string     `json:"name"`
+		Rules []PayeeRule `json:"rules"`
+	}
+	if err := c.ShouldBindJSON(&update); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID := currentUserID(c)
+
+	var id int
+	var name string
+	err := db.QueryRow("SELECT id, name FROM payees WHERE id = ? AND user_id = ?", c.Param("id"), userID).Scan(&id, &name)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Payee not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if update.Name != nil {
+		name = strings.TrimSpace(*update.Name)
+	}
+	if name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	if payeeNameTaken(userID, name, id) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Payee %s already exists", name)})
+		return
+	}
+	if message := validatePayeeRules(update.Rules); message != "" {
+		c.JSON(400, gin.H{"error": message})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("UPDATE payees SET name = ? WHERE id = ?", name, id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if update.Rules != nil {
+		if err := setPayeeRules(tx, id, update.Rules); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	payees, err := loadPayees(userID, id)
+	if err != nil || len(payees) == 0 {
+		c.JSON(500, gin.H{"error": "Failed to load payee"})
+		return
+	}
+	c.JSON(200, payees[0])
+}
+
+// Delete a payee with its rules. Its transactions are left without payee.
+func deletePayee(c *gin.Context) {
+	result, err := db.Exec("DELETE FROM payees WHERE id = ? AND user_id = ?", c.Param("id"), currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Payee not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Payee deleted successfully"})
+}
+
+// Run the payee rules over the transactions the user can change that have no
+// payee, or over all of them with ?reassign=true, and assign the payees that
+// match. With ?dry_run=true nothing is changed and the response tells what
+// would be.
+func applyPayeeRules(c *gin.Context) {
+	userID := currentUserID(c)
+	dryRun := c.Query("dry_run") == "true"
+	reassign := c.Query("reassign") == "true"
+
+	matchers, err := loadPayeeMatchers(db, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	query := `
+		SELECT t.id, t.payee_id, COALESCE(t.description, ''), t.amount, t.date
+		FROM transactions t
+		WHERE t.deleted_at IS NULL AND t.account_id IN (` + editableAccountsQuery + `)`
+	if !reassign {
+		query += " AND t.payee_id IS NULL"
+	}
+	rows, err := db.Query(query+" ORDER BY t.date, t.id", userID, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	type change struct {
+		TransactionID int     `json:"transaction_id"`
+		Date          string  `json:"date"`
+		Description   string  `json:"description"`
+		Amount        float64 `json:"amount"`
+		PayeeID       *int    `json:"payee_id"`
+	}
+	changes := []change{}
+	examined := 0
+	for rows.Next() {
+		var ch change
+		var current *int
+		if err := rows.Scan(&ch.TransactionID, &current, &ch.Description, &ch.Amount, &ch.Date); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		examined++
+		// Transactions no rule matches keep the payee they have
+		ch.PayeeID = matchPayee(matchers, ch.Description)
+		if ch.PayeeID != nil && (current == nil || *current != *ch.PayeeID) {
+			changes = append(changes, ch)
+		}
+	}
+	rows.Close()
+
+	if !dryRun && len(changes) > 0 {
+		tx, err := db.Begin()
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		defer tx.Rollback()
+
+		for _, ch := range changes {
+			before := auditRow(tx, "transactions", ch.TransactionID)
+			if _, err := tx.Exec("UPDATE transactions SET payee_id = ? WHERE id = ?", ch.PayeeID, ch.TransactionID); err != nil {
+				c.JSON(500, gin.H{"error": err.Error()})
+				return
+			}
+			recordAudit(c, tx, "transaction", ch.TransactionID, before, auditRow(tx, "transactions", ch.TransactionID))
+		}
+		if err := tx.Commit(); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(200, gin.H{
+		"dry_run":  dryRun,
+		"examined": examined,
+		"assigned": len(changes),
+		"changes":  changes,
+	})
+}
+
+// Payee ID picked by the rules for a row being imported, nil when no rule
+// matches
+func payeeForImportedRow(matchers []payeeMatcher, values map[string]interface{}) interface{} {
+	description, _ := values["description"].(string)
+	if id := matchPayee(matchers, description); id != nil {
+		return int64(*id)
+	}
+	return nil
+}
+
+// Income or expenses by payee over time, in the base currency: the total of
+// each payee in the period and by month (or by year with ?interval=year).
+// Transactions without a payee are summed apart.
+func getStatsByPayee(c *gin.Context) {
+	transactionType := c.DefaultQuery("type", "expense")
+	if transactionType != "income" && transactionType != "expense" {
+		c.JSON(400, gin.H{"error": "type must be income or expense"})
+		return
+	}
+	periodLength := 7
+	switch c.DefaultQuery("interval", "month") {
+	case "month":
+	case "year":
+		periodLength = 4
+	default:
+		c.JSON(400, gin.H{"error": "interval must be month or year"})
+		return
+	}
+	userID := currentUserID(c)
+
+	where := "t.type = ? AND t.deleted_at IS NULL AND t.account_id IN (" + visibleAccountsQuery + ")"
+	args := []interface{}{transactionType, userID, userID}
+	if start := c.Query("start"); start != "" {
+		where += " AND t.date >= ?"
+		args = append(args, start)
+	}
+	if end := c.Query("end"); end != "" {
+		where += " AND t.date <= ?"
+		args = append(args, end)
+	}
+
+	rows, err := db.Query(`
+		SELECT t.payee_id, p.name, t.currency, t.date, SUM(t.amount), COUNT(*)
+		FROM transactions t
+		LEFT JOIN payees p ON p.id = t.payee_id
+		WHERE `+where+`
+		GROUP BY t.payee_id, t.currency, t.date
+	`, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	type dayTotal struct {
+		payeeID        *int
+		name           *string
+		currency, date string
+		amount         float64
+		count          int
+	}
+	var days []dayTotal
+	for rows.Next() {
+		var d dayTotal
+		if err := rows.Scan(&d.payeeID, &d.name, &d.currency, &d.date, &d.amount, &d.count); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		days = append(days, d)
+	}
+	rows.Close()
+
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+
+	type periodTotal struct {
+		Period string  `json:"period"`
+		Total  float64 `json:"total"`
+	}
+	type payeeTotal struct {
+		ID      int           `json:"id"`
+		Name    string        `json:"name"`
+		Total   float64       `json:"total"`
+		Count   int           `json:"count"`
+		Periods []periodTotal `json:"periods"`
+		periods map[string]float64
+	}
+	byPayee := make(map[int]*payeeTotal)
+	totals := []*payeeTotal{}
+	unassigned := &payeeTotal{periods: make(map[string]float64)}
+	for _, d := range days {
+		total := unassigned
+		// Payees deleted since count as none
+		if d.payeeID != nil && d.name != nil {
+			var ok bool
+			if total, ok = byPayee[*d.payeeID]; !ok {
+				total = &payeeTotal{ID: *d.payeeID, Name: *d.name, periods: make(map[string]float64)}
+				byPayee[*d.payeeID] = total
+				totals = append(totals, total)
+			}
+		}
+		amount := fx.convert(d.amount, d.currency, currency, d.date)
+		total.Total += amount
+		total.Count += d.count
+		if len(d.date) >= periodLength {
+			total.periods[d.date[:periodLength]] += amount
+		}
+	}
+
+	for _, total := range append(totals, unassigned) {
+		total.Periods = []periodTotal{}
+		for period, amount := range total.periods {
+			total.Periods = append(total.Periods, periodTotal{period, amount})
+		}
+		sort.Slice(total.Periods, func(i, j int) bool { return total.Periods[i].Period < total.Periods[j].Period })
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Total > totals[j].Total })
+
+	response := gin.H{
+		"type":     transactionType,
+		"currency": currency,
+		"payees":   totals,
+		"unassigned": gin.H{
+			"total":   unassigned.Total,
+			"count":   unassigned.Count,
+			"periods": unassigned.Periods,
+		},
+	}
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		response["missingRates"] = missing
+	}
+	c.JSON(200, response)
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx so history can be recorded
+// inside or outside a transaction.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Record a buy/sell/adjust movement of an investment. Amount is the cash that
+// went into (buy, positive adjust) or out of (sell) the position.
+func recordInvestmentMovement(e execer, userID, investmentID int, ticker, invType, currency, movementType, date string, quantity, price, amount float64, profitLoss *float64) error {
+	_, err := e.Exec(
+		`INSERT INTO investment_movements (user_id, investment_id, ticker, type, currency, movement_type, date, quantity, price, amount, profit_loss)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		userID, investmentID, ticker, invType, currency, movementType, date, quantity, price, amount, profitLoss,
+	)
+	return err
+}
+
+// Store the current state of an investment as its valuation for the day.
+// Positions without a quote are valued at cost.
+func snapshotInvestment(e execer, investmentID interface{}, date string) error {
+	_, err := e.Exec(`
+		INSERT INTO investment_snapshots (user_id, investment_id, ticker, type, currency, date, quantity, price, value, invested)
+		SELECT user_id, id, ticker, type, currency, ?, quantity, COALESCE(current_price, average_price),
+		       COALESCE(current_value, total_invested), total_invested
+		FROM investments WHERE id = ?
+		ON CONFLICT(investment_id, date) DO UPDATE SET
+			currency = excluded.currency, quantity = excluded.quantity, price = excluded.price,
+			value = excluded.value, invested = excluded.invested
+	`, date, investmentID)
+	return err
+}
+
+// Store a zero valuation for a position that was closed.
+func snapshotClosedInvestment(e execer, userID, investmentID int, ticker, invType, currency, date string) error {
+	_, err := e.Exec(`
+		INSERT INTO investment_snapshots (user_id, investment_id, ticker, type, currency, date, quantity, price, value, invested)
+		VALUES (?, ?, ?, ?, ?, ?, 0, 0, 0, 0)
+		ON CONFLICT(investment_id, date) DO UPDATE SET
+			currency = excluded.currency, quantity = 0, price = 0, value = 0, invested = 0
+	`, userID, investmentID, ticker, invType, currency, date)
+	return err
+}
+
+// Seed history for investments created before movements and snapshots were
+// tracked: a buy at creation valued at cost and, when a quote is known, a
+// valuation at the last update. History from before it kept its currency
+// gets the one of its investment, or BRL, the only currency back then, when
+// the position was closed.
+func backfillInvestmentHistory() {
+	statements := []string{
+		`INSERT INTO investment_movements (user_id, investment_id, ticker, type, currency, movement_type, date, quantity, price, amount)
+		 SELECT user_id, id, ticker, type, currency, 'buy', date(created_at), quantity, average_price, total_invested
+		 FROM investments i
+		 WHERE NOT EXISTS (SELECT 1 FROM investment_movements m WHERE m.investment_id = i.id)`,
+		`INSERT OR IGNORE INTO investment_snapshots (user_id, investment_id, ticker, type, currency, date, quantity, price, value, invested)
+		 SELECT user_id, id, ticker, type, currency, date(created_at), quantity, average_price, total_invested, total_invested
+		 FROM investments i
+		 WHERE NOT EXISTS (SELECT 1 FROM investment_snapshots s WHERE s.investment_id = i.id)`,
+		`INSERT OR IGNORE INTO investment_snapshots (user_id, investment_id, ticker, type, currency, date, quantity, price, value, invested)
+		 SELECT user_id, id, ticker, type, currency, date(updated_at), quantity, current_price, current_value, total_invested
+		 FROM investments
+		 WHERE current_value IS NOT NULL`,
+		`UPDATE investment_movements SET currency = COALESCE(
+			(SELECT currency FROM investments i WHERE i.id = investment_movements.investment_id), 'BRL'
+		 ) WHERE currency IS NULL`,
+		`UPDATE investment_snapshots SET currency = COALESCE(
+			(SELECT currency FROM investments i WHERE i.id = investment_snapshots.investment_id), 'BRL'
+		 ) WHERE currency IS NULL`,
+	}
+
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Printf("Failed to backfill investment history: %v", err)
+		}
+	}
+}
+
+type cashFlow struct {
+	Date   time.Time
+	Amount float64
+}
+
+// One day of a valuation series: value at the end of the day and net money
+// put into the portfolio that day.
+type valuationPoint struct {
+	Date  string
+	Value float64
+	Flow  float64
+}
+
+type monthlyReturn struct {
+	Month  string  `json:"month"`
+	Return float64 `json:"return"`
+}
+
+type PerformanceMetrics struct {
+	StartDate             string          `json:"start_date"`
+	EndDate               string          `json:"end_date"`
+	StartValue            float64         `json:"start_value"`
+	EndValue              float64         `json:"end_value"`
+	NetContributions      float64         `json:"net_contributions"`
+	TWR                   float64         `json:"twr"`
+	TWRAnnualized         *float64        `json:"twr_annualized"`
+	XIRR                  *float64        `json:"xirr"`
+	Volatility            *float64        `json:"volatility"`
+	MaxDrawdown           float64         `json:"max_drawdown"`
+	MaxDrawdownPeakDate   string          `json:"max_drawdown_peak_date"`
+	MaxDrawdownValleyDate string          `json:"max_drawdown_valley_date"`
+	MonthlyReturns        []monthlyReturn `json:"monthly_returns"`
+}
+
+// Build the daily valuation series of a user's portfolio (or of a single
+// asset type when invType is not empty) in a currency. Each investment's last
+// known valuation is carried forward until a newer snapshot exists, and is
+// converted at the rate of every day of the series; flows are converted at
+// the rate of their day.
+func loadValuationSeries(fx *fxConverter, userID int, currency, invType string) ([]valuationPoint, error) {
+	snapshotQuery := "SELECT investment_id, date, COALESCE(currency, 'BRL'), value FROM investment_snapshots WHERE user_id = ?"
+	movementQuery := `SELECT date, COALESCE(currency, 'BRL'), SUM(CASE WHEN movement_type = 'sell' THEN -amount ELSE amount END)
+		FROM investment_movements WHERE user_id = ?`
+	args := []interface{}{userID}
+	if invType != "" {
+		snapshotQuery += " AND type = ?"
+		movementQuery += " AND type = ?"
+		args = append(args, invType)
+	}
+	snapshotQuery += " ORDER BY date, investment_id"
+	movementQuery += " GROUP BY 1, 2"
+
+	type nativeAmount struct {
+		date, currency string
+		amount         float64
+	}
+	var movements []nativeAmount
+	rows, err := db.Query(movementQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var m nativeAmount
+		if err := rows.Scan(&m.date, &m.currency, &m.amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		movements = append(movements, m)
+	}
+	rows.Close()
+
+	type snapshot struct {
+		investmentID int
+		nativeAmount
+	}
+	var snapshots []snapshot
+	rows, err = db.Query(snapshotQuery, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s snapshot
+		if err := rows.Scan(&s.investmentID, &s.date, &s.currency, &s.amount); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	rows.Close()
+
+	// Rates are read once the rows are closed, as the converter queries too
+	flows := make(map[string]float64)
+	for _, m := range movements {
+		flows[m.date] += fx.convert(m.amount, m.currency, currency, m.date)
+	}
+
+	values := make(map[int]nativeAmount)
+	var series []valuationPoint
+	for _, s := range snapshots {
+		values[s.investmentID] = s.nativeAmount
+
+		if len(series) == 0 || series[len(series)-1].Date != s.date {
+			series = append(series, valuationPoint{Date: s.date, Flow: flows[s.date]})
+			delete(flows, s.date)
+		}
+		total := 0.0
+		for _, v := range values {
+			total += fx.convert(v.amount, v.currency, currency, s.date)
+		}
+		series[len(series)-1].Value = total
+	}
+
+	// Flows on days without any valuation (e.g. a buy and a full sale on the
+	// same day) still count towards contributions.
+	for date, amount := range flows {
+		series = append(series, valuationPoint{Date: date, Flow: amount, Value: math.NaN()})
+	}
+	sort.SliceStable(series, func(i, j int) bool { return series[i].Date < series[j].Date })
+	for i := range series {
+		if math.IsNaN(series[i].Value) {
+			series[i].Value = 0
+			if i > 0 {
+				series[i].Value = series[i-1].Value
+			}
+		}
+	}
+
+	return series, nil
+}
+
+// Compute performance metrics of a valuation series within [start, end].
+// Flows are assumed to happen at the start of the day, so the daily return is
+// V(t) / (V(t-1) + F(t)) - 1.
+func computePerformance(series []valuationPoint, start, end string) PerformanceMetrics {
+	metrics := PerformanceMetrics{StartDate: start, EndDate: end, MonthlyReturns: []monthlyReturn{}}
+
+	prevValue := 0.0
+	var window []valuationPoint
+	for _, p := range series {
+		if p.Date < start {
+			prevValue = p.Value
+			continue
+		}
+		if p.Date > end {
+			break
+		}
+		window = append(window, p)
+	}
+	metrics.StartValue = prevValue
+	metrics.EndValue = prevValue
+
+	startTime, _ := time.Parse("2006-01-02", start)
+	endTime, _ := time.Parse("2006-01-02", end)
+
+	var flows []cashFlow
+	if prevValue > 0 {
+		flows = append(flows, cashFlow{Date: startTime, Amount: -prevValue})
+	}
+
+	index := 1.0
+	peak, peakDate := 1.0, start
+	monthFactors := make(map[string]float64)
+	var months []string
+
+	for _, p := range window {
+		metrics.NetContributions += p.Flow
+		if p.Flow != 0 {
+			d, _ := time.Parse("2006-01-02", p.Date)
+			flows = append(flows, cashFlow{Date: d, Amount: -p.Flow})
+		}
+
+		base := prevValue + p.Flow
+		if base > 0 {
+			r := p.Value/base - 1
+			index *= 1 + r
+
+			month := p.Date[:7]
+			if _, ok := monthFactors[month]; !ok {
+				monthFactors[month] = 1
+				months = append(months, month)
+			}
+			monthFactors[month] *= 1 + r
+		}
+
+		if index > peak {
+			peak, peakDate = index, p.Date
+		}
+		if dd := (index/peak - 1) * 100; dd < metrics.MaxDrawdown {
+			metrics.MaxDrawdown = dd
+			metrics.MaxDrawdownPeakDate = peakDate
+			metrics.MaxDrawdownValleyDate = p.Date
+		}
+
+		prevValue = p.Value
+	}
+	metrics.EndValue = prevValue
+	metrics.TWR = (index - 1) * 100
+
+	days := endTime.Sub(startTime).Hours() / 24
+	if days >= 365 {
+		annualized := (math.Pow(index, 365/days) - 1) * 100
+		metrics.TWRAnnualized = &annualized
+	}
+
+	var returns []float64
+	for _, month := range months {
+		r := (monthFactors[month] - 1) * 100
+		metrics.MonthlyReturns = append(metrics.MonthlyReturns, monthlyReturn{Month: month, Return: r})
+		returns = append(returns, r)
+	}
+	if len(returns) >= 2 {
+		volatility := stdDev(returns) * math.Sqrt(12)
+		metrics.Volatility = &volatility
+	}
+
+	if metrics.EndValue > 0 {
+		flows = append(flows, cashFlow{Date: endTime, Amount: metrics.EndValue})
+	}
+	if rate, err := xirr(flows); err == nil {
+		rate *= 100
+		metrics.XIRR = &rate
+	}
+
+	return metrics
+}
+
+// Sample standard deviation
+func stdDev(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	mean := 0.0
+	for _, v := range values {
+		mean += v
+	}
+	mean /= float64(len(values))
+
+	sum := 0.0
+	for _, v := range values {
+		sum += (v - mean) * (v - mean)
+	}
+	return math.Sqrt(sum / float64(len(values)-1))
+}
+
+// Annual money-weighted return of a set of dated cash flows (negative =
+// money invested, positive = money received or final value). Uses Newton's
+// method with a bisection fallback.
+func xirr(flows []cashFlow) (float64, error) {
+	if len(flows) < 2 {
+		return 0, fmt.Errorf("not enough cash flows")
+	}
+	hasNegative, hasPositive := false, false
+	for _, f := range flows {
+		if f.Amount < 0 {
+			hasNegative = true
+		}
+		if f.Amount > 0 {
+			hasPositive = true
+		}
+	}
+	if !hasNegative || !hasPositive {
+		return 0, fmt.Errorf("cash flows must have both signs")
+	}
+
+	first := flows[0].Date
+	for _, f := range flows {
+		if f.Date.Before(first) {
+			first = f.Date
+		}
+	}
+
+	npv := func(rate float64) (float64, float64) {
+		value, derivative := 0.0, 0.0
+		for _, f := range flows {
+			years := f.Date.Sub(first).Hours() / 24 / 365
+			factor := math.Pow(1+rate, years)
+			value += f.Amount / factor
+			derivative -= years * f.Amount / (factor * (1 + rate))
+		}
+		return value, derivative
+	}
+
+	rate := 0.1
+	for i := 0; i < 100; i++ {
+		value, derivative := npv(rate)
+		if math.Abs(value) < 1e-7 {
+			return rate, nil
+		}
+		if derivative == 0 {
+			break
+		}
+		next := rate - value/derivative
+		if next <= -1 || math.IsNaN(next) || math.IsInf(next, 0) {
+			break
+		}
+		if math.Abs(next-rate) < 1e-10 {
+			return next, nil
+		}
+		rate = next
+	}
+
+	low, high := -0.9999, 10.0
+	lowValue, _ := npv(low)
+	highValue, _ := npv(high)
+	if lowValue*highValue > 0 {
+		return 0, fmt.Errorf("xirr did not converge")
+	}
+	for i := 0; i < 200; i++ {
+		mid := (low + high) / 2
+		midValue, _ := npv(mid)
+		if math.Abs(midValue) < 1e-7 {
+			return mid, nil
+		}
+		if lowValue*midValue < 0 {
+			high = mid
+		} else {
+			low, lowValue = mid, midValue
+		}
+	}
+	return (low + high) / 2, nil
+}
+
+// Benchmark returns over the same range and months as the portfolio
+func benchmarkPerformance(code, start, end string, months []monthlyReturn) gin.H {
+	factor, periods, err := accumulatedIndex(code, start, end)
+	if err != nil || periods == 0 {
+		return gin.H{"available": false}
+	}
+
+	monthly := []monthlyReturn{}
+	for _, m := range months {
+		t, _ := time.Parse("2006-01", m.Month)
+		monthEnd := t.AddDate(0, 1, -1).Format("2006-01-02")
+		monthStart := t.Format("2006-01-02")
+		if monthStart < start {
+			monthStart = start
+		}
+		if monthEnd > end {
+			monthEnd = end
+		}
+		f, n, err := accumulatedIndex(code, monthStart, monthEnd)
+		if err != nil || n == 0 {
+			continue
+		}
+		monthly = append(monthly, monthlyReturn{Month: m.Month, Return: (f - 1) * 100})
+	}
+
+	return gin.H{
+		"available":       true,
+		"accumulated":     (factor - 1) * 100,
+		"periods":         periods,
+		"monthly_returns": monthly,
+	}
+}
+
+// Get portfolio performance (TWR, XIRR, volatility, drawdown) overall and per
+// asset type, compared with CDI and Ibovespa
+func getInvestmentPerformance(c *gin.Context) {
+	// Positions in other currencies are converted into the base currency
+	userID := currentUserID(c)
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+	series, err := loadValuationSeries(fx, userID, currency, "")
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	start := c.Query("start")
+	end := c.DefaultQuery("end", time.Now().Format("2006-01-02"))
+	if start == "" {
+		if len(series) == 0 {
+			start = end
+		} else {
+			start = series[0].Date
+		}
+	}
+	if _, err := time.Parse("2006-01-02", start); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid start date. Use YYYY-MM-DD"})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", end); err != nil {
+		c.JSON(400, gin.H{"error": "Invalid end date. Use YYYY-MM-DD"})
+		return
+	}
+
+	portfolio := computePerformance(series, start, end)
+
+	rows, err := db.Query("SELECT DISTINCT type FROM investment_snapshots WHERE user_id = ? ORDER BY type", userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	var types []string
+	for rows.Next() {
+		var invType string
+		if err := rows.Scan(&invType); err == nil {
+			types = append(types, invType)
+		}
+	}
+	rows.Close()
+
+	byType := make(map[string]PerformanceMetrics)
+	for _, invType := range types {
+		typeSeries, err := loadValuationSeries(fx, userID, currency, invType)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		byType[invType] = computePerformance(typeSeries, start, end)
+	}
+
+	response := gin.H{
+		"start":     start,
+		"end":       end,
+		"currency":  currency,
+		"portfolio": portfolio,
+		"by_type":   byType,
+		"benchmarks": gin.H{
+			"CDI":  benchmarkPerformance("CDI", start, end, portfolio.MonthlyReturns),
+			"IBOV": benchmarkPerformance("IBOV", start, end, portfolio.MonthlyReturns),
+		},
+	}
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		response["missingRates"] = missing
+	}
+	c.JSON(200, response)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func approxEqual(a, b, tolerance float64) bool {
+	return math.Abs(a-b) <= tolerance
+}
+
+func TestXIRR(t *testing.T) {
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	day := func(n int) time.Time { return start.AddDate(0, 0, n) }
+
+	tests := []struct {
+		name    string
+		flows   []cashFlow
+		want    float64
+		wantErr bool
+	}{
+		{
+			name:  "ten percent in a year",
+			flows: []cashFlow{{day(0), -1000}, {day(365), 1100}},
+			want:  0.10,
+		},
+		{
+			name:  "no gain",
+			flows: []cashFlow{{day(0), -1000}, {day(365), 1000}},
+			want:  0,
+		},
+		{
+			name:  "loss",
+			flows: []cashFlow{{day(0), -1000}, {day(365), 900}},
+			want:  -0.10,
+		},
+		{
+			name:  "two contributions",
+			flows: []cashFlow{{day(0), -1000}, {day(365), -1000}, {day(730), 2310}},
+			want:  0.10,
+		},
+		{
+			name:  "flows out of order",
+			flows: []cashFlow{{day(730), 1210}, {day(0), -1000}},
+			want:  0.10,
+		},
+		{
+			name:    "single flow",
+			flows:   []cashFlow{{day(0), -1000}},
+			wantErr: true,
+		},
+		{
+			name:    "only money invested",
+			flows:   []cashFlow{{day(0), -1000}, {day(365), -500}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := xirr(tt.flows)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("xirr() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("xirr() error = %v", err)
+			}
+			if !approxEqual(got, tt.want, 1e-6) {
+				t.Errorf("xirr() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestComputePerformance(t *testing.T) {
+	tests := []struct {
+		name             string
+		series           []valuationPoint
+		start, end       string
+		wantTWR          float64
+		wantAnnualized   *float64
+		wantXIRR         *float64
+		wantStartValue   float64
+		wantEndValue     float64
+		wantContributed  float64
+		wantMaxDrawdown  float64
+		wantValleyDate   string
+		wantMonthReturns int
+	}{
+		{
+			name: "growth without flows",
+			series: []valuationPoint{
+				{Date: "2024-01-02", Value: 1000, Flow: 1000},
+				{Date: "2024-03-01", Value: 1100},
+			},
+			start: "2024-01-02", end: "2024-03-01",
+			wantTWR:          10,
+			wantEndValue:     1100,
+			wantContributed:  1000,
+			wantMonthReturns: 2,
+		},
+		{
+			name: "contributions do not count as returns",
+			series: []valuationPoint{
+				{Date: "2024-01-02", Value: 1000, Flow: 1000},
+				{Date: "2024-02-01", Value: 1100},
+				{Date: "2024-03-01", Value: 2100, Flow: 1000},
+				{Date: "2024-04-01", Value: 2310},
+			},
+			start: "2024-01-02", end: "2024-04-01",
+			wantTWR:          21,
+			wantEndValue:     2310,
+			wantContributed:  2000,
+			wantMonthReturns: 4,
+		},
+		{
+			name: "drawdown and recovery",
+			series: []valuationPoint{
+				{Date: "2024-01-02", Value: 1000, Flow: 1000},
+				{Date: "2024-01-15", Value: 800},
+				{Date: "2024-01-30", Value: 1200},
+			},
+			start: "2024-01-02", end: "2024-01-30",
+			wantTWR:          20,
+			wantEndValue:     1200,
+			wantContributed:  1000,
+			wantMaxDrawdown:  -20,
+			wantValleyDate:   "2024-01-15",
+			wantMonthReturns: 1,
+		},
+		{
+			name: "value before the window is the starting value",
+			series: []valuationPoint{
+				{Date: "2023-12-01", Value: 1000, Flow: 1000},
+				{Date: "2024-01-10", Value: 1100},
+			},
+			start: "2024-01-01", end: "2024-01-31",
+			wantTWR:          10,
+			wantStartValue:   1000,
+			wantEndValue:     1100,
+			wantMonthReturns: 1,
+		},
+		{
+			name: "two years are annualized",
+			series: []valuationPoint{
+				{Date: "2023-01-01", Value: 1000, Flow: 1000},
+				{Date: "2024-12-31", Value: 1210},
+			},
+			start: "2023-01-01", end: "2024-12-31",
+			wantTWR:          21,
+			wantAnnualized:   floatPointer(10),
+			wantXIRR:         floatPointer(10),
+			wantEndValue:     1210,
+			wantContributed:  1000,
+			wantMonthReturns: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := computePerformance(tt.series, tt.start, tt.end)
+			if !approxEqual(got.TWR, tt.wantTWR, 1e-6) {
+				t.Errorf("TWR = %v, want %v", got.TWR, tt.wantTWR)
+			}
+			if got.StartValue != tt.wantStartValue {
+				t.Errorf("StartValue = %v, want %v", got.StartValue, tt.wantStartValue)
+			}
+			if got.EndValue != tt.wantEndValue {
+				t.Errorf("EndValue = %v, want %v", got.EndValue, tt.wantEndValue)
+			}
+			if got.NetContributions != tt.wantContributed {
+				t.Errorf("NetContributions = %v, want %v", got.NetContributions, tt.wantContributed)
+			}
+			if !approxEqual(got.MaxDrawdown, tt.wantMaxDrawdown, 1e-6) {
+				t.Errorf("MaxDrawdown = %v, want %v", got.MaxDrawdown, tt.wantMaxDrawdown)
+			}
+			if got.MaxDrawdownValleyDate != tt.wantValleyDate {
+				t.Errorf("MaxDrawdownValleyDate = %q, want %q", got.MaxDrawdownValleyDate, tt.wantValleyDate)
+			}
+			if len(got.MonthlyReturns) != tt.wantMonthReturns {
+				t.Errorf("len(MonthlyReturns) = %d, want %d", len(got.MonthlyReturns), tt.wantMonthReturns)
+			}
+			if tt.wantAnnualized == nil {
+				if got.TWRAnnualized != nil {
+					t.Errorf("TWRAnnualized = %v, want nil", *got.TWRAnnualized)
+				}
+			} else if got.TWRAnnualized == nil || !approxEqual(*got.TWRAnnualized, *tt.wantAnnualized, 0.01) {
+				t.Errorf("TWRAnnualized = %v, want %v", got.TWRAnnualized, *tt.wantAnnualized)
+			}
+			if tt.wantXIRR != nil && (got.XIRR == nil || !approxEqual(*got.XIRR, *tt.wantXIRR, 0.01)) {
+				t.Errorf("XIRR = %v, want %v", got.XIRR, *tt.wantXIRR)
+			}
+		})
+	}
+}
+
+func floatPointer(v float64) *float64 {
+	return &v
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var riskProfiles = []string{"conservador", "moderado", "arrojado"}
+
+func isRiskProfile(profile string) bool {
+	for _, p := range riskProfiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// CatalogItem is an asset that can be recommended. Profiles lists the risk
+// profiles it is suitable for; an empty list means every profile.
+type CatalogItem struct {
+	ID        int      `json:"id"`
+	Ticker    string   `json:"ticker"`
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Reason    string   `json:"reason"`
+	RiskLevel string   `json:"risk_level"`
+	Profiles  []string `json:"profiles"`
+	Tags      []string `json:"tags"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"created_at"`
+	UpdatedAt string   `json:"updated_at"`
+}
+
+// RecommendationRule selects catalog items to recommend. A rule applies when
+// the user's profile is eligible and the portfolio is below MaxTypePercent
+// for AssetType (the user's allocation target takes precedence when set).
+type RecommendationRule struct {
+	ID             int      `json:"id"`
+	Name           string   `json:"name"`
+	AssetType      *string  `json:"asset_type"`
+	Tag            *string  `json:"tag"`
+	MaxTypePercent *float64 `json:"max_type_percent"`
+	OnlyWhenEmpty  bool     `json:"only_when_empty"`
+	Profiles       []string `json:"profiles"`
+	Category       string   `json:"category"`
+	Priority       string   `json:"priority"`
+	Reason         *string  `json:"reason"`
+	MaxItems       int      `json:"max_items"`
+	SortOrder      int      `json:"sort_order"`
+	Active         bool     `json:"active"`
+	CreatedAt      string   `json:"created_at"`
+	UpdatedAt      string   `json:"updated_at"`
+}
+
+// Lists are stored as JSON arrays in TEXT columns
+func encodeList(values []string) string {
+	cleaned := []string{}
+	for _, v := range values {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if v != "" {
+			cleaned = append(cleaned, v)
+		}
+	}
+	data, _ := json.Marshal(cleaned)
+	return string(data)
+}
+
+func decodeList(value sql.NullString) []string {
+	list := []string{}
+	if value.Valid && value.String != "" {
+		json.Unmarshal([]byte(value.String), &list)
+	}
+	return list
+}
+
+func containsString(list []string, value string) bool {
+	for _, v := range list {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func seedRecommendationCatalog() {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM recommendation_catalog").Scan(&count); err != nil || count > 0 {
+		return
+	}
+
+	all := []string{}
+	notConservative := []string{"moderado", "arrojado"}
+	defaultCatalog := []CatalogItem{
+		{Ticker: "MXRF11", Name: "Maxi Renda", Type: "FII", RiskLevel: "baixo", Profiles: all, Tags: []string{"renda", "primeiro-investimento"},
+			Reason: "Um dos maiores FIIs do Brasil, alta liquidez e histórico consistente de dividendos. Ideal para começar ou diversificar em FIIs."},
+		{Ticker: "HGLG11", Name: "CSHG Logística", Type: "FII", RiskLevel: "baixo", Profiles: all, Tags: []string{"renda", "logistica"},
+			Reason: "Foco em galpões logísticos, setor em crescimento. Boa distribuição de dividendos e gestão sólida."},
+		{Ticker: "XPLG11", Name: "XP Log", Type: "FII", RiskLevel: "moderado", Profiles: notConservative, Tags: []string{"renda", "logistica"},
+			Reason: "FII de logística com forte gestão da XP. Boa diversificação geográfica e histórico de valorização."},
+		{Ticker: "KNRI11", Name: "Kinea Rendimentos Imobiliários", Type: "FII", RiskLevel: "baixo", Profiles: all, Tags: []string{"renda"},
+			Reason: "FII diversificado com foco em renda. Boa gestão e distribuição regular de proventos."},
+		{Ticker: "VISC11", Name: "Vinci Shopping Centers", Type: "FII", RiskLevel: "moderado", Profiles: notConservative, Tags: []string{"renda", "shoppings"},
+			Reason: "Exposição ao setor de shoppings, setor em recuperação. Potencial de valorização e dividendos."},
+		{Ticker: "ITUB4", Name: "Itaú Unibanco", Type: "Ação", RiskLevel: "baixo", Profiles: all, Tags: []string{"dividendos", "blue-chip", "bancos"},
+			Reason: "Maior banco privado do Brasil, alta liquidez e dividendos consistentes. Ação blue chip, ideal para carteira conservadora."},
+		{Ticker: "PETR4", Name: "Petrobras", Type: "Ação", RiskLevel: "alto", Profiles: notConservative, Tags: []string{"dividendos", "energia"},
+			Reason: "Maior empresa do Brasil, alta liquidez. Boa para diversificação e exposição ao setor de energia."},
+		{Ticker: "VALE3", Name: "Vale", Type: "Ação", RiskLevel: "alto", Profiles: notConservative, Tags: []string{"dividendos", "commodities"},
+			Reason: "Líder mundial em mineração de ferro, alta liquidez e dividendos. Exposta ao ciclo de commodities."},
+		{Ticker: "WEGE3", Name: "WEG", Type: "Ação", RiskLevel: "moderado", Profiles: notConservative, Tags: []string{"crescimento", "industria"},
+			Reason: "Empresa de tecnologia industrial, forte crescimento e boa gestão. Boa para crescimento de longo prazo."},
+		{Ticker: "BBDC4", Name: "Bradesco", Type: "Ação", RiskLevel: "moderado", Profiles: all, Tags: []string{"dividendos", "bancos"},
+			Reason: "Um dos maiores bancos do Brasil, histórico sólido de dividendos. Boa para renda e crescimento."},
+		{Ticker: "BOVA11", Name: "iShares Ibovespa", Type: "ETF", RiskLevel: "moderado", Profiles: all, Tags: []string{"indice"},
+			Reason: "ETF que replica o Ibovespa, diversificação automática nas principais ações. Ideal para quem quer exposição ampla ao mercado."},
+		{Ticker: "IVVB11", Name: "iShares S&P 500", Type: "ETF", RiskLevel: "moderado", Profiles: all, Tags: []string{"indice", "internacional"},
+			Reason: "Exposição ao mercado americano, diversificação internacional. Boa para reduzir risco geográfico."},
+		{Ticker: "SMAL11", Name: "iShares Small Cap", Type: "ETF", RiskLevel: "alto", Profiles: []string{"arrojado"}, Tags: []string{"indice", "crescimento"},
+			Reason: "ETF de pequenas empresas, maior potencial de crescimento. Boa para quem busca mais risco/retorno."},
+	}
+
+	for _, item := range defaultCatalog {
+		_, err := db.Exec(
+			`INSERT INTO recommendation_catalog (ticker, name, type, reason, risk_level, profiles, tags)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			item.Ticker, item.Name, item.Type, item.Reason, item.RiskLevel, encodeList(item.Profiles), encodeList(item.Tags),
+		)
+		if err != nil {
+			log.Printf("Failed to insert catalog item %s: %v", item.Ticker, err)
+		}
+	}
+}
+
+func seedRecommendationRules() {
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM recommendation_rules").Scan(&count); err != nil || count > 0 {
+		return
+	}
+
+	strPtr := func(s string) *string { return &s }
+	floatPtr := func(f float64) *float64 { return &f }
+	defaultRules := []RecommendationRule{
+		{Name: "Primeiro investimento", Tag: strPtr("primeiro-investimento"), OnlyWhenEmpty: true,
+			Category: "Primeiro Investimento", Priority: "alta", MaxItems: 1, SortOrder: 0,
+			Reason: strPtr("Excelente para começar: um dos maiores e mais líquidos FIIs do Brasil, com histórico consistente de dividendos.")},
+		{Name: "Diversificação em FIIs", AssetType: strPtr("FII"), MaxTypePercent: floatPtr(40),
+			Category: "Diversificação em FIIs", Priority: "alta", SortOrder: 10},
+		{Name: "Diversificação em Ações", AssetType: strPtr("Ação"), MaxTypePercent: floatPtr(30),
+			Category: "Diversificação em Ações", Priority: "alta", SortOrder: 20},
+		{Name: "Diversificação com ETFs", AssetType: strPtr("ETF"), MaxTypePercent: floatPtr(20),
+			Category: "Diversificação com ETFs", Priority: "média", SortOrder: 30},
+	}
+
+	for _, rule := range defaultRules {
+		_, err := db.Exec(
+			`INSERT INTO recommendation_rules (name, asset_type, tag, max_type_percent, only_when_empty, profiles, category, priority, reason, max_items, sort_order)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			rule.Name, rule.AssetType, rule.Tag, rule.MaxTypePercent, rule.OnlyWhenEmpty, encodeList(rule.Profiles),
+			rule.Category, rule.Priority, rule.Reason, rule.MaxItems, rule.SortOrder,
+		)
+		if err != nil {
+			log.Printf("Failed to insert recommendation rule %s: %v", rule.Name, err)
+		}
+	}
+}
+
+func scanCatalogItem(scanner interface{ Scan(...interface{}) error }) (CatalogItem, error) {
+	var item CatalogItem
+	var profiles, tags sql.NullString
+	err := scanner.Scan(
+		&item.ID, &item.Ticker, &item.Name, &item.Type, &item.Reason, &item.RiskLevel,
+		&profiles, &tags, &item.Active, &item.CreatedAt, &item.UpdatedAt,
+	)
+	item.Profiles = decodeList(profiles)
+	item.Tags = decodeList(tags)
+	return item, err
+}
+
+const catalogColumns = "id, ticker, name, type, reason, risk_level, profiles, tags, active, created_at, updated_at"
+
+func loadCatalog(activeOnly bool) ([]CatalogItem, error) {
+	query := "SELECT " + catalogColumns + " FROM recommendation_catalog"
+	if activeOnly {
+		query += " WHERE active = 1"
+	}
+	query += " ORDER BY type, id"
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []CatalogItem{}
+	for rows.Next() {
+		item, err := scanCatalogItem(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+func scanRecommendationRule(scanner interface{ Scan(...interface{}) error }) (RecommendationRule, error) {
+	var rule RecommendationRule
+	var profiles sql.NullString
+	err := scanner.Scan(
+		&rule.ID, &rule.Name, &rule.AssetType, &rule.Tag, &rule.MaxTypePercent, &rule.OnlyWhenEmpty,
+		&profiles, &rule.Category, &rule.Priority, &rule.Reason, &rule.MaxItems, &rule.SortOrder,
+		&rule.Active, &rule.CreatedAt, &rule.UpdatedAt,
+	)
+	rule.Profiles = decodeList(profiles)
+	return rule, err
+}
+
+const recommendationRuleColumns = `id, name, asset_type, tag, max_type_percent, only_when_empty, profiles,
+	category, priority, reason, max_items, sort_order, active, created_at, updated_at`
+
+func loadRecommendationRules(activeOnly bool) ([]RecommendationRule, error) {
+	query := "SELECT " + recommendationRuleColumns + " FROM recommendation_rules"
+	if activeOnly {
+		query += " WHERE active = 1"
+	}
+	query += " ORDER BY sort_order, id"
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := []RecommendationRule{}
+	for rows.Next() {
+		rule, err := scanRecommendationRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+// Current risk profile of a user, "moderado" until they pick one
+func loadRiskProfile(userID int) string {
+	var profile string
+	if err := db.QueryRow("SELECT risk_profile FROM investor_profile WHERE user_id = ? LIMIT 1", userID).Scan(&profile); err != nil {
+		return "moderado"
+	}
+	return profile
+}
+
+// Get investor profile
+func getInvestorProfile(c *gin.Context) {
+	c.JSON(200, gin.H{"risk_profile": loadRiskProfile(currentUserID(c)), "profiles": riskProfiles})
+}
+
+// Save investor profile
+func saveInvestorProfile(c *gin.Context) {
+	var req struct {
+		RiskProfile string `json:"risk_profile"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.RiskProfile = strings.ToLower(strings.TrimSpace(req.RiskProfile))
+	if !isRiskProfile(req.RiskProfile) {
+		c.JSON(400, gin.H{"error": "risk_profile must be one of: " + strings.Join(riskProfiles, ", ")})
+		return
+	}
+
+	userID := currentUserID(c)
+	var exists bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM investor_profile WHERE user_id = ?)", userID).Scan(&exists)
+
+	var err error
+	if exists {
+		_, err = db.Exec("UPDATE investor_profile SET risk_profile = ?, updated_at = CURRENT_TIMESTAMP WHERE user_id = ?", req.RiskProfile, userID)
+	} else {
+		_, err = db.Exec("INSERT INTO investor_profile (user_id, risk_profile) VALUES (?, ?)", userID, req.RiskProfile)
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Investor profile saved successfully", "risk_profile": req.RiskProfile})
+}
+
+// Get recommendation catalog
+func getRecommendationCatalog(c *gin.Context) {
+	items, err := loadCatalog(false)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, items)
+}
+
+func validateCatalogItem(item *CatalogItem) string {
+	item.Ticker = strings.ToUpper(strings.TrimSpace(item.Ticker))
+	item.Name = strings.TrimSpace(item.Name)
+	if item.Ticker == "" || item.Name == "" || item.Type == "" {
+		return "ticker, name and type are required"
+	}
+	if item.RiskLevel == "" {
+		item.RiskLevel = "moderado"
+	}
+	if item.RiskLevel != "baixo" && item.RiskLevel != "moderado" && item.RiskLevel != "alto" {
+		return "risk_level must be one of: baixo, moderado, alto"
+	}
+	for _, p := range item.Profiles {
+		if !isRiskProfile(strings.ToLower(p)) {
+			return "invalid profile: " + p
+		}
+	}
+	return ""
+}
+
+// Create catalog item
+func createCatalogItem(c *gin.Context) {
+	item := CatalogItem{Active: true}
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateCatalogItem(&item); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	result, err := db.Exec(
+		`INSERT INTO recommendation_catalog (ticker, name, type, reason, risk_level, profiles, tags, active)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		item.Ticker, item.Name, item.Type, item.Reason, item.RiskLevel,
+		encodeList(item.Profiles), encodeList(item.Tags), item.Active,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	created, err := scanCatalogItem(db.QueryRow("SELECT "+catalogColumns+" FROM recommendation_catalog WHERE id = ?", id))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, created)
+}
+
+// Update catalog item
+func updateCatalogItem(c *gin.Context) {
+	id := c.Param("id")
+	item := CatalogItem{Active: true}
+	if err := c.ShouldBindJSON(&item); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateCatalogItem(&item); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	result, err := db.Exec(
+		`UPDATE recommendation_catalog SET
+		 ticker = ?, name = ?, type = ?, reason = ?, risk_level = ?, profiles = ?, tags = ?, active = ?,
+		 updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ?`,
+		item.Ticker, item.Name, item.Type, item.Reason, item.RiskLevel,
+		encodeList(item.Profiles), encodeList(item.Tags), item.Active, id,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Catalog item not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Catalog item updated successfully"})
+}
+
+// Delete catalog item
+func deleteCatalogItem(c *gin.Context) {
+	result, err := db.Exec("DELETE FROM recommendation_catalog WHERE id = ?", c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Catalog item not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Catalog item deleted successfully"})
+}
+
+// Get recommendation rules
+func getRecommendationRules(c *gin.Context) {
+	rules, err := loadRecommendationRules(false)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, rules)
+}
+
+func validateRecommendationRule(rule *RecommendationRule) string {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" || rule.Category == "" {
+		return "name and category are required"
+	}
+	if rule.Priority == "" {
+		rule.Priority = "média"
+	}
+	if rule.MaxTypePercent != nil && (*rule.MaxTypePercent < 0 || *rule.MaxTypePercent > 100) {
+		return "max_type_percent must be between 0 and 100"
+	}
+	for _, p := range rule.Profiles {
+		if !isRiskProfile(strings.ToLower(p)) {
+			return "invalid profile: " + p
+		}
+	}
+	return ""
+}
+
+// Create recommendation rule
+func createRecommendationRule(c *gin.Context) {
+	rule := RecommendationRule{Active: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateRecommendationRule(&rule); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	result, err := db.Exec(
+		`INSERT INTO recommendation_rules (name, asset_type, tag, max_type_percent, only_when_empty, profiles, category, priority, reason, max_items, sort_order, active)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		rule.Name, rule.AssetType, rule.Tag, rule.MaxTypePercent, rule.OnlyWhenEmpty, encodeList(rule.Profiles),
+		rule.Category, rule.Priority, rule.Reason, rule.MaxItems, rule.SortOrder, rule.Active,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	created, err := scanRecommendationRule(db.QueryRow("SELECT "+recommendationRuleColumns+" FROM recommendation_rules WHERE id = ?", id))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, created)
+}
+
+// Update recommendation rule
+func updateRecommendationRule(c *gin.Context) {
+	id := c.Param("id")
+	rule := RecommendationRule{Active: true}
+	if err := c.ShouldBindJSON(&rule); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	if msg := validateRecommendationRule(&rule); msg != "" {
+		c.JSON(400, gin.H{"error": msg})
+		return
+	}
+
+	result, err := db.Exec(
+		`UPDATE recommendation_rules SET
+		 name = ?, asset_type = ?, tag = ?, max_type_percent = ?, only_when_empty = ?, profiles = ?,
+		 category = ?, priority = ?, reason = ?, max_items = ?, sort_order = ?, active = ?,
+		 updated_at = CURRENT_TIMESTAMP
+		 WHERE id = ?`,
+		rule.Name, rule.AssetType, rule.Tag, rule.MaxTypePercent, rule.OnlyWhenEmpty, encodeList(rule.Profiles),
+		rule.Category, rule.Priority, rule.Reason, rule.MaxItems, rule.SortOrder, rule.Active, id,
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Recommendation rule not found"})
+		return
+	}
+
+	c.JSON(200, gin.H{"message": "Recommendation rule updated successfully"})
+}
+
+// Delete recommendation rule
+func deleteRecommendationRule(c *gin.Context) {
+	result, err := db.Exec("DELETE FROM recommendation_rules WHERE id = ?", c.Param("id"))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Recommendation rule not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Recommendation rule deleted successfully"})
+}
+
+// Get investment recommendations by evaluating the recommendation rules
+// against the user's risk profile and current allocation
+func getInvestmentRecommendations(c *gin.Context) {
+	userID := currentUserID(c)
+	rows, err := db.Query(`
+		SELECT ticker, type, COALESCE(currency, 'BRL'), total_invested
+		FROM investments
+		WHERE user_id = ?
+	`, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	type holding struct {
+		invType, currency string
+		invested          float64
+	}
+	ownedTickers := make(map[string]bool)
+	var holdings []holding
+	for rows.Next() {
+		var ticker string
+		var h holding
+		rows.Scan(&ticker, &h.invType, &h.currency, &h.invested)
+		ownedTickers[strings.ToUpper(ticker)] = true
+		holdings = append(holdings, h)
+	}
+	rows.Close()
+
+	// Weigh the types in the base currency
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+	today := time.Now().Format("2006-01-02")
+	typeDistribution := make(map[string]float64)
+	totalInvested := 0.0
+	for _, h := range holdings {
+		invested := fx.convert(h.invested, h.currency, currency, today)
+		typeDistribution[h.invType] += invested
+		totalInvested += invested
+	}
+
+	typePercentages := make(map[string]float64)
+	if totalInvested > 0 {
+		for invType, amount := range typeDistribution {
+			typePercentages[invType] = (amount / totalInvested) * 100
+		}
+	}
+
+	typeTargets, err := loadTypeTargets(userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	rules, err := loadRecommendationRules(true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	catalog, err := loadCatalog(true)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile := c.DefaultQuery("profile", loadRiskProfile(userID))
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit <= 0 {
+		limit = 10
+	}
+
+	recommendations := []gin.H{}
+	recommended := make(map[string]bool)
+	typeLimits := make(map[string]float64)
+
+	for _, rule := range rules {
+		if len(rule.Profiles) > 0 && !containsString(rule.Profiles, profile) {
+			continue
+		}
+		if rule.OnlyWhenEmpty && totalInvested > 0 {
+			continue
+		}
+
+		if rule.AssetType != nil && *rule.AssetType != "" {
+			limitPercent := rule.MaxTypePercent
+			if target, ok := typeTargets[*rule.AssetType]; ok {
+				limitPercent = &target
+			}
+			if limitPercent != nil {
+				typeLimits[*rule.AssetType] = *limitPercent
+				if totalInvested > 0 && typePercentages[*rule.AssetType] >= *limitPercent {
+					continue
+				}
+			}
+		}
+
+		added := 0
+		for _, item := range catalog {
+			if rule.MaxItems > 0 && added >= rule.MaxItems {
+				break
+			}
+			if rule.AssetType != nil && *rule.AssetType != "" && item.Type != *rule.AssetType {
+				continue
+			}
+			if rule.Tag != nil && *rule.Tag != "" && !containsString(item.Tags, *rule.Tag) {
+				continue
+			}
+			if len(item.Profiles) > 0 && !containsString(item.Profiles, profile) {
+				continue
+			}
+			if ownedTickers[item.Ticker] || recommended[item.Ticker] {
+				continue
+			}
+
+			reason := item.Reason
+			if rule.Reason != nil && *rule.Reason != "" {
+				reason = *rule.Reason
+			}
+
+			recommendations = append(recommendations, gin.H{
+				"ticker":     item.Ticker,
+				"name":       item.Name,
+				"type":       item.Type,
+				"reason":     reason,
+				"priority":   rule.Priority,
+				"category":   rule.Category,
+				"risk_level": item.RiskLevel,
+				"tags":       item.Tags,
+				"rule":       rule.Name,
+			})
+			recommended[item.Ticker] = true
+			added++
+		}
+	}
+
+	if len(recommendations) > limit {
+		recommendations = recommendations[:limit]
+	}
+
+	response := gin.H{
+		"recommendations": recommendations,
+		"portfolio_analysis": gin.H{
+			"currency":          currency,
+			"total_invested":    totalInvested,
+			"type_distribution": typePercentages,
+			"type_targets":      typeLimits,
+			"owned_count":       len(ownedTickers),
+			"risk_profile":      profile,
+		},
+	}
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		response["missingRates"] = missing
+	}
+	c.JSON(200, response)
+}
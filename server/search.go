@@ -0,0 +1,220 @@
+package main
+
+import (
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Full-text search over transactions. transactions_fts indexes the
+// description, category name and account name of every transaction (rowid =
+// transaction id) and is kept in sync by triggers. Its tokenizer folds case
+// and removes accents, so "farmacia" finds "Farmácia".
+//
+// FTS5 is only compiled into go-sqlite3 with the sqlite_fts5 build tag
+// (go build -tags sqlite_fts5). Without it search falls back to LIKE, which
+// matches substrings but is neither accent-insensitive nor ranked.
+
+var ftsAvailable bool
+
+const maxSearchResults = 200
+
+var searchTriggerNames = []string{
+	"transactions_fts_insert", "transactions_fts_update", "transactions_fts_delete",
+	"categories_fts_rename", "accounts_fts_rename",
+}
+
+var searchTriggers = []string{
+	`CREATE TRIGGER IF NOT EXISTS transactions_fts_insert AFTER INSERT ON transactions BEGIN
+		INSERT INTO transactions_fts (rowid, description, category, account) VALUES (
+			new.id, COALESCE(new.description, ''),
+			COALESCE((SELECT name FROM categories WHERE id = new.category_id), ''),
+			COALESCE((SELECT name FROM accounts WHERE id = new.account_id), '')
+		);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_fts_update AFTER UPDATE OF description, category_id, account_id ON transactions BEGIN
+		DELETE FROM transactions_fts WHERE rowid = old.id;
+		INSERT INTO transactions_fts (rowid, description, category, account) VALUES (
+			new.id, COALESCE(new.description, ''),
+			COALESCE((SELECT name FROM categories WHERE id = new.category_id), ''),
+			COALESCE((SELECT name FROM accounts WHERE id = new.account_id), '')
+		);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS transactions_fts_delete AFTER DELETE ON transactions BEGIN
+		DELETE FROM transactions_fts WHERE rowid = old.id;
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS categories_fts_rename AFTER UPDATE OF name ON categories BEGIN
+		UPDATE transactions_fts SET category = new.name WHERE rowid IN (SELECT id FROM transactions WHERE category_id = new.id);
+	END`,
+	`CREATE TRIGGER IF NOT EXISTS accounts_fts_rename AFTER UPDATE OF name ON accounts BEGIN
+		UPDATE transactions_fts SET account = new.name WHERE rowid IN (SELECT id FROM transactions WHERE account_id = new.id);
+	END`,
+}
+
+// Create the search index and its triggers. The index is rebuilt whenever
+// the triggers have to be created: on the first run, and after a server built
+// without FTS5 wrote to the database.
+func initSearchIndex() {
+	db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&ftsAvailable)
+	if !ftsAvailable {
+		log.Println("Full-text search unavailable: build with -tags sqlite_fts5 to enable it")
+		// Triggers left by an FTS5 build would make every write to
+		// transactions fail
+		for _, trigger := range searchTriggerNames {
+			if _, err := db.Exec("DROP TRIGGER IF EXISTS " + trigger); err != nil {
+				log.Fatal("Failed to drop search trigger:", err)
+			}
+		}
+		return
+	}
+
+	_, err := db.Exec(`CREATE VIRTUAL TABLE IF NOT EXISTS transactions_fts USING fts5(
+		description, category, account,
+		tokenize = 'unicode61 remove_diacritics 2'
+	)`)
+	if err != nil {
+		log.Fatal("Failed to create search index:", err)
+	}
+
+	var inSync bool
+	db.QueryRow("SELECT EXISTS(SELECT 1 FROM sqlite_master WHERE type = 'trigger' AND name = 'transactions_fts_insert')").Scan(&inSync)
+
+	for _, trigger := range searchTriggers {
+		if _, err := db.Exec(trigger); err != nil {
+			log.Fatal("Failed to create search trigger:", err)
+		}
+	}
+	if inSync {
+		return
+	}
+
+	statements := []string{
+		"DELETE FROM transactions_fts",
+		`INSERT INTO transactions_fts (rowid, description, category, account)
+			SELECT t.id, COALESCE(t.description, ''), COALESCE(c.name, ''), COALESCE(a.name, '')
+			FROM transactions t
+			LEFT JOIN categories c ON c.id = t.category_id
+			LEFT JOIN accounts a ON a.id = t.account_id`,
+	}
+	for _, stmt := range statements {
+		if _, err := db.Exec(stmt); err != nil {
+			log.Fatal("Failed to build search index:", err)
+		}
+	}
+	log.Println("Built the transaction search index")
+}
+
+// FTS5 query for what the user typed: every word must match, as a prefix.
+// Words are quoted so FTS5 operators and punctuation are taken literally.
+func ftsQuery(q string) string {
+	var terms []string
+	for _, word := range strings.Fields(q) {
+		word = strings.ReplaceAll(word, `"`, "")
+		if word != "" {
+			terms = append(terms, `"`+word+`"*`)
+		}
+	}
+	return strings.Join(terms, " ")
+}
+
+type SearchResult struct {
+	Transaction
+	Rank float64 `json:"rank"`
+}
+
+// Search the transactions the user can see. Results are ranked by relevance,
+// descriptions weighing more than category and account names, then by date.
+func searchTransactions(c *gin.Context) {
+	q := strings.TrimSpace(c.Query("q"))
+	if q == "" {
+		c.JSON(400, gin.H{"error": "Query parameter 'q' is required"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit <= 0 || limit > maxSearchResults {
+		limit = 50
+	}
+	userID := currentUserID(c)
+
+	columns := `
+		t.id, t.account_id, t.category_id, t.type, t.amount, t.currency, t.description, t.date, t.created_at,
+		a.name, a.color, c.name, c.color, c.icon, t.payee_id, p.name`
+	visible := "t.deleted_at IS NULL AND t.account_id IN (" + visibleAccountsQuery + ")"
+
+	var query string
+	var args []interface{}
+	if ftsAvailable {
+		match := ftsQuery(q)
+		if match == "" {
+			c.JSON(200, []SearchResult{})
+			return
+		}
+		query = `
+			SELECT ` + columns + `, bm25(transactions_fts, 10.0, 4.0, 1.0) AS score
+			FROM transactions_fts f
+			JOIN transactions t ON t.id = f.rowid
+			LEFT JOIN accounts a ON t.account_id = a.id
+			LEFT JOIN categories c ON t.category_id = c.id
+			LEFT JOIN payees p ON t.payee_id = p.id
+			WHERE transactions_fts MATCH ? AND ` + visible + `
+			ORDER BY score, t.date DESC, t.id DESC
+			LIMIT ?`
+		args = []interface{}{match, userID, userID, limit}
+	} else {
+		query = `
+			SELECT ` + columns + `, 0 AS score
+			FROM transactions t
+			LEFT JOIN accounts a ON t.account_id = a.id
+			LEFT JOIN categories c ON t.category_id = c.id
+			LEFT JOIN payees p ON t.payee_id = p.id
+			WHERE ` + visible
+		args = []interface{}{userID, userID}
+		escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+		for _, word := range strings.Fields(q) {
+			pattern := "%" + escaper.Replace(word) + "%"
+			query += ` AND (t.description LIKE ? ESCAPE '\' OR c.name LIKE ? ESCAPE '\' OR a.name LIKE ? ESCAPE '\')`
+			args = append(args, pattern, pattern, pattern)
+		}
+		query += " ORDER BY t.date DESC, t.id DESC LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	for rows.Next() {
+		var r SearchResult
+		err := rows.Scan(
+			&r.ID, &r.AccountID, &r.CategoryID, &r.Type, &r.Amount, &r.Currency, &r.Description, &r.Date, &r.CreatedAt,
+			&r.AccountName, &r.AccountColor, &r.CategoryName, &r.CategoryColor, &r.CategoryIcon, &r.PayeeID, &r.PayeeName, &r.Rank,
+		)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		if ftsAvailable {
+			// bm25 is lower for better matches; expose it as higher is better
+			r.Rank = -r.Rank
+		}
+		results = append(results, r)
+	}
+	rows.Close()
+
+	transactions := make([]*Transaction, len(results))
+	for i := range results {
+		transactions[i] = &results[i].Transaction
+	}
+	if err := attachTransactionDetails(userID, transactions); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(200, results)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// A transaction can be split into lines with their own category and amount,
+// like a supermarket receipt covering food, cleaning and pharmacy. The lines
+// add up to the transaction's amount, which is what moves the account
+// balance; reports by category count the lines instead of the transaction.
+
+type TransactionSplit struct {
+	ID            int     `json:"id"`
+	CategoryID    *int    `json:"category_id"`
+	Amount        float64 `json:"amount"`
+	Description   *string `json:"description"`
+	CategoryName  *string `json:"category_name"`
+	CategoryColor *string `json:"category_color"`
+	CategoryIcon  *string `json:"category_icon"`
+}
+
+// Every transaction as the lines reports count: one row per split line, or
+// the transaction itself when it is not split. Columns are named like the
+// ones of transactions, so queries can select from it instead.
+const transactionLinesQuery = `
+	SELECT t.id, t.user_id, t.account_id, t.type, t.currency, t.date, t.deleted_at,
+		COALESCE(s.description, t.description) AS description, s.category_id, s.amount, t.payee_id
+	FROM transactions t
+	JOIN transaction_splits s ON s.transaction_id = t.id
+	UNION ALL
+	SELECT t.id, t.user_id, t.account_id, t.type, t.currency, t.date, t.deleted_at,
+		t.description, t.category_id, t.amount, t.payee_id
+	FROM transactions t
+	WHERE NOT EXISTS (SELECT 1 FROM transaction_splits s WHERE s.transaction_id = t.id)`
+
+// Check the split lines given on a transaction: at least two, each with a
+// positive amount and a category the user can see, adding up to the amount
+func validateSplits(splits []TransactionSplit, amount float64, userID int) error {
+	if len(splits) == 1 {
+		return fmt.Errorf("A split needs at least two lines")
+	}
+	total := 0.0
+	for i, s := range splits {
+		if s.Amount <= 0 {
+			return fmt.Errorf("splits[%d]: amount must be positive", i)
+		}
+		if !categoryVisible(s.CategoryID, userID) {
+			return fmt.Errorf("splits[%d]: Category not found", i)
+		}
+		total += s.Amount
+	}
+	if len(splits) > 0 && !sameAmount(total, amount) {
+		return fmt.Errorf("Splits add up to %.2f, not to the amount of %.2f", total, amount)
+	}
+	return nil
+}
+
+// Amounts are compared to the cent
+func sameAmount(a, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
+
+// Replace the split lines of a transaction; no lines leaves it unsplit
+func setTransactionSplits(e execer, transactionID interface{}, splits []TransactionSplit) error {
+	if _, err := e.Exec("DELETE FROM transaction_splits WHERE transaction_id = ?", transactionID); err != nil {
+		return err
+	}
+	for _, s := range splits {
+		_, err := e.Exec(
+			"INSERT INTO transaction_splits (transaction_id, category_id, amount, description) VALUES (?, ?, ?, ?)",
+			transactionID, s.CategoryID, s.Amount, s.Description,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The split lines of each of the transactions, by transaction ID
+func transactionSplits(transactionIDs []int) (map[int][]TransactionSplit, error) {
+	splits := make(map[int][]TransactionSplit)
+	const batch = 500
+	for start := 0; start < len(transactionIDs); start += batch {
+		end := start + batch
+		if end > len(transactionIDs) {
+			end = len(transactionIDs)
+		}
+		args := make([]interface{}, 0, end-start)
+		for _, id := range transactionIDs[start:end] {
+			args = append(args, id)
+		}
+
+		rows, err := db.Query(`
+			SELECT s.transaction_id, s.id, s.category_id, s.amount, s.description, c.name, c.color, c.icon
+			FROM transaction_splits s
+			LEFT JOIN categories c ON c.id = s.category_id
+			WHERE s.transaction_id IN (`+placeholders(end-start)+`)
+			ORDER BY s.id
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var transactionID int
+			var s TransactionSplit
+			err := rows.Scan(&transactionID, &s.ID, &s.CategoryID, &s.Amount, &s.Description, &s.CategoryName, &s.CategoryColor, &s.CategoryIcon)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			splits[transactionID] = append(splits[transactionID], s)
+		}
+		rows.Close()
+	}
+	return splits, nil
+}
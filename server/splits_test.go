@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// Replace the database with an in-memory one for the duration of a test
+func useTestDB(t *testing.T, statements ...string) {
+	t.Helper()
+	testDB, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testDB.SetMaxOpenConns(1)
+	for _, statement := range statements {
+		if _, err := testDB.Exec(statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+
+	previous := db
+	db = testDB
+	t.Cleanup(func() {
+		db = previous
+		testDB.Close()
+	})
+}
+
+func TestValidateSplits(t *testing.T) {
+	useTestDB(t,
+		`CREATE TABLE categories (id INTEGER PRIMARY KEY, name TEXT, user_id INTEGER)`,
+		`INSERT INTO categories (id, name, user_id) VALUES (1, 'Mercado', NULL), (2, 'Pets', 1), (3, 'Hobbies', 2)`,
+	)
+	category := func(id int) *int { return &id }
+	line := func(categoryID *int, amount float64) TransactionSplit {
+		return TransactionSplit{CategoryID: categoryID, Amount: amount}
+	}
+
+	tests := []struct {
+		name   string
+		splits []TransactionSplit
+		amount float64
+		want   string
+	}{
+		{
+			name:   "no splits",
+			amount: 100,
+		},
+		{
+			name:   "shared and own categories",
+			splits: []TransactionSplit{line(category(1), 60), line(category(2), 40)},
+			amount: 100,
+		},
+		{
+			name:   "line without a category",
+			splits: []TransactionSplit{line(nil, 60), line(category(1), 40)},
+			amount: 100,
+		},
+		{
+			name:   "rounded to the cent",
+			splits: []TransactionSplit{line(category(1), 33.33), line(category(1), 33.33), line(category(2), 33.34)},
+			amount: 100,
+		},
+		{
+			name:   "single line",
+			splits: []TransactionSplit{line(category(1), 100)},
+			amount: 100,
+			want:   "A split needs at least two lines",
+		},
+		{
+			name:   "zero amount",
+			splits: []TransactionSplit{line(category(1), 100), line(category(2), 0)},
+			amount: 100,
+			want:   "splits[1]: amount must be positive",
+		},
+		{
+			name:   "negative amount",
+			splits: []TransactionSplit{line(category(1), -10), line(category(2), 110)},
+			amount: 100,
+			want:   "splits[0]: amount must be positive",
+		},
+		{
+			name:   "category of another user",
+			splits: []TransactionSplit{line(category(1), 50), line(category(3), 50)},
+			amount: 100,
+			want:   "splits[1]: Category not found",
+		},
+		{
+			name:   "unknown category",
+			splits: []TransactionSplit{line(category(99), 50), line(category(1), 50)},
+			amount: 100,
+			want:   "splits[0]: Category not found",
+		},
+		{
+			name:   "short by a cent",
+			splits: []TransactionSplit{line(category(1), 49.99), line(category(2), 50)},
+			amount: 100,
+			want:   "Splits add up to 99.99, not to the amount of 100.00",
+		},
+		{
+			name:   "more than the amount",
+			splits: []TransactionSplit{line(category(1), 60), line(category(2), 60)},
+			amount: 100,
+			want:   "Splits add up to 120.00, not to the amount of 100.00",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateSplits(tt.splits, tt.amount, 1)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("validateSplits() error = %v, want nil", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.want {
+				t.Errorf("validateSplits() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Tags are free labels a user puts on transactions ("viagem", "reembolsável"),
+// across categories. Every user has their own tags: on a shared account each
+// member only sees the tags they put on its transactions.
+
+const defaultTagColor = "#6B7280"
+
+type Tag struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Color string `json:"color"`
+}
+
+type TagUsage struct {
+	Tag
+	TransactionCount int    `json:"transaction_count"`
+	CreatedAt        string `json:"created_at"`
+}
+
+type tagQueryer interface {
+	queryer
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// Get the user's tags with the number of transactions using each one
+func getTags(c *gin.Context) {
+	rows, err := db.Query(`
+		SELECT g.id, g.name, g.color, g.created_at, COUNT(t.id)
+		FROM tags g
+		LEFT JOIN transaction_tags tt ON tt.tag_id = g.id
+		LEFT JOIN transactions t ON t.id = tt.transaction_id AND t.deleted_at IS NULL
+		WHERE g.user_id = ?
+		GROUP BY g.id
+		ORDER BY g.name COLLATE NOCASE
+	`, currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	tags := []TagUsage{}
+	for rows.Next() {
+		var t TagUsage
+		if err := rows.Scan(&t.ID, &t.Name, &t.Color, &t.CreatedAt, &t.TransactionCount); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		tags = append(tags, t)
+	}
+
+	c.JSON(200, tags)
+}
+
+func createTag(c *gin.Context) {
+	var t Tag
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	if t.Color == "" {
+		t.Color = defaultTagColor
+	}
+
+	userID := currentUserID(c)
+	if tagNameTaken(userID, t.Name, 0) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Tag %s already exists", t.Name)})
+		return
+	}
+	result, err := db.Exec("INSERT INTO tags (user_id, name, color) VALUES (?, ?, ?)", userID, t.Name, t.Color)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	id, _ := result.LastInsertId()
+	t.ID = int(id)
+	c.JSON(200, t)
+}
+
+// Rename or recolor a tag
+func updateTag(c *gin.Context) {
+	var t Tag
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	userID := currentUserID(c)
+
+	var current Tag
+	err := db.QueryRow("SELECT id, name, color FROM tags WHERE id = ? AND user_id = ?", c.Param("id"), userID).
+		Scan(&current.ID, &current.Name, &current.Color)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Tag not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	if name := strings.TrimSpace(t.Name); name != "" {
+		current.Name = name
+	}
+	if t.Color != "" {
+		current.Color = t.Color
+	}
+	if tagNameTaken(userID, current.Name, current.ID) {
+		c.JSON(400, gin.H{"error": fmt.Sprintf("Tag %s already exists", current.Name)})
+		return
+	}
+
+	if _, err := db.Exec("UPDATE tags SET name = ?, color = ? WHERE id = ?", current.Name, current.Color, current.ID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(200, current)
+}
+
+// Delete a tag, removing it from the transactions that had it
+func deleteTag(c *gin.Context) {
+	result, err := db.Exec("DELETE FROM tags WHERE id = ? AND user_id = ?", c.Param("id"), currentUserID(c))
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Tag not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Tag deleted successfully"})
+}
+
+// Tag names are unique per user, ignoring case
+func tagNameTaken(userID int, name string, exceptID int) bool {
+	var taken bool
+	db.QueryRow(
+		"SELECT EXISTS(SELECT 1 FROM tags WHERE user_id = ? AND name = ? COLLATE NOCASE AND id != ?)",
+		userID, name, exceptID,
+	).Scan(&taken)
+	return taken
+}
+
+// IDs of the tags given on a transaction. Tags are given by ID or by name;
+// names the user has no tag for yet create the tag.
+func resolveTags(q tagQueryer, userID int, tags []Tag) ([]int, error) {
+	seen := make(map[int]bool)
+	ids := []int{}
+	for _, t := range tags {
+		id := t.ID
+		name := strings.TrimSpace(t.Name)
+		switch {
+		case id > 0:
+			var exists bool
+			q.QueryRow("SELECT EXISTS(SELECT 1 FROM tags WHERE id = ? AND user_id = ?)", id, userID).Scan(&exists)
+			if !exists {
+				return nil, fmt.Errorf("Tag %d not found", id)
+			}
+		case name != "":
+			err := q.QueryRow("SELECT id FROM tags WHERE user_id = ? AND name = ? COLLATE NOCASE", userID, name).Scan(&id)
+			if err == sql.ErrNoRows {
+				color := t.Color
+				if color == "" {
+					color = defaultTagColor
+				}
+				result, err := q.Exec("INSERT INTO tags (user_id, name, color) VALUES (?, ?, ?)", userID, name, color)
+				if err != nil {
+					return nil, err
+				}
+				newID, _ := result.LastInsertId()
+				id = int(newID)
+			} else if err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("Tags need an id or a name")
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// Replace the user's tags on a transaction. Tags other members of a shared
+// account put on it are kept.
+func setTransactionTags(e execer, userID int, transactionID interface{}, tagIDs []int) error {
+	_, err := e.Exec(
+		"DELETE FROM transaction_tags WHERE transaction_id = ? AND tag_id IN (SELECT id FROM tags WHERE user_id = ?)",
+		transactionID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	for _, tagID := range tagIDs {
+		if _, err := e.Exec("INSERT OR IGNORE INTO transaction_tags (transaction_id, tag_id) VALUES (?, ?)", transactionID, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The user's tags on each of the transactions, by transaction ID
+func transactionTags(userID int, transactionIDs []int) (map[int][]Tag, error) {
+	tags := make(map[int][]Tag)
+	// Batches stay well under SQLite's limit on query parameters
+	const batch = 500
+	for start := 0; start < len(transactionIDs); start += batch {
+		end := start + batch
+		if end > len(transactionIDs) {
+			end = len(transactionIDs)
+		}
+		args := []interface{}{userID}
+		for _, id := range transactionIDs[start:end] {
+			args = append(args, id)
+		}
+
+		rows, err := db.Query(`
+			SELECT tt.transaction_id, g.id, g.name, g.color
+			FROM transaction_tags tt
+			JOIN tags g ON g.id = tt.tag_id
+			WHERE g.user_id = ? AND tt.transaction_id IN (`+placeholders(end-start)+`)
+			ORDER BY g.name COLLATE NOCASE
+		`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var transactionID int
+			var t Tag
+			if err := rows.Scan(&transactionID, &t.ID, &t.Name, &t.Color); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			tags[transactionID] = append(tags[transactionID], t)
+		}
+		rows.Close()
+	}
+	return tags, nil
+}
+
+// Condition on a transaction ID column for the tag_id parameter: transactions
+// with any of the user's tags listed, or with none of them for "none"
+func tagFilter(c *gin.Context, column string) (string, []interface{}, error) {
+	tagIDs, err := queryIDs(c, "tag_id")
+	if err != nil || len(tagIDs) == 0 {
+		return "", nil, err
+	}
+	userID := currentUserID(c)
+
+	var ids []interface{}
+	untagged := false
+	for _, id := range tagIDs {
+		if id == "none" {
+			untagged = true
+		} else {
+			ids = append(ids, id)
+		}
+	}
+
+	tagged := "SELECT tt.transaction_id FROM transaction_tags tt JOIN tags g ON g.id = tt.tag_id WHERE g.user_id = ?"
+	var alternatives []string
+	var args []interface{}
+	if len(ids) > 0 {
+		alternatives = append(alternatives, column+" IN ("+tagged+" AND tt.tag_id IN ("+placeholders(len(ids))+"))")
+		args = append(append(args, userID), ids...)
+	}
+	if untagged {
+		alternatives = append(alternatives, column+" NOT IN ("+tagged+")")
+		args = append(args, userID)
+	}
+	return "(" + strings.Join(alternatives, " OR ") + ")", args, nil
+}
+
+// Income or expenses in a period by tag, in the base currency. A transaction
+// with several tags counts towards each of them, so the totals can add up to
+// more than the period's total; untagged transactions are summed apart.
+func getStatsByTag(c *gin.Context) {
+	transactionType := c.DefaultQuery("type", "expense")
+	if transactionType != "income" && transactionType != "expense" {
+		c.JSON(400, gin.H{"error": "type must be income or expense"})
+		return
+	}
+	userID := currentUserID(c)
+
+	where := "t.type = ? AND t.deleted_at IS NULL AND t.account_id IN (" + visibleAccountsQuery + ")"
+	args := []interface{}{transactionType, userID, userID}
+	if start := c.Query("start"); start != "" {
+		where += " AND t.date >= ?"
+		args = append(args, start)
+	}
+	if end := c.Query("end"); end != "" {
+		where += " AND t.date <= ?"
+		args = append(args, end)
+	}
+
+	rows, err := db.Query(`
+		SELECT g.id, g.name, g.color, t.currency, t.date, SUM(t.amount), COUNT(*)
+		FROM transactions t
+		JOIN transaction_tags tt ON tt.transaction_id = t.id
+		JOIN tags g ON g.id = tt.tag_id AND g.user_id = ?
+		WHERE `+where+`
+		GROUP BY g.id, t.currency, t.date
+	`, append([]interface{}{userID}, args...)...)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	type dayTotal struct {
+		tag            Tag
+		currency, date string
+		amount         float64
+		count          int
+	}
+	var days []dayTotal
+	for rows.Next() {
+		var d dayTotal
+		if err := rows.Scan(&d.tag.ID, &d.tag.Name, &d.tag.Color, &d.currency, &d.date, &d.amount, &d.count); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		days = append(days, d)
+	}
+	rows.Close()
+
+	currency := baseCurrency(userID)
+	fx := newFXConverter()
+
+	type tagTotal struct {
+		Tag
+		Total float64 `json:"total"`
+		Count int     `json:"count"`
+	}
+	byTag := make(map[int]*tagTotal)
+	totals := []*tagTotal{}
+	for _, d := range days {
+		total, ok := byTag[d.tag.ID]
+		if !ok {
+			total = &tagTotal{Tag: d.tag}
+			byTag[d.tag.ID] = total
+			totals = append(totals, total)
+		}
+		total.Total += fx.convert(d.amount, d.currency, currency, d.date)
+		total.Count += d.count
+	}
+	sort.Slice(totals, func(i, j int) bool { return totals[i].Total > totals[j].Total })
+
+	untagged := fx.sumTransactions(currency, transactionType,
+		"id IN (SELECT t.id FROM transactions t WHERE "+where+") AND id NOT IN (SELECT tt.transaction_id FROM transaction_tags tt JOIN tags g ON g.id = tt.tag_id WHERE g.user_id = ?)",
+		append(args, userID)...)
+
+	response := gin.H{
+		"type":     transactionType,
+		"currency": currency,
+		"tags":     totals,
+		"untagged": untagged,
+	}
+	if missing := fx.missingCurrencies(); len(missing) > 0 {
+		response["missingRates"] = missing
+	}
+	c.JSON(200, response)
+}
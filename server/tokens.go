@@ -0,0 +1,245 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Personal API tokens let scripts call the API without a session. They are
+// told apart from session tokens by their prefix.
+const apiTokenPrefix = "mmpat_"
+
+// Token scopes. Read-only tokens can call every GET route but the
+// session-only ones; the write scopes only unlock the routes listed in
+// scopeRoutes.
+const (
+	scopeRead              = "read"
+	scopeTransactionsWrite = "transactions:write"
+	scopeInvestmentsWrite  = "investments:write"
+)
+
+var scopeRoutes = map[string][]string{
+	scopeTransactionsWrite: {
+		"POST /api/transactions",
+		"PUT /api/transactions/:id",
+		"DELETE /api/transactions/:id",
+		"POST /api/transactions/:id/attachments",
+		"DELETE /api/attachments/:id",
+		"POST /api/tags",
+		"PUT /api/tags/:id",
+		"DELETE /api/tags/:id",
+		"POST /api/categorization-rules",
+		"PUT /api/categorization-rules/:id",
+		"DELETE /api/categorization-rules/:id",
+		"POST /api/categorization-rules/apply",
+		"POST /api/payees",
+		"PUT /api/payees/:id",
+		"DELETE /api/payees/:id",
+		"POST /api/payees/apply",
+	},
+	scopeInvestmentsWrite: {
+		"POST /api/investments",
+		"PUT /api/investments/:id",
+		"DELETE /api/investments/:id",
+		"POST /api/investments/:id/sell",
+		"POST /api/investments/:id/update-price",
+		"POST /api/investments/update-all-prices",
+	},
+}
+
+// Routes no token can call, whatever its scopes: the user's account and
+// tokens, full exports and backups, the audit log and household sharing need
+// a signed in session. Entries cover the route and the ones below it.
+var sessionOnlyRoutes = []string{
+	"/api/auth",
+	"/api/tokens",
+	"/api/export",
+	"/api/import",
+	"/api/backups",
+	"/api/audit",
+	"/api/households",
+}
+
+func isSessionOnlyRoute(route string) bool {
+	for _, prefix := range sessionOnlyRoutes {
+		if route == prefix || strings.HasPrefix(route, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+type APIToken struct {
+	ID         int      `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	LastUsedAt *string  `json:"last_used_at"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+func isValidScope(scope string) bool {
+	if scope == scopeRead {
+		return true
+	}
+	_, ok := scopeRoutes[scope]
+	return ok
+}
+
+// Whether any of the scopes allows a request to a route
+func scopesAllow(scopes []string, method, route string) bool {
+	if isSessionOnlyRoute(route) {
+		return false
+	}
+	for _, scope := range scopes {
+		if scope == scopeRead && (method == http.MethodGet || method == http.MethodHead) {
+			return true
+		}
+		for _, allowed := range scopeRoutes[scope] {
+			if allowed == method+" "+route {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Authenticate a request made with a personal API token, rejecting it when
+// the token is unknown or revoked or its scopes do not cover the route. Session-only
+// routes (account management, tokens, backups, households...) are never
+// covered.
+func authenticateAPIToken(c *gin.Context, token string) {
+	var id, userID int
+	var scopes string
+	err := db.QueryRow(
+		"SELECT id, user_id, scopes FROM api_tokens WHERE token_hash = ? AND revoked_at IS NULL",
+		hashToken(token),
+	).Scan(&id, &userID, &scopes)
+	if err != nil {
+		c.AbortWithStatusJSON(401, gin.H{"error": "Authentication required"})
+		return
+	}
+
+	if !scopesAllow(strings.Split(scopes, ","), c.Request.Method, c.FullPath()) {
+		c.AbortWithStatusJSON(403, gin.H{"error": "Token scope does not allow this request"})
+		return
+	}
+
+	db.Exec("UPDATE api_tokens SET last_used_at = CURRENT_TIMESTAMP WHERE id = ?", id)
+	c.Set("userID", userID)
+	c.Set("apiTokenID", id)
+	c.Next()
+}
+
+// Get the API tokens of the signed in user. Token values are only shown once,
+// when created.
+func getAPITokens(c *gin.Context) {
+	rows, err := db.Query(
+		"SELECT id, name, prefix, scopes, last_used_at, created_at FROM api_tokens WHERE user_id = ? AND revoked_at IS NULL ORDER BY created_at DESC",
+		currentUserID(c),
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	tokens := []APIToken{}
+	for rows.Next() {
+		var t APIToken
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.Name, &t.Prefix, &scopes, &t.LastUsedAt, &t.CreatedAt); err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		t.Scopes = strings.Split(scopes, ",")
+		tokens = append(tokens, t)
+	}
+
+	c.JSON(200, tokens)
+}
+
+// Create an API token. The response is the only time the token is returned;
+// only its hash is stored.
+func createAPIToken(c *gin.Context) {
+	var req struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(400, gin.H{"error": err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" {
+		c.JSON(400, gin.H{"error": "name is required"})
+		return
+	}
+	if len(req.Scopes) == 0 {
+		c.JSON(400, gin.H{"error": "at least one scope is required"})
+		return
+	}
+
+	seen := make(map[string]bool)
+	var scopes []string
+	for _, scope := range req.Scopes {
+		scope = strings.TrimSpace(scope)
+		if !isValidScope(scope) {
+			c.JSON(400, gin.H{"error": fmt.Sprintf("Invalid scope: %s", scope)})
+			return
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+
+	secret, err := newSessionToken()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	token := apiTokenPrefix + secret
+	// The prefix stored in clear lets users recognise their tokens
+	prefix := token[:len(apiTokenPrefix)+6]
+
+	result, err := db.Exec(
+		"INSERT INTO api_tokens (user_id, name, token_hash, prefix, scopes) VALUES (?, ?, ?, ?, ?)",
+		currentUserID(c), req.Name, hashToken(token), prefix, strings.Join(scopes, ","),
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	id, _ := result.LastInsertId()
+
+	c.JSON(200, gin.H{
+		"id":      id,
+		"name":    req.Name,
+		"prefix":  prefix,
+		"scopes":  scopes,
+		"token":   token,
+		"message": "Token created successfully",
+	})
+}
+
+// Revoke an API token. The row is kept so the audit log can still name the
+// token that made past changes.
+func revokeAPIToken(c *gin.Context) {
+	result, err := db.Exec(
+		"UPDATE api_tokens SET revoked_at = CURRENT_TIMESTAMP WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		c.Param("id"), currentUserID(c),
+	)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		c.JSON(404, gin.H{"error": "Token not found"})
+		return
+	}
+	c.JSON(200, gin.H{"message": "Token revoked successfully"})
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Query parameters of GET /transactions. Every filter is optional:
+//
+//	start, end              date range (inclusive)
+//	account_id              one or more accounts (repeated or comma separated)
+//	category_id             one or more categories, of the transaction or of one
+//	                        of its split lines; "none" matches uncategorized
+//	tag_id                  one or more of the user's tags; "none" matches untagged
+//	payee_id                one or more payees; "none" matches the ones without
+//	type                    income or expense
+//	min_amount, max_amount  amount range (inclusive)
+//	q                       words that must all appear in the description
+//	sort                    date, -date (default), amount or -amount
+//	limit, cursor           page size (up to maxTransactionPage) and the cursor
+//	                        returned in X-Next-Cursor for the next page
+//
+// Without limit every matching transaction is returned.
+
+const maxTransactionPage = 500
+
+type transactionSort struct {
+	column string
+	desc   bool
+}
+
+var transactionSorts = map[string]transactionSort{
+	"date":    {"t.date", false},
+	"-date":   {"t.date", true},
+	"amount":  {"t.amount", false},
+	"-amount": {"t.amount", true},
+}
+
+// Position after the last transaction of a page: its sort value and ID, the
+// tie-breaker between transactions with the same value
+type transactionCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    int         `json:"id"`
+}
+
+func encodeTransactionCursor(cursor transactionCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeTransactionCursor(value, sort string) (transactionCursor, error) {
+	var cursor transactionCursor
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(data, &cursor) != nil {
+		return cursor, fmt.Errorf("Invalid cursor")
+	}
+	if cursor.Sort != sort {
+		return cursor, fmt.Errorf("Cursor does not match the sort order")
+	}
+	switch cursor.Value.(type) {
+	case string, float64:
+	default:
+		return cursor, fmt.Errorf("Invalid cursor")
+	}
+	return cursor, nil
+}
+
+// IDs of a list parameter, given repeated (?account_id=1&account_id=2) or
+// comma separated (?account_id=1,2)
+func queryIDs(c *gin.Context, name string) ([]string, error) {
+	var ids []string
+	for _, value := range c.QueryArray(name) {
+		for _, id := range strings.Split(value, ",") {
+			id = strings.TrimSpace(id)
+			if id == "" {
+				continue
+			}
+			if _, err := strconv.Atoi(id); err != nil && !(id == "none" && (name == "category_id" || name == "tag_id" || name == "payee_id")) {
+				return nil, fmt.Errorf("Invalid %s: %s", name, id)
+			}
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// SQL conditions (on transactions t) and their arguments for the filters of a
+// request
+func transactionFilters(c *gin.Context) ([]string, []interface{}, error) {
+	var conditions []string
+	var args []interface{}
+
+	for _, param := range []struct{ name, condition string }{
+		{"start", "t.date >= ?"},
+		{"end", "t.date <= ?"},
+	} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return nil, nil, fmt.Errorf("Invalid %s date, use YYYY-MM-DD", param.name)
+		}
+		conditions = append(conditions, param.condition)
+		args = append(args, value)
+	}
+
+	accountIDs, err := queryIDs(c, "account_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(accountIDs) > 0 {
+		conditions = append(conditions, "t.account_id IN ("+placeholders(len(accountIDs))+")")
+		for _, id := range accountIDs {
+			args = append(args, id)
+		}
+	}
+
+	categoryIDs, err := queryIDs(c, "category_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(categoryIDs) > 0 {
+		var ids []interface{}
+		uncategorized := false
+		for _, id := range categoryIDs {
+			if id == "none" {
+				uncategorized = true
+			} else {
+				ids = append(ids, id)
+			}
+		}
+		var alternatives []string
+		if len(ids) > 0 {
+			// A category includes its subcategories
+			withChildren := "SELECT id FROM categories WHERE id IN (" + placeholders(len(ids)) + ") OR parent_id IN (" + placeholders(len(ids)) + ")"
+			alternatives = append(alternatives,
+				"t.category_id IN ("+withChildren+")",
+				"t.id IN (SELECT transaction_id FROM transaction_splits WHERE category_id IN ("+withChildren+"))")
+			for i := 0; i < 4; i++ {
+				args = append(args, ids...)
+			}
+		}
+		if uncategorized {
+			alternatives = append(alternatives, "t.category_id IS NULL")
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	payeeIDs, err := queryIDs(c, "payee_id")
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(payeeIDs) > 0 {
+		var ids []interface{}
+		var alternatives []string
+		for _, id := range payeeIDs {
+			if id == "none" {
+				alternatives = append(alternatives, "t.payee_id IS NULL")
+			} else {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) > 0 {
+			alternatives = append(alternatives, "t.payee_id IN ("+placeholders(len(ids))+")")
+			args = append(args, ids...)
+		}
+		conditions = append(conditions, "("+strings.Join(alternatives, " OR ")+")")
+	}
+
+	tagCondition, tagArgs, err := tagFilter(c, "t.id")
+	if err != nil {
+		return nil, nil, err
+	}
+	if tagCondition != "" {
+		conditions = append(conditions, tagCondition)
+		args = append(args, tagArgs...)
+	}
+
+	if transactionType := c.Query("type"); transactionType != "" {
+		if transactionType != "income" && transactionType != "expense" {
+			return nil, nil, fmt.Errorf("type must be income or expense")
+		}
+		conditions = append(conditions, "t.type = ?")
+		args = append(args, transactionType)
+	}
+
+	for _, param := range []struct{ name, condition string }{
+		{"min_amount", "t.amount >= ?"},
+		{"max_amount", "t.amount <= ?"},
+	} {
+		value := c.Query(param.name)
+		if value == "" {
+			continue
+		}
+		amount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid %s", param.name)
+		}
+		conditions = append(conditions, param.condition)
+		args = append(args, amount)
+	}
+
+	// LIKE is case-insensitive for ASCII letters; % and _ typed by the user
+	// are matched literally
+	escaper := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	for _, word := range strings.Fields(c.Query("q")) {
+		conditions = append(conditions, `t.description LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escaper.Replace(word)+"%")
+	}
+
+	return conditions, args, nil
+}
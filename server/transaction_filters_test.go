@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestTransactionCursorRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		cursor transactionCursor
+	}{
+		{"date", transactionCursor{Sort: "-date", Value: "2024-05-31", ID: 42}},
+		{"ascending date", transactionCursor{Sort: "date", Value: "2023-01-01", ID: 1}},
+		{"amount", transactionCursor{Sort: "-amount", Value: 1234.56, ID: 7}},
+		{"negative amount", transactionCursor{Sort: "amount", Value: -0.01, ID: 99}},
+		{"whole amount", transactionCursor{Sort: "amount", Value: float64(100), ID: 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded := encodeTransactionCursor(tt.cursor)
+			got, err := decodeTransactionCursor(encoded, tt.cursor.Sort)
+			if err != nil {
+				t.Fatalf("decodeTransactionCursor(%q) error = %v", encoded, err)
+			}
+			if got != tt.cursor {
+				t.Errorf("decodeTransactionCursor(%q) = %+v, want %+v", encoded, got, tt.cursor)
+			}
+		})
+	}
+}
+
+func TestDecodeTransactionCursorErrors(t *testing.T) {
+	encode := func(json string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(json))
+	}
+
+	tests := []struct {
+		name  string
+		value string
+		sort  string
+		want  string
+	}{
+		{"not base64", "%%%", "-date", "Invalid cursor"},
+		{"not json", encode("not json"), "-date", "Invalid cursor"},
+		{"padded base64", base64.URLEncoding.EncodeToString([]byte(`{"s":"-date","v":"2024-01-01","id":1}`)), "-date", "Invalid cursor"},
+		{"other sort", encodeTransactionCursor(transactionCursor{Sort: "-date", Value: "2024-01-01", ID: 1}), "-amount", "Cursor does not match the sort order"},
+		{"missing value", encode(`{"s":"-date","id":1}`), "-date", "Invalid cursor"},
+		{"boolean value", encode(`{"s":"-date","v":true,"id":1}`), "-date", "Invalid cursor"},
+		{"object value", encode(`{"s":"-date","v":{"x":1},"id":1}`), "-date", "Invalid cursor"},
+		{"id not a number", encode(`{"s":"-date","v":"2024-01-01","id":"1"}`), "-date", "Invalid cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeTransactionCursor(tt.value, tt.sort)
+			if err == nil {
+				t.Fatalf("decodeTransactionCursor(%q, %q) succeeded, want %q", tt.value, tt.sort, tt.want)
+			}
+			if err.Error() != tt.want {
+				t.Errorf("decodeTransactionCursor(%q, %q) error = %q, want %q", tt.value, tt.sort, err.Error(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// deleted_at is stored in UTC with microseconds, so the transactions trashed
+// together with an account can be told apart from the ones deleted before it
+// by matching the account's deleted_at
+const deletedAtFormat = "2006-01-02 15:04:05.000000"
+
+func deletedAtNow() string {
+	return time.Now().UTC().Format(deletedAtFormat)
+}
+
+// Deleted transactions and accounts are kept in the trash, with deleted_at
+// set, for trash.retention_days before they are purged
+func trashRetentionDays() int {
+	return config.Trash.RetentionDays
+}
+
+type TrashedAccount struct {
+	ID               int     `json:"id"`
+	Name             string  `json:"name"`
+	Type             string  `json:"type"`
+	Balance          float64 `json:"balance"`
+	Currency         string  `json:"currency"`
+	TransactionCount int     `json:"transaction_count"`
+	DeletedAt        string  `json:"deleted_at"`
+	PurgeAt          string  `json:"purge_at"`
+}
+
+type TrashedTransaction struct {
+	Transaction
+	DeletedAt string `json:"deleted_at"`
+	PurgeAt   string `json:"purge_at"`
+}
+
+// Date a trashed row will be purged
+func purgeDate(deletedAt string) string {
+	t, err := time.Parse(time.RFC3339, deletedAt)
+	if err != nil {
+		return ""
+	}
+	return t.AddDate(0, 0, trashRetentionDays()).Format("2006-01-02")
+}
+
+// Effect of a transaction on its account balance
+func balanceEffect(transactionType string, amount float64) float64 {
+	if transactionType == "income" {
+		return amount
+	}
+	return -amount
+}
+
+// Get the trash: deleted accounts of the user and deleted transactions of the
+// accounts they can still see. Transactions deleted together with their
+// account are listed under the account.
+func getTrash(c *gin.Context) {
+	userID := currentUserID(c)
+
+	rows, err := db.Query(`
+		SELECT a.id, a.name, a.type, a.balance, a.currency, a.deleted_at,
+			(SELECT COUNT(*) FROM transactions t WHERE t.account_id = a.id AND t.deleted_at = a.deleted_at)
+		FROM accounts a
+		WHERE a.user_id = ? AND a.deleted_at IS NOT NULL
+		ORDER BY a.deleted_at DESC
+	`, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	accounts := []TrashedAccount{}
+	for rows.Next() {
+		var a TrashedAccount
+		if err := rows.Scan(&a.ID, &a.Name, &a.Type, &a.Balance, &a.Currency, &a.DeletedAt, &a.TransactionCount); err != nil {
+			rows.Close()
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		a.PurgeAt = purgeDate(a.DeletedAt)
+		accounts = append(accounts, a)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`
+		SELECT
+			t.id, t.account_id, t.category_id, t.type, t.amount, t.currency, t.description, t.date, t.created_at,
+			a.name, a.color, c.name, c.color, c.icon, t.deleted_at
+		FROM transactions t
+		LEFT JOIN accounts a ON t.account_id = a.id
+		LEFT JOIN categories c ON t.category_id = c.id
+		WHERE t.deleted_at IS NOT NULL AND t.account_id IN (`+visibleAccountsQuery+`)
+		ORDER BY t.deleted_at DESC
+	`, userID, userID)
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	transactions := []TrashedTransaction{}
+	for rows.Next() {
+		var t TrashedTransaction
+		err := rows.Scan(
+			&t.ID, &t.AccountID, &t.CategoryID, &t.Type, &t.Amount, &t.Currency, &t.Description, &t.Date, &t.CreatedAt,
+			&t.AccountName, &t.AccountColor, &t.CategoryName, &t.CategoryColor, &t.CategoryIcon, &t.DeletedAt,
+		)
+		if err != nil {
+			c.JSON(500, gin.H{"error": err.Error()})
+			return
+		}
+		t.PurgeAt = purgeDate(t.DeletedAt)
+		transactions = append(transactions, t)
+	}
+
+	c.JSON(200, gin.H{
+		"accounts":       accounts,
+		"transactions":   transactions,
+		"retention_days": trashRetentionDays(),
+	})
+}
+
+// Restore a deleted transaction, applying its amount to the account balance
+// again
+func restoreTransaction(c *gin.Context) {
+	id := c.Param("id")
+	userID := currentUserID(c)
+
+	var accountID int
+	var transactionType string
+	var amount float64
+	err := db.QueryRow(
+		"SELECT account_id, type, amount FROM transactions WHERE id = ? AND deleted_at IS NOT NULL AND account_id IN ("+visibleAccountsQuery+")",
+		id, userID, userID,
+	).Scan(&accountID, &transactionType, &amount)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Transaction not found in trash"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if !authorizeAccount(c, accountID, roleEditor, 404) {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	before := auditRow(tx, "transactions", id)
+	if _, err := tx.Exec("UPDATE transactions SET deleted_at = NULL WHERE id = ?", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE accounts SET balance = balance + ? WHERE id = ?", balanceEffect(transactionType, amount), accountID); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	recordAudit(c, tx, "transaction", id, before, auditRow(tx, "transactions", id))
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	learnTransaction(id, 1)
+	c.JSON(200, gin.H{"message": "Transaction restored successfully"})
+}
+
+// Restore a deleted account with the transactions that were deleted along
+// with it. Its balance was left untouched when it was deleted, so it is
+// already consistent with those transactions.
+func restoreAccount(c *gin.Context) {
+	id := c.Param("id")
+
+	var exists bool
+	err := db.QueryRow(
+		"SELECT 1 FROM accounts WHERE id = ? AND user_id = ? AND deleted_at IS NOT NULL",
+		id, currentUserID(c),
+	).Scan(&exists)
+	if err == sql.ErrNoRows {
+		c.JSON(404, gin.H{"error": "Account not found in trash"})
+		return
+	}
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	defer tx.Rollback()
+
+	before := auditRow(tx, "accounts", id)
+	// Transactions deleted with the account share its deleted_at
+	withAccount := "account_id = ? AND deleted_at = (SELECT deleted_at FROM accounts WHERE id = ?)"
+	restored := auditRows(tx, "transactions", withAccount, id, id)
+	if _, err := tx.Exec("UPDATE transactions SET deleted_at = NULL WHERE "+withAccount, id, id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	if _, err := tx.Exec("UPDATE accounts SET deleted_at = NULL WHERE id = ?", id); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	for _, t := range restored {
+		recordAudit(c, tx, "transaction", t["id"], t, auditRow(tx, "transactions", t["id"]))
+	}
+	recordAudit(c, tx, "account", id, before, auditRow(tx, "accounts", id))
+
+	if err := tx.Commit(); err != nil {
+		c.JSON(500, gin.H{"error": err.Error()})
+		return
+	}
+	resetCategoryModels()
+	c.JSON(200, gin.H{"message": "Account restored successfully", "transactions_restored": len(restored)})
+}
+
+// Permanently delete what has been in the trash for longer than the
+// retention period
+func purgeTrash() {
+	cutoff := time.Now().UTC().AddDate(0, 0, -trashRetentionDays()).Format(deletedAtFormat)
+
+	tx, err := db.Begin()
+	if err != nil {
+		log.Printf("Error purging trash: %v", err)
+		return
+	}
+	defer tx.Rollback()
+
+	accountCondition := "deleted_at IS NOT NULL AND deleted_at < ?"
+	transactionCondition := "(deleted_at IS NOT NULL AND deleted_at < ?) OR account_id IN (SELECT id FROM accounts WHERE " + accountCondition + ")"
+
+	transactions := auditRows(tx, "transactions", transactionCondition, cutoff, cutoff)
+	accounts := auditRows(tx, "accounts", accountCondition, cutoff)
+	if len(transactions) == 0 && len(accounts) == 0 {
+		return
+	}
+
+	if _, err := tx.Exec("DELETE FROM transactions WHERE "+transactionCondition, cutoff, cutoff); err != nil {
+		log.Printf("Error purging trash: %v", err)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM household_accounts WHERE account_id IN (SELECT id FROM accounts WHERE "+accountCondition+")", cutoff); err != nil {
+		log.Printf("Error purging trash: %v", err)
+		return
+	}
+	if _, err := tx.Exec("DELETE FROM accounts WHERE "+accountCondition, cutoff); err != nil {
+		log.Printf("Error purging trash: %v", err)
+		return
+	}
+
+	for _, t := range transactions {
+		recordSystemAudit(tx, auditOwner(t), "trash purge", "transaction", nil, t, nil)
+	}
+	for _, a := range accounts {
+		recordSystemAudit(tx, auditOwner(a), "trash purge", "account", nil, a, nil)
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error purging trash: %v", err)
+		return
+	}
+	log.Printf("Purged %d transactions and %d accounts from the trash", len(transactions), len(accounts))
+	removeOrphanAttachmentFiles()
+}
+
+// User a row read with auditRows belongs to
+func auditOwner(row map[string]interface{}) int {
+	if userID, ok := row["user_id"].(int64); ok {
+		return int(userID)
+	}
+	return 0
+}
+
+func autoPurgeTrash() {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("Panic in autoPurgeTrash: %v", r)
+		}
+	}()
+
+	purgeTrash()
+
+	ticker := time.NewTicker(24 * time.Hour)
+	defer ticker.Stop()
+	for range ticker.C {
+		purgeTrash()
+	}
+}